@@ -2,14 +2,29 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/drummonds/openwrt-configurator.git/internal/compliance"
+	"github.com/drummonds/openwrt-configurator.git/internal/condition"
 	"github.com/drummonds/openwrt-configurator.git/internal/config"
 	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/diff"
 	"github.com/drummonds/openwrt-configurator.git/internal/export"
+	"github.com/drummonds/openwrt-configurator.git/internal/inventory"
+	"github.com/drummonds/openwrt-configurator.git/internal/migrate"
 	"github.com/drummonds/openwrt-configurator.git/internal/provision"
+	"github.com/drummonds/openwrt-configurator.git/internal/redact"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+	"github.com/drummonds/openwrt-configurator.git/internal/secret"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/topology"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+	"github.com/drummonds/openwrt-configurator.git/internal/validate"
 )
 
 const version = "0.0.4"
@@ -21,33 +36,95 @@ func main() {
 	}
 
 	// Check for global flags
-	if os.Args[1] == "-h" || os.Args[1] == "--help" {
+	args := os.Args[1:]
+	for len(args) > 0 && (args[0] == "-redact-logs" || args[0] == "--redact-logs") {
+		redactLogs = true
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	if args[0] == "-h" || args[0] == "--help" {
 		printUsage()
 		os.Exit(0)
 	}
 
-	if os.Args[1] == "-v" || os.Args[1] == "--version" {
+	if args[0] == "-v" || args[0] == "--version" {
 		fmt.Printf("openwrt-configurator version %s\n", version)
 		os.Exit(0)
 	}
 
 	// Parse subcommand
-	subcommand := os.Args[1]
+	subcommand := args[0]
 
 	switch subcommand {
 	case "provision":
-		if err := provisionCmd(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if err := provisionCmd(args[1:]); err != nil {
+			printError(err)
 			os.Exit(1)
 		}
 	case "print-uci-commands":
-		if err := printUciCommandsCmd(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if err := printUciCommandsCmd(args[1:]); err != nil {
+			printError(err)
 			os.Exit(1)
 		}
 	case "export-config":
-		if err := exportConfigCmd(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if err := exportConfigCmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "validate":
+		if err := validateCmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "diff":
+		if err := diffCmd(args[1:]); err != nil {
+			if !errors.Is(err, errDifferences) {
+				printError(err)
+			}
+			os.Exit(1)
+		}
+	case "config-diff":
+		if err := configDiffCmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "drift-check":
+		if err := driftCheckCmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "verify-fleet":
+		if err := verifyFleetCmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "collect-facts":
+		if err := collectFactsCmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "topology":
+		if err := topologyCmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "migrate-dsa":
+		if err := migrateDSACmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "explain-condition":
+		if err := explainConditionCmd(args[1:]); err != nil {
+			printError(err)
+			os.Exit(1)
+		}
+	case "encrypt-secrets":
+		if err := encryptSecretsCmd(args[1:]); err != nil {
+			printError(err)
 			os.Exit(1)
 		}
 	default:
@@ -67,17 +144,73 @@ Available Commands:
   provision              Provision configuration to devices
   print-uci-commands     Print UCI commands for configuration
   export-config          Export configuration from an OpenWRT device
+  validate               Validate configuration without connecting to devices
+  diff                   Show differences between configuration and devices
+  config-diff            Show differences between two configuration files
+  drift-check            Report devices whose config has drifted from the configuration
+  verify-fleet           Check every device against the configuration for CI
+  collect-facts          Collect inventory facts from every device as JSON
+  topology               Draw the network topology of each device
+  migrate-dsa            Convert a swconfig network config to DSA
+  explain-condition      Evaluate a condition against a device for debugging
+  encrypt-secrets        Encrypt a secrets file into a vault
 
 Flags:
   -h, --help             Show help
   -v, --version          Show version
+  --redact-logs          Mask secrets such as Wi-Fi keys in printed commands,
+                         changes, logs and errors; given before the command
 
 Use "openwrt-configurator <command> -h" for more information about a command.
 `)
 }
 
+// redactLogs is set by the global --redact-logs flag
+var redactLogs bool
+
+// logRedactor masks secrets in output and errors when --redact-logs is
+// set, and is nil otherwise
+var logRedactor *redact.Redactor
+
+// newRedactor returns the redactor for a config's sensitive options, or nil
+// without --redact-logs
+func newRedactor(oncConfig *config.ONCConfig) *redact.Redactor {
+	if !redactLogs {
+		return nil
+	}
+	var sensitiveOptions []string
+	if oncConfig.Secrets != nil {
+		sensitiveOptions = oncConfig.Secrets.SensitiveOptions
+	}
+	return redact.New(sensitiveOptions)
+}
+
+// printError prints a command's error, with secrets masked
+func printError(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", logRedactor.Error(err))
+}
+
 func provisionCmd(args []string) error {
 	fs := flag.NewFlagSet("provision", flag.ExitOnError)
+
+	verifyHostname := fs.String("verify-hostname", "", "Check the device's current hostname before applying: warn or refuse")
+	continueOnError := fs.Bool("continue-on-error", false, "Log failing commands and carry on instead of reverting")
+	parallel := fs.Int("parallel", 1, "Number of devices to provision at once")
+	onDeviceError := fs.String("on-device-error", provision.DeviceErrorStop, "After a device fails, stop starting devices (stop) or provision the rest (continue)")
+	limitRate := fs.Float64("limit-rate", 0, "Open at most this many SSH connections per second, e.g. 0.5")
+	assumeInstalled := fs.String("assume-installed", "", "Comma-separated packages to treat as installed instead of asking opkg")
+	skipPackages := fs.Bool("skip-packages", false, "Don't install or remove packages, only apply the config")
+	waitOnline := fs.Duration("wait-online", 0, "Wait up to this long for the device to be online before installing packages, e.g. 2m")
+	followLog := fs.Bool("follow-log", false, "Echo the device's log while the config is applied")
+	validateOnDevice := fs.Bool("validate-on-device", false, "Stage and revert the changes first, stopping if uci refuses any; nothing is committed")
+	allowModelMismatch := fs.String("allow-model-mismatch", "", "Comma-separated model ids devices may have instead of their configured one")
+	restartServices := fs.String("restart-services", "", "Comma-separated init.d services to restart instead of reloading the changed configs")
+	auditDir := fs.String("audit-dir", "", "Write a JSON record of what was applied to each device to this directory")
+	var tags tagFilters
+	fs.Var(&tags, "tag", "Only provision devices with this tag, as key=value; repeat to require several")
+	dryRun := fs.Bool("dry-run", false, "Verify each device and print the commands that would run, without running them")
+	mode := fs.String("mode", provision.ApplyModeAuto, "Reset the config sections first (reset), keep the device's other sections (merge) or pick by factory defaults (auto)")
+
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Provision configuration to devices
 
@@ -85,7 +218,52 @@ Usage:
   openwrt-configurator provision [flags] <config-file>
 
 Flags:
-  -h, --help    Show help
+  -verify-hostname string   Check the device's current hostname before applying:
+                            warn or refuse (devices with the factory hostname pass)
+  -continue-on-error        Log failing commands and carry on instead of reverting,
+                            then report every failure at the end
+  -parallel int             Number of devices to provision at once; devices still
+                            wait for their depends_on devices (default 1)
+  -on-device-error string   stop starts no more devices once one fails; continue
+                            provisions the rest and reports every failed device
+                            at the end (default "stop")
+  -limit-rate float         Open at most this many SSH connections per second
+                            across all devices, e.g. 0.5 for one every two
+                            seconds (default 0, unlimited)
+  -assume-installed string  Comma-separated packages to treat as the installed
+                            list instead of running opkg list-installed
+  -skip-packages            Don't install or remove packages, only apply the config
+  -wait-online duration     Before installing packages, wait up to this long (e.g.
+                            2m) for the wan interface to be up, or on devices
+                            without one for the package feeds to be reachable
+  -follow-log               Echo the device's log (logread -f) while the config
+                            is applied, to show errors such as a service failing
+                            to reload
+  -validate-on-device       Stage the changes on the device and revert them before
+                            applying anything, stopping with the changes uci
+                            refuses to stage. Only staging is checked: nothing
+                            is committed, so values a service would reject are
+                            not caught
+  -allow-model-mismatch string
+                            Comma-separated model ids, e.g. another hardware
+                            revision, that devices may have instead of their
+                            configured one; the mismatch is then a warning
+  -mode string              reset clears the model's config sections before
+                            applying, merge keeps the device's other sections,
+                            auto resets factory default devices and merges into
+                            configured ones (default "auto")
+  -restart-services string  Comma-separated init.d services, e.g. network,dnsmasq,
+                            to restart after applying instead of reload_config
+                            and the changed configs' reloads
+  -audit-dir string         Write a JSON record of each device's applied commands,
+                            package changes and config hash to this directory
+  -tag key=value            Only provision devices whose tag has this value, e.g.
+                            role=ap; repeat to require several tags. depends_on
+                            devices that aren't selected are taken as done
+  -dry-run                  Connect and verify each device, then print the commands
+                            provisioning would run, accounting for its installed
+                            packages and apply mode, without running them
+  -h, --help                Show help
 
 Arguments:
   config-file   Path to the configuration JSON file
@@ -101,22 +279,37 @@ Arguments:
 		return fmt.Errorf("requires exactly one argument: config-file")
 	}
 
-	configPath := fs.Arg(0)
-
-	// Read config file
-	configData, err := os.ReadFile(configPath)
+	// Read and parse config file
+	oncConfig, err := config.Load(fs.Arg(0))
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return err
 	}
 
-	// Parse config
-	var oncConfig config.ONCConfig
-	if err := json.Unmarshal(configData, &oncConfig); err != nil {
-		return fmt.Errorf("failed to parse config file: %w", err)
+	opts := provision.Options{
+		HostnameCheck:    *verifyHostname,
+		ContinueOnError:  *continueOnError,
+		Parallel:         *parallel,
+		SkipPackages:     *skipPackages,
+		WaitOnline:       *waitOnline,
+		FollowLog:        *followLog,
+		ValidateOnDevice: *validateOnDevice,
+		CompatibleModels: splitList(*allowModelMismatch),
+		ApplyMode:        *mode,
+		RestartServices:  splitList(*restartServices),
+		AuditDir:         *auditDir,
+		RateLimit:        *limitRate,
+		DryRun:           *dryRun,
+		DeviceErrors:     *onDeviceError,
+		Tags:             tags,
+		Redactor:         newRedactor(oncConfig),
+	}
+	logRedactor = opts.Redactor
+	if *assumeInstalled != "" {
+		opts.AssumeInstalled = append([]string{}, splitList(*assumeInstalled)...)
 	}
 
 	// Validate and provision
-	if err := provision.ProvisionConfig(&oncConfig); err != nil {
+	if err := provision.ProvisionConfig(oncConfig, opts); err != nil {
 		return fmt.Errorf("provisioning failed: %w", err)
 	}
 
@@ -125,6 +318,12 @@ Arguments:
 
 func printUciCommandsCmd(args []string) error {
 	fs := flag.NewFlagSet("print-uci-commands", flag.ExitOnError)
+
+	format := fs.String("format", "commands", "Output format: commands or uci")
+	var tags tagFilters
+	fs.Var(&tags, "tag", "Only print devices with this tag, as key=value; repeat to require several")
+	boardDB := fs.String("board-db", "", "Build schemas from this board.json database file or URL instead of connecting")
+
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Print UCI commands for configuration
 
@@ -132,7 +331,14 @@ Usage:
   openwrt-configurator print-uci-commands [flags] <config-file>
 
 Flags:
-  -h, --help    Show help
+  -format string   Output format: commands (uci set commands) or uci
+                   (/etc/config files) (default "commands")
+  -tag key=value   Only print devices whose tag has this value, e.g. role=ap;
+                   repeat to require several tags
+  -board-db string Build each device's schema from its model's board.json in
+                   this file or URL instead of connecting to it. board.json
+                   has no radios, so devices with wireless sections fail.
+  -h, --help       Show help
 
 Arguments:
   config-file   Path to the configuration JSON file
@@ -148,39 +354,65 @@ Arguments:
 		return fmt.Errorf("requires exactly one argument: config-file")
 	}
 
-	configPath := fs.Arg(0)
+	if *format != "commands" && *format != "uci" {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
 
-	// Read config file
-	configData, err := os.ReadFile(configPath)
+	// Read and parse config file
+	oncConfig, err := config.Load(fs.Arg(0))
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return err
 	}
 
-	// Parse config
-	var oncConfig config.ONCConfig
-	if err := json.Unmarshal(configData, &oncConfig); err != nil {
-		return fmt.Errorf("failed to parse config file: %w", err)
+	// Get enabled devices
+	devices, err := condition.SelectDevices(getEnabledDevices(oncConfig), tags)
+	if err != nil {
+		return err
 	}
 
-	// Get enabled devices
-	devices := getEnabledDevices(&oncConfig)
+	getSchema := device.GetDeviceSchema
+	if *boardDB != "" {
+		getSchema = device.NewBoardDatabase(*boardDB).Schema
+	}
 
 	// Get device schemas for all devices
 	deviceSchemas := make(map[string]*device.DeviceSchema)
 	for _, dev := range devices {
-		schema, err := device.GetDeviceSchema(&dev)
+		schema, err := getSchema(&dev)
 		if err != nil {
 			return fmt.Errorf("failed to get device schema for %s: %w", dev.ModelID, err)
 		}
 		deviceSchemas[dev.ModelID] = schema
 	}
 
+	secrets, err := secret.NewResolver(oncConfig.Secrets)
+	if err != nil {
+		return err
+	}
+	logRedactor = newRedactor(oncConfig)
+	secrets = logRedactor.Resolver(secrets)
+
 	// Generate and print commands for each device
 	for _, dev := range devices {
 		schema := deviceSchemas[dev.ModelID]
-		state, err := device.GetOpenWrtState(&oncConfig, &dev, schema)
+		state, err := device.GetOpenWrtStateWithOptions(oncConfig, &dev, schema, device.StateOptions{Secrets: secrets})
 		if err != nil {
-			return fmt.Errorf("failed to get state for device %s: %w", dev.Hostname, err)
+			return stateError(&dev, err)
+		}
+		logRedactor.Collect(state.Config)
+
+		// Wireless sections for a band need the device's radios
+		if *boardDB != "" && len(schema.Radios) == 0 && hasWirelessSections(state.Config) {
+			return fmt.Errorf("device %s has wireless sections, but the radios of %s are unknown; connect to the device instead of using -board-db", dev.Hostname, dev.ModelID)
+		}
+
+		for _, warning := range state.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", dev.Hostname, logRedactor.Text(warning))
+		}
+
+		if *format == "uci" {
+			printUciFiles(dev.Hostname, state)
+			continue
 		}
 
 		commands, err := device.GetDeviceScript(state, nil)
@@ -190,21 +422,47 @@ Arguments:
 
 		fmt.Printf("# device %s\n", dev.Hostname)
 		for _, cmd := range commands {
-			fmt.Println(cmd)
+			fmt.Println(logRedactor.Command(cmd))
 		}
 	}
 
 	return nil
 }
 
+// printUciFiles prints a device's resolved config as /etc/config files
+func printUciFiles(hostname string, state *device.OpenWrtState) {
+	configKeys := uci.SortedKeys(state.Config)
+
+	fmt.Printf("# device %s\n", hostname)
+	for _, configKey := range configKeys {
+		fmt.Printf("\n# /etc/config/%s\n", configKey)
+		fmt.Print(logRedactor.Text(uci.RenderConfig(state.Config[configKey])))
+	}
+}
+
 func exportConfigCmd(args []string) error {
 	fs := flag.NewFlagSet("export-config", flag.ExitOnError)
 
 	modelID := fs.String("model", "", "Device model ID (e.g., ubnt,edgerouter-x)")
-	ipAddr := fs.String("ip", "", "Device IP address")
+	ipAddr := fs.String("ip", "", "Device IP address or host name")
+	port := fs.Int("port", 0, "SSH port (default 22)")
 	username := fs.String("user", "root", "SSH username")
 	password := fs.String("pass", "", "SSH password")
+	keyPath := fs.String("key", "", "SSH private key file")
+	keyPassphrase := fs.String("key-passphrase", "", "Passphrase of an encrypted SSH private key")
+	hostKeyChecking := fs.String("strict-host-key-checking", "", "How the device's host key is checked: accept-new, yes or no")
+	knownHosts := fs.String("known-hosts", "", "known_hosts file (default ~/.ssh/known_hosts)")
 	output := fs.String("output", "", "Output file (default: stdout)")
+	outputDir := fs.String("output-dir", "", "Write one /etc/config style file per config to this directory instead of JSON")
+	noFacts := fs.Bool("no-facts", false, "Don't add device facts (board, version, arch) to tags")
+	configName := fs.String("config", "", "Only export this config (system, network, wireless, dropbear or dhcp)")
+	canonical := fs.Bool("canonical", false, "Write the config in canonical form, with sections and keys sorted")
+	ciphers := fs.String("ciphers", "", "Comma-separated SSH ciphers to offer")
+	kex := fs.String("kex", "", "Comma-separated SSH key exchanges to offer")
+	hostKeys := fs.String("hostkeys", "", "Comma-separated SSH host key algorithms to accept")
+	noMetadata := fs.Bool("no-metadata", false, "Don't record when and where the config was exported")
+	defaultsFile := fs.String("defaults", "", "Device schema file whose default_config is left out of the export")
+	backend := fs.String("backend", export.BackendUCI, "How to read configs: uci (uci show) or ubus (ubus call uci get)")
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Export configuration from an OpenWRT device
@@ -214,10 +472,41 @@ Usage:
 
 Flags:
   -model string     Device model ID (optional, auto-detected from device)
-  -ip string        Device IP address (required)
+  -ip string        Device IP address or host name (required)
+  -port int         SSH port (default 22)
   -user string      SSH username (default "root")
-  -pass string      SSH password (required)
+  -pass string      SSH password (required unless -key is set)
+  -key string       SSH private key file, tried before the password
+  -key-passphrase string
+                    Passphrase of an encrypted private key
+  -strict-host-key-checking string
+                    How the device's host key is checked against known_hosts:
+                    accept-new records unknown keys and rejects changed
+                    ones, yes also rejects unknown keys, no skips the check
+                    (default "accept-new")
+  -known-hosts string
+                    known_hosts file (default ~/.ssh/known_hosts)
   -output string    Output file (default: stdout)
+  -output-dir string
+                    Write the configs to this directory instead, one file
+                    per config in the /etc/config format, e.g. <dir>/network
+  -no-facts         Don't add device facts (board, version, arch) to tags
+  -config string    Only export this config (system, network, wireless, dropbear or dhcp)
+  -canonical        Write the config in canonical form, with sections and keys
+                    sorted, so exports of the same config diff cleanly
+  -no-metadata      Don't record when and where the config was exported
+  -defaults string  Device schema file (e.g. deviceSchemas/<model>.json) whose
+                    default_config lists the factory defaults; only options
+                    that differ from them are exported
+  -backend string   How to read configs: uci (uci show) or ubus (ubus call
+                    uci get, JSON) (default "uci")
+  -ciphers string   Comma-separated SSH ciphers to offer, e.g. aes128-cbc
+  -kex string       Comma-separated SSH key exchanges to offer, e.g.
+                    diffie-hellman-group1-sha1
+  -hostkeys string  Comma-separated SSH host key algorithms to accept, e.g.
+                    ssh-rsa
+                    Each list replaces the defaults. Legacy algorithms are
+                    weak, only enable them for old devices that need them.
   -h, --help        Show help
 
 Examples:
@@ -229,6 +518,19 @@ Examples:
 
   # Export with explicit model ID (for verification)
   openwrt-configurator export-config -model ubnt,edgerouter-x -ip 192.168.1.1 -pass mypassword -output config.json
+
+  # Export only the network config
+  openwrt-configurator export-config -ip 192.168.1.1 -pass mypassword -config network
+
+  # Export from a device that only accepts key authentication
+  openwrt-configurator export-config -ip 192.168.1.1 -key ~/.ssh/id_ed25519
+
+  # Export the raw configs to a directory mirroring /etc/config
+  openwrt-configurator export-config -ip 192.168.1.1 -pass mypassword -output-dir router/etc/config
+
+  # Export from an old device that only speaks legacy algorithms
+  openwrt-configurator export-config -ip 192.168.1.1 -pass mypassword \
+    -kex diffie-hellman-group14-sha1,diffie-hellman-group1-sha1 -hostkeys ssh-rsa
 `)
 	}
 
@@ -241,21 +543,65 @@ Examples:
 		fs.Usage()
 		return fmt.Errorf("required flag: -ip")
 	}
-	if *password == "" {
+	if *password == "" && *keyPath == "" {
 		fs.Usage()
-		return fmt.Errorf("required flag: -pass")
+		return fmt.Errorf("required flag: -pass or -key")
 	}
 
 	// Export configuration from device
-	fmt.Fprintf(os.Stderr, "Connecting to %s@%s...\n", *username, *ipAddr)
-	oncConfig, err := export.ExportConfig(*modelID, *ipAddr, *username, *password)
+	fmt.Fprintf(os.Stderr, "Connecting to %s@%s...\n", *username, ssh.Address(*ipAddr, *port))
+	exportOpts := export.Options{
+		NoFacts:     *noFacts,
+		Config:      *configName,
+		NoMetadata:  *noMetadata,
+		ToolVersion: version,
+		Backend:     *backend,
+
+		PrivateKeyPath:       *keyPath,
+		PrivateKeyPassphrase: *keyPassphrase,
+		Port:                 *port,
+
+		StrictHostKeyChecking: *hostKeyChecking,
+		KnownHostsFile:        *knownHosts,
+	}
+	if *defaultsFile != "" {
+		defaults, err := device.LoadSchema(*defaultsFile)
+		if err != nil {
+			return err
+		}
+		exportOpts.Defaults = defaults
+	}
+	if *ciphers != "" || *kex != "" || *hostKeys != "" {
+		exportOpts.SSHAlgorithms = &config.SSHAlgorithms{
+			Ciphers:           splitList(*ciphers),
+			KeyExchanges:      splitList(*kex),
+			HostKeyAlgorithms: splitList(*hostKeys),
+		}
+	}
+	oncConfig, err := export.ExportConfig(*modelID, *ipAddr, *username, *password, exportOpts)
 	if err != nil {
 		return fmt.Errorf("failed to export config: %w", err)
 	}
 	fmt.Fprintf(os.Stderr, "Configuration exported successfully.\n")
 
+	if *outputDir != "" {
+		paths, err := export.WriteConfigDir(*outputDir, oncConfig.Config)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			fmt.Fprintf(os.Stderr, "Configuration written to %s\n", path)
+		}
+		return nil
+	}
+
 	// Marshal to JSON with indentation
-	jsonData, err := json.MarshalIndent(oncConfig, "", "  ")
+	var jsonData []byte
+	if *canonical {
+		jsonData, err = config.Canonicalize(oncConfig)
+	} else {
+		jsonData, err = json.MarshalIndent(oncConfig, "", "  ")
+	}
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -267,18 +613,847 @@ Examples:
 		}
 		fmt.Fprintf(os.Stderr, "Configuration written to %s\n", *output)
 	} else {
-		fmt.Println(string(jsonData))
+		fmt.Println(strings.TrimSuffix(string(jsonData), "\n"))
 	}
 
 	return nil
 }
 
-func getEnabledDevices(cfg *config.ONCConfig) []config.DeviceConfig {
-	var enabled []config.DeviceConfig
-	for _, dev := range cfg.Devices {
-		if dev.Enabled == nil || *dev.Enabled {
-			enabled = append(enabled, dev)
+func validateCmd(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	jsonLines := fs.Bool("json-lines", false, "Print one JSON object per finding")
+	online := fs.Bool("online", false, "Connect to devices to check against their capabilities")
+	strict := fs.Bool("strict", false, "Treat warnings as errors")
+	ignore := fs.String("ignore", "", "Comma-separated rules whose findings are suppressed")
+	boardDB := fs.String("board-db", "", "Check ports against this board.json database file or URL")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Validate configuration without connecting to devices
+
+Usage:
+  openwrt-configurator validate [flags] <config-file>
+
+Flags:
+  -json-lines      Print one JSON object per finding
+  -online          Connect to devices to check against their capabilities
+                   (e.g. supported radio channels and htmodes)
+  -strict          Treat warnings as errors, exiting non-zero for any
+                   finding that isn't suppressed
+  -ignore string   Comma-separated rules whose findings are suppressed,
+                   e.g. zonename,section-name
+  -board-db string Check devices against the ports of their model's
+                   board.json in this file or URL. board.json has no
+                   radios, so wireless sections aren't checked.
+  -h, --help       Show help
+
+Arguments:
+  config-file   Path to the configuration JSON file
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly one argument: config-file")
+	}
+
+	oncConfig, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var findings []report.Finding
+	if *online {
+		findings = validate.ValidateConfigWithSchemas(oncConfig, device.GetDeviceSchema)
+	} else if *boardDB != "" {
+		if oncConfig.Config.Wireless != nil {
+			fmt.Fprintf(os.Stderr, "Warning: board.json has no radios, so wireless sections aren't checked against the devices.\n")
+		}
+		findings = validate.ValidateConfigWithSchemas(oncConfig, device.NewBoardDatabase(*boardDB).Schema)
+	} else {
+		findings = validate.ValidateConfig(oncConfig)
+	}
+	findings = report.Suppress(findings, splitList(*ignore))
+	if *strict {
+		findings = report.Strict(findings)
+	}
+	for _, f := range findings {
+		if *jsonLines {
+			if err := report.WriteJSONLine(os.Stdout, f); err != nil {
+				return err
+			}
+		} else {
+			fmt.Println(f)
 		}
 	}
-	return enabled
+
+	if report.HasErrors(findings) {
+		return fmt.Errorf("configuration is invalid")
+	}
+	if !*jsonLines {
+		fmt.Fprintf(os.Stderr, "Configuration is valid.\n")
+	}
+
+	return nil
+}
+
+// errDifferences is returned by diff -exit-code when a device differs from
+// the configuration, to exit 1 without an error message
+var errDifferences = errors.New("devices differ from the configuration")
+
+func diffCmd(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	jsonLines := fs.Bool("json-lines", false, "Print one JSON object per change")
+	exitCode := fs.Bool("exit-code", false, "Exit 1 if any device differs from the configuration, 0 if none do")
+	var tags tagFilters
+	fs.Var(&tags, "tag", "Only diff devices with this tag, as key=value; repeat to require several")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Show differences between configuration and devices
+
+Usage:
+  openwrt-configurator diff [flags] <config-file>
+
+Flags:
+  -json-lines       Print one JSON object per change
+  -exit-code        Exit 1 if any device differs from the configuration and 0
+                    if none do, like git diff --exit-code, e.g. to alert on
+                    drift
+  -tag key=value    Only diff devices whose tag has this value, e.g. role=ap;
+                    repeat to require several tags
+  -h, --help        Show help
+
+Arguments:
+  config-file   Path to the configuration JSON file
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly one argument: config-file")
+	}
+
+	oncConfig, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	devices, err := condition.SelectDevices(getEnabledDevices(oncConfig), tags)
+	if err != nil {
+		return err
+	}
+	logRedactor = newRedactor(oncConfig)
+
+	differing := 0
+	for _, dev := range devices {
+		if dev.IPAddr == "" || dev.ProvisioningConfig == nil {
+			fmt.Fprintf(os.Stderr, "Skipping device %s: no IP address or provisioning config\n", dev.Hostname)
+			continue
+		}
+
+		changes, err := deviceDiff(oncConfig, &dev)
+		if err != nil {
+			return err
+		}
+		changes = diff.Redact(changes, logRedactor)
+		if len(changes) > 0 {
+			differing++
+		}
+
+		if *jsonLines {
+			for _, change := range changes {
+				if err := report.WriteJSONLine(os.Stdout, change.Finding(validate.DeviceName(&dev))); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		fmt.Printf("# device %s\n", dev.Hostname)
+		if len(changes) == 0 {
+			fmt.Println("No differences.")
+		}
+		for _, change := range changes {
+			fmt.Println(change)
+		}
+	}
+
+	if *exitCode && differing > 0 {
+		return errDifferences
+	}
+	return nil
+}
+
+// deviceDiff connects to a device and compares it with its configuration,
+// replaced in tests
+var deviceDiff = func(oncConfig *config.ONCConfig, dev *config.DeviceConfig) ([]diff.Change, error) {
+	state, ahead, client, err := connectWithState(oncConfig, dev)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	return diff.Device(client, state, ahead), nil
+}
+
+func configDiffCmd(args []string) error {
+	fs := flag.NewFlagSet("config-diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Show differences between two configuration files
+
+Compares the files offline, without connecting to any device. Devices and
+named sections are matched by name, so reordering them isn't a change.
+
+Usage:
+  openwrt-configurator config-diff <old-config-file> <new-config-file>
+
+Flags:
+  -h, --help   Show help
+
+Arguments:
+  old-config-file   Path to the original configuration JSON file
+  new-config-file   Path to the changed configuration JSON file
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly two arguments: old-config-file and new-config-file")
+	}
+
+	oldConfig, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	newConfig, err := config.Load(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	changes, err := diff.Configs(oldConfig, newConfig)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("No differences.")
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+
+	return nil
+}
+
+func driftCheckCmd(args []string) error {
+	fs := flag.NewFlagSet("drift-check", flag.ExitOnError)
+	jsonLines := fs.Bool("json-lines", false, "Print one JSON object per change")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Report devices whose config has drifted from the configuration
+
+Reads each device's current config and reports options that differ from the
+configuration or that exist on the device but aren't declared (unmanaged),
+e.g. changes made by hand through LuCI. Exits non-zero if any device drifted.
+
+Usage:
+  openwrt-configurator drift-check [flags] <config-file>
+
+Flags:
+  -json-lines   Print one JSON object per change
+  -h, --help    Show help
+
+Arguments:
+  config-file   Path to the configuration JSON file
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly one argument: config-file")
+	}
+
+	oncConfig, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	logRedactor = newRedactor(oncConfig)
+
+	drifted := 0
+	for _, dev := range getEnabledDevices(oncConfig) {
+		if dev.IPAddr == "" || dev.ProvisioningConfig == nil {
+			fmt.Fprintf(os.Stderr, "Skipping device %s: no IP address or provisioning config\n", dev.Hostname)
+			continue
+		}
+
+		state, ahead, client, err := connectWithState(oncConfig, &dev)
+		if err != nil {
+			return err
+		}
+		changes := diff.Redact(diff.Drift(client, state, ahead), logRedactor)
+		client.Close()
+
+		if len(changes) > 0 {
+			drifted++
+		}
+
+		if *jsonLines {
+			for _, change := range changes {
+				if err := report.WriteJSONLine(os.Stdout, change.Finding(validate.DeviceName(&dev))); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		fmt.Printf("%s: %s\n", validate.DeviceName(&dev), diff.Summary(changes))
+		for _, change := range changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("%d device(s) drifted", drifted)
+	}
+
+	return nil
+}
+
+func verifyFleetCmd(args []string) error {
+	fs := flag.NewFlagSet("verify-fleet", flag.ExitOnError)
+	jsonLines := fs.Bool("json-lines", false, "Print one JSON object per change or failure")
+	parallel := fs.Int("parallel", 1, "Number of devices to check at once")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Check every device in the fleet against the configuration
+
+Checks each enabled device for drift like drift-check, carrying on past
+devices that can't be reached, and prints a single pass/fail report. Exits
+non-zero unless every device is in sync, for use in CI.
+
+Usage:
+  openwrt-configurator verify-fleet [flags] <config-file>
+
+Flags:
+  -json-lines     Print one JSON object per change or failure
+  -parallel int   Number of devices to check at once (default 1)
+  -h, --help      Show help
+
+Arguments:
+  config-file   Path to the configuration JSON file
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly one argument: config-file")
+	}
+
+	oncConfig, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	logRedactor = newRedactor(oncConfig)
+	rep, err := compliance.Verify(oncConfig, compliance.Options{Parallel: *parallel, Redactor: logRedactor})
+	if err != nil {
+		return err
+	}
+
+	if *jsonLines {
+		for _, finding := range rep.Findings() {
+			if err := report.WriteJSONLine(os.Stdout, finding); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintln(os.Stderr, rep.Summary())
+	} else {
+		rep.Write(os.Stdout)
+	}
+
+	return rep.Err()
+}
+
+func collectFactsCmd(args []string) error {
+	fs := flag.NewFlagSet("collect-facts", flag.ExitOnError)
+	output := fs.String("output", "", "Output file (default: stdout)")
+	parallel := fs.Int("parallel", 1, "Number of devices to read at once")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Collect inventory facts from every device in a configuration
+
+Connects to each enabled device and reads its model, OpenWrt version,
+target, arch, MAC address, uptime, free space, radio bands and number of
+installed packages into a JSON report. Nothing on the devices is changed.
+Exits non-zero if any device couldn't be read.
+
+Usage:
+  openwrt-configurator collect-facts [flags] <config-file>
+
+Flags:
+  -output string  Output file (default: stdout)
+  -parallel int   Number of devices to read at once (default 1)
+  -h, --help      Show help
+
+Arguments:
+  config-file   Path to the configuration JSON file
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly one argument: config-file")
+	}
+
+	oncConfig, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	rep := inventory.Collect(oncConfig, inventory.Options{Parallel: *parallel})
+
+	jsonData, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal facts: %w", err)
+	}
+	if *output != "" {
+		if err := os.WriteFile(*output, jsonData, 0644); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Facts written to %s\n", *output)
+	} else {
+		fmt.Println(string(jsonData))
+	}
+
+	failed := 0
+	for _, facts := range rep.Devices {
+		if facts.Status == inventory.StatusFailed {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", facts.Device, facts.Error)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to collect facts from %d device(s)", failed)
+	}
+
+	return nil
+}
+
+// connectWithState connects to a device and resolves its intended state,
+// reading its current uci values for conditions that use them. The configs
+// the state can manage are read while the device's schema is, over the
+// same connection, for comparing with the state.
+func connectWithState(oncConfig *config.ONCConfig, dev *config.DeviceConfig) (*device.OpenWrtState, *diff.Reading, *ssh.Client, error) {
+	secrets, err := secret.NewResolver(oncConfig.Secrets)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	secrets = logRedactor.Resolver(secrets)
+
+	configs, err := device.ManagedConfigs(oncConfig)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to list managed configs: %w", err)
+	}
+
+	client, err := ssh.ConnectDevice(dev)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to connect to device %s: %w", dev.Hostname, err)
+	}
+
+	ahead := diff.ReadAhead(client, configs)
+	schema, err := device.GetDeviceSchemaFromClient(client, dev)
+	if err != nil {
+		client.Close()
+		return nil, nil, nil, fmt.Errorf("failed to get device schema for %s: %w", dev.ModelID, err)
+	}
+
+	state, err := device.GetOpenWrtStateWithOptions(oncConfig, dev, schema, device.StateOptions{Secrets: secrets, Executor: client})
+	if err != nil {
+		client.Close()
+		return nil, nil, nil, stateError(dev, err)
+	}
+	logRedactor.Collect(state.Config)
+
+	return state, ahead, client, nil
+}
+
+// stateError describes a failure to resolve a device's state, naming the
+// offending condition directly when an invalid one caused it
+func stateError(dev *config.DeviceConfig, err error) error {
+	var conditionErr *condition.Error
+	if errors.As(err, &conditionErr) {
+		return fmt.Errorf("device %s: %w", dev.Hostname, conditionErr)
+	}
+	return fmt.Errorf("failed to get state for device %s: %w", dev.Hostname, err)
+}
+
+func topologyCmd(args []string) error {
+	fs := flag.NewFlagSet("topology", flag.ExitOnError)
+	format := fs.String("format", "dot", "Output format: dot or mermaid")
+	output := fs.String("output", "", "Output file (default: stdout)")
+	hostname := fs.String("device", "", "Only draw the device with this hostname")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Draw the network topology of each device
+
+Usage:
+  openwrt-configurator topology [flags] <config-file>
+
+Flags:
+  -format string   Output format: dot (Graphviz) or mermaid (default "dot")
+  -output string   Output file (default: stdout)
+  -device string   Only draw the device with this hostname
+  -h, --help       Show help
+
+Arguments:
+  config-file   Path to the configuration JSON file
+
+Examples:
+  openwrt-configurator topology ./network-config.json | dot -Tsvg > network.svg
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly one argument: config-file")
+	}
+	if *format != "dot" && *format != "mermaid" {
+		return fmt.Errorf("unknown format: %s", *format)
+	}
+
+	oncConfig, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var out strings.Builder
+	for _, dev := range getEnabledDevices(oncConfig) {
+		if *hostname != "" && dev.Hostname != *hostname {
+			continue
+		}
+
+		// Topology is drawn from the config alone, without connecting
+		schema := &device.DeviceSchema{Name: dev.ModelID}
+		state, err := device.GetOpenWrtState(oncConfig, &dev, schema)
+		if err != nil {
+			return fmt.Errorf("failed to get state for device %s: %w", dev.Hostname, err)
+		}
+		cfg, err := device.DecodeConfig(state.Config)
+		if err != nil {
+			return fmt.Errorf("failed to decode config for device %s: %w", dev.Hostname, err)
+		}
+
+		graph := topology.Build(cfg)
+		if *format == "mermaid" {
+			fmt.Fprintf(&out, "%%%% device %s\n", validate.DeviceName(&dev))
+			out.WriteString(graph.Mermaid())
+		} else {
+			out.WriteString(graph.DOT(validate.DeviceName(&dev)))
+		}
+	}
+
+	if *output != "" {
+		if err := os.WriteFile(*output, []byte(out.String()), 0644); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Topology written to %s\n", *output)
+	} else {
+		fmt.Print(out.String())
+	}
+
+	return nil
+}
+
+func getEnabledDevices(cfg *config.ONCConfig) []config.DeviceConfig {
+	var enabled []config.DeviceConfig
+	for _, dev := range cfg.Devices {
+		if dev.Enabled == nil || *dev.Enabled {
+			enabled = append(enabled, dev)
+		}
+	}
+	return enabled
+}
+
+func explainConditionCmd(args []string) error {
+	fs := flag.NewFlagSet("explain-condition", flag.ExitOnError)
+	hostname := fs.String("device", "", "Hostname of the device to evaluate against")
+	osVersion := fs.String("version", "", "OpenWrt version of the device")
+	target := fs.String("target", "", "OpenWrt target of the device, e.g. ramips/mt7621")
+	arch := fs.String("arch", "", "Package architecture of the device, e.g. mipsel_24kc")
+	swConfig := fs.Bool("sw-config", false, "Whether the device uses swconfig")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Evaluate a condition against a device for debugging
+
+Prints the value each term of the condition resolved to and the result,
+without connecting to the device.
+
+Usage:
+  openwrt-configurator explain-condition [flags] <config-file> <condition>
+
+Flags:
+  -device string    Hostname of the device to evaluate against (required
+                    when the config has more than one device)
+  -version string   OpenWrt version of the device, for device.version
+  -target string    OpenWrt target of the device, e.g. ramips/mt7621, for
+                    device.target
+  -arch string      Package architecture of the device, e.g. mipsel_24kc, for
+                    device.arch
+  -sw-config        Whether the device uses swconfig, for device.sw_config
+  -h, --help        Show help
+
+Arguments:
+  config-file   Path to the configuration JSON file
+  condition     Condition expression, e.g. 'device.tag.role == "ap"'
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly two arguments: config-file and condition")
+	}
+
+	oncConfig, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var dev *config.DeviceConfig
+	for i := range oncConfig.Devices {
+		if *hostname == "" || oncConfig.Devices[i].Hostname == *hostname {
+			if dev != nil {
+				return fmt.Errorf("config has more than one device, use -device to choose one")
+			}
+			dev = &oncConfig.Devices[i]
+		}
+	}
+	if dev == nil {
+		return fmt.Errorf("device not found: %s", *hostname)
+	}
+
+	explanation, err := condition.Explain(fs.Arg(1), &condition.ConditionContext{
+		DeviceConfig: dev,
+		DeviceSchema: &condition.DeviceSchema{SwConfig: *swConfig, Version: *osVersion, Target: *target, Arch: *arch},
+	})
+	fmt.Print(explanation)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate condition")
+	}
+
+	return nil
+}
+
+func encryptSecretsCmd(args []string) error {
+	fs := flag.NewFlagSet("encrypt-secrets", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Encrypt a secrets file into a vault
+
+Usage:
+  openwrt-configurator encrypt-secrets <secrets-file> <vault-file>
+
+The secrets file is a JSON object of secret names to values. It is encrypted
+with the base64 encoded 32 byte key in %s.
+
+Flags:
+  -h, --help   Show help
+
+Examples:
+  export %s=$(head -c 32 /dev/urandom | base64)
+  openwrt-configurator encrypt-secrets ./secrets.json ./secrets.vault
+`, secret.KeyEnv, secret.KeyEnv)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly two arguments: secrets-file and vault-file")
+	}
+
+	key, err := secret.KeyFromEnv()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return fmt.Errorf("failed to parse secrets file: %w", err)
+	}
+
+	vault, err := secret.EncryptVault(secrets, key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secrets: %w", err)
+	}
+
+	if err := os.WriteFile(fs.Arg(1), vault, 0o600); err != nil {
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+
+	return nil
+}
+
+func migrateDSACmd(args []string) error {
+	fs := flag.NewFlagSet("migrate-dsa", flag.ExitOnError)
+	portMap := fs.String("ports", "", "Comma-separated switch port to DSA port mappings, e.g. 1=lan1,5=wan")
+	bridge := fs.String("bridge", migrate.DefaultBridge, "Bridge device to configure the VLANs on")
+	targetVersion := fs.String("version", "", "Target OpenWrt version, checked for DSA support")
+	output := fs.String("output", "", "Output file (default: stdout)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `Convert a swconfig network config to DSA
+
+Replaces the switch and switch_vlan sections with a bridge over the mapped
+ports and a bridge-vlan section per VLAN, and moves interfaces from the
+switch VLAN devices (eth0.2) to the bridge VLAN devices (br-lan.2). Switch
+ports without a mapping, such as the CPU port, are dropped.
+
+Usage:
+  openwrt-configurator migrate-dsa [flags] <config-file>
+
+Flags:
+  -ports string     Comma-separated switch port to DSA port mappings (required)
+  -bridge string    Bridge device to configure the VLANs on (default "br-lan")
+  -version string   Target OpenWrt version, checked for DSA support
+  -output string    Output file (default: stdout)
+  -h, --help        Show help
+
+Examples:
+  openwrt-configurator migrate-dsa -ports 1=lan1,2=lan2,3=lan3,4=lan4,5=wan \
+    -version 23.05.0 ./network-config.json
+`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("requires exactly one argument: config-file")
+	}
+	if *portMap == "" {
+		fs.Usage()
+		return fmt.Errorf("required flag: -ports")
+	}
+
+	opts := migrate.DSAOptions{
+		PortMap: make(map[int]string),
+		Bridge:  *bridge,
+		Version: *targetVersion,
+	}
+	for _, mapping := range splitList(*portMap) {
+		num, name, ok := strings.Cut(mapping, "=")
+		port, err := strconv.Atoi(num)
+		if !ok || err != nil || name == "" {
+			return fmt.Errorf("invalid port mapping %q, expected <switch port>=<DSA port>", mapping)
+		}
+		opts.PortMap[port] = name
+	}
+
+	// Read the file as written, so templates and paths are kept
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var oncConfig config.ONCConfig
+	if err := json.Unmarshal(data, &oncConfig); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if oncConfig.Config.Network == nil {
+		return fmt.Errorf("config has no network config")
+	}
+
+	network, warnings, err := migrate.SwConfigToDSA(oncConfig.Config.Network, opts)
+	if err != nil {
+		return fmt.Errorf("failed to migrate network config: %w", err)
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	oncConfig.Config.Network = network
+
+	jsonData, err := json.MarshalIndent(&oncConfig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if *output != "" {
+		if err := os.WriteFile(*output, jsonData, 0644); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Configuration written to %s\n", *output)
+	} else {
+		fmt.Println(string(jsonData))
+	}
+
+	return nil
+}
+
+// hasWirelessSections reports whether a resolved config has any wireless
+// sections
+func hasWirelessSections(configs map[string]any) bool {
+	wireless, _ := configs["wireless"].(map[string]any)
+	for _, sections := range wireless {
+		if list, _ := sections.([]any); len(list) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tagFilters collects repeated -tag key=value flags
+type tagFilters []condition.TagFilter
+
+func (f *tagFilters) String() string {
+	return condition.FormatTagFilters(*f)
+}
+
+func (f *tagFilters) Set(value string) error {
+	filter, err := condition.ParseTagFilter(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, filter)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/diff"
+)
+
+func TestDiffExitCode(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(`{
+  "devices": [
+    {
+      "model_id": "ubnt,edgerouter-x",
+      "ipaddr": "192.168.1.1",
+      "hostname": "router",
+      "provisioning_config": {"ssh_auth": {"username": "root", "password": "secret"}}
+    }
+  ],
+  "config": {}
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalDeviceDiff := deviceDiff
+	defer func() { deviceDiff = originalDeviceDiff }()
+
+	// A device that differs exits 1, reported as errDifferences
+	deviceDiff = func(*config.ONCConfig, *config.DeviceConfig) ([]diff.Change, error) {
+		return []diff.Change{{Kind: diff.KindChange, Config: "system", Section: "@system[0]", Option: "hostname", Old: "OpenWrt", New: "router"}}, nil
+	}
+	if err := diffCmd([]string{"-exit-code", configFile}); !errors.Is(err, errDifferences) {
+		t.Errorf("Expected errDifferences, got %v", err)
+	}
+	// Without the flag differences aren't an error
+	if err := diffCmd([]string{configFile}); err != nil {
+		t.Errorf("Expected no error without -exit-code, got %v", err)
+	}
+
+	// A device in sync exits 0
+	deviceDiff = func(*config.ONCConfig, *config.DeviceConfig) ([]diff.Change, error) {
+		return nil, nil
+	}
+	if err := diffCmd([]string{"-exit-code", configFile}); err != nil {
+		t.Errorf("Expected no error for a device in sync, got %v", err)
+	}
+}
+
+func TestDiffTagFilter(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(`{
+  "devices": [
+    {"model_id": "ubnt,edgerouter-x", "ipaddr": "192.168.1.1", "hostname": "router", "tags": {"role": "router"},
+     "provisioning_config": {"ssh_auth": {"username": "root", "password": "secret"}}},
+    {"model_id": "ubnt,edgerouter-x", "ipaddr": "192.168.1.2", "hostname": "ap-1", "tags": {"role": "ap", "floor": 1},
+     "provisioning_config": {"ssh_auth": {"username": "root", "password": "secret"}}},
+    {"model_id": "ubnt,edgerouter-x", "ipaddr": "192.168.1.3", "hostname": "ap-2", "tags": {"role": ["ap", "mesh"], "floor": 2},
+     "provisioning_config": {"ssh_auth": {"username": "root", "password": "secret"}}}
+  ],
+  "config": {}
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	originalDeviceDiff := deviceDiff
+	defer func() { deviceDiff = originalDeviceDiff }()
+
+	var diffed []string
+	deviceDiff = func(_ *config.ONCConfig, dev *config.DeviceConfig) ([]diff.Change, error) {
+		diffed = append(diffed, dev.Hostname)
+		return nil, nil
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-tag", "role=ap"}, "ap-1,ap-2"},
+		{[]string{"-tag", "role=ap", "-tag", "floor=2"}, "ap-2"},
+		{[]string{"-tag", "role=mesh"}, "ap-2"},
+		{nil, "router,ap-1,ap-2"},
+	}
+	for _, test := range tests {
+		diffed = nil
+		if err := diffCmd(append(test.args, configFile)); err != nil {
+			t.Fatalf("%v: diff failed: %v", test.args, err)
+		}
+		if strings.Join(diffed, ",") != test.expected {
+			t.Errorf("%v: expected %s, got %v", test.args, test.expected, diffed)
+		}
+	}
+
+	if err := diffCmd([]string{"-tag", "role=switch", configFile}); err == nil || !strings.Contains(err.Error(), "no enabled device matches role=switch") {
+		t.Errorf("Expected an error when no device matches, got %v", err)
+	}
+}
+
+func TestPrintUciCommandsBoardDBWireless(t *testing.T) {
+	dir := t.TempDir()
+	boardFile := filepath.Join(dir, "board.json")
+	if err := os.WriteFile(boardFile, []byte(`{
+  "model": {"id": "ubnt,edgerouter-x"},
+  "network": {"lan": {"ports": ["eth1", "eth2"], "protocol": "static"}, "wan": {"device": "eth0", "protocol": "dhcp"}}
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig := func(config string) string {
+		configFile := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(configFile, []byte(`{
+  "devices": [{"model_id": "ubnt,edgerouter-x", "ipaddr": "192.168.1.1", "hostname": "router"}],
+  "config": `+config+`
+}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return configFile
+	}
+
+	// Ports alone come from board.json
+	if err := printUciCommandsCmd([]string{"-board-db", boardFile, writeConfig(`{"system": {"system": [{".name": "system", "hostname": "router"}]}}`)}); err != nil {
+		t.Errorf("Expected commands without wireless sections, got %v", err)
+	}
+
+	// Radios don't, so wireless sections can't be generated
+	err := printUciCommandsCmd([]string{"-board-db", boardFile, writeConfig(`{"wireless": {"wifi-device": [{"band": "2g", "channel": "auto"}]}}`)})
+	if err == nil || !strings.Contains(err.Error(), "radios of ubnt,edgerouter-x are unknown") {
+		t.Errorf("Expected an error for wireless sections without radios, got %v", err)
+	}
+}
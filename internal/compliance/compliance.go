@@ -0,0 +1,220 @@
+package compliance
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/diff"
+	"github.com/drummonds/openwrt-configurator.git/internal/redact"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+	"github.com/drummonds/openwrt-configurator.git/internal/secret"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/validate"
+)
+
+// Device statuses
+const (
+	// StatusInSync means the device matches the configuration
+	StatusInSync = "in-sync"
+	// StatusDrifted means the device's config differs from the configuration
+	StatusDrifted = "drifted"
+	// StatusFailed means the device couldn't be checked, e.g. it was unreachable
+	StatusFailed = "failed"
+	// StatusSkipped means the device has no IP address or provisioning config
+	StatusSkipped = "skipped"
+)
+
+// getSchema and connect are replaced in tests
+var (
+	getSchema = device.GetDeviceSchemaFromClient
+	connect   = func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		return ssh.ConnectDevice(deviceConfig)
+	}
+)
+
+// Options controls how the fleet is verified
+type Options struct {
+	// Parallel is the number of devices checked at once. Zero or one checks
+	// devices one at a time.
+	Parallel int
+
+	// Redactor, when set, masks secret values in the reported changes and
+	// errors
+	Redactor *redact.Redactor
+}
+
+// DeviceResult is the outcome of checking a single device
+type DeviceResult struct {
+	Device  string
+	Status  string
+	Changes []diff.Change
+	Err     error
+}
+
+// Report is the outcome of checking every enabled device, in config order
+type Report struct {
+	Devices []DeviceResult
+}
+
+// Count returns the number of devices with the given status
+func (r *Report) Count(status string) int {
+	count := 0
+	for _, result := range r.Devices {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Passed reports whether every checked device is in sync
+func (r *Report) Passed() bool {
+	return r.Count(StatusDrifted) == 0 && r.Count(StatusFailed) == 0
+}
+
+// Err returns an error describing the failure when the report didn't pass
+func (r *Report) Err() error {
+	if r.Passed() {
+		return nil
+	}
+	return fmt.Errorf("fleet verification failed: %d device(s) drifted, %d failed",
+		r.Count(StatusDrifted), r.Count(StatusFailed))
+}
+
+// Summary summarizes the report in a single line
+func (r *Report) Summary() string {
+	result := "PASS"
+	if !r.Passed() {
+		result = "FAIL"
+	}
+	return fmt.Sprintf("%s: %d in sync, %d drifted, %d failed, %d skipped", result,
+		r.Count(StatusInSync), r.Count(StatusDrifted), r.Count(StatusFailed), r.Count(StatusSkipped))
+}
+
+// Write writes the report in human-readable form
+func (r *Report) Write(w io.Writer) {
+	for _, result := range r.Devices {
+		switch result.Status {
+		case StatusFailed:
+			fmt.Fprintf(w, "%s: failed: %v\n", result.Device, result.Err)
+		case StatusSkipped:
+			fmt.Fprintf(w, "%s: skipped: no IP address or provisioning config\n", result.Device)
+		default:
+			fmt.Fprintf(w, "%s: %s\n", result.Device, diff.Summary(result.Changes))
+			for _, change := range result.Changes {
+				fmt.Fprintf(w, "  %s\n", change)
+			}
+		}
+	}
+	fmt.Fprintln(w, r.Summary())
+}
+
+// Findings returns the report as findings, one per change and one per
+// device that couldn't be checked
+func (r *Report) Findings() []report.Finding {
+	var findings []report.Finding
+	for _, result := range r.Devices {
+		if result.Status == StatusFailed {
+			findings = append(findings, report.Finding{
+				Severity: report.SeverityError,
+				Rule:     StatusFailed,
+				Device:   result.Device,
+				Message:  result.Err.Error(),
+			})
+		}
+		for _, change := range result.Changes {
+			findings = append(findings, change.Finding(result.Device))
+		}
+	}
+	return findings
+}
+
+// Verify checks every enabled device in the fleet against the configuration,
+// reporting drift like drift-check. A device that can't be checked is
+// reported as failed rather than stopping the run.
+func Verify(oncConfig *config.ONCConfig, opts Options) (*Report, error) {
+	secrets, err := secret.NewResolver(oncConfig.Secrets)
+	if err != nil {
+		return nil, err
+	}
+	secrets = opts.Redactor.Resolver(secrets)
+
+	var devices []config.DeviceConfig
+	for _, dev := range oncConfig.Devices {
+		if dev.Enabled == nil || *dev.Enabled {
+			devices = append(devices, dev)
+		}
+	}
+
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]DeviceResult, len(devices))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := range devices {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = verifyDevice(oncConfig, &devices[i], secrets, opts.Redactor)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		results[i].Err = opts.Redactor.Error(results[i].Err)
+	}
+
+	return &Report{Devices: results}, nil
+}
+
+func verifyDevice(oncConfig *config.ONCConfig, dev *config.DeviceConfig, secrets secret.Resolver, redactor *redact.Redactor) DeviceResult {
+	result := DeviceResult{Device: validate.DeviceName(dev)}
+	if dev.IPAddr == "" || dev.ProvisioningConfig == nil {
+		result.Status = StatusSkipped
+		return result
+	}
+
+	configs, err := device.ManagedConfigs(oncConfig)
+	if err != nil {
+		result.Status, result.Err = StatusFailed, fmt.Errorf("failed to list managed configs: %w", err)
+		return result
+	}
+
+	client, err := connect(dev)
+	if err != nil {
+		result.Status, result.Err = StatusFailed, fmt.Errorf("failed to connect: %w", err)
+		return result
+	}
+	defer client.Close()
+
+	// Read the configs while the schema is read over the same connection
+	ahead := diff.ReadAhead(client, configs)
+	schema, err := getSchema(client, dev)
+	if err != nil {
+		result.Status, result.Err = StatusFailed, fmt.Errorf("failed to get device schema for %s: %w", dev.ModelID, err)
+		return result
+	}
+
+	state, err := device.GetOpenWrtStateWithOptions(oncConfig, dev, schema, device.StateOptions{Secrets: secrets, Executor: client})
+	if err != nil {
+		result.Status, result.Err = StatusFailed, fmt.Errorf("failed to get state: %w", err)
+		return result
+	}
+
+	redactor.Collect(state.Config)
+	result.Changes = diff.Redact(diff.Drift(client, state, ahead), redactor)
+	result.Status = StatusInSync
+	if len(result.Changes) > 0 {
+		result.Status = StatusDrifted
+	}
+
+	return result
+}
@@ -0,0 +1,99 @@
+package compliance
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+func TestVerify(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:            "ubnt,edgerouter-x",
+				Hostname:           "router",
+				IPAddr:             "192.168.1.1",
+				ProvisioningConfig: &config.ProvisioningConfig{},
+			},
+			{
+				ModelID:            "ubnt,edgerouter-x",
+				Hostname:           "ap",
+				IPAddr:             "192.168.1.2",
+				ProvisioningConfig: &config.ProvisioningConfig{},
+			},
+		},
+		Config: config.ConfigConfig{
+			System: &config.SystemConfig{
+				System: []config.SystemSection{
+					{
+						Name:     stringPtr("system"),
+						Hostname: stringPtr("${device.hostname}"),
+					},
+				},
+			},
+		},
+	}
+
+	schemaFn := func(_ ssh.SSHExecutor, deviceConfig *config.DeviceConfig) (*device.DeviceSchema, error) {
+		return &device.DeviceSchema{
+			Name:           deviceConfig.ModelID,
+			ConfigSections: map[string][]string{"system": {"system"}},
+		}, nil
+	}
+
+	// The router matches, the ap's hostname was changed by hand
+	stubDevices(t, schemaFn, func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		hostname := deviceConfig.Hostname
+		if hostname == "ap" {
+			hostname = "OpenWrt"
+		}
+		client := ssh.NewMockClient(deviceConfig.ModelID)
+		client.Responses["uci show system"] = "system.system=system\nsystem.system.hostname='" + hostname + "'\n"
+		return client, nil
+	})
+
+	rep, err := Verify(oncConfig, Options{Parallel: 2})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	if rep.Devices[0].Status != StatusInSync || rep.Devices[1].Status != StatusDrifted {
+		t.Errorf("Unexpected statuses: %+v", rep.Devices)
+	}
+	if rep.Passed() || rep.Err() == nil {
+		t.Error("Expected verification to fail")
+	}
+
+	var buf bytes.Buffer
+	rep.Write(&buf)
+	expected := `router: in sync
+ap: drifted (1 changes)
+  ~ system.system.hostname: 'OpenWrt' -> 'ap'
+FAIL: 1 in sync, 1 drifted, 0 failed, 0 skipped
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected report:\n%s", buf.String())
+	}
+
+	findings := rep.Findings()
+	if len(findings) != 1 || findings[0].Device != "ap" || !strings.Contains(findings[0].Message, "hostname") {
+		t.Errorf("Unexpected findings: %v", findings)
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// stubDevices replaces how device schemas are read and devices connected to
+// for the rest of the test
+func stubDevices(t *testing.T, schemaFn func(ssh.SSHExecutor, *config.DeviceConfig) (*device.DeviceSchema, error), connectFn func(*config.DeviceConfig) (ssh.SSHExecutor, error)) {
+	t.Helper()
+	originalGetSchema, originalConnect := getSchema, connect
+	t.Cleanup(func() { getSchema, connect = originalGetSchema, originalConnect })
+	getSchema, connect = schemaFn, connectFn
+}
@@ -2,6 +2,7 @@ package condition
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -12,25 +13,60 @@ import (
 type DeviceSchema struct {
 	SwConfig bool
 	Version  string
+	Target   string
+	Arch     string
 }
 
 // ConditionContext holds the context for evaluating conditions
 type ConditionContext struct {
 	DeviceConfig *config.DeviceConfig
 	DeviceSchema *DeviceSchema
+
+	// Executor, when set, reads the device's current uci values for
+	// uci.<config>.<section>.<option> terms. Without it those terms can't
+	// be evaluated.
+	Executor Executor
+
+	// uciValues caches the values read through Executor
+	uciValues map[string]any
+}
+
+// Executor runs a command on the device, e.g. an ssh.SSHExecutor
+type Executor interface {
+	Execute(command string) (string, error)
+}
+
+// Error is a condition in the config that couldn't be evaluated
+type Error struct {
+	Condition string
+	Err       error
 }
 
-// Evaluate evaluates a condition string and returns true if it matches
-func Evaluate(condition *string, ctx *ConditionContext) bool {
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid condition in config: %q: %v", e.Condition, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Evaluate evaluates a condition string and returns true if it matches. A
+// condition that can't be parsed or uses an unknown term is an *Error.
+func Evaluate(condition *string, ctx *ConditionContext) (bool, error) {
 	if condition == nil || *condition == "*" {
-		return true
+		return true, nil
 	}
 
 	// Build the LHS mapping
 	lhsMapping := buildLHSMapping(ctx)
+	addUCIValues(lhsMapping, *condition, ctx)
 
 	// Parse and evaluate the condition
-	return evaluateExpression(*condition, lhsMapping)
+	matches, err := evaluateExpression(*condition, lhsMapping)
+	if err != nil {
+		return false, &Error{Condition: *condition, Err: err}
+	}
+	return matches, nil
 }
 
 func buildLHSMapping(ctx *ConditionContext) map[string]interface{} {
@@ -42,6 +78,8 @@ func buildLHSMapping(ctx *ConditionContext) map[string]interface{} {
 	mapping["device.ipaddr"] = ctx.DeviceConfig.IPAddr
 	mapping["device.model_id"] = ctx.DeviceConfig.ModelID
 	mapping["device.version"] = ctx.DeviceSchema.Version
+	mapping["device.target"] = ctx.DeviceSchema.Target
+	mapping["device.arch"] = ctx.DeviceSchema.Arch
 
 	// Add device tags
 	for tagKey, tagValue := range ctx.DeviceConfig.Tags {
@@ -51,94 +89,289 @@ func buildLHSMapping(ctx *ConditionContext) map[string]interface{} {
 	return mapping
 }
 
-func evaluateExpression(expr string, lhsMapping map[string]interface{}) bool {
-	// Split by OR (||)
-	orParts := splitByOperator(expr, "||")
+// addUCIValues adds the uci.* terms the condition references to the
+// mapping, reading each from the device the first time it is used
+func addUCIValues(mapping map[string]interface{}, condition string, ctx *ConditionContext) {
+	if ctx.Executor == nil {
+		return
+	}
 
-	for _, orPart := range orParts {
-		// Split by AND (&&)
-		andParts := splitByOperator(orPart, "&&")
+	for _, comparison := range conditionComparisons(condition) {
+		lhs := conditionLHS(comparison)
+		key, ok := strings.CutPrefix(lhs, "uci.")
+		if !ok || !isUCIKey(key) {
+			continue
+		}
 
-		allTrue := true
-		for _, andPart := range andParts {
-			if !evaluateComparison(strings.TrimSpace(andPart), lhsMapping) {
-				allTrue = false
-				break
+		if ctx.uciValues == nil {
+			ctx.uciValues = make(map[string]any)
+		}
+		value, ok := ctx.uciValues[key]
+		if !ok {
+			// Options that aren't set compare unequal to every value
+			output, err := ctx.Executor.Execute(fmt.Sprintf("uci -q get %s", key))
+			if err == nil {
+				value = strings.TrimSpace(output)
 			}
+			ctx.uciValues[key] = value
 		}
+		mapping[lhs] = value
+	}
+}
 
-		if allTrue {
-			return true
+// isUCIKey reports whether key is a config.section.option key that is safe
+// to pass to uci get
+func isUCIKey(key string) bool {
+	if strings.Count(key, ".") != 2 {
+		return false
+	}
+	for _, c := range key {
+		if !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_.-@[]", c) {
+			return false
 		}
 	}
+	return true
+}
 
-	return false
+// evaluateExpression evaluates a condition of comparisons joined by && and
+// ||, where && binds tighter than || and parentheses group
+func evaluateExpression(expr string, lhsMapping map[string]interface{}) (bool, error) {
+	node, err := parseCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	return node.evaluate(lhsMapping)
 }
 
-func splitByOperator(expr string, operator string) []string {
-	var parts []string
-	var current strings.Builder
-	inQuotes := false
+// conditionNode is a parsed condition, or a part of one
+type conditionNode interface {
+	evaluate(lhsMapping map[string]interface{}) (bool, error)
+}
 
-	i := 0
-	for i < len(expr) {
-		if expr[i] == '\'' || expr[i] == '"' {
-			inQuotes = !inQuotes
-			current.WriteByte(expr[i])
-			i++
-			continue
+// orNode matches when any of its parts match, evaluated left to right
+type orNode []conditionNode
+
+func (n orNode) evaluate(lhsMapping map[string]interface{}) (bool, error) {
+	for _, part := range n {
+		if matches, err := part.evaluate(lhsMapping); err != nil || matches {
+			return matches, err
 		}
+	}
+	return false, nil
+}
 
-		if !inQuotes && i+len(operator) <= len(expr) && expr[i:i+len(operator)] == operator {
-			parts = append(parts, current.String())
-			current.Reset()
-			i += len(operator)
-			continue
+// andNode matches when all of its parts match, evaluated left to right
+type andNode []conditionNode
+
+func (n andNode) evaluate(lhsMapping map[string]interface{}) (bool, error) {
+	for _, part := range n {
+		if matches, err := part.evaluate(lhsMapping); err != nil || !matches {
+			return false, err
 		}
+	}
+	return true, nil
+}
 
-		current.WriteByte(expr[i])
-		i++
+// comparisonNode is a single comparison, e.g. == or in
+type comparisonNode string
+
+func (n comparisonNode) evaluate(lhsMapping map[string]interface{}) (bool, error) {
+	return evaluateComparison(string(n), lhsMapping)
+}
+
+// conditionParser is a recursive descent parser over the tokens of a
+// condition. The first error stops it.
+type conditionParser struct {
+	tokens []string
+	pos    int
+	err    error
+}
+
+// parseCondition parses a condition
+func parseCondition(expr string) (conditionNode, error) {
+	p := &conditionParser{tokens: tokenizeCondition(expr)}
+	node := p.parseOr()
+	if p.err == nil && p.pos < len(p.tokens) {
+		p.fail(fmt.Sprintf("unexpected %s", p.tokens[p.pos]))
 	}
+	if p.err != nil {
+		return nil, fmt.Errorf("Unable to parse condition: %s: %w", expr, p.err)
+	}
+	return node, nil
+}
 
-	if current.Len() > 0 {
-		parts = append(parts, current.String())
+func (p *conditionParser) parseOr() conditionNode {
+	parts := orNode{p.parseAnd()}
+	for p.err == nil && p.peek() == "||" {
+		p.pos++
+		parts = append(parts, p.parseAnd())
+	}
+	if len(parts) == 1 {
+		return parts[0]
 	}
+	return parts
+}
 
+func (p *conditionParser) parseAnd() conditionNode {
+	parts := andNode{p.parseTerm()}
+	for p.err == nil && p.peek() == "&&" {
+		p.pos++
+		parts = append(parts, p.parseTerm())
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
 	return parts
 }
 
-func evaluateComparison(expr string, lhsMapping map[string]interface{}) bool {
-	expr = strings.TrimSpace(expr)
+// parseTerm parses a comparison or a parenthesized group
+func (p *conditionParser) parseTerm() conditionNode {
+	if p.err != nil {
+		return nil
+	}
+
+	token := p.peek()
+	switch token {
+	case "":
+		p.fail("expected a comparison at the end")
+		return nil
+	case "&&", "||", ")":
+		p.fail(fmt.Sprintf("expected a comparison before %s", token))
+		return nil
+	case "(":
+		p.pos++
+		node := p.parseOr()
+		if p.err == nil && p.peek() != ")" {
+			p.fail("missing )")
+		}
+		p.pos++
+		return node
+	}
+	p.pos++
+	return comparisonNode(token)
+}
 
-	// Try to split by ==
-	if parts := splitComparison(expr, "=="); len(parts) == 2 {
-		lhs := strings.TrimSpace(parts[0])
-		rhs := strings.TrimSpace(parts[1])
+// peek returns the next token, or "" at the end
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
 
-		lhsValue, ok := lhsMapping[lhs]
-		if !ok {
-			panic(fmt.Sprintf("Invalid conditional parameter: %s", lhs))
+// fail records the first parse error
+func (p *conditionParser) fail(reason string) {
+	if p.err == nil {
+		p.err = errors.New(reason)
+	}
+}
+
+// tokenizeCondition splits a condition into parentheses, && and ||, and the
+// comparisons between them. Operators and parentheses inside quoted strings
+// are part of the comparison.
+func tokenizeCondition(expr string) []string {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if comparison := strings.TrimSpace(current.String()); comparison != "" {
+			tokens = append(tokens, comparison)
+		}
+		current.Reset()
+	}
+
+	quoteChar := byte(0)
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case quoteChar != 0:
+			if c == quoteChar {
+				quoteChar = 0
+			}
+		case c == '\'' || c == '"':
+			quoteChar = c
+		case c == '(' || c == ')':
+			flush()
+			tokens = append(tokens, string(c))
+			continue
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			flush()
+			tokens = append(tokens, expr[i:i+2])
+			i++
+			continue
+		}
+		current.WriteByte(expr[i])
+	}
+	flush()
+
+	return tokens
+}
+
+// conditionComparisons returns the comparisons of a condition in the order
+// they appear
+func conditionComparisons(expr string) []string {
+	var comparisons []string
+	for _, token := range tokenizeCondition(expr) {
+		switch token {
+		case "(", ")", "&&", "||":
+		default:
+			comparisons = append(comparisons, token)
 		}
+	}
+	return comparisons
+}
+
+// comparisonOperators are tried in order, so >= is found before >
+var comparisonOperators = []string{"==", "!=", ">=", "<=", ">", "<"}
+
+// inOperator tests list membership with the term on the right, e.g.
+// 'guest' in device.tag.networks. It is tried after the other operators.
+const inOperator = "in"
 
-		rhsValue := parseValue(rhs)
-		return compareValues(lhsValue, rhsValue, true)
+// parseComparison splits a single comparison into its term, operator and
+// value, or returns false when it has no operator
+func parseComparison(expr string) (term, operator, value string, ok bool) {
+	expr = strings.TrimSpace(expr)
+	for _, operator := range comparisonOperators {
+		if parts := splitComparison(expr, operator); len(parts) == 2 {
+			return strings.TrimSpace(parts[0]), operator, strings.TrimSpace(parts[1]), true
+		}
+	}
+	if parts := splitComparison(expr, " "+inOperator+" "); len(parts) == 2 {
+		return strings.TrimSpace(parts[1]), inOperator, strings.TrimSpace(parts[0]), true
 	}
+	return "", "", "", false
+}
 
-	// Try to split by !=
-	if parts := splitComparison(expr, "!="); len(parts) == 2 {
-		lhs := strings.TrimSpace(parts[0])
-		rhs := strings.TrimSpace(parts[1])
+func evaluateComparison(expr string, lhsMapping map[string]interface{}) (bool, error) {
+	term, operator, value, ok := parseComparison(expr)
+	if !ok {
+		return false, fmt.Errorf("Unable to parse condition: %s", strings.TrimSpace(expr))
+	}
 
-		lhsValue, ok := lhsMapping[lhs]
-		if !ok {
-			panic(fmt.Sprintf("Invalid conditional parameter: %s", lhs))
+	termValue, ok := lhsMapping[term]
+	if !ok {
+		// A device without the tag has nothing in it
+		if operator == inOperator && strings.HasPrefix(term, "device.tag.") {
+			return false, nil
 		}
+		return false, errors.New(invalidParameter(term))
+	}
 
-		rhsValue := parseValue(rhs)
-		return compareValues(lhsValue, rhsValue, false)
+	switch operator {
+	case "==", "!=":
+		return compareValues(termValue, parseValue(value), operator == "=="), nil
+	case inOperator:
+		return compareValues(termValue, parseValue(value), true), nil
 	}
+	return compareOrdered(termValue, operator, unquote(value))
+}
 
-	panic(fmt.Sprintf("Unable to parse condition: %s", expr))
+// invalidParameter returns the message for a left-hand side term that isn't
+// a conditional parameter
+func invalidParameter(lhs string) string {
+	if strings.HasPrefix(lhs, "uci.") {
+		return fmt.Sprintf("Invalid conditional parameter: %s (uci values are only read when connected to the device)", lhs)
+	}
+	return fmt.Sprintf("Invalid conditional parameter: %s", lhs)
 }
 
 func splitComparison(expr string, operator string) []string {
@@ -165,14 +398,17 @@ func splitComparison(expr string, operator string) []string {
 	return []string{expr}
 }
 
-func parseValue(s string) interface{} {
+// unquote removes the quotes around a value
+func unquote(s string) string {
 	s = strings.TrimSpace(s)
-
-	// Remove quotes
-	if (strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'")) ||
-		(strings.HasPrefix(s, "\"") && strings.HasSuffix(s, "\"")) {
-		s = s[1 : len(s)-1]
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
 	}
+	return s
+}
+
+func parseValue(s string) interface{} {
+	s = unquote(s)
 
 	// Try to parse as JSON for booleans, numbers, etc.
 	var value interface{}
@@ -0,0 +1,222 @@
+package condition
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+func TestEvaluateUCIValues(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci -q get network.lan.proto"] = "dhcp\n"
+	mockClient.FailOnCommand = "network.wan.proto"
+
+	ctx := &ConditionContext{
+		DeviceConfig: &config.DeviceConfig{Hostname: "ap"},
+		DeviceSchema: &DeviceSchema{},
+		Executor:     mockClient,
+	}
+
+	condition := "uci.network.lan.proto == 'dhcp'"
+	if matches, err := Evaluate(&condition, ctx); err != nil || !matches {
+		t.Errorf("Expected the device's current proto to match, got %t, %v", matches, err)
+	}
+	condition = "uci.network.lan.proto == 'static' || device.hostname == 'router'"
+	if matches, err := Evaluate(&condition, ctx); err != nil || matches {
+		t.Errorf("Expected a different proto not to match, got %t, %v", matches, err)
+	}
+
+	// Values are read once per context
+	reads := 0
+	for _, command := range mockClient.GetExecutedCommands() {
+		if command == "uci -q get network.lan.proto" {
+			reads++
+		}
+	}
+	if reads != 1 {
+		t.Errorf("Expected network.lan.proto to be read once, got %d", reads)
+	}
+
+	// Options that aren't set don't match
+	condition = "uci.network.wan.proto != 'dhcp'"
+	if matches, err := Evaluate(&condition, ctx); err != nil || !matches {
+		t.Errorf("Expected an unset option not to equal dhcp, got %t, %v", matches, err)
+	}
+
+	// Without a connection uci terms can't be evaluated
+	ctx = &ConditionContext{DeviceConfig: &config.DeviceConfig{}, DeviceSchema: &DeviceSchema{}}
+	explanation, err := Explain("uci.network.lan.proto == 'dhcp'", ctx)
+	if err == nil || !strings.Contains(explanation.Err.Error(), "connected to the device") {
+		t.Errorf("Expected an error without a connection, got %v", err)
+	}
+}
+
+func TestEvaluateGrouping(t *testing.T) {
+	ctx := &ConditionContext{
+		DeviceConfig: &config.DeviceConfig{
+			Hostname: "ap (garden)",
+			Tags:     map[string]any{"role": "ap", "site": "home"},
+		},
+		DeviceSchema: &DeviceSchema{Version: "22.03.5"},
+	}
+
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		// && binds tighter than ||
+		{"device.tag.role == 'router' && device.tag.site == 'home' || device.version == '22.03.5'", true},
+		{"device.tag.role == 'router' && (device.tag.site == 'home' || device.version == '22.03.5')", false},
+		{"device.tag.role == 'ap' && (device.version == '23.05.0' || device.version == '22.03.5')", true},
+		{"device.tag.role == 'ap' && (device.version == '23.05.0' || device.version == '21.02.7')", false},
+		// Nested groups
+		{"((device.tag.role == 'ap') && (device.tag.site == 'office' || (device.version != '23.05.0' && device.tag.site == 'home')))", true},
+		{"(device.tag.role == 'router' || (device.tag.site == 'home' && (device.version == '21.02.7'))) || device.hostname == 'router'", false},
+		// Parentheses and operators inside quotes are part of the value
+		{"device.hostname == 'ap (garden)'", true},
+		{"(device.hostname == \"ap (garden)\") && device.tag.role == 'ap'", true},
+		{"device.hostname == 'ap (garden' || device.tag.role == 'x || y)'", false},
+	}
+	for _, test := range tests {
+		condition := test.condition
+		if result, err := Evaluate(&condition, ctx); err != nil || result != test.expected {
+			t.Errorf("%s: expected %t, got %t, %v", condition, test.expected, result, err)
+		}
+	}
+
+	for _, condition := range []string{
+		"(device.tag.role == 'ap'",
+		"device.tag.role == 'ap')",
+		"device.tag.role == 'ap' && ()",
+		"device.tag.role == 'ap' ||",
+	} {
+		explanation, err := Explain(condition, ctx)
+		if err == nil || !strings.Contains(explanation.Err.Error(), "Unable to parse condition") {
+			t.Errorf("%s: expected a parse error, got %v", condition, err)
+		}
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	ctx := &ConditionContext{
+		DeviceConfig: &config.DeviceConfig{Tags: map[string]any{"role": "ap"}},
+		DeviceSchema: &DeviceSchema{},
+	}
+
+	tests := map[string]string{
+		"device.tag.rol == 'ap'":                           `invalid condition in config: "device.tag.rol == 'ap'": Invalid conditional parameter: device.tag.rol`,
+		"device.tag.role = 'ap'":                           `invalid condition in config: "device.tag.role = 'ap'": Unable to parse condition: device.tag.role = 'ap'`,
+		"(device.tag.role == 'ap'":                         `invalid condition in config: "(device.tag.role == 'ap'": Unable to parse condition: (device.tag.role == 'ap': missing )`,
+		"device.tag.role == 'ap' && device.tag.x == 'foo'": "Invalid conditional parameter: device.tag.x",
+	}
+	for condition, expected := range tests {
+		matches, err := Evaluate(&condition, ctx)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error %q, got %t, %v", condition, expected, matches, err)
+		}
+		var conditionErr *Error
+		if !errors.As(err, &conditionErr) || conditionErr.Condition != condition {
+			t.Errorf("%s: expected an *Error naming the condition, got %#v", condition, err)
+		}
+	}
+
+	// Terms after a comparison that decides the result aren't evaluated
+	condition := "device.tag.role == 'ap' || device.tag.x == 'foo'"
+	if matches, err := Evaluate(&condition, ctx); err != nil || !matches {
+		t.Errorf("Expected a short-circuited match, got %t, %v", matches, err)
+	}
+}
+
+func TestEvaluateOrdering(t *testing.T) {
+	newCtx := func(osVersion string) *ConditionContext {
+		return &ConditionContext{
+			DeviceConfig: &config.DeviceConfig{Tags: map[string]any{"floor": float64(2), "releases": []any{"21.02", "22.03.5"}}},
+			DeviceSchema: &DeviceSchema{Version: osVersion},
+		}
+	}
+
+	tests := []struct {
+		osVersion string
+		condition string
+		expected  bool
+	}{
+		{"22.03.5", "device.version < '23.05.0'", true},
+		{"23.05.0", "device.version > '22.03.5'", true},
+		{"22.03.5", "device.version >= '23.05.0'", false},
+		// Partial versions compare missing components as zero
+		{"23.05.2", "device.version >= '23.05'", true},
+		{"23.05.0", "device.version <= '23.05'", true},
+		{"23.05.0", "device.version > '23.05'", false},
+		{"22.03.5", "device.version >= 22.03", true},
+		{"22.10.0", "device.version > 22.9", true},
+		{"24.10.0-rc2", "device.version >= '24.10'", true},
+		// Snapshots are newer than every release
+		{"SNAPSHOT", "device.version >= '23.05'", true},
+		{"SNAPSHOT", "device.version < '99.0'", false},
+		// Numbers compare numerically, and lists match if any item does
+		{"23.05.0", "device.tag.floor > 1 && device.tag.floor <= 2", true},
+		{"23.05.0", "device.tag.floor >= 10", false},
+		{"23.05.0", "device.tag.releases >= '22.03'", true},
+		{"23.05.0", "device.tag.releases > '22.03.5'", false},
+		{"22.03.5", "device.version >= '21.02' && device.version < '23.05'", true},
+	}
+	for _, test := range tests {
+		condition := test.condition
+		if result, err := Evaluate(&condition, newCtx(test.osVersion)); err != nil || result != test.expected {
+			t.Errorf("%s with %s: expected %t, got %t, %v", condition, test.osVersion, test.expected, result, err)
+		}
+	}
+
+	for _, condition := range []string{
+		"device.version >= 'latest'",
+		"device.tag.floor > 'ground'",
+	} {
+		if _, err := Evaluate(&condition, newCtx("23.05.0")); err == nil {
+			t.Errorf("%s: expected an error", condition)
+		}
+	}
+}
+
+func TestEvaluateIn(t *testing.T) {
+	ctx := &ConditionContext{
+		DeviceConfig: &config.DeviceConfig{
+			Hostname: "ap",
+			Tags:     map[string]any{"networks": []any{"lan", "guest"}, "role": "ap", "floor": float64(2)},
+		},
+		DeviceSchema: &DeviceSchema{},
+	}
+
+	tests := map[string]bool{
+		"'guest' in device.tag.networks": true,
+		"guest in device.tag.networks":   true,
+		"'iot' in device.tag.networks":   false,
+		// A scalar tag contains only its own value
+		"'ap' in device.tag.role":     true,
+		"'router' in device.tag.role": false,
+		"2 in device.tag.floor":       true,
+		// A device without the tag has nothing in it
+		"'guest' in device.tag.vlans": false,
+		// Quoted values may contain in
+		"'lan in' in device.tag.networks":                                false,
+		"'guest' in device.tag.networks && device.tag.role == 'ap'":      true,
+		"('iot' in device.tag.networks || 'lan' in device.tag.networks)": true,
+	}
+	for condition, expected := range tests {
+		if matches, err := Evaluate(&condition, ctx); err != nil || matches != expected {
+			t.Errorf("%s: expected %t, got %t, %v", condition, expected, matches, err)
+		}
+	}
+
+	condition := "'ap' in device.hostnme"
+	if _, err := Evaluate(&condition, ctx); err == nil || !strings.Contains(err.Error(), "Invalid conditional parameter: device.hostnme") {
+		t.Errorf("Expected an unknown term to be an error, got %v", err)
+	}
+
+	explanation, err := Explain("'guest' in device.tag.networks", ctx)
+	if err != nil || explanation.Terms[0].Name != "device.tag.networks" || !explanation.Result {
+		t.Errorf("Expected the tag to be explained, got %+v, %v", explanation, err)
+	}
+}
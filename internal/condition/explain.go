@@ -0,0 +1,83 @@
+package condition
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Term is a left-hand side term of a condition and the value it resolved to
+type Term struct {
+	Name  string
+	Value interface{}
+	// Known is false when the term isn't a conditional parameter
+	Known bool
+}
+
+// Explanation is the result of evaluating a condition, with the value each
+// of its terms resolved to
+type Explanation struct {
+	Condition string
+	Terms     []Term
+	Result    bool
+	// Err is set when the condition couldn't be evaluated
+	Err error
+}
+
+// Explain evaluates a condition like Evaluate, also returning the value each
+// left-hand side term resolved to, for debugging .if expressions
+func Explain(condition string, ctx *ConditionContext) (*Explanation, error) {
+	lhsMapping := buildLHSMapping(ctx)
+
+	explanation := &Explanation{Condition: condition}
+	seen := make(map[string]bool)
+	for _, comparison := range conditionComparisons(condition) {
+		lhs := conditionLHS(comparison)
+		if lhs == "" || seen[lhs] {
+			continue
+		}
+		seen[lhs] = true
+
+		value, ok := lhsMapping[lhs]
+		explanation.Terms = append(explanation.Terms, Term{Name: lhs, Value: value, Known: ok})
+	}
+
+	if strings.TrimSpace(condition) == "*" {
+		explanation.Result = true
+		return explanation, nil
+	}
+
+	explanation.Result, explanation.Err = evaluateExpression(condition, lhsMapping)
+
+	return explanation, explanation.Err
+}
+
+// String formats the explanation as one line per term followed by the
+// result, or the error when it couldn't be evaluated
+func (e *Explanation) String() string {
+	var b strings.Builder
+	for _, term := range e.Terms {
+		if !term.Known {
+			fmt.Fprintf(&b, "%s = <unknown>\n", term.Name)
+			continue
+		}
+		value, err := json.Marshal(term.Value)
+		if err != nil {
+			value = []byte(fmt.Sprintf("%v", term.Value))
+		}
+		fmt.Fprintf(&b, "%s = %s\n", term.Name, value)
+	}
+	if e.Err != nil {
+		fmt.Fprintf(&b, "error: %v\n", e.Err)
+	} else {
+		fmt.Fprintf(&b, "result: %t\n", e.Result)
+	}
+	return b.String()
+}
+
+// conditionLHS returns the term of a single comparison, which is on the
+// right for in
+func conditionLHS(expr string) string {
+	term, _, _, _ := parseComparison(expr)
+	return term
+}
@@ -0,0 +1,49 @@
+package condition
+
+import (
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestExplain(t *testing.T) {
+	ctx := &ConditionContext{
+		DeviceConfig: &config.DeviceConfig{
+			Hostname: "ap-garden",
+			ModelID:  "ubnt,edgerouter-x",
+			Tags: map[string]any{
+				"role":     "ap",
+				"features": []any{"guest", "outdoor"},
+			},
+		},
+		DeviceSchema: &DeviceSchema{Version: "23.05.2"},
+	}
+
+	explanation, err := Explain(`device.tag.role == "ap" && device.tag.features == "outdoor" || device.version == "21.02"`, ctx)
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+
+	expected := `device.tag.role = "ap"
+device.tag.features = ["guest","outdoor"]
+device.version = "23.05.2"
+result: true
+`
+	if explanation.String() != expected {
+		t.Errorf("Unexpected explanation:\n%s", explanation)
+	}
+
+	explanation, err = Explain(`device.tag.role != "ap"`, ctx)
+	if err != nil || explanation.Result {
+		t.Errorf("Expected false, got %v, %v", explanation, err)
+	}
+
+	// An unknown term is shown and reported rather than panicking
+	explanation, err = Explain(`device.tag.site == "home"`, ctx)
+	if err == nil {
+		t.Fatal("Expected an error for an unknown term")
+	}
+	if explanation.String() != "device.tag.site = <unknown>\nerror: Invalid conditional parameter: device.tag.site\n" {
+		t.Errorf("Unexpected explanation:\n%s", explanation)
+	}
+}
@@ -0,0 +1,86 @@
+package condition
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/version"
+)
+
+// compareOrdered evaluates lhs >=, <=, > or < rhs. Numbers compare
+// numerically and anything else as an OpenWrt release, so
+// device.version >= '23.05' matches 23.05.2 and SNAPSHOT builds are newer
+// than every release. A list matches if any of its items does.
+func compareOrdered(lhs interface{}, operator, rhs string) (bool, error) {
+	if items, ok := lhs.([]interface{}); ok {
+		for _, item := range items {
+			if matches, err := compareOrdered(item, operator, rhs); err != nil || matches {
+				return matches, err
+			}
+		}
+		return false, nil
+	}
+	if items, ok := lhs.([]string); ok {
+		for _, item := range items {
+			if matches, err := compareOrdered(item, operator, rhs); err != nil || matches {
+				return matches, err
+			}
+		}
+		return false, nil
+	}
+
+	cmp, err := orderValues(lhs, rhs)
+	if err != nil {
+		return false, err
+	}
+
+	switch operator {
+	case ">=":
+		return cmp >= 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp < 0, nil
+	}
+}
+
+// orderValues returns -1, 0 or 1 as lhs is less than, equal to or greater
+// than rhs
+func orderValues(lhs interface{}, rhs string) (int, error) {
+	switch lhs := lhs.(type) {
+	case bool, nil:
+		return 0, fmt.Errorf("can't order %v, only numbers and versions", lhs)
+	case float64, int:
+		x, _ := strconv.ParseFloat(fmt.Sprintf("%v", lhs), 64)
+		y, err := strconv.ParseFloat(rhs, 64)
+		if err != nil {
+			return 0, fmt.Errorf("can't compare the number %v with %q", lhs, rhs)
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	value := fmt.Sprintf("%v", lhs)
+	if !isVersion(value) {
+		return 0, fmt.Errorf("can't order %q, only numbers and versions", value)
+	}
+	if !isVersion(rhs) {
+		return 0, fmt.Errorf("can't compare the version %s with %q", value, rhs)
+	}
+	return version.Compare(value, rhs), nil
+}
+
+// isVersion reports whether s looks like a release, e.g. 23.05 or
+// 23.05.0-rc1, or is SNAPSHOT
+func isVersion(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.EqualFold(s, "snapshot") || (s != "" && s[0] >= '0' && s[0] <= '9')
+}
@@ -0,0 +1,91 @@
+package condition
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// TagFilter selects devices by a tag, e.g. role=ap
+type TagFilter struct {
+	Key   string
+	Value string
+}
+
+// ParseTagFilter parses a key=value tag filter
+func ParseTagFilter(s string) (TagFilter, error) {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return TagFilter{}, fmt.Errorf("invalid tag filter %q, expected key=value", s)
+	}
+	return TagFilter{Key: key, Value: strings.TrimSpace(value)}, nil
+}
+
+func (f TagFilter) String() string {
+	return f.Key + "=" + f.Value
+}
+
+// Matches reports whether the device's tag has the filter's value, or
+// contains it for a list tag, compared like device.tag.<key> == value in a
+// condition
+func (f TagFilter) Matches(dev *config.DeviceConfig) bool {
+	value, ok := dev.Tags[f.Key]
+	if !ok {
+		return false
+	}
+	return compareValues(value, parseValue(f.Value), true)
+}
+
+// MatchesTags reports whether the device matches every filter
+func MatchesTags(dev *config.DeviceConfig, filters []TagFilter) bool {
+	for _, filter := range filters {
+		if !filter.Matches(dev) {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatTagFilters formats filters as a list of key=value
+func FormatTagFilters(filters []TagFilter) string {
+	var parts []string
+	for _, filter := range filters {
+		parts = append(parts, filter.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SelectDevices returns the devices matching every tag filter, failing if
+// there are filters and no device matches them. Their dependencies on
+// devices that weren't selected are dropped.
+func SelectDevices(devices []config.DeviceConfig, filters []TagFilter) ([]config.DeviceConfig, error) {
+	if len(filters) == 0 {
+		return devices, nil
+	}
+
+	var selected []config.DeviceConfig
+	hostnames := make(map[string]bool)
+	for _, dev := range devices {
+		if MatchesTags(&dev, filters) {
+			selected = append(selected, dev)
+			hostnames[dev.Hostname] = true
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no enabled device matches %s", FormatTagFilters(filters))
+	}
+
+	for i := range selected {
+		var dependsOn []string
+		for _, dep := range selected[i].DependsOn {
+			if hostnames[dep] {
+				dependsOn = append(dependsOn, dep)
+			}
+		}
+		selected[i].DependsOn = dependsOn
+	}
+
+	return selected, nil
+}
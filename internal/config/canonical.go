@@ -0,0 +1,155 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// builtinConfigs are the configs with typed sections
+var builtinConfigs = map[string]bool{
+	"system": true, "network": true, "firewall": true,
+	"dhcp": true, "wireless": true, "dropbear": true,
+}
+
+// orderedSections are section types whose order is significant, e.g.
+// firewall rules are matched in order, so they aren't sorted
+var orderedSections = map[string]map[string]bool{
+	"firewall": {"rule": true, "redirect": true, "nat": true},
+	"network":  {"rule": true, "rule6": true},
+}
+
+// Canonicalize renders a config in a canonical form, so configs that only
+// differ in key order, section order or formatting produce identical
+// output: keys are sorted, named sections are sorted by name and the option
+// values of untyped configs are written as the strings UCI stores.
+func Canonicalize(oncConfig *ONCConfig) ([]byte, error) {
+	data, err := json.Marshal(oncConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	if configs, ok := raw["config"].(map[string]any); ok {
+		for configKey, configValue := range configs {
+			configMap, ok := configValue.(map[string]any)
+			if !ok {
+				continue
+			}
+			canonicalizeConfig(configKey, configMap)
+		}
+	}
+
+	canonical, err := json.MarshalIndent(normalizeNumbers(raw), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(canonical, '\n'), nil
+}
+
+func canonicalizeConfig(configKey string, configMap map[string]any) {
+	for sectionKey, sectionValue := range configMap {
+		sections, ok := sectionValue.([]any)
+		if !ok {
+			continue
+		}
+
+		if !builtinConfigs[configKey] {
+			for _, section := range sections {
+				if sectionMap, ok := section.(map[string]any); ok {
+					normalizeOptions(sectionMap)
+				}
+			}
+		}
+
+		if !orderedSections[configKey][sectionKey] {
+			sortSections(sections)
+		}
+	}
+}
+
+// sortSections sorts named sections by name, keeping unnamed ones after
+// them in their original order
+func sortSections(sections []any) {
+	name := func(section any) (string, bool) {
+		sectionMap, ok := section.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		name, ok := sectionMap[".name"].(string)
+		return name, ok
+	}
+
+	sort.SliceStable(sections, func(i, j int) bool {
+		a, aNamed := name(sections[i])
+		b, bNamed := name(sections[j])
+		if aNamed != bNamed {
+			return aNamed
+		}
+		return aNamed && a < b
+	})
+}
+
+// normalizeOptions writes option values as the strings UCI stores, e.g.
+// true as "1" and 1500 as "1500"
+func normalizeOptions(sectionMap map[string]any) {
+	for key, value := range sectionMap {
+		if key == ".if" || key == ".overrides" {
+			continue
+		}
+		switch v := value.(type) {
+		case []any:
+			for i, item := range v {
+				v[i] = uciString(item)
+			}
+		default:
+			sectionMap[key] = uciString(v)
+		}
+	}
+}
+
+func uciString(value any) any {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case json.Number:
+		return formatNumber(v)
+	default:
+		return value
+	}
+}
+
+// normalizeNumbers rewrites numbers in their shortest form, e.g. 1.0 as 1
+func normalizeNumbers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = normalizeNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+	case json.Number:
+		return json.Number(formatNumber(v))
+	}
+	return value
+}
+
+func formatNumber(n json.Number) string {
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return string(n)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
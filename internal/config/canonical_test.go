@@ -0,0 +1,65 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	a := `{
+  "devices": [{"model_id": "ubnt,edgerouter-x", "ipaddr": "10.0.0.1", "hostname": "router", "tags": {"site": "home", "floor": 1}}],
+  "config": {
+    "network": {
+      "interface": [
+        {".name": "wan", "proto": "dhcp"},
+        {".name": "lan", "proto": "static", "ipaddr": "10.0.0.1", "mtu": 1500}
+      ]
+    },
+    "firewall": {
+      "rule": [
+        {".name": "b", "target": "ACCEPT"},
+        {".name": "a", "target": "DROP"}
+      ]
+    },
+    "sqm": {
+      "queue": [{".name": "wan", "enabled": true, "download": 50000, "qdisc": "cake"}]
+    }
+  }
+}`
+
+	b := `{"config": {"sqm": {"queue": [{"qdisc": "cake", "download": "50000", "enabled": "1", ".name": "wan"}]},
+"firewall": {"rule": [{"target": "ACCEPT", ".name": "b"}, {"target": "DROP", ".name": "a"}]},
+"network": {"interface": [{"mtu": 1500, "ipaddr": "10.0.0.1", "proto": "static", ".name": "lan"}, {"proto": "dhcp", ".name": "wan"}]}},
+"devices": [{"tags": {"floor": 1.0, "site": "home"}, "hostname": "router", "ipaddr": "10.0.0.1", "model_id": "ubnt,edgerouter-x"}]}`
+
+	canonicalA := canonicalize(t, a)
+	canonicalB := canonicalize(t, b)
+	if canonicalA != canonicalB {
+		t.Errorf("Expected identical canonical forms:\n%s\n---\n%s", canonicalA, canonicalB)
+	}
+
+	// Firewall rules keep their order, which is significant
+	config, err := Parse([]byte(canonicalA))
+	if err != nil {
+		t.Fatalf("Canonical form doesn't parse: %v", err)
+	}
+	if *config.Config.Firewall.Rule[0].Name != "b" {
+		t.Errorf("Expected firewall rule order to be kept")
+	}
+	if *config.Config.Network.Interface[0].Name != "lan" {
+		t.Errorf("Expected interfaces sorted by name")
+	}
+}
+
+func canonicalize(t *testing.T, data string) string {
+	t.Helper()
+
+	config, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	canonical, err := Canonicalize(config)
+	if err != nil {
+		t.Fatalf("Failed to canonicalize config: %v", err)
+	}
+	return string(canonical)
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+var (
+	// uciPackagePattern matches the config names uci accepts, which are
+	// also the file names in /etc/config
+	uciPackagePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// uciTypePattern matches section types such as wifi-iface
+	uciTypePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// uciOptionPattern matches option names
+	uciOptionPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+)
+
+// NormalizeExtra checks that the extra configs are laid out like the
+// built-in ones, config -> section type -> list of sections, so their
+// commands can be generated. A section type given as a single section is
+// wrapped in a list.
+func (c *ConfigConfig) NormalizeExtra() error {
+	for _, configKey := range uci.SortedKeys(c.Extra) {
+		if !uciPackagePattern.MatchString(configKey) {
+			return fmt.Errorf("config %q: invalid config name, expected letters, digits, _ and -", configKey)
+		}
+
+		configMap, ok := c.Extra[configKey].(map[string]any)
+		if !ok {
+			return fmt.Errorf("config %s: expected an object of section types, got %s", configKey, describeJSON(c.Extra[configKey]))
+		}
+
+		for _, sectionKey := range uci.SortedKeys(configMap) {
+			value := configMap[sectionKey]
+			if strings.HasPrefix(sectionKey, ".") {
+				if err := checkConditionKey(configKey, sectionKey, value); err != nil {
+					return err
+				}
+				continue
+			}
+			if !uciTypePattern.MatchString(sectionKey) {
+				return fmt.Errorf("config %s: invalid section type %q, expected letters, digits, _ and -", configKey, sectionKey)
+			}
+
+			// A lone section is a list of one
+			if section, ok := value.(map[string]any); ok {
+				value = []any{section}
+				configMap[sectionKey] = value
+			}
+			sections, ok := value.([]any)
+			if !ok {
+				return fmt.Errorf("config %s: %s must be a list of sections, got %s", configKey, sectionKey, describeJSON(value))
+			}
+
+			for i, section := range sections {
+				where := fmt.Sprintf("config %s: %s[%d]", configKey, sectionKey, i)
+				sectionMap, ok := section.(map[string]any)
+				if !ok {
+					return fmt.Errorf("%s: expected a section object, got %s", where, describeJSON(section))
+				}
+				if err := checkExtraSection(where, sectionMap); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkExtraSection checks the options of a section of an extra config
+func checkExtraSection(where string, sectionMap map[string]any) error {
+	name, ok := sectionMap[".name"]
+	if !ok {
+		return fmt.Errorf("%s: missing .name", where)
+	}
+	if s, ok := name.(string); !ok || strings.TrimSpace(s) == "" {
+		return fmt.Errorf("%s: .name must be a non-empty string", where)
+	}
+
+	for _, key := range uci.SortedKeys(sectionMap) {
+		value := sectionMap[key]
+		switch key {
+		case ".name":
+			continue
+		case ".if", ".overrides":
+			if err := checkConditionKey(where, key, value); err != nil {
+				return err
+			}
+			for _, override := range overrideOptions(value) {
+				if err := checkExtraOptions(where, override); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(key, ".") {
+			return fmt.Errorf("%s: unknown key %s", where, key)
+		}
+	}
+
+	return checkExtraOptions(where, sectionMap)
+}
+
+// checkExtraOptions checks that options have uci names and hold a value or
+// a list of values
+func checkExtraOptions(where string, options map[string]any) error {
+	for _, key := range uci.SortedKeys(options) {
+		if strings.HasPrefix(key, ".") {
+			continue
+		}
+		// A trailing + appends to a list in overrides
+		if !uciOptionPattern.MatchString(strings.TrimSuffix(key, "+")) {
+			return fmt.Errorf("%s: invalid option name %q, expected letters, digits and _", where, key)
+		}
+
+		values, ok := options[key].([]any)
+		if !ok {
+			values = []any{options[key]}
+		}
+		for _, value := range values {
+			switch value.(type) {
+			case string, float64, bool:
+			default:
+				return fmt.Errorf("%s: option %s must be a value or a list of values, got %s", where, key, describeJSON(value))
+			}
+		}
+	}
+	return nil
+}
+
+// checkConditionKey checks the type of a .if or .overrides key
+func checkConditionKey(where, key string, value any) error {
+	switch key {
+	case ".if":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: .if must be a string, got %s", where, describeJSON(value))
+		}
+	case ".overrides":
+		overrides, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: .overrides must be a list, got %s", where, describeJSON(value))
+		}
+		for i, override := range overrides {
+			overrideMap, ok := override.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: .overrides[%d] must be an object, got %s", where, i, describeJSON(override))
+			}
+			if _, ok := overrideMap["override"].(map[string]any); !ok {
+				return fmt.Errorf("%s: .overrides[%d] has no override object", where, i)
+			}
+		}
+	}
+	return nil
+}
+
+// overrideOptions returns the option maps of a section's overrides
+func overrideOptions(value any) []map[string]any {
+	overrides, _ := value.([]any)
+	var options []map[string]any
+	for _, override := range overrides {
+		if overrideMap, ok := override.(map[string]any); ok {
+			if opts, ok := overrideMap["override"].(map[string]any); ok {
+				options = append(options, opts)
+			}
+		}
+	}
+	return options
+}
+
+// describeJSON names the JSON type of a decoded value for error messages
+func describeJSON(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// unmarshalWithExtra unmarshals a section into its typed fields, keeping
+// the options it has no field for in extra. typed is an alias of the
+// section type, so its own UnmarshalJSON isn't called again.
+func unmarshalWithExtra(data []byte, typed any, extra *map[string]any) error {
+	if err := json.Unmarshal(data, typed); err != nil {
+		return err
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	known := jsonFields(reflect.TypeOf(typed).Elem())
+	for key, value := range raw {
+		if known[key] {
+			continue
+		}
+		if *extra == nil {
+			*extra = make(map[string]any)
+		}
+		(*extra)[key] = value
+	}
+	return nil
+}
+
+// marshalWithExtra marshals a section's typed fields with its extra
+// options inlined alongside them. Typed fields win over extra options.
+func marshalWithExtra(typed any, extra map[string]any) ([]byte, error) {
+	data, err := json.Marshal(typed)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if _, ok := raw[key]; ok {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		raw[key] = encoded
+	}
+	return json.Marshal(raw)
+}
+
+// jsonFields returns the JSON names of a struct's fields
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
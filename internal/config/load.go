@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Load reads and parses a configuration file
+func Load(path string) (*ONCConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	oncConfig, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range oncConfig.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", path, warning)
+	}
+
+	// The vault is found next to the config, wherever it is run from
+	if oncConfig.Secrets != nil && oncConfig.Secrets.Vault != "" && !filepath.IsAbs(oncConfig.Secrets.Vault) {
+		oncConfig.Secrets.Vault = filepath.Join(filepath.Dir(path), oncConfig.Secrets.Vault)
+	}
+
+	return oncConfig, nil
+}
+
+// Parse parses a configuration, migrating it from older schema versions,
+// and expands its device templates into devices
+func Parse(data []byte) (*ONCConfig, error) {
+	data, warnings, err := migrate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var oncConfig ONCConfig
+	if err := json.Unmarshal(data, &oncConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	oncConfig.Warnings = warnings
+
+	if err := oncConfig.Config.NormalizeExtra(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	if err := expandDeviceTemplates(&oncConfig); err != nil {
+		return nil, err
+	}
+
+	return &oncConfig, nil
+}
+
+// expandDeviceTemplates appends a device for every row of every device
+// template, after the explicitly listed devices
+func expandDeviceTemplates(oncConfig *ONCConfig) error {
+	for i, template := range oncConfig.DeviceTemplates {
+		if len(template.Devices) == 0 {
+			return fmt.Errorf("device_templates[%d] has no devices", i)
+		}
+
+		for j, values := range template.Devices {
+			if values.Hostname == "" && values.IPAddr == "" {
+				return fmt.Errorf("device_templates[%d].devices[%d] needs a hostname or ipaddr", i, j)
+			}
+
+			dev := template.Template
+			if values.Hostname != "" {
+				dev.Hostname = values.Hostname
+			}
+			if values.IPAddr != "" {
+				dev.IPAddr = values.IPAddr
+			}
+
+			// Copy the tags so devices don't share the template's map
+			dev.Tags = make(map[string]any, len(template.Template.Tags)+len(values.Tags))
+			for key, value := range template.Template.Tags {
+				dev.Tags[key] = value
+			}
+			for key, value := range values.Tags {
+				dev.Tags[key] = value
+			}
+
+			oncConfig.Devices = append(oncConfig.Devices, dev)
+		}
+	}
+
+	oncConfig.DeviceTemplates = nil
+
+	return nil
+}
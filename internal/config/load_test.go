@@ -0,0 +1,116 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDeviceTemplates(t *testing.T) {
+	data := []byte(`{
+		"devices": [
+			{"model_id": "ubnt,edgerouter-x", "hostname": "router", "ipaddr": "10.0.0.1"}
+		],
+		"device_templates": [
+			{
+				"template": {
+					"model_id": "tplink,archer-c50-v4",
+					"tags": {"role": "ap", "floor": 0},
+					"provisioning_config": {"ssh_auth": {"username": "root", "password": "secret"}}
+				},
+				"devices": [
+					{"hostname": "ap-1", "ipaddr": "10.0.0.11", "tags": {"floor": 1}},
+					{"hostname": "ap-2", "ipaddr": "10.0.0.12", "tags": {"floor": 2}},
+					{"hostname": "ap-3", "ipaddr": "10.0.0.13"}
+				]
+			}
+		],
+		"config": {}
+	}`)
+
+	oncConfig, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if len(oncConfig.Devices) != 4 {
+		t.Fatalf("Expected 4 devices, got %d", len(oncConfig.Devices))
+	}
+	if oncConfig.DeviceTemplates != nil {
+		t.Errorf("Expected templates to be consumed, got %v", oncConfig.DeviceTemplates)
+	}
+
+	expected := []struct {
+		hostname string
+		ipAddr   string
+		floor    any
+	}{
+		{"ap-1", "10.0.0.11", float64(1)},
+		{"ap-2", "10.0.0.12", float64(2)},
+		{"ap-3", "10.0.0.13", float64(0)},
+	}
+	for i, want := range expected {
+		dev := oncConfig.Devices[i+1]
+		if dev.Hostname != want.hostname || dev.IPAddr != want.ipAddr {
+			t.Errorf("Device %d: expected %s@%s, got %s@%s", i, want.hostname, want.ipAddr, dev.Hostname, dev.IPAddr)
+		}
+		if dev.ModelID != "tplink,archer-c50-v4" || dev.ProvisioningConfig == nil {
+			t.Errorf("Device %d: expected template model and provisioning config, got %+v", i, dev)
+		}
+		if dev.Tags["role"] != "ap" || dev.Tags["floor"] != want.floor {
+			t.Errorf("Device %d: unexpected tags %v", i, dev.Tags)
+		}
+	}
+}
+
+func TestParseDeviceTemplateWithoutHost(t *testing.T) {
+	data := []byte(`{
+		"devices": [],
+		"device_templates": [
+			{"template": {"model_id": "tplink,archer-c50-v4"}, "devices": [{"tags": {"floor": 1}}]}
+		],
+		"config": {}
+	}`)
+
+	if _, err := Parse(data); err == nil {
+		t.Error("Expected error for a template row without hostname or ipaddr")
+	}
+}
+
+func TestParseExtraConfigs(t *testing.T) {
+	data := []byte(`{
+		"devices": [],
+		"config": {
+			"adblock": {
+				"adblock": {".name": "global", "adb_enabled": true, "adb_sources": ["adguard", "oisd"]}
+			}
+		}
+	}`)
+
+	oncConfig, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	// A lone section becomes a list of one
+	adblock := oncConfig.Config.Extra["adblock"].(map[string]any)
+	sections, ok := adblock["adblock"].([]any)
+	if !ok || len(sections) != 1 {
+		t.Fatalf("Expected a list of one section, got %v", adblock["adblock"])
+	}
+
+	malformed := map[string]string{
+		"not an object":     `{"adblock": ["global"]}`,
+		"section not a map": `{"adblock": {"adblock": ["global"]}}`,
+		"nested option":     `{"adblock": {"adblock": [{".name": "global", "adb_dns": {"server": "1.1.1.1"}}]}}`,
+		"numeric name":      `{"adblock": {"adblock": [{".name": 1}]}}`,
+		"missing name":      `{"adblock": {"adblock": [{"adb_enabled": true}]}}`,
+		"bad config name":   `{"ad block": {"adblock": []}}`,
+		"bad option name":   `{"adblock": {"adblock": [{".name": "global", "adb-enabled": "1"}]}}`,
+	}
+	for name, configJSON := range malformed {
+		data := []byte(`{"devices": [], "config": ` + configJSON + `}`)
+		if _, err := Parse(data); err == nil || !strings.Contains(err.Error(), "invalid config") {
+			t.Errorf("%s: expected an invalid config error, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the version of the config format this build reads.
+// Configs with an older schema_version are migrated when they are loaded.
+const SchemaVersion = 2
+
+// migrations upgrade a raw config by one schema version: migrations[i]
+// upgrades version i+1 to i+2
+var migrations = []func(raw map[string]any){
+	migrateV1,
+}
+
+// migrate upgrades a raw config to SchemaVersion. Configs without a
+// schema_version are taken to be version 1, with a warning.
+func migrate(data []byte) ([]byte, []string, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	var warnings []string
+	version := 1
+	switch value := raw["schema_version"].(type) {
+	case nil:
+		warnings = append(warnings, fmt.Sprintf("config has no schema_version, assuming 1; set \"schema_version\": %d once it is up to date", SchemaVersion))
+	case float64:
+		version = int(value)
+		if float64(version) != value || version < 1 {
+			return nil, nil, fmt.Errorf("invalid schema_version %v", value)
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid schema_version %v", value)
+	}
+	if version > SchemaVersion {
+		return nil, nil, fmt.Errorf("config schema_version %d is newer than this version supports (%d)", version, SchemaVersion)
+	}
+	if version == SchemaVersion {
+		return data, warnings, nil
+	}
+
+	for ; version < SchemaVersion; version++ {
+		migrations[version-1](raw)
+	}
+	raw["schema_version"] = SchemaVersion
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to migrate config: %w", err)
+	}
+	return migrated, warnings, nil
+}
+
+// migrateV1 renames the version 1 device fields model (now model_id) and
+// the ssh_auth user and pass (now username and password)
+func migrateV1(raw map[string]any) {
+	devices, _ := raw["devices"].([]any)
+	for _, templates := range listOf(raw["device_templates"]) {
+		if template, ok := templates["template"]; ok {
+			devices = append(devices, template)
+		}
+	}
+
+	for _, dev := range devices {
+		devMap, ok := dev.(map[string]any)
+		if !ok {
+			continue
+		}
+		renameKey(devMap, "model", "model_id")
+
+		provisioning, _ := devMap["provisioning_config"].(map[string]any)
+		if sshAuth, ok := provisioning["ssh_auth"].(map[string]any); ok {
+			renameKey(sshAuth, "user", "username")
+			renameKey(sshAuth, "pass", "password")
+		}
+	}
+}
+
+// listOf returns the objects of a JSON array, skipping other values
+func listOf(value any) []map[string]any {
+	list, _ := value.([]any)
+	var objects []map[string]any
+	for _, item := range list {
+		if object, ok := item.(map[string]any); ok {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+// renameKey moves a value from an old key to a new one, unless the new
+// key is already set
+func renameKey(object map[string]any, oldKey, newKey string) {
+	value, ok := object[oldKey]
+	if !ok {
+		return
+	}
+	delete(object, oldKey)
+	if _, ok := object[newKey]; !ok {
+		object[newKey] = value
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateV1(t *testing.T) {
+	oncConfig, err := Parse([]byte(`{
+		"devices": [
+			{
+				"model": "ubnt,edgerouter-x",
+				"hostname": "router",
+				"ipaddr": "10.0.0.1",
+				"provisioning_config": {"ssh_auth": {"user": "root", "pass": "secret"}}
+			}
+		],
+		"device_templates": [
+			{
+				"template": {"model": "tplink,archer-c50-v4"},
+				"devices": [{"hostname": "ap-1"}]
+			}
+		],
+		"config": {"system": {"system": [{".name": "system", "hostname": "router"}]}}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	if oncConfig.SchemaVersion != SchemaVersion {
+		t.Errorf("Expected schema_version %d, got %d", SchemaVersion, oncConfig.SchemaVersion)
+	}
+	if len(oncConfig.Warnings) != 1 || !strings.Contains(oncConfig.Warnings[0], "no schema_version") {
+		t.Errorf("Expected a missing schema_version warning, got %v", oncConfig.Warnings)
+	}
+
+	router := oncConfig.Devices[0]
+	if router.ModelID != "ubnt,edgerouter-x" {
+		t.Errorf("Expected model to migrate to model_id, got %q", router.ModelID)
+	}
+	if auth := router.ProvisioningConfig.SSHAuth; auth.Username != "root" || auth.Password != "secret" {
+		t.Errorf("Expected user and pass to migrate, got %+v", auth)
+	}
+	if ap := oncConfig.Devices[1]; ap.ModelID != "tplink,archer-c50-v4" {
+		t.Errorf("Expected the template's model to migrate, got %q", ap.ModelID)
+	}
+	if hostname := *oncConfig.Config.System.System[0].Hostname; hostname != "router" {
+		t.Errorf("Expected the rest of the config to be kept, got hostname %q", hostname)
+	}
+
+	// Current configs load as they are, without a warning
+	oncConfig, err = Parse([]byte(`{"schema_version": 2, "devices": [{"model_id": "ubnt,edgerouter-x", "model": "ignored"}], "config": {}}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if len(oncConfig.Warnings) != 0 || oncConfig.Devices[0].ModelID != "ubnt,edgerouter-x" {
+		t.Errorf("Expected a current config to load unchanged, got %+v", oncConfig)
+	}
+
+	// Newer versions aren't guessed at
+	if _, err := Parse([]byte(`{"schema_version": 3, "devices": [], "config": {}}`)); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected an error for a newer schema_version, got %v", err)
+	}
+}
@@ -1,13 +1,100 @@
 package config
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // ONCConfig represents the root configuration structure
 type ONCConfig struct {
+	// SchemaVersion is the version of the config format, see SchemaVersion
+	SchemaVersion int `json:"schema_version,omitempty"`
+
 	Devices           []DeviceConfig      `json:"devices"`
+	DeviceTemplates   []DeviceTemplate    `json:"device_templates,omitempty"`
 	PackageProfiles   []PackageProfile    `json:"package_profiles,omitempty"`
 	ConfigsToNotReset []ConfigsToNotReset `json:"configs_to_not_reset,omitempty"`
 	Config            ConfigConfig        `json:"config"`
+	Secrets           *SecretsConfig      `json:"secrets,omitempty"`
+	Files             []FileConfig        `json:"files,omitempty"`
+	PostCommands      []PostCommands      `json:"post_commands,omitempty"`
+	LEDSchedules      []LEDSchedule       `json:"led_schedules,omitempty"`
+	Sysctl            []SysctlConfig      `json:"sysctl,omitempty"`
+	FirewallBundles   []FirewallBundles   `json:"firewall_bundles,omitempty"`
+
+	// PreserveDHCPHosts keeps the dhcp host sections (static leases) found
+	// on a device when the dhcp config is reset, e.g. ones added in LuCI
+	PreserveDHCPHosts bool `json:"preserve_dhcp_hosts,omitempty"`
+
+	// Metadata records where an exported config came from. It is for
+	// auditing only and is ignored when provisioning.
+	Metadata *Metadata `json:"metadata,omitempty"`
+
+	// Warnings are problems found while loading the config that don't
+	// stop it being used, e.g. a missing schema_version
+	Warnings []string `json:"-"`
+}
+
+// Metadata is the provenance of an exported config
+type Metadata struct {
+	ExportedAt  string `json:"exported_at,omitempty"` // RFC 3339, UTC
+	ToolVersion string `json:"tool_version,omitempty"`
+	SourceIP    string `json:"source_ip,omitempty"`
+	ModelID     string `json:"model_id,omitempty"`
+	Version     string `json:"version,omitempty"` // OpenWrt release of the source device
+}
+
+// FileConfig is a file written to the device after the UCI config is
+// applied, for settings that aren't UCI
+type FileConfig struct {
+	If      *string `json:".if,omitempty"`
+	Path    string  `json:"path"`
+	Content string  `json:"content"`
+	// Mode is the octal file mode, e.g. 0755 (default 0644)
+	Mode string `json:"mode,omitempty"`
+}
+
+// PostCommands are shell commands run after the UCI config and files are
+// applied
+type PostCommands struct {
+	If       *string  `json:".if,omitempty"`
+	Commands []string `json:"commands"`
+}
+
+// LEDSchedule turns the device's LEDs off at night
+type LEDSchedule struct {
+	If *string `json:".if,omitempty"`
+	// Off and On are the times the LEDs are turned off and back on, as HH:MM
+	Off string `json:"off"`
+	On  string `json:"on"`
+	// LEDs are the /sys/class/leds names to turn off (default all)
+	LEDs []string `json:"leds,omitempty"`
+}
+
+// SysctlConfig sets kernel parameters, e.g. net.ipv4.ip_forward, written
+// to a file in /etc/sysctl.d so they persist across reboots
+type SysctlConfig struct {
+	If       *string           `json:".if,omitempty"`
+	Settings map[string]string `json:"settings"`
+}
+
+// FirewallBundles adds predefined sets of firewall rules, named by Bundles,
+// to the devices matching the condition
+type FirewallBundles struct {
+	If      *string  `json:".if,omitempty"`
+	Bundles []string `json:"bundles"`
+}
+
+// SecretsConfig configures where ${secret.<name>} placeholders are resolved
+// from, besides OPENWRT_SECRET_<NAME> environment variables
+type SecretsConfig struct {
+	// Vault is an encrypted secrets file, relative to the config file
+	Vault string `json:"vault,omitempty"`
+
+	// SensitiveOptions are uci options masked in output with -redact-logs,
+	// as config.section.option paths where * matches any section, in
+	// addition to the built-in ones such as wireless.*.key
+	SensitiveOptions []string `json:"sensitive_options,omitempty"`
 }
 
 // DeviceConfig represents a single device configuration
@@ -18,23 +105,97 @@ type DeviceConfig struct {
 	Hostname           string              `json:"hostname"`
 	Tags               map[string]any      `json:"tags"`
 	ProvisioningConfig *ProvisioningConfig `json:"provisioning_config,omitempty"`
+
+	// DependsOn lists the hostnames of devices that must be provisioned
+	// before this one
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// CompatibleModels lists other model ids the config may be applied to,
+	// e.g. another hardware revision, with a warning instead of an error
+	CompatibleModels []string `json:"compatible_models,omitempty"`
+
+	// RestartServices limits the services restarted after provisioning to
+	// these init.d scripts, e.g. network and dnsmasq. Empty reloads the
+	// services of the changed configs.
+	RestartServices []string `json:"restart_services,omitempty"`
+
+	// ExpectedMAC, when set, must be one of the device's hardware
+	// addresses, so a config for a reused IP address can't be applied to
+	// the wrong box
+	ExpectedMAC string `json:"expected_mac,omitempty"`
+
+	// RebootAfter reboots the device once the config is applied and waits
+	// for it to come back, for changes such as sysctls and kernel modules
+	// that only take effect after a reboot
+	RebootAfter bool `json:"reboot_after,omitempty"`
+
+	// PortRoles overrides the roles board.json gives the device's ports,
+	// e.g. {"eth0": "lan"} to use a wan port as another lan port
+	PortRoles map[string]string `json:"port_roles,omitempty"`
+}
+
+// DeviceTemplate expands into one device per row of values, each a copy of
+// the template with the row's hostname, ipaddr and tags applied
+type DeviceTemplate struct {
+	Template DeviceConfig           `json:"template"`
+	Devices  []DeviceTemplateValues `json:"devices"`
+}
+
+// DeviceTemplateValues are the per-device values of a device template. Tags
+// are merged over the template's tags.
+type DeviceTemplateValues struct {
+	Hostname string         `json:"hostname"`
+	IPAddr   string         `json:"ipaddr"`
+	Tags     map[string]any `json:"tags,omitempty"`
 }
 
 // ProvisioningConfig contains SSH authentication details
 type ProvisioningConfig struct {
 	SSHAuth SSHAuth `json:"ssh_auth"`
+
+	// Port is the device's SSH port (default 22)
+	Port int `json:"port,omitempty"`
+
+	// StrictHostKeyChecking is how the device's host key is checked against
+	// KnownHostsFile (default ~/.ssh/known_hosts): "accept-new" (the
+	// default) records unknown keys and rejects changed ones, "yes" also
+	// rejects unknown keys and "no" skips the check
+	StrictHostKeyChecking string `json:"strict_host_key_checking,omitempty"`
+	KnownHostsFile        string `json:"known_hosts_file,omitempty"`
+
+	// SSHAlgorithms overrides the SSH algorithms offered, e.g. to enable
+	// legacy ones for old Dropbear builds
+	SSHAlgorithms *SSHAlgorithms `json:"ssh_algorithms,omitempty"`
+}
+
+// SSHAlgorithms lists the SSH algorithms to offer. Each non-empty list
+// replaces the defaults, so it should include the modern algorithms too.
+type SSHAlgorithms struct {
+	Ciphers           []string `json:"ciphers,omitempty"`
+	KeyExchanges      []string `json:"key_exchanges,omitempty"`
+	HostKeyAlgorithms []string `json:"host_key_algorithms,omitempty"`
 }
 
 // SSHAuth contains SSH credentials
 type SSHAuth struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// PrivateKeyPath is a private key file to authenticate with, tried
+	// before the password. PrivateKeyPassphrase decrypts it if it is
+	// encrypted.
+	PrivateKeyPath       string `json:"private_key_path,omitempty"`
+	PrivateKeyPassphrase string `json:"private_key_passphrase,omitempty"`
 }
 
 // PackageProfile defines packages to install/uninstall based on conditions
 type PackageProfile struct {
 	If       *string  `json:".if,omitempty"`
 	Packages []string `json:"packages"`
+
+	// Priority orders installation: packages from lower priority profiles
+	// are installed first, e.g. a kmod before the tool that needs it
+	Priority int `json:"priority,omitempty"`
 }
 
 // ConfigsToNotReset defines configs that should not be reset
@@ -78,13 +239,8 @@ func (c *ConfigConfig) UnmarshalJSON(data []byte) error {
 
 	// Store any extra fields
 	c.Extra = make(map[string]any)
-	knownFields := map[string]bool{
-		"system": true, "network": true, "firewall": true,
-		"dhcp": true, "wireless": true, "dropbear": true,
-	}
-
 	for key, val := range raw {
-		if !knownFields[key] {
+		if !builtinConfigs[key] {
 			var v any
 			json.Unmarshal(val, &v)
 			c.Extra[key] = v
@@ -94,6 +250,63 @@ func (c *ConfigConfig) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON marshals the typed configs with the extra configs inlined
+// alongside them
+func (c ConfigConfig) MarshalJSON() ([]byte, error) {
+	type Alias ConfigConfig
+	data, err := json.Marshal(Alias(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	for key, value := range c.Extra {
+		if _, ok := raw[key]; ok {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		raw[key] = encoded
+	}
+
+	return json.Marshal(raw)
+}
+
+// Set stores the value of a config, in its typed field for the built-in
+// configs or in Extra otherwise
+func (c *ConfigConfig) Set(configKey string, value any) error {
+	var ok bool
+	switch configKey {
+	case "system":
+		c.System, ok = value.(*SystemConfig)
+	case "network":
+		c.Network, ok = value.(*NetworkConfig)
+	case "firewall":
+		c.Firewall, ok = value.(*FirewallConfig)
+	case "dhcp":
+		c.DHCP, ok = value.(*DHCPConfig)
+	case "wireless":
+		c.Wireless, ok = value.(*WirelessConfig)
+	case "dropbear":
+		c.Dropbear, ok = value.(*DropbearConfig)
+	default:
+		if c.Extra == nil {
+			c.Extra = make(map[string]any)
+		}
+		c.Extra[configKey] = value
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("unexpected type %T for config %s", value, configKey)
+	}
+	return nil
+}
+
 // SystemConfig contains system configuration
 type SystemConfig struct {
 	If        *string         `json:".if,omitempty"`
@@ -107,17 +320,28 @@ type SystemSection struct {
 	Hostname *string `json:"hostname,omitempty"`
 	Timezone *string `json:"timezone,omitempty"`
 	Zonename *string `json:"zonename,omitempty"`
+
+	// TTYLogin requires a password to log in on the serial console
+	TTYLogin    *bool `json:"ttylogin,omitempty"`
+	URandomSeed *bool `json:"urandom_seed,omitempty"`
+	// CompatVersion is the config compatibility version sysupgrade checks,
+	// e.g. 1.1
+	CompatVersion *string `json:"compat_version,omitempty"`
+	// Buffersize is the kernel log buffer size in KiB
+	Buffersize *int `json:"buffersize,omitempty"`
 }
 
 // NetworkConfig contains network configuration
 type NetworkConfig struct {
-	If         *string             `json:".if,omitempty"`
-	Overrides  []Override          `json:".overrides,omitempty"`
-	Interface  []InterfaceSection  `json:"interface,omitempty"`
-	Device     []DeviceSection     `json:"device,omitempty"`
-	Switch     []SwitchSection     `json:"switch,omitempty"`
-	SwitchVlan []SwitchVlanSection `json:"switch_vlan,omitempty"`
-	BridgeVlan []BridgeVlanSection `json:"bridge-vlan,omitempty"`
+	If         *string              `json:".if,omitempty"`
+	Overrides  []Override           `json:".overrides,omitempty"`
+	Interface  []InterfaceSection   `json:"interface,omitempty"`
+	Device     []DeviceSection      `json:"device,omitempty"`
+	Switch     []SwitchSection      `json:"switch,omitempty"`
+	SwitchVlan []SwitchVlanSection  `json:"switch_vlan,omitempty"`
+	BridgeVlan []BridgeVlanSection  `json:"bridge-vlan,omitempty"`
+	Rule       []NetworkRuleSection `json:"rule,omitempty"`
+	Rule6      []NetworkRuleSection `json:"rule6,omitempty"`
 }
 
 // InterfaceSection represents a network interface
@@ -126,13 +350,30 @@ type InterfaceSection struct {
 	If        *string    `json:".if,omitempty"`
 	Overrides []Override `json:".overrides,omitempty"`
 	Device    *string    `json:"device,omitempty"`
+	Ifname    *string    `json:"ifname,omitempty"` // device before OpenWrt 21.02
 	Proto     *string    `json:"proto,omitempty"`
 	IPAddr    *string    `json:"ipaddr,omitempty"`
+	IP6Addr   []string   `json:"ip6addr,omitempty"`
 	Netmask   *string    `json:"netmask,omitempty"`
 	Gateway   *string    `json:"gateway,omitempty"`
 	DNS       []string   `json:"dns,omitempty"`
 	Username  *string    `json:"username,omitempty"`
 	Password  *string    `json:"password,omitempty"`
+	MTU       *int       `json:"mtu,omitempty"`
+
+	// DHCPv6 client options, e.g. for an ISP that delegates a prefix:
+	// reqaddress is try, force or none and reqprefix is auto, no or a
+	// prefix length such as 56
+	ReqAddress *string `json:"reqaddress,omitempty"`
+	ReqPrefix  *string `json:"reqprefix,omitempty"`
+	// IP6Class lists the delegated prefix classes to assign from, and
+	// IP6IfaceID is the interface ID of the assigned addresses, e.g. ::1
+	IP6Class   []string `json:"ip6class,omitempty"`
+	IP6IfaceID *string  `json:"ip6ifaceid,omitempty"`
+
+	// Zone names a firewall zone this interface is added to during
+	// resolution. It is not emitted as a UCI option.
+	Zone *string `json:"zone,omitempty"`
 
 	// Support for additional fields
 	Extra map[string]any `json:"-"`
@@ -146,6 +387,7 @@ type DeviceSection struct {
 	DeviceName *string    `json:"name,omitempty"`
 	Type       *string    `json:"type,omitempty"`
 	Ports      []string   `json:"ports,omitempty"`
+	MTU        *int       `json:"mtu,omitempty"`
 
 	// Support for additional fields
 	Extra map[string]any `json:"-"`
@@ -175,6 +417,19 @@ type BridgeVlanSection struct {
 	Ports  []string `json:"ports,omitempty"`
 }
 
+// NetworkRuleSection represents a policy routing rule (network rule or
+// rule6), selecting the routing table for matching traffic. Not to be
+// confused with firewall rules.
+type NetworkRuleSection struct {
+	Name     *string `json:".name,omitempty"`
+	In       *string `json:"in,omitempty"`
+	Out      *string `json:"out,omitempty"`
+	Src      *string `json:"src,omitempty"`
+	Dest     *string `json:"dest,omitempty"`
+	Lookup   *string `json:"lookup,omitempty"`
+	Priority *int    `json:"priority,omitempty"`
+}
+
 // FirewallConfig contains firewall configuration
 type FirewallConfig struct {
 	If         *string             `json:".if,omitempty"`
@@ -216,13 +471,19 @@ type ForwardingSection struct {
 
 // RuleSection represents a firewall rule
 type RuleSection struct {
-	Name     *string `json:".name,omitempty"`
-	Src      *string `json:"src,omitempty"`
-	Dest     *string `json:"dest,omitempty"`
-	Proto    *string `json:"proto,omitempty"`
-	DestPort *string `json:"dest_port,omitempty"`
-	Target   *string `json:"target,omitempty"`
-	Family   *string `json:"family,omitempty"`
+	Name      *string    `json:".name,omitempty"`
+	If        *string    `json:".if,omitempty"`
+	Overrides []Override `json:".overrides,omitempty"`
+	Src       *string    `json:"src,omitempty"`
+	Dest      *string    `json:"dest,omitempty"`
+	Proto     *string    `json:"proto,omitempty"`
+	DestPort  *string    `json:"dest_port,omitempty"`
+	Target    *string    `json:"target,omitempty"`
+	Family    *string    `json:"family,omitempty"`
+
+	// Ports expands the rule into one rule per destination port or range,
+	// e.g. [80, 443] or ["8000-8100"]
+	Ports []any `json:"ports,omitempty"`
 }
 
 // DHCPConfig contains DHCP configuration
@@ -232,6 +493,7 @@ type DHCPConfig struct {
 	Dnsmasq   []DnsmasqSection `json:"dnsmasq,omitempty"`
 	DHCP      []DHCPSection    `json:"dhcp,omitempty"`
 	Odhcpd    []OdhcpdSection  `json:"odhcpd,omitempty"`
+	Host      []HostSection    `json:"host,omitempty"`
 }
 
 // DnsmasqSection represents dnsmasq configuration
@@ -240,6 +502,21 @@ type DnsmasqSection struct {
 	DomainNeeded *bool   `json:"domainneeded,omitempty"`
 	Boguspriv    *bool   `json:"boguspriv,omitempty"`
 	LocalService *bool   `json:"localservice,omitempty"`
+
+	// Support for additional fields
+	Extra map[string]any `json:"-"`
+}
+
+// UnmarshalJSON keeps the options DnsmasqSection has no field for in Extra
+func (s *DnsmasqSection) UnmarshalJSON(data []byte) error {
+	type Alias DnsmasqSection
+	return unmarshalWithExtra(data, (*Alias)(s), &s.Extra)
+}
+
+// MarshalJSON inlines Extra alongside the typed options
+func (s DnsmasqSection) MarshalJSON() ([]byte, error) {
+	type Alias DnsmasqSection
+	return marshalWithExtra(Alias(s), s.Extra)
 }
 
 // DHCPSection represents a DHCP configuration
@@ -250,6 +527,50 @@ type DHCPSection struct {
 	Limit      *int     `json:"limit,omitempty"`
 	Leasetime  *string  `json:"leasetime,omitempty"`
 	DHCPOption []string `json:"dhcp_option,omitempty"`
+	Ignore     *bool    `json:"ignore,omitempty"`
+	DHCPv4     *string  `json:"dhcpv4,omitempty"`
+
+	// Support for additional fields
+	Extra map[string]any `json:"-"`
+}
+
+// UnmarshalJSON keeps the options DHCPSection has no field for in Extra
+func (s *DHCPSection) UnmarshalJSON(data []byte) error {
+	type Alias DHCPSection
+	return unmarshalWithExtra(data, (*Alias)(s), &s.Extra)
+}
+
+// MarshalJSON inlines Extra alongside the typed options
+func (s DHCPSection) MarshalJSON() ([]byte, error) {
+	type Alias DHCPSection
+	return marshalWithExtra(Alias(s), s.Extra)
+}
+
+// HostSection represents a static DHCP lease
+type HostSection struct {
+	Name      *string    `json:".name,omitempty"`
+	If        *string    `json:".if,omitempty"`
+	Overrides []Override `json:".overrides,omitempty"`
+	HostName  *string    `json:"name,omitempty"`
+	MAC       *string    `json:"mac,omitempty"`
+	IP        *string    `json:"ip,omitempty"`
+	Leasetime *string    `json:"leasetime,omitempty"`
+	DNS       *bool      `json:"dns,omitempty"`
+
+	// Support for additional fields
+	Extra map[string]any `json:"-"`
+}
+
+// UnmarshalJSON keeps the options HostSection has no field for in Extra
+func (s *HostSection) UnmarshalJSON(data []byte) error {
+	type Alias HostSection
+	return unmarshalWithExtra(data, (*Alias)(s), &s.Extra)
+}
+
+// MarshalJSON inlines Extra alongside the typed options
+func (s HostSection) MarshalJSON() ([]byte, error) {
+	type Alias HostSection
+	return marshalWithExtra(Alias(s), s.Extra)
 }
 
 // OdhcpdSection represents odhcpd configuration
@@ -258,6 +579,21 @@ type OdhcpdSection struct {
 	Maindhcp     *bool   `json:"maindhcp,omitempty"`
 	Leasefile    *string `json:"leasefile,omitempty"`
 	Leasetrigger *string `json:"leasetrigger,omitempty"`
+
+	// Support for additional fields
+	Extra map[string]any `json:"-"`
+}
+
+// UnmarshalJSON keeps the options OdhcpdSection has no field for in Extra
+func (s *OdhcpdSection) UnmarshalJSON(data []byte) error {
+	type Alias OdhcpdSection
+	return unmarshalWithExtra(data, (*Alias)(s), &s.Extra)
+}
+
+// MarshalJSON inlines Extra alongside the typed options
+func (s OdhcpdSection) MarshalJSON() ([]byte, error) {
+	type Alias OdhcpdSection
+	return marshalWithExtra(Alias(s), s.Extra)
 }
 
 // WirelessConfig contains wireless configuration
@@ -273,6 +609,7 @@ type WifiDeviceSection struct {
 	Name     *string `json:".name,omitempty"`
 	Type     *string `json:"type,omitempty"`
 	Band     *string `json:"band,omitempty"`
+	Hwmode   *string `json:"hwmode,omitempty"` // band before OpenWrt 21.02
 	Channel  *string `json:"channel,omitempty"`
 	Htmode   *string `json:"htmode,omitempty"`
 	Disabled *bool   `json:"disabled,omitempty"`
@@ -288,6 +625,11 @@ type WifiIfaceSection struct {
 	Encryption *string `json:"encryption,omitempty"`
 	Key        *string `json:"key,omitempty"`
 	Disabled   *bool   `json:"disabled,omitempty"`
+
+	// Macfilter is disable, allow to admit only the stations in Maclist, or
+	// deny to turn them away
+	Macfilter *string  `json:"macfilter,omitempty"`
+	Maclist   []string `json:"maclist,omitempty"`
 }
 
 // DropbearConfig contains dropbear SSH configuration
@@ -311,3 +653,8 @@ type Override struct {
 	If       string         `json:".if"`
 	Override map[string]any `json:"override"`
 }
+
+// Ptr returns a pointer to v, for setting optional config fields
+func Ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,106 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/version"
+)
+
+// optionAlias describes an option OpenWrt renamed in a release
+type optionAlias struct {
+	config  string
+	section string
+	oldName string
+	newName string
+	// since is the first release using newName
+	since string
+	// values maps old values to new ones where the values changed too
+	values map[string]string
+}
+
+// optionAliases lists the renamed options that are translated to the name
+// the target device's release expects
+var optionAliases = []optionAlias{
+	{config: "network", section: "interface", oldName: "ifname", newName: "device", since: "21.02"},
+	{
+		config: "wireless", section: "wifi-device", oldName: "hwmode", newName: "band", since: "21.02",
+		values: map[string]string{"11a": "5g", "11g": "2g"},
+	},
+}
+
+// applyOptionAliases renames options in a resolved config to the names used
+// by the given OpenWrt version, so one config works across releases. Using
+// an old name on a release that renamed it is reported as a deprecation
+// warning. Nothing is renamed when the version is unknown.
+func applyOptionAliases(openWrtConfig map[string]any, deviceVersion string) []string {
+	if deviceVersion == "" {
+		return nil
+	}
+
+	var warnings []string
+	for _, alias := range optionAliases {
+		configMap, ok := openWrtConfig[alias.config].(map[string]any)
+		if !ok {
+			continue
+		}
+		sections, ok := configMap[alias.section].([]any)
+		if !ok {
+			continue
+		}
+
+		useNew := version.AtLeast(deviceVersion, alias.since)
+		for i, section := range sections {
+			sectionMap, ok := section.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			if useNew {
+				value, ok := sectionMap[alias.oldName]
+				if !ok {
+					continue
+				}
+				warnings = append(warnings, fmt.Sprintf("%s.%s: option %s is deprecated since OpenWrt %s, using %s",
+					alias.config, aliasSectionName(alias.section, i, sectionMap), alias.oldName, alias.since, alias.newName))
+				delete(sectionMap, alias.oldName)
+				if _, ok := sectionMap[alias.newName]; !ok {
+					sectionMap[alias.newName] = mapAliasValue(value, alias.values)
+				}
+			} else if value, ok := sectionMap[alias.newName]; ok {
+				delete(sectionMap, alias.newName)
+				if _, ok := sectionMap[alias.oldName]; !ok {
+					sectionMap[alias.oldName] = mapAliasValue(value, invertValues(alias.values))
+				}
+			}
+		}
+	}
+
+	sort.Strings(warnings)
+	return warnings
+}
+
+// aliasSectionName names a section in warnings, e.g. lan or @interface[0]
+func aliasSectionName(sectionKey string, i int, sectionMap map[string]any) string {
+	if name, ok := sectionMap[".name"].(string); ok {
+		return name
+	}
+	return fmt.Sprintf("@%s[%d]", sectionKey, i)
+}
+
+func mapAliasValue(value any, values map[string]string) any {
+	if s, ok := value.(string); ok {
+		if mapped, ok := values[s]; ok {
+			return mapped
+		}
+	}
+	return value
+}
+
+func invertValues(values map[string]string) map[string]string {
+	inverted := make(map[string]string, len(values))
+	for oldValue, newValue := range values {
+		inverted[newValue] = oldValue
+	}
+	return inverted
+}
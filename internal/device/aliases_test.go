@@ -0,0 +1,77 @@
+package device
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// TestOptionAliases tests that renamed options are emitted with the name
+// the device's OpenWrt version expects
+func TestOptionAliases(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Config: config.ConfigConfig{
+			Network: &config.NetworkConfig{
+				Interface: []config.InterfaceSection{
+					{Name: config.Ptr("lan"), Device: config.Ptr("br-lan")},
+					{Name: config.Ptr("wan"), Ifname: config.Ptr("eth0")},
+				},
+			},
+			Wireless: &config.WirelessConfig{
+				WifiDevice: []config.WifiDeviceSection{
+					{Name: config.Ptr("radio0"), Band: config.Ptr("2g")},
+				},
+			},
+		},
+	}
+	deviceConfig := &config.DeviceConfig{ModelID: "ubnt,edgerouter-x", Hostname: "router"}
+
+	testCases := []struct {
+		version  string
+		expected []string
+		warnings int
+	}{
+		{
+			version: "19.07.10",
+			expected: []string{
+				"uci set network.lan.ifname='br-lan'",
+				"uci set network.wan.ifname='eth0'",
+				"uci set wireless.radio0.hwmode='11g'",
+			},
+		},
+		{
+			version: "23.05.0",
+			expected: []string{
+				"uci set network.lan.device='br-lan'",
+				"uci set network.wan.device='eth0'",
+				"uci set wireless.radio0.band='2g'",
+			},
+			warnings: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			schema := &DeviceSchema{Name: deviceConfig.ModelID, Version: tc.version}
+			state, err := GetOpenWrtState(oncConfig, deviceConfig, schema)
+			if err != nil {
+				t.Fatalf("Failed to get state: %v", err)
+			}
+
+			commands, err := GetDeviceScript(state, nil)
+			if err != nil {
+				t.Fatalf("Failed to get device script: %v", err)
+			}
+			for _, cmd := range tc.expected {
+				if !slices.Contains(commands, cmd) {
+					t.Errorf("Expected command %q in %v", cmd, commands)
+				}
+			}
+
+			if len(state.Warnings) != tc.warnings {
+				t.Errorf("Expected %d warnings, got %v", tc.warnings, state.Warnings)
+			}
+		})
+	}
+}
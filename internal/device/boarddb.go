@@ -0,0 +1,210 @@
+package device
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// ModelIDPlaceholder is replaced with the model id in board database
+// sources holding one board.json per model
+const ModelIDPlaceholder = "{model_id}"
+
+// boardCacheTTL is how long a fetched board.json is used before it is
+// fetched again. A stale copy is still used when fetching fails.
+const boardCacheTTL = 24 * time.Hour
+
+// boardCacheDir returns the directory fetched board data is cached in,
+// replaced in tests
+var boardCacheDir = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "openwrt-configurator", "boards"), nil
+}
+
+var boardHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// BoardDatabase reads the board.json of models without connecting to a
+// device, so schemas can be built for devices you don't have. Its source is
+// a file or http(s) URL holding either a single board.json or an object of
+// board.json files keyed by model id, or a file or URL containing
+// {model_id}, e.g. https://example.com/boards/{model_id}.json, for one
+// board.json per model.
+type BoardDatabase struct {
+	Source string
+
+	mu      sync.Mutex
+	entries map[string]json.RawMessage
+	boards  map[string]*BoardJSON
+}
+
+// NewBoardDatabase returns a board database reading from source
+func NewBoardDatabase(source string) *BoardDatabase {
+	return &BoardDatabase{Source: source, boards: make(map[string]*BoardJSON)}
+}
+
+// Board returns the board.json of a model
+func (db *BoardDatabase) Board(modelID string) (*BoardJSON, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if board, ok := db.boards[modelID]; ok {
+		return board, nil
+	}
+
+	board, err := db.readBoard(modelID)
+	if err != nil {
+		return nil, err
+	}
+	db.boards[modelID] = board
+	return board, nil
+}
+
+func (db *BoardDatabase) readBoard(modelID string) (*BoardJSON, error) {
+	if strings.Contains(db.Source, ModelIDPlaceholder) {
+		location := expandModelID(db.Source, modelID)
+		data, err := readBoardSource(location)
+		if err != nil {
+			return nil, err
+		}
+		return parseBoardEntry(data, location)
+	}
+
+	if db.entries == nil {
+		data, err := readBoardSource(db.Source)
+		if err != nil {
+			return nil, err
+		}
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse board database %s: %w", db.Source, err)
+		}
+		// A single board.json is keyed by its own model id
+		if _, ok := entries["model"]; ok {
+			board, err := parseBoardEntry(data, db.Source)
+			if err != nil {
+				return nil, err
+			}
+			entries = map[string]json.RawMessage{board.Model.ID: data}
+		}
+		db.entries = entries
+	}
+
+	entry, ok := db.entries[modelID]
+	if !ok {
+		return nil, fmt.Errorf("model %s isn't in the board database %s", modelID, db.Source)
+	}
+	return parseBoardEntry(entry, db.Source)
+}
+
+// Schema builds the schema of a device from its model's board.json. Only
+// the ports and whether the device uses swconfig are known, so there are no
+// radios, release details or config sections to reset.
+func (db *BoardDatabase) Schema(deviceConfig *config.DeviceConfig) (*DeviceSchema, error) {
+	board, err := db.Board(deviceConfig.ModelID)
+	if err != nil {
+		return nil, err
+	}
+
+	ports, isSwConfig, err := boardPorts(board)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s in the board database", err, deviceConfig.ModelID)
+	}
+
+	return &DeviceSchema{
+		Name:     deviceConfig.ModelID,
+		SwConfig: isSwConfig,
+		Ports:    ports,
+	}, nil
+}
+
+// expandModelID puts the model id into a source, escaping it in URLs
+func expandModelID(source, modelID string) string {
+	if isURL(source) {
+		modelID = url.PathEscape(modelID)
+	}
+	return strings.ReplaceAll(source, ModelIDPlaceholder, modelID)
+}
+
+func parseBoardEntry(data []byte, location string) (*BoardJSON, error) {
+	var board BoardJSON
+	if err := json.Unmarshal(data, &board); err != nil {
+		return nil, fmt.Errorf("failed to parse board.json from %s: %w", location, err)
+	}
+	return &board, nil
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// readBoardSource reads a file, or fetches a URL through the cache
+func readBoardSource(location string) ([]byte, error) {
+	if !isURL(location) {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read board database: %w", err)
+		}
+		return data, nil
+	}
+
+	var cachePath string
+	if dir, err := boardCacheDir(); err == nil {
+		sum := sha256.Sum256([]byte(location))
+		cachePath = filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+		if info, err := os.Stat(cachePath); err == nil && time.Since(info.ModTime()) < boardCacheTTL {
+			if data, err := os.ReadFile(cachePath); err == nil {
+				return data, nil
+			}
+		}
+	}
+
+	data, fetchErr := fetchURL(location)
+	if fetchErr != nil {
+		// A stale copy is better than nothing offline
+		if cachePath != "" {
+			if data, err := os.ReadFile(cachePath); err == nil {
+				return data, nil
+			}
+		}
+		return nil, fetchErr
+	}
+
+	if cachePath != "" {
+		if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+			os.WriteFile(cachePath, data, 0644)
+		}
+	}
+	return data, nil
+}
+
+func fetchURL(location string) ([]byte, error) {
+	resp, err := boardHTTPClient.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", location, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", location, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", location, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package device
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+const testDSABoardJSON = `{
+	"model": {"id": "cudy,wr3000-v1", "name": "Cudy WR3000 v1"},
+	"network": {
+		"lan": {"ports": ["lan1", "lan2"], "protocol": "static"},
+		"wan": {"device": "wan", "protocol": "dhcp"}
+	}
+}`
+
+const testSwConfigBoardJSON = `{
+	"model": {"id": "tplink,archer-c7-v2"},
+	"switch": {"switch0": {"enable": true, "reset": true, "ports": [
+		{"num": 0, "device": "eth1"},
+		{"num": 1, "role": "wan"},
+		{"num": 2, "role": "lan"}
+	]}},
+	"network": {"lan": {"device": "eth1.1", "protocol": "static"}}
+}`
+
+func TestBoardDatabaseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "board.json")
+	if err := os.WriteFile(path, []byte(testDSABoardJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := NewBoardDatabase(path)
+	schema, err := db.Schema(&config.DeviceConfig{ModelID: "cudy,wr3000-v1"})
+	if err != nil {
+		t.Fatalf("Failed to build schema: %v", err)
+	}
+
+	if schema.Name != "cudy,wr3000-v1" || schema.SwConfig {
+		t.Errorf("Expected a DSA schema for cudy,wr3000-v1, got %+v", schema)
+	}
+	var got []string
+	for _, port := range schema.Ports {
+		got = append(got, port.Name+":"+*port.DefaultRole)
+	}
+	if strings.Join(got, " ") != "lan1:lan lan2:lan wan:wan" {
+		t.Errorf("Unexpected ports %v", got)
+	}
+
+	if _, err := db.Schema(&config.DeviceConfig{ModelID: "other,model"}); err == nil || !strings.Contains(err.Error(), "isn't in the board database") {
+		t.Errorf("Expected a missing model error, got %v", err)
+	}
+}
+
+func TestBoardDatabaseKeyedByModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "boards.json")
+	data := `{"cudy,wr3000-v1": ` + testDSABoardJSON + `, "tplink,archer-c7-v2": ` + testSwConfigBoardJSON + `}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := NewBoardDatabase(path).Schema(&config.DeviceConfig{ModelID: "tplink,archer-c7-v2"})
+	if err != nil {
+		t.Fatalf("Failed to build schema: %v", err)
+	}
+	if !schema.SwConfig || len(schema.Ports) != 3 {
+		t.Fatalf("Expected a swconfig schema with 3 ports, got %+v", schema)
+	}
+	if cpu := schema.Ports[0]; cpu.Name != "eth0" || cpu.SwConfigCPUName == nil || *cpu.SwConfigCPUName != "eth1" {
+		t.Errorf("Expected eth0 to be the CPU port on eth1, got %+v", cpu)
+	}
+}
+
+func TestBoardDatabaseURLIsCached(t *testing.T) {
+	cacheDir := t.TempDir()
+	oldCacheDir := boardCacheDir
+	boardCacheDir = func() (string, error) { return cacheDir, nil }
+	defer func() { boardCacheDir = oldCacheDir }()
+
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.EscapedPath())
+		if r.URL.Path != "/boards/cudy,wr3000-v1.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testDSABoardJSON))
+	}))
+
+	source := server.URL + "/boards/" + ModelIDPlaceholder + ".json"
+	dev := &config.DeviceConfig{ModelID: "cudy,wr3000-v1"}
+	if _, err := NewBoardDatabase(source).Schema(dev); err != nil {
+		t.Fatalf("Failed to build schema from %s: %v", source, err)
+	}
+	if _, err := NewBoardDatabase(source).Schema(&config.DeviceConfig{ModelID: "missing"}); err == nil {
+		t.Error("Expected an error for a model the server doesn't have")
+	}
+
+	// A new database reads the cached copy once the server is gone
+	server.Close()
+	schema, err := NewBoardDatabase(source).Schema(dev)
+	if err != nil {
+		t.Fatalf("Expected the cached board.json to be used, got %v", err)
+	}
+	if len(schema.Ports) != 3 {
+		t.Errorf("Expected 3 ports from the cache, got %+v", schema.Ports)
+	}
+	if len(requests) != 2 {
+		t.Errorf("Expected 2 requests, got %v", requests)
+	}
+}
@@ -0,0 +1,100 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/condition"
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// firewallBundles are the predefined firewall rule sets, by name. Each rule
+// is added as a firewall rule section named <bundle>_<key>.
+var firewallBundles = map[string][]struct {
+	key  string
+	rule map[string]any
+}{
+	// router opens what the upstream link needs and stops anything from
+	// the wan reaching the lan, even if the forward policy is relaxed
+	"router": {
+		{"allow_dhcp_renew", map[string]any{"name": "Allow-DHCP-Renew", "src": "wan", "proto": "udp", "dest_port": "68", "family": "ipv4", "target": "ACCEPT"}},
+		{"allow_ping", map[string]any{"name": "Allow-Ping", "src": "wan", "proto": "icmp", "icmp_type": "echo-request", "family": "ipv4", "target": "ACCEPT"}},
+		{"allow_dhcpv6", map[string]any{"name": "Allow-DHCPv6", "src": "wan", "proto": "udp", "dest_port": "546", "family": "ipv6", "target": "ACCEPT"}},
+		{"block_wan_to_lan", map[string]any{"name": "Block-WAN-To-LAN", "src": "wan", "dest": "lan", "proto": "all", "target": "REJECT"}},
+	},
+	// guest lets guest clients get an address and resolve names, and keeps
+	// them off the lan
+	"guest": {
+		{"allow_dhcp", map[string]any{"name": "Allow-Guest-DHCP", "src": "guest", "proto": "udp", "dest_port": "67-68", "target": "ACCEPT"}},
+		{"allow_dns", map[string]any{"name": "Allow-Guest-DNS", "src": "guest", "proto": "tcp udp", "dest_port": "53", "target": "ACCEPT"}},
+		{"block_guest_to_lan", map[string]any{"name": "Block-Guest-To-LAN", "src": "guest", "dest": "lan", "proto": "all", "target": "REJECT"}},
+	},
+}
+
+// FirewallBundleNames returns the names of the predefined firewall bundles
+func FirewallBundleNames() []string {
+	var names []string
+	for name := range firewallBundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyFirewallBundles appends the rules of the firewall bundles that apply
+// to the device after its own firewall rules. A configured rule with the
+// same section name takes precedence over the bundle's.
+func applyFirewallBundles(openWrtConfig map[string]any, oncConfig *config.ONCConfig, ctx *condition.ConditionContext) error {
+	var rules []any
+	for _, bundles := range oncConfig.FirewallBundles {
+		matches, err := condition.Evaluate(bundles.If, ctx)
+		if err != nil {
+			return err
+		}
+		if !matches {
+			continue
+		}
+
+		for _, name := range bundles.Bundles {
+			bundle, ok := firewallBundles[name]
+			if !ok {
+				return fmt.Errorf("unknown firewall bundle %s, expected one of: %s", name, strings.Join(FirewallBundleNames(), ", "))
+			}
+			for _, entry := range bundle {
+				rule := map[string]any{".name": name + "_" + entry.key}
+				for key, value := range entry.rule {
+					rule[key] = value
+				}
+				rules = append(rules, rule)
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	firewall, ok := openWrtConfig["firewall"].(map[string]any)
+	if !ok {
+		firewall = make(map[string]any)
+		openWrtConfig["firewall"] = firewall
+	}
+
+	existing, _ := firewall["rule"].([]any)
+	names := make(map[any]bool)
+	for _, rule := range existing {
+		if ruleMap, ok := rule.(map[string]any); ok && ruleMap[".name"] != nil {
+			names[ruleMap[".name"]] = true
+		}
+	}
+	for _, rule := range rules {
+		name := rule.(map[string]any)[".name"]
+		if !names[name] {
+			names[name] = true
+			existing = append(existing, rule)
+		}
+	}
+	firewall["rule"] = existing
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestFirewallBundles(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [
+			{ "model_id": "ubnt,edgerouter-x", "hostname": "router", "tags": { "role": "router" } },
+			{ "model_id": "tplink,eap245-v3", "hostname": "ap", "tags": { "role": "ap" } }
+		],
+		"firewall_bundles": [
+			{ ".if": "device.tag.role == 'router'", "bundles": ["router"] }
+		],
+		"config": {
+			"firewall": {
+				"rule": [
+					{ ".name": "allow_ssh", "src": "wan", "proto": "tcp", "dest_port": "22", "target": "ACCEPT" },
+					{ ".name": "router_allow_ping", "src": "wan", "proto": "icmp", "target": "DROP" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	// The bundle's rules follow the configured ones, which take precedence
+	var names []any
+	for _, rule := range getSections(state.Config, "firewall", "rule") {
+		names = append(names, rule[".name"])
+	}
+	expected := []any{"allow_ssh", "router_allow_ping", "router_allow_dhcp_renew", "router_allow_dhcpv6", "router_block_wan_to_lan"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected rules %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Fatalf("Expected rules %v, got %v", expected, names)
+		}
+	}
+	if rule := getSection(t, state, "firewall", "rule", "router_allow_ping"); rule["target"] != "DROP" {
+		t.Errorf("Expected the configured rule to be kept, got %v", rule)
+	}
+	if rule := getSection(t, state, "firewall", "rule", "router_block_wan_to_lan"); rule["dest"] != "lan" || rule["target"] != "REJECT" {
+		t.Errorf("Unexpected bundle rule: %v", rule)
+	}
+
+	// Devices of other roles don't get the bundle
+	state, err = GetOpenWrtState(oncConfig, &oncConfig.Devices[1], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	if rules := getSections(state.Config, "firewall", "rule"); len(rules) != 2 {
+		t.Errorf("Expected only the configured rules on the ap, got %v", rules)
+	}
+
+	// Unknown bundles are an error
+	oncConfig.FirewallBundles[0].Bundles = []string{"routr"}
+	if _, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{}); err == nil {
+		t.Error("Expected an error for an unknown bundle")
+	}
+}
@@ -0,0 +1,155 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// defaultLANAddress is OpenWrt's own default lan address
+const defaultLANAddress = "192.168.1.1"
+
+// DefaultNetworkConfig generates a minimal network config from the port
+// roles of a device schema: a static lan over the lan ports with OpenWrt's
+// default address, and DHCP wan and wan6 interfaces on the wan port. It is a
+// starting point for bootstrapping a config, to be extended as needed.
+func DefaultNetworkConfig(schema *DeviceSchema) (*config.NetworkConfig, error) {
+	var lanPorts, wanPorts []Port
+	var cpuPort *Port
+	for i, port := range schema.Ports {
+		switch {
+		case port.SwConfigCPUName != nil:
+			cpuPort = &schema.Ports[i]
+		case port.DefaultRole != nil && *port.DefaultRole == "lan":
+			lanPorts = append(lanPorts, port)
+		case port.DefaultRole != nil && *port.DefaultRole == "wan":
+			wanPorts = append(wanPorts, port)
+		}
+	}
+	if len(lanPorts) == 0 {
+		return nil, fmt.Errorf("device schema %s has no lan ports", schema.Name)
+	}
+
+	network := &config.NetworkConfig{}
+	var lanDevice, wanDevice string
+
+	if schema.SwConfig {
+		if cpuPort == nil {
+			return nil, fmt.Errorf("device schema %s has no CPU port", schema.Name)
+		}
+
+		// Put the lan and wan ports in VLANs 1 and 2, tagged on the CPU port
+		network.Switch = []config.SwitchSection{{
+			Name:       config.Ptr("switch0"),
+			SwitchName: config.Ptr("switch0"),
+			Reset:      config.Ptr(true),
+			EnableVlan: config.Ptr(true),
+		}}
+		network.SwitchVlan = append(network.SwitchVlan, switchVlan(1, lanPorts, cpuPort))
+		lanDevice = fmt.Sprintf("%s.1", *cpuPort.SwConfigCPUName)
+		if len(wanPorts) > 0 {
+			network.SwitchVlan = append(network.SwitchVlan, switchVlan(2, wanPorts, cpuPort))
+			wanDevice = fmt.Sprintf("%s.2", *cpuPort.SwConfigCPUName)
+		}
+	} else {
+		var ports []string
+		for _, port := range lanPorts {
+			ports = append(ports, port.Name)
+		}
+		network.Device = []config.DeviceSection{{
+			Name:       config.Ptr("br_lan"),
+			DeviceName: config.Ptr("br-lan"),
+			Type:       config.Ptr("bridge"),
+			Ports:      ports,
+		}}
+		lanDevice = "br-lan"
+		if len(wanPorts) > 0 {
+			wanDevice = wanPorts[0].Name
+		}
+	}
+
+	network.Interface = []config.InterfaceSection{{
+		Name:    config.Ptr("lan"),
+		Device:  config.Ptr(lanDevice),
+		Proto:   config.Ptr("static"),
+		IPAddr:  config.Ptr(defaultLANAddress),
+		Netmask: config.Ptr("255.255.255.0"),
+	}}
+	if wanDevice != "" {
+		network.Interface = append(network.Interface,
+			config.InterfaceSection{Name: config.Ptr("wan"), Device: config.Ptr(wanDevice), Proto: config.Ptr("dhcp")},
+			config.InterfaceSection{Name: config.Ptr("wan6"), Device: config.Ptr(wanDevice), Proto: config.Ptr("dhcpv6")},
+		)
+	}
+
+	return network, nil
+}
+
+// switchVlan returns a switch VLAN over the given ports, tagged on the CPU
+// port. Switch ports are numbered by their eth<N> schema names.
+func switchVlan(vlan int, ports []Port, cpuPort *Port) config.SwitchVlanSection {
+	var numbers []string
+	for _, port := range append(ports, *cpuPort) {
+		number := strings.TrimPrefix(port.Name, "eth")
+		if port.SwConfigCPUName != nil {
+			number += "t"
+		}
+		numbers = append(numbers, number)
+	}
+
+	return config.SwitchVlanSection{
+		Name:   config.Ptr(fmt.Sprintf("vlan%d", vlan)),
+		Device: config.Ptr("switch0"),
+		Vlan:   &vlan,
+		Ports:  config.Ptr(strings.Join(numbers, " ")),
+	}
+}
+
+// ApplyPortRoles returns a copy of the schema with the ports' roles
+// overridden, e.g. a wan port repurposed as a lan port. The ports must exist
+// on the device, unless its ports aren't known, and can't be the CPU port.
+func ApplyPortRoles(schema *DeviceSchema, portRoles map[string]string) (*DeviceSchema, error) {
+	if len(portRoles) == 0 {
+		return schema, nil
+	}
+
+	var names []string
+	for name := range portRoles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	overridden := *schema
+	overridden.Ports = append([]Port{}, schema.Ports...)
+	for _, name := range names {
+		role := portRoles[name]
+		if role != "lan" && role != "wan" {
+			return nil, fmt.Errorf("invalid role %q for port %s, expected lan or wan", role, name)
+		}
+		if len(schema.Ports) == 0 {
+			continue
+		}
+
+		index := -1
+		for i, port := range overridden.Ports {
+			if port.Name == name {
+				index = i
+			}
+		}
+		if index == -1 {
+			var known []string
+			for _, port := range schema.Ports {
+				known = append(known, port.Name)
+			}
+			return nil, fmt.Errorf("port_roles names unknown port %s, expected one of: %s", name, strings.Join(known, ", "))
+		}
+		if overridden.Ports[index].SwConfigCPUName != nil {
+			return nil, fmt.Errorf("port %s is the switch's CPU port and has no role", name)
+		}
+		overridden.Ports[index].DefaultRole = config.Ptr(role)
+	}
+
+	return &overridden, nil
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDefaultNetworkConfig(t *testing.T) {
+	schema, err := LoadSchema("../../deviceSchemas/ubnt,edgerouter-x.json")
+	if err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+
+	network, err := DefaultNetworkConfig(schema)
+	if err != nil {
+		t.Fatalf("DefaultNetworkConfig failed: %v", err)
+	}
+
+	data, err := json.Marshal(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"interface":[` +
+		`{".name":"lan","device":"br-lan","proto":"static","ipaddr":"192.168.1.1","netmask":"255.255.255.0"},` +
+		`{".name":"wan","device":"eth0","proto":"dhcp"},` +
+		`{".name":"wan6","device":"eth0","proto":"dhcpv6"}],` +
+		`"device":[{".name":"br_lan","name":"br-lan","type":"bridge","ports":["eth1","eth2","eth3","eth4"]}]}`
+	if string(data) != expected {
+		t.Errorf("Unexpected network config:\n%s", data)
+	}
+
+	// swconfig devices get switch VLANs tagged on the CPU port instead
+	schema, err = LoadSchema("../../deviceSchemas/tplink,archer-c50-v4.json")
+	if err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+	network, err = DefaultNetworkConfig(schema)
+	if err != nil {
+		t.Fatalf("DefaultNetworkConfig failed: %v", err)
+	}
+	if len(network.SwitchVlan) != 2 || *network.SwitchVlan[0].Ports != "1 2 3 4 6t" || *network.SwitchVlan[1].Ports != "0 6t" {
+		t.Errorf("Unexpected switch VLANs: %+v", network.SwitchVlan)
+	}
+	if *network.Interface[0].Device != "eth0.1" || *network.Interface[1].Device != "eth0.2" {
+		t.Errorf("Expected lan and wan on the CPU port VLANs, got %s and %s",
+			*network.Interface[0].Device, *network.Interface[1].Device)
+	}
+}
+
+func TestApplyPortRoles(t *testing.T) {
+	schema, err := LoadSchema("../../deviceSchemas/ubnt,edgerouter-x.json")
+	if err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+
+	// Use the wan port as a fifth lan port
+	overridden, err := ApplyPortRoles(schema, map[string]string{"eth0": "lan"})
+	if err != nil {
+		t.Fatalf("ApplyPortRoles failed: %v", err)
+	}
+	network, err := DefaultNetworkConfig(overridden)
+	if err != nil {
+		t.Fatalf("DefaultNetworkConfig failed: %v", err)
+	}
+	if len(network.Interface) != 1 {
+		t.Errorf("Expected only a lan interface, got %d interfaces", len(network.Interface))
+	}
+	if ports := network.Device[0].Ports; len(ports) != 5 || ports[0] != "eth0" {
+		t.Errorf("Expected eth0 in the lan bridge, got %v", ports)
+	}
+	if *schema.Ports[0].DefaultRole != "wan" {
+		t.Error("Expected the original schema to be unchanged")
+	}
+
+	if _, err := ApplyPortRoles(schema, map[string]string{"eth9": "lan"}); err == nil || !strings.Contains(err.Error(), "unknown port eth9") {
+		t.Errorf("Expected an unknown port error, got %v", err)
+	}
+	if _, err := ApplyPortRoles(schema, map[string]string{"eth1": "dmz"}); err == nil {
+		t.Error("Expected an error for an invalid role")
+	}
+
+	// swconfig VLANs follow the overridden roles too
+	schema, err = LoadSchema("../../deviceSchemas/tplink,archer-c50-v4.json")
+	if err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+	overridden, err = ApplyPortRoles(schema, map[string]string{"eth4": "wan"})
+	if err != nil {
+		t.Fatalf("ApplyPortRoles failed: %v", err)
+	}
+	network, err = DefaultNetworkConfig(overridden)
+	if err != nil {
+		t.Fatalf("DefaultNetworkConfig failed: %v", err)
+	}
+	if *network.SwitchVlan[0].Ports != "1 2 3 6t" || *network.SwitchVlan[1].Ports != "0 4 6t" {
+		t.Errorf("Unexpected switch VLANs: %+v", network.SwitchVlan)
+	}
+	if _, err := ApplyPortRoles(schema, map[string]string{"eth6": "lan"}); err == nil {
+		t.Error("Expected an error for the CPU port")
+	}
+}
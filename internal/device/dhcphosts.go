@@ -0,0 +1,121 @@
+package device
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// preserveDHCPHosts adds the device's dhcp host sections to the config so
+// they are set again after the dhcp config is reset. Anonymous hosts keep
+// their cfg IDs as names. Hosts the config already declares, by name or MAC
+// address, are left to the config.
+func preserveDHCPHosts(openWrtConfig map[string]any, client ssh.SSHExecutor) error {
+	output, err := client.Execute("uci -X show dhcp")
+	if err != nil {
+		return fmt.Errorf("failed to read dhcp hosts: %w", err)
+	}
+	hosts := parseShowSections(output, "dhcp", "host")
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	declaredNames := make(map[string]bool)
+	declaredMACs := make(map[string]bool)
+	for _, host := range getSections(openWrtConfig, "dhcp", "host") {
+		if name, ok := host[".name"].(string); ok {
+			declaredNames[name] = true
+		}
+		for _, mac := range optionValues(host["mac"]) {
+			declaredMACs[strings.ToLower(mac)] = true
+		}
+	}
+
+	dhcp, ok := openWrtConfig["dhcp"].(map[string]any)
+	if !ok {
+		dhcp = make(map[string]any)
+		openWrtConfig["dhcp"] = dhcp
+	}
+	sections, _ := dhcp["host"].([]any)
+
+	for _, host := range hosts {
+		if declaredNames[host[".name"].(string)] {
+			continue
+		}
+		if slices.ContainsFunc(optionValues(host["mac"]), func(mac string) bool { return declaredMACs[strings.ToLower(mac)] }) {
+			continue
+		}
+		sections = append(sections, host)
+	}
+	dhcp["host"] = sections
+
+	return nil
+}
+
+// parseShowSections returns the sections of a type from `uci -X show`
+// output, in the order they appear, as section maps. Options with several
+// values become lists.
+func parseShowSections(output, configKey, sectionKey string) []map[string]any {
+	sections := make(map[string]map[string]any)
+	var names []string
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(key, ".", 3)
+		if len(parts) < 2 || parts[0] != configKey {
+			continue
+		}
+
+		if len(parts) == 2 {
+			if value == sectionKey {
+				sections[parts[1]] = map[string]any{".name": parts[1]}
+				names = append(names, parts[1])
+			}
+			continue
+		}
+
+		section, ok := sections[parts[1]]
+		if !ok {
+			continue
+		}
+		values := uci.ParseShowValue(value)
+		if len(values) == 1 {
+			section[parts[2]] = values[0]
+		} else {
+			list := make([]any, len(values))
+			for i, v := range values {
+				list[i] = v
+			}
+			section[parts[2]] = list
+		}
+	}
+
+	result := make([]map[string]any, 0, len(names))
+	for _, name := range names {
+		result = append(result, sections[name])
+	}
+	return result
+}
+
+// optionValues returns an option's value as a list of strings
+func optionValues(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var values []string
+		for _, item := range v {
+			values = append(values, fmt.Sprintf("%v", item))
+		}
+		return values
+	case []string:
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package device
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+func TestPreserveDHCPHosts(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [{ "model_id": "ubnt,edgerouter-x", "hostname": "router" }],
+		"preserve_dhcp_hosts": true,
+		"config": {
+			"dhcp": {
+				"host": [
+					{ ".name": "printer", "name": "printer", "mac": "00:11:22:33:44:55", "ip": "192.168.1.20" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	schema := &DeviceSchema{ConfigSections: map[string][]string{"dhcp": {"dnsmasq", "host"}}}
+
+	// The NAS was added in LuCI, and the printer's old lease is replaced
+	// by the config's
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci -X show dhcp"] = `dhcp.cfg01411c=dnsmasq
+dhcp.cfg01411c.domainneeded='1'
+dhcp.cfg07ee1a=host
+dhcp.cfg07ee1a.name='nas'
+dhcp.cfg07ee1a.mac='aa:bb:cc:dd:ee:01' 'aa:bb:cc:dd:ee:02'
+dhcp.cfg07ee1a.ip='192.168.1.10'
+dhcp.cfg08ee1a=host
+dhcp.cfg08ee1a.name='old-printer'
+dhcp.cfg08ee1a.mac='00:11:22:33:44:55'
+dhcp.cfg08ee1a.ip='192.168.1.99'
+`
+
+	state, err := GetOpenWrtStateWithOptions(oncConfig, &oncConfig.Devices[0], schema, StateOptions{Executor: mockClient})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+
+	reset := slices.Index(commands, "while uci -q delete dhcp.@host[0]; do :; done")
+	readded := slices.Index(commands, "uci set dhcp.cfg07ee1a=host")
+	if reset == -1 || readded == -1 || readded < reset {
+		t.Fatalf("Expected the NAS to be set again after the hosts are reset: %v", commands)
+	}
+	for _, cmd := range []string{
+		"uci set dhcp.cfg07ee1a.name='nas'",
+		"uci add_list dhcp.cfg07ee1a.mac='aa:bb:cc:dd:ee:01'",
+		"uci add_list dhcp.cfg07ee1a.mac='aa:bb:cc:dd:ee:02'",
+		"uci set dhcp.cfg07ee1a.ip='192.168.1.10'",
+		"uci set dhcp.printer.ip='192.168.1.20'",
+	} {
+		if !slices.Contains(commands, cmd) {
+			t.Errorf("Expected command %q in %v", cmd, commands)
+		}
+	}
+	for _, cmd := range commands {
+		if strings.Contains(cmd, "cfg08ee1a") {
+			t.Errorf("Expected the old printer lease to be dropped, got %q", cmd)
+		}
+	}
+
+	// Offline the hosts can't be read, which is warned about
+	state, err = GetOpenWrtState(oncConfig, &oncConfig.Devices[0], schema)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	if len(state.Warnings) != 1 || !strings.Contains(state.Warnings[0], "preserve_dhcp_hosts") {
+		t.Errorf("Expected a warning that the hosts weren't read, got %v", state.Warnings)
+	}
+}
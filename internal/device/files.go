@@ -0,0 +1,105 @@
+package device
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/condition"
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// resolveFiles returns the files and post commands that apply to the device,
+// including those generated for LED schedules and sysctl settings
+func resolveFiles(oncConfig *config.ONCConfig, ctx *condition.ConditionContext) ([]config.FileConfig, []string, error) {
+	var files []config.FileConfig
+	var commands []string
+
+	for _, file := range oncConfig.Files {
+		matches, err := condition.Evaluate(file.If, ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !matches {
+			continue
+		}
+		if !path.IsAbs(file.Path) {
+			return nil, nil, fmt.Errorf("file path %q is not absolute", file.Path)
+		}
+		file.If = nil
+		files = append(files, file)
+	}
+
+	for _, schedule := range oncConfig.LEDSchedules {
+		matches, err := condition.Evaluate(schedule.If, ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !matches {
+			continue
+		}
+		file, scheduleCommands, err := getLEDScheduleFiles(schedule)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+		commands = append(commands, scheduleCommands...)
+	}
+
+	// Later sysctl settings override earlier ones for the same key
+	sysctls := make(map[string]string)
+	for _, sysctl := range oncConfig.Sysctl {
+		matches, err := condition.Evaluate(sysctl.If, ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !matches {
+			continue
+		}
+		for key, value := range sysctl.Settings {
+			sysctls[key] = value
+		}
+	}
+	if len(sysctls) > 0 {
+		file, sysctlCommands, err := getSysctlFile(sysctls)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+		commands = append(commands, sysctlCommands...)
+	}
+
+	for _, postCommands := range oncConfig.PostCommands {
+		matches, err := condition.Evaluate(postCommands.If, ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if matches {
+			commands = append(commands, postCommands.Commands...)
+		}
+	}
+
+	return files, commands, nil
+}
+
+// getFileCommands returns the commands that write files to the device
+func getFileCommands(files []config.FileConfig) []string {
+	var commands []string
+	for _, file := range files {
+		mode := file.Mode
+		if mode == "" {
+			mode = "0644"
+		}
+		commands = append(commands,
+			fmt.Sprintf("mkdir -p %s", shellQuote(path.Dir(file.Path))),
+			fmt.Sprintf("printf '%%s' %s > %s", shellQuote(file.Content), shellQuote(file.Path)),
+			fmt.Sprintf("chmod %s %s", mode, shellQuote(file.Path)),
+		)
+	}
+	return commands
+}
+
+// shellQuote single quotes s for the device shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
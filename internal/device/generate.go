@@ -0,0 +1,122 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/plugin"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// typedSections maps config -> section type -> the struct describing it
+var typedSections = map[string]map[string]reflect.Type{
+	"system": {
+		"system": reflect.TypeOf(config.SystemSection{}),
+	},
+	"network": {
+		"interface":   reflect.TypeOf(config.InterfaceSection{}),
+		"device":      reflect.TypeOf(config.DeviceSection{}),
+		"switch":      reflect.TypeOf(config.SwitchSection{}),
+		"switch_vlan": reflect.TypeOf(config.SwitchVlanSection{}),
+		"bridge-vlan": reflect.TypeOf(config.BridgeVlanSection{}),
+	},
+	"firewall": {
+		"defaults":   reflect.TypeOf(config.DefaultSection{}),
+		"zone":       reflect.TypeOf(config.ZoneSection{}),
+		"forwarding": reflect.TypeOf(config.ForwardingSection{}),
+		"rule":       reflect.TypeOf(config.RuleSection{}),
+	},
+	"dhcp": {
+		"dnsmasq": reflect.TypeOf(config.DnsmasqSection{}),
+		"dhcp":    reflect.TypeOf(config.DHCPSection{}),
+		"odhcpd":  reflect.TypeOf(config.OdhcpdSection{}),
+		"host":    reflect.TypeOf(config.HostSection{}),
+	},
+	"wireless": {
+		"wifi-device": reflect.TypeOf(config.WifiDeviceSection{}),
+		"wifi-iface":  reflect.TypeOf(config.WifiIfaceSection{}),
+	},
+	"dropbear": {
+		"dropbear": reflect.TypeOf(config.DropbearSection{}),
+	},
+}
+
+func init() {
+	// The built-in configs are generated through the plugin registry like
+	// any other handler
+	for configKey := range typedSections {
+		plugin.Register(configKey, plugin.Handler{GenerateCommands: generateTypedConfigCommands})
+	}
+}
+
+// generateConfigCommands generates the UCI commands for a resolved config,
+// using the handler registered for it or the generic map path otherwise
+func generateConfigCommands(configKey string, configValue any) []string {
+	if handler, ok := plugin.Lookup(configKey); ok && handler.GenerateCommands != nil {
+		return handler.GenerateCommands(configKey, configValue)
+	}
+	return uci.GenerateConfigCommands(configKey, configValue)
+}
+
+// generateTypedConfigCommands generates the UCI commands for a built-in
+// config. Sections with a typed struct are generated from the struct so
+// option values are formatted by their declared type; anything the struct
+// can't represent falls back to the generic map path.
+func generateTypedConfigCommands(configKey string, configValue any) []string {
+	configMap, ok := configValue.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	var commands []string
+	for _, sectionKey := range uci.SortedKeys(configMap) {
+		sections, ok := configMap[sectionKey].([]any)
+		if !ok {
+			continue
+		}
+
+		for _, section := range sections {
+			sectionMap, ok := section.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			if typed, ok := decodeTypedSection(configKey, sectionKey, sectionMap); ok {
+				if sectionCommands, err := uci.GenerateSectionCommands(configKey, sectionKey, typed); err == nil {
+					commands = append(commands, sectionCommands...)
+					continue
+				}
+			}
+
+			commands = append(commands, uci.GenerateSectionMapCommands(configKey, sectionKey, sectionMap)...)
+		}
+	}
+
+	return commands
+}
+
+// decodeTypedSection decodes a resolved section into its typed struct. It
+// fails if the section has options the struct doesn't declare or values of
+// the wrong type.
+func decodeTypedSection(configKey, sectionKey string, sectionMap map[string]any) (any, bool) {
+	typ, ok := typedSections[configKey][sectionKey]
+	if !ok {
+		return nil, false
+	}
+
+	data, err := json.Marshal(sectionMap)
+	if err != nil {
+		return nil, false
+	}
+
+	typed := reflect.New(typ).Interface()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(typed); err != nil {
+		return nil, false
+	}
+
+	return typed, true
+}
@@ -0,0 +1,109 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+const (
+	ledScriptPath = "/usr/bin/led-night"
+	crontabPath   = "/etc/crontabs/root"
+)
+
+// ledScript turns LEDs off, saving their trigger and brightness, and
+// restores them. LEDS is filled in with the LEDs to switch.
+const ledScript = `#!/bin/sh
+# Turns LEDs off at night and back on, generated by openwrt-configurator
+LEDS="%s"
+STATE=/tmp/led-night
+
+case "$1" in
+off)
+	mkdir -p "$STATE"
+	for led in $LEDS; do
+		dir="/sys/class/leds/$led"
+		[ -d "$dir" ] || continue
+		sed -n 's/.*\[\(.*\)\].*/\1/p' "$dir/trigger" > "$STATE/$led.trigger"
+		cat "$dir/brightness" > "$STATE/$led.brightness"
+		echo none > "$dir/trigger"
+		echo 0 > "$dir/brightness"
+	done
+	;;
+on)
+	for led in $LEDS; do
+		dir="/sys/class/leds/$led"
+		[ -f "$STATE/$led.trigger" ] && cat "$STATE/$led.trigger" > "$dir/trigger"
+		[ -f "$STATE/$led.brightness" ] && cat "$STATE/$led.brightness" > "$dir/brightness"
+	done
+	rm -rf "$STATE"
+	;;
+*)
+	echo "usage: $0 off|on" >&2
+	exit 1
+	;;
+esac
+`
+
+// getLEDScheduleFiles returns the script and the commands installing the
+// cron entries for an LED schedule. Cron entries from an earlier schedule
+// are replaced, other entries are kept.
+func getLEDScheduleFiles(schedule config.LEDSchedule) (config.FileConfig, []string, error) {
+	offHour, offMinute, err := parseClock(schedule.Off)
+	if err != nil {
+		return config.FileConfig{}, nil, fmt.Errorf("invalid led schedule off time: %w", err)
+	}
+	onHour, onMinute, err := parseClock(schedule.On)
+	if err != nil {
+		return config.FileConfig{}, nil, fmt.Errorf("invalid led schedule on time: %w", err)
+	}
+	if schedule.Off == schedule.On {
+		return config.FileConfig{}, nil, fmt.Errorf("invalid led schedule: off and on are both %s", schedule.Off)
+	}
+
+	leds := "$(ls /sys/class/leds)"
+	if len(schedule.LEDs) > 0 {
+		leds = strings.Join(schedule.LEDs, " ")
+	}
+
+	file := config.FileConfig{
+		Path:    ledScriptPath,
+		Content: fmt.Sprintf(ledScript, leds),
+		Mode:    "0755",
+	}
+
+	commands := []string{
+		fmt.Sprintf("touch %s", crontabPath),
+		fmt.Sprintf("sed -i '\\#%s#d' %s", ledScriptPath, crontabPath),
+		fmt.Sprintf("echo '%d %d * * * %s off' >> %s", offMinute, offHour, ledScriptPath, crontabPath),
+		fmt.Sprintf("echo '%d %d * * * %s on' >> %s", onMinute, onHour, ledScriptPath, crontabPath),
+		"/etc/init.d/cron enable",
+		"/etc/init.d/cron restart",
+	}
+
+	return file, commands, nil
+}
+
+// parseClock parses a 24 hour HH:MM time
+func parseClock(s string) (hour, minute int, err error) {
+	if len(s) != 5 || s[2] != ':' || !isDigits(s[:2]) || !isDigits(s[3:]) {
+		return 0, 0, fmt.Errorf("%q is not HH:MM", s)
+	}
+	hour, _ = strconv.Atoi(s[:2])
+	minute, _ = strconv.Atoi(s[3:])
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("%q is not a valid time of day", s)
+	}
+	return hour, minute, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package device
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestLEDSchedule(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		LEDSchedules: []config.LEDSchedule{
+			{Off: "22:00", On: "06:00", LEDs: []string{"green:power", "blue:wlan"}},
+		},
+	}
+	deviceConfig := &config.DeviceConfig{ModelID: "tplink,archer-c7-v5", Hostname: "ap"}
+
+	state, err := GetOpenWrtState(oncConfig, deviceConfig, &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	if len(state.Files) != 1 || state.Files[0].Path != "/usr/bin/led-night" || state.Files[0].Mode != "0755" {
+		t.Fatalf("Expected the led-night script, got %v", state.Files)
+	}
+	if !strings.Contains(state.Files[0].Content, `LEDS="green:power blue:wlan"`) {
+		t.Errorf("Expected the script to switch the configured LEDs:\n%s", state.Files[0].Content)
+	}
+
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+
+	expected := []string{
+		"chmod 0755 '/usr/bin/led-night'",
+		`sed -i '\#/usr/bin/led-night#d' /etc/crontabs/root`,
+		"echo '0 22 * * * /usr/bin/led-night off' >> /etc/crontabs/root",
+		"echo '0 6 * * * /usr/bin/led-night on' >> /etc/crontabs/root",
+		"/etc/init.d/cron restart",
+	}
+	for _, cmd := range expected {
+		if !slices.Contains(commands, cmd) {
+			t.Errorf("Expected command %q in %v", cmd, commands)
+		}
+	}
+
+	// Files and cron entries come after the config is reloaded
+	if slices.Index(commands, "reload_config") > slices.Index(commands, expected[0]) {
+		t.Errorf("Expected files to be written after reload_config: %v", commands)
+	}
+}
+
+func TestLEDScheduleInvalidTime(t *testing.T) {
+	for _, schedule := range []config.LEDSchedule{
+		{Off: "22", On: "06:00"},
+		{Off: "24:00", On: "06:00"},
+		{Off: "22:00", On: "6:00"},
+		{Off: "22:00", On: "22:00"},
+	} {
+		if _, _, err := getLEDScheduleFiles(schedule); err == nil {
+			t.Errorf("Expected an error for %s-%s", schedule.Off, schedule.On)
+		}
+	}
+}
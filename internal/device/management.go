@@ -0,0 +1,142 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+// interfaceDump represents the ubus network.interface dump response
+type interfaceDump struct {
+	Interface []interfaceStatus `json:"interface"`
+}
+
+// interfaceStatus represents the status of a single logical interface
+type interfaceStatus struct {
+	Interface   string             `json:"interface"`
+	Up          bool               `json:"up"`
+	L3Device    string             `json:"l3_device"`
+	IPv4Address []interfaceAddress `json:"ipv4-address"`
+	IPv6Address []interfaceAddress `json:"ipv6-address"`
+}
+
+// interfaceAddress represents an address assigned to an interface
+type interfaceAddress struct {
+	Address string `json:"address"`
+	Mask    int    `json:"mask"`
+}
+
+// GetManagementInterface returns the name of the logical interface that
+// carries the given device address, i.e. the interface the SSH session is
+// connected through
+func GetManagementInterface(client ssh.SSHExecutor, ipAddr string) (string, error) {
+	status, err := getInterfaceStatus(client, ipAddr)
+	if err != nil {
+		return "", err
+	}
+	return status.Interface, nil
+}
+
+// ManagementSession describes the connection the device is managed over
+type ManagementSession struct {
+	// LocalIP is our address as seen by the device
+	LocalIP net.IP
+	// RemoteIP is the device address we are connected to
+	RemoteIP net.IP
+	// Interface is the logical interface carrying RemoteIP
+	Interface string
+	// Subnet is the subnet of that interface
+	Subnet *net.IPNet
+}
+
+// Direct reports whether we share the management subnet with the device,
+// i.e. the session isn't routed through another interface
+func (s *ManagementSession) Direct() bool {
+	return s.Subnet != nil && s.Subnet.Contains(s.LocalIP)
+}
+
+// GetManagementSession derives the management interface and subnet from the
+// addresses of the SSH connection itself, so it works whatever the device
+// was addressed by
+func GetManagementSession(client ssh.SSHExecutor) (*ManagementSession, error) {
+	provider, ok := client.(ssh.AddrProvider)
+	if !ok {
+		return nil, fmt.Errorf("connection addresses are not available")
+	}
+
+	localIP := addrIP(provider.LocalAddr())
+	remoteIP := addrIP(provider.RemoteAddr())
+	if localIP == nil || remoteIP == nil {
+		return nil, fmt.Errorf("connection addresses are not available")
+	}
+
+	status, err := getInterfaceStatus(client, remoteIP.String())
+	if err != nil {
+		return nil, err
+	}
+
+	session := &ManagementSession{
+		LocalIP:   localIP,
+		RemoteIP:  remoteIP,
+		Interface: status.Interface,
+	}
+
+	for _, addr := range append(status.IPv4Address, status.IPv6Address...) {
+		if remoteIP.Equal(net.ParseIP(addr.Address)) {
+			bits := 32
+			if remoteIP.To4() == nil {
+				bits = 128
+			}
+			mask := net.CIDRMask(addr.Mask, bits)
+			session.Subnet = &net.IPNet{IP: remoteIP.Mask(mask), Mask: mask}
+			break
+		}
+	}
+
+	return session, nil
+}
+
+// addrIP returns the IP of a TCP address, or nil
+func addrIP(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func getInterfaceStatus(client ssh.SSHExecutor, ipAddr string) (*interfaceStatus, error) {
+	ip := net.ParseIP(ipAddr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ipAddr)
+	}
+
+	output, err := client.Execute("ubus call network.interface dump")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read interface status: %w", err)
+	}
+
+	var dump interfaceDump
+	if err := json.Unmarshal([]byte(output), &dump); err != nil {
+		return nil, fmt.Errorf("failed to parse interface status: %w", err)
+	}
+
+	for i, iface := range dump.Interface {
+		addresses := append(iface.IPv4Address, iface.IPv6Address...)
+		for _, addr := range addresses {
+			if ip.Equal(net.ParseIP(addr.Address)) {
+				return &dump.Interface[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no interface found with address %s", ipAddr)
+}
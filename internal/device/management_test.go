@@ -0,0 +1,49 @@
+package device
+
+import (
+	"net"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+func TestGetManagementSession(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["ubus call network.interface dump"] = `{"interface": [
+		{"interface": "wan", "up": true, "l3_device": "eth0", "ipv4-address": [{"address": "203.0.113.5", "mask": 24}]},
+		{"interface": "mgmt", "up": true, "l3_device": "br-lan.99", "ipv4-address": [{"address": "10.99.0.1", "mask": 24}]}
+	]}`
+	mockClient.LocalAddress = &net.TCPAddr{IP: net.ParseIP("10.99.0.20"), Port: 51234}
+	mockClient.RemoteAddress = &net.TCPAddr{IP: net.ParseIP("10.99.0.1"), Port: 22}
+
+	session, err := GetManagementSession(mockClient)
+	if err != nil {
+		t.Fatalf("Failed to get management session: %v", err)
+	}
+
+	if session.Interface != "mgmt" {
+		t.Errorf("Expected interface 'mgmt', got '%s'", session.Interface)
+	}
+	if session.Subnet == nil || session.Subnet.String() != "10.99.0.0/24" {
+		t.Errorf("Expected subnet 10.99.0.0/24, got %v", session.Subnet)
+	}
+	if !session.Direct() {
+		t.Error("Expected a direct session")
+	}
+
+	// A session from another subnet is routed
+	mockClient.LocalAddress = &net.TCPAddr{IP: net.ParseIP("192.168.5.7"), Port: 51234}
+	session, err = GetManagementSession(mockClient)
+	if err != nil {
+		t.Fatalf("Failed to get management session: %v", err)
+	}
+	if session.Direct() {
+		t.Error("Expected a routed session")
+	}
+
+	// Without connection addresses there is nothing to derive
+	mockClient.RemoteAddress = nil
+	if _, err := GetManagementSession(mockClient); err == nil {
+		t.Error("Expected error without connection addresses")
+	}
+}
@@ -0,0 +1,175 @@
+package device
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// PruneInstalled drops the packages already installed on the device from an
+// opkg install command, e.g. ones an earlier install pulled in as
+// dependencies. It returns the command, empty when every package is
+// installed, and the packages dropped.
+func PruneInstalled(client ssh.SSHExecutor, command string) (string, []string, error) {
+	names, ok := strings.CutPrefix(command, "opkg install ")
+	if !ok {
+		return command, nil, nil
+	}
+
+	output, err := client.Execute("opkg list-installed")
+	if err != nil {
+		return command, nil, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+	installed := make(map[string]bool)
+	for _, pkg := range parseInstalledPackages(output) {
+		installed[pkg.Name] = true
+	}
+
+	var remaining, dropped []string
+	for _, name := range strings.Fields(names) {
+		if installed[name] {
+			dropped = append(dropped, name)
+		} else {
+			remaining = append(remaining, name)
+		}
+	}
+	if len(remaining) == 0 {
+		return "", dropped, nil
+	}
+
+	return "opkg install " + strings.Join(remaining, " "), dropped, nil
+}
+
+// CheckPackageArchitectures returns a warning for each package that targets
+// an architecture the device doesn't accept, which opkg would otherwise
+// reject part way through provisioning. Packages whose architecture can't
+// be determined are not checked.
+func CheckPackageArchitectures(client ssh.SSHExecutor, packages []uci.Package) ([]string, error) {
+	if len(packages) == 0 {
+		return nil, nil
+	}
+
+	archs, err := GetArchitectures(client)
+	if err != nil {
+		return nil, err
+	}
+
+	supported := make(map[string]bool)
+	for _, arch := range archs {
+		supported[arch] = true
+	}
+
+	var warnings []string
+	for _, pkg := range packages {
+		arch := getPackageArchitecture(client, pkg.Name)
+		if arch == "" || supported[arch] {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("package %s targets architecture %s, but the device only accepts %s",
+			pkg.Name, arch, strings.Join(archs, ", ")))
+	}
+
+	return warnings, nil
+}
+
+// getPackageArchitecture returns the architecture of a package, read from
+// the file name for .ipk files (name_version_arch.ipk) or from the feed
+// index via opkg info otherwise
+func getPackageArchitecture(client ssh.SSHExecutor, name string) string {
+	if strings.HasSuffix(name, ".ipk") {
+		parts := strings.Split(strings.TrimSuffix(path.Base(name), ".ipk"), "_")
+		if len(parts) < 3 {
+			return ""
+		}
+		return parts[len(parts)-1]
+	}
+
+	output, err := client.Execute(fmt.Sprintf("opkg info %s", name))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range splitLines(output) {
+		if arch, ok := strings.CutPrefix(line, "Architecture:"); ok {
+			return strings.TrimSpace(arch)
+		}
+	}
+
+	return ""
+}
+
+// CheckPackageRemovals returns a warning for each package to be removed
+// that other installed packages depend on, listing the dependents opkg
+// will remove along with it
+func CheckPackageRemovals(client ssh.SSHExecutor, packages []string) ([]string, error) {
+	dependents, err := GetPackageDependents(client, packages)
+	return RemovalWarnings(packages, dependents), err
+}
+
+// GetPackageDependents returns the installed packages that depend on each
+// of the given packages, read with opkg whatdepends. On error it returns
+// the dependents read so far.
+func GetPackageDependents(client ssh.SSHExecutor, packages []string) (map[string][]string, error) {
+	dependents := make(map[string][]string)
+	for _, name := range packages {
+		output, err := client.Execute(fmt.Sprintf("opkg whatdepends %s", name))
+		if err != nil {
+			return dependents, fmt.Errorf("failed to read dependents of %s: %w", name, err)
+		}
+		dependents[name] = parseWhatDepends(output)
+	}
+
+	return dependents, nil
+}
+
+// RemovalWarnings returns a warning for each package to be removed whose
+// dependents aren't all being removed too
+func RemovalWarnings(packages []string, dependents map[string][]string) []string {
+	removing := make(map[string]bool)
+	for _, name := range packages {
+		removing[name] = true
+	}
+
+	var warnings []string
+	for _, name := range packages {
+		var others []string
+		for _, dependent := range dependents[name] {
+			if !removing[dependent] {
+				others = append(others, dependent)
+			}
+		}
+		if len(others) > 0 {
+			warnings = append(warnings, fmt.Sprintf("removing package %s also removes the packages that depend on it: %s",
+				name, strings.Join(others, ", ")))
+		}
+	}
+
+	return warnings
+}
+
+// parseWhatDepends returns the package names listed by opkg whatdepends,
+// which follow the "What depends on root set" line as
+// "<name> <version> depends on <package>"
+func parseWhatDepends(output string) []string {
+	var dependents []string
+	seen := make(map[string]bool)
+	inDependents := false
+
+	for _, line := range splitLines(output) {
+		if strings.HasPrefix(line, "What depends on") {
+			inDependents = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !inDependents || len(fields) == 0 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		dependents = append(dependents, fields[0])
+	}
+
+	return dependents
+}
@@ -0,0 +1,71 @@
+package device
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+func TestCheckPackageArchitectures(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["opkg info custom-agent"] = `Package: custom-agent
+Version: 1.2.0
+Architecture: aarch64_cortex-a53
+`
+	mockClient.Responses["opkg info tcpdump"] = `Package: tcpdump
+Version: 4.99.4-1
+Architecture: mipsel_24kc
+`
+
+	packages := []uci.Package{
+		{Name: "custom-agent"},
+		{Name: "tcpdump"},
+		{Name: "luci-app-foo"},
+		{Name: "https://example.com/feed/helper_1.0-1_x86_64.ipk"},
+		{Name: "/tmp/theme_2.0_all.ipk"},
+	}
+
+	warnings, err := CheckPackageArchitectures(mockClient, packages)
+	if err != nil {
+		t.Fatalf("Failed to check packages: %v", err)
+	}
+
+	if len(warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got %d: %v", len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0], "custom-agent") || !strings.Contains(warnings[0], "aarch64_cortex-a53") {
+		t.Errorf("Unexpected warning: %s", warnings[0])
+	}
+	if !strings.Contains(warnings[1], "helper_1.0-1_x86_64.ipk") || !strings.Contains(warnings[1], "mipsel_24kc") {
+		t.Errorf("Unexpected warning: %s", warnings[1])
+	}
+}
+
+func TestCheckPackageRemovals(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["opkg whatdepends firewall4"] = `Root set:
+  firewall4
+What depends on root set
+	luci-app-firewall 23.05.0	depends on firewall4
+	luci-mod-network 23.05.0	depends on firewall4
+	luci-app-firewall 23.05.0	depends on firewall4
+	odhcpd-ipv6only 2023-10-24	depends on firewall4
+`
+	mockClient.Responses["opkg whatdepends odhcpd-ipv6only"] = `Root set:
+  odhcpd-ipv6only
+What depends on root set
+`
+
+	warnings, err := CheckPackageRemovals(mockClient, []string{"firewall4", "odhcpd-ipv6only"})
+	if err != nil {
+		t.Fatalf("Failed to check removals: %v", err)
+	}
+
+	// Dependents being removed anyway aren't listed
+	expected := "removing package firewall4 also removes the packages that depend on it: luci-app-firewall, luci-mod-network"
+	if len(warnings) != 1 || warnings[0] != expected {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+}
@@ -0,0 +1,257 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// iwinfoFreqList represents the ubus iwinfo freqlist response
+type iwinfoFreqList struct {
+	Results []struct {
+		Channel    int  `json:"channel"`
+		Restricted bool `json:"restricted"`
+	} `json:"results"`
+}
+
+// iwinfoInfo represents the ubus iwinfo info response
+type iwinfoInfo struct {
+	HTModes []string `json:"htmodes"`
+}
+
+// probeRadioCapabilities records the channels and htmodes a radio supports.
+// The probe is best effort: capabilities that can't be read are left empty
+// and not checked.
+func probeRadioCapabilities(client ssh.SSHExecutor, radio *Radio) {
+	output, err := client.Execute(fmt.Sprintf(`ubus call iwinfo freqlist '{"device": "%s"}'`, radio.Name))
+	if err == nil {
+		var freqList iwinfoFreqList
+		if json.Unmarshal([]byte(output), &freqList) == nil {
+			for _, freq := range freqList.Results {
+				if !freq.Restricted {
+					radio.Channels = append(radio.Channels, freq.Channel)
+				}
+			}
+		}
+	}
+
+	output, err = client.Execute(fmt.Sprintf(`ubus call iwinfo info '{"device": "%s"}'`, radio.Name))
+	if err == nil {
+		var info iwinfoInfo
+		if json.Unmarshal([]byte(output), &info) == nil {
+			radio.HTModes = info.HTModes
+		}
+	}
+}
+
+// assignRadios maps generic wifi-device sections, i.e. ones with a band but
+// no .name, onto the device's radios of that band, so a config can describe
+// "a 2g radio and a 5g radio" without knowing the model's radio names. A
+// generic section is copied once per matching radio, taking the radio's
+// name, type and path. wifi-iface sections whose device is a band are
+// likewise attached to the radios of that band, one copy per radio.
+// Nothing is assigned when the device's radios aren't known.
+func assignRadios(openWrtConfig map[string]any, radios []Radio) []string {
+	wireless, ok := openWrtConfig["wireless"].(map[string]any)
+	if !ok || len(radios) == 0 {
+		return nil
+	}
+
+	radiosByBand := make(map[string][]Radio)
+	for _, radio := range radios {
+		radiosByBand[radio.Band] = append(radiosByBand[radio.Band], radio)
+	}
+
+	var warnings []string
+
+	if sections, ok := wireless["wifi-device"].([]any); ok {
+		var assigned []any
+		for _, section := range sections {
+			sectionMap, _ := section.(map[string]any)
+			band, _ := sectionMap["band"].(string)
+			if _, named := sectionMap[".name"]; named || band == "" {
+				assigned = append(assigned, section)
+				continue
+			}
+
+			if len(radiosByBand[band]) == 0 {
+				warnings = append(warnings, fmt.Sprintf("wireless: no %s radio for generic wifi-device, skipping it", band))
+				continue
+			}
+			for _, radio := range radiosByBand[band] {
+				radioSection := copySection(sectionMap)
+				radioSection[".name"] = radio.Name
+				if _, ok := radioSection["type"]; !ok && radio.Type != "" {
+					radioSection["type"] = radio.Type
+				}
+				if _, ok := radioSection["path"]; !ok && radio.Path != "" {
+					radioSection["path"] = radio.Path
+				}
+				assigned = append(assigned, radioSection)
+			}
+		}
+		wireless["wifi-device"] = assigned
+	}
+
+	if sections, ok := wireless["wifi-iface"].([]any); ok {
+		var assigned []any
+		for _, section := range sections {
+			sectionMap, _ := section.(map[string]any)
+			band, _ := sectionMap["device"].(string)
+			if !isBand(band) {
+				assigned = append(assigned, section)
+				continue
+			}
+
+			bandRadios := radiosByBand[band]
+
+			if len(bandRadios) == 0 {
+				warnings = append(warnings, fmt.Sprintf("wireless: no %s radio for wifi-iface %v, skipping it", band, sectionMap[".name"]))
+				continue
+			}
+			for _, radio := range bandRadios {
+				ifaceSection := copySection(sectionMap)
+				ifaceSection["device"] = radio.Name
+				// Copies need distinct names when a band has several radios
+				if name, ok := ifaceSection[".name"].(string); ok && len(bandRadios) > 1 {
+					ifaceSection[".name"] = name + "_" + radio.Name
+				}
+				assigned = append(assigned, ifaceSection)
+			}
+		}
+		wireless["wifi-iface"] = assigned
+	}
+
+	return warnings
+}
+
+// isBand reports whether s names a wifi band rather than a radio
+func isBand(s string) bool {
+	switch s {
+	case "2g", "5g", "6g", "60g":
+		return true
+	}
+	return false
+}
+
+// copySection returns a shallow copy of a resolved section
+func copySection(sectionMap map[string]any) map[string]any {
+	copied := make(map[string]any, len(sectionMap))
+	for k, v := range sectionMap {
+		copied[k] = v
+	}
+	return copied
+}
+
+// changedRadios compares the wireless config with the device's and returns
+// the radios whose wifi-device or wifi-iface sections change, so only they
+// are restarted. It reports false when the device's config can't be read or
+// a changed section's radio isn't known, to reload every radio instead.
+func changedRadios(client ssh.SSHExecutor, state *OpenWrtState) ([]string, bool) {
+	output, err := client.Execute("uci show wireless")
+	if err != nil {
+		return nil, false
+	}
+	actual := uci.ParseShow(output)
+	intended := uci.Flatten(map[string]any{"wireless": state.Config["wireless"]})
+
+	// Sections of reset types are replaced, so options and sections the
+	// config doesn't declare are removed too
+	reset := make(map[string]bool)
+	for _, sectionKey := range state.ConfigSectionsToReset["wireless"] {
+		reset[sectionKey] = true
+	}
+
+	changed := make(map[string]bool)
+	for key, value := range intended {
+		if actualValue, ok := actual[key]; !ok || actualValue != value {
+			changed[sectionOf(key)] = true
+		}
+	}
+	for key := range actual {
+		if _, ok := intended[key]; !ok && reset[actual["wireless."+sectionOf(key)]] {
+			changed[sectionOf(key)] = true
+		}
+	}
+
+	radioSet := make(map[string]bool)
+	for section := range changed {
+		radio := sectionRadio(section, intended)
+		if radio == "" {
+			radio = sectionRadio(section, actual)
+		}
+		if radio == "" {
+			return nil, false
+		}
+		radioSet[radio] = true
+	}
+
+	radios := make([]string, 0, len(radioSet))
+	for radio := range radioSet {
+		radios = append(radios, radio)
+	}
+	sort.Strings(radios)
+
+	return radios, true
+}
+
+// sectionOf returns the section name of a flat wireless key, e.g.
+// default_radio0 for wireless.default_radio0.ssid
+func sectionOf(key string) string {
+	parts := strings.SplitN(key, ".", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
+
+// sectionRadio returns the radio a wireless section belongs to: a
+// wifi-device is the radio, a wifi-iface is on its device
+func sectionRadio(section string, flat map[string]string) string {
+	if flat["wireless."+section] == "wifi-device" {
+		return section
+	}
+	return flat["wireless."+section+".device"]
+}
+
+// PreserveWifiKeys sets the key of each wifi-iface that doesn't declare one
+// to the key it has on the device, so merging a config that leaves keys out
+// keeps the device's passwords. An explicit key always overwrites. It
+// returns the names of the sections whose key was kept.
+func PreserveWifiKeys(client ssh.SSHExecutor, state *OpenWrtState) []string {
+	wireless, ok := state.Config["wireless"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	ifaces, _ := wireless["wifi-iface"].([]any)
+
+	var preserved []string
+	for _, iface := range ifaces {
+		sectionMap, ok := iface.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, ok := sectionMap[".name"].(string)
+		if !ok {
+			continue
+		}
+		if _, ok := sectionMap["key"]; ok {
+			continue
+		}
+
+		output, err := client.Execute(fmt.Sprintf("uci -q get wireless.%s.key", name))
+		if err != nil {
+			continue
+		}
+		if key := strings.TrimRight(output, "\n"); key != "" {
+			sectionMap["key"] = key
+			preserved = append(preserved, name)
+		}
+	}
+
+	return preserved
+}
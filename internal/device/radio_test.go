@@ -0,0 +1,100 @@
+package device
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// TestAssignRadios tests that band-based radio configs are mapped onto a
+// dual-band device whose 5g radio comes first
+func TestAssignRadios(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Config: config.ConfigConfig{
+			Wireless: &config.WirelessConfig{
+				WifiDevice: []config.WifiDeviceSection{
+					{Band: config.Ptr("2g"), Channel: config.Ptr("1")},
+					{Band: config.Ptr("5g"), Channel: config.Ptr("36")},
+					{Band: config.Ptr("6g"), Channel: config.Ptr("auto")},
+				},
+				WifiIface: []config.WifiIfaceSection{
+					{Name: config.Ptr("home"), Device: "2g", SSID: config.Ptr("home")},
+					{Name: config.Ptr("home5"), Device: "5g", SSID: config.Ptr("home")},
+				},
+			},
+		},
+	}
+	deviceConfig := &config.DeviceConfig{ModelID: "tplink,archer-c7-v5", Hostname: "ap"}
+	schema := &DeviceSchema{
+		Name: deviceConfig.ModelID,
+		Radios: []Radio{
+			{Name: "radio0", Type: "mac80211", Path: "pci0000:00/0000:00:00.0", Band: "5g"},
+			{Name: "radio1", Type: "mac80211", Path: "platform/ahb/18100000.wmac", Band: "2g"},
+		},
+	}
+
+	state, err := GetOpenWrtState(oncConfig, deviceConfig, schema)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+
+	for _, cmd := range []string{
+		"uci set wireless.radio0=wifi-device",
+		"uci set wireless.radio0.band='5g'",
+		"uci set wireless.radio0.channel='36'",
+		"uci set wireless.radio0.path='pci0000:00/0000:00:00.0'",
+		"uci set wireless.radio1.band='2g'",
+		"uci set wireless.radio1.channel='1'",
+		"uci set wireless.radio1.path='platform/ahb/18100000.wmac'",
+		"uci set wireless.radio1.type='mac80211'",
+		"uci set wireless.home.device='radio1'",
+		"uci set wireless.home5.device='radio0'",
+	} {
+		if !slices.Contains(commands, cmd) {
+			t.Errorf("Expected command %q in %v", cmd, commands)
+		}
+	}
+
+	// The device has no 6g radio
+	if len(state.Warnings) != 1 {
+		t.Errorf("Expected a warning for the missing 6g radio, got %v", state.Warnings)
+	}
+}
+
+func TestAssignRadiosSameBand(t *testing.T) {
+	openWrtConfig := map[string]any{
+		"wireless": map[string]any{
+			"wifi-device": []any{
+				map[string]any{"band": "5g", "htmode": "VHT80"},
+			},
+			"wifi-iface": []any{
+				map[string]any{".name": "home", "device": "5g"},
+			},
+		},
+	}
+	radios := []Radio{
+		{Name: "radio0", Band: "2g"},
+		{Name: "radio1", Band: "5g"},
+		{Name: "radio2", Band: "5g"},
+	}
+
+	if warnings := assignRadios(openWrtConfig, radios); len(warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	wireless := openWrtConfig["wireless"].(map[string]any)
+	devices := wireless["wifi-device"].([]any)
+	if len(devices) != 2 || devices[0].(map[string]any)[".name"] != "radio1" || devices[1].(map[string]any)[".name"] != "radio2" {
+		t.Errorf("Expected the 5g radios radio1 and radio2, got %v", devices)
+	}
+	ifaces := wireless["wifi-iface"].([]any)
+	if len(ifaces) != 2 || ifaces[0].(map[string]any)[".name"] != "home_radio1" || ifaces[1].(map[string]any)[".name"] != "home_radio2" {
+		t.Errorf("Expected an iface per 5g radio, got %v", ifaces)
+	}
+}
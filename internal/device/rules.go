@@ -0,0 +1,105 @@
+package device
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// expandRuleTemplates replaces each firewall rule that lists ports with one
+// rule per port, with the port as its dest_port. Expanded rules are named
+// <rule>_<port>, e.g. allow_web_80, or <rule>_<first>_<last> for a range.
+func expandRuleTemplates(openWrtConfig map[string]any) error {
+	firewall, ok := openWrtConfig["firewall"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	rules, ok := firewall["rule"].([]any)
+	if !ok {
+		return nil
+	}
+
+	var expanded []any
+	for i, rule := range rules {
+		ruleMap, ok := rule.(map[string]any)
+		if !ok {
+			expanded = append(expanded, rule)
+			continue
+		}
+		ports, ok := ruleMap["ports"]
+		if !ok {
+			expanded = append(expanded, rule)
+			continue
+		}
+
+		name, named := ruleMap[".name"].(string)
+		if !named {
+			name = fmt.Sprintf("@rule[%d]", i)
+		}
+		if _, ok := ruleMap["dest_port"]; ok {
+			return fmt.Errorf("firewall rule %s sets both ports and dest_port", name)
+		}
+		portList, ok := ports.([]any)
+		if !ok {
+			return fmt.Errorf("firewall rule %s: ports must be a list", name)
+		}
+
+		for _, value := range portList {
+			port, err := parsePort(value)
+			if err != nil {
+				return fmt.Errorf("firewall rule %s: %w", name, err)
+			}
+
+			portRule := make(map[string]any, len(ruleMap))
+			for key, v := range ruleMap {
+				if key != "ports" {
+					portRule[key] = v
+				}
+			}
+			portRule["dest_port"] = port
+			if named {
+				portRule[".name"] = name + "_" + strings.ReplaceAll(port, "-", "_")
+			}
+			expanded = append(expanded, portRule)
+		}
+	}
+	firewall["rule"] = expanded
+
+	return nil
+}
+
+// parsePort checks a port or port range, e.g. 443 or "8000-8100", and
+// returns it as a dest_port value
+func parsePort(value any) (string, error) {
+	var s string
+	switch v := value.(type) {
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		s = v
+	default:
+		return "", fmt.Errorf("invalid port %v", value)
+	}
+
+	first, last, isRange := strings.Cut(s, "-")
+	low, err := portNumber(first)
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q", s)
+	}
+	if isRange {
+		high, err := portNumber(last)
+		if err != nil || high < low {
+			return "", fmt.Errorf("invalid port range %q", s)
+		}
+	}
+
+	return s, nil
+}
+
+func portNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 65535 {
+		return 0, fmt.Errorf("port out of range")
+	}
+	return n, nil
+}
@@ -0,0 +1,62 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestRuleTemplatePorts(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [
+			{ "model_id": "ubnt,edgerouter-x", "hostname": "router" }
+		],
+		"config": {
+			"firewall": {
+				"rule": [
+					{ ".name": "allow_web", "src": "wan", "proto": "tcp", "ports": [80, 443, "8000-8100"], "target": "ACCEPT" },
+					{ ".name": "allow_ssh", "src": "wan", "proto": "tcp", "dest_port": "22", "target": "ACCEPT" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	rules := getSections(state.Config, "firewall", "rule")
+	if len(rules) != 4 {
+		t.Fatalf("Expected 3 expanded rules and allow_ssh, got %v", rules)
+	}
+	expected := []struct{ name, port string }{
+		{"allow_web_80", "80"},
+		{"allow_web_443", "443"},
+		{"allow_web_8000_8100", "8000-8100"},
+		{"allow_ssh", "22"},
+	}
+	for i, e := range expected {
+		rule := rules[i]
+		if rule[".name"] != e.name || rule["dest_port"] != e.port {
+			t.Errorf("Expected rule %s with dest_port %s, got %v", e.name, e.port, rule)
+		}
+		if _, ok := rule["ports"]; ok {
+			t.Errorf("Expected ports to be removed from %v", rule)
+		}
+		if rule["proto"] != "tcp" || rule["target"] != "ACCEPT" {
+			t.Errorf("Expected the template's options on %v", rule)
+		}
+	}
+
+	// Invalid ports are an error
+	for _, ports := range [][]any{{0}, {65536}, {"http"}, {"443-80"}} {
+		oncConfig.Config.Firewall.Rule[0].Ports = ports
+		if _, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{}); err == nil {
+			t.Errorf("Expected an error for ports %v", ports)
+		}
+	}
+}
@@ -2,7 +2,11 @@ package device
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/drummonds/openwrt-configurator.git/internal/config"
 	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
@@ -12,10 +16,37 @@ import (
 type DeviceSchema struct {
 	Name           string              `json:"name"`
 	Version        string              `json:"version"`
+	Revision       string              `json:"revision,omitempty"`
+	Target         string              `json:"target,omitempty"`
+	Arch           string              `json:"arch,omitempty"`
 	SwConfig       bool                `json:"sw_config"`
 	ConfigSections map[string][]string `json:"config_sections,omitempty"`
 	Ports          []Port              `json:"ports,omitempty"`
 	Radios         []Radio             `json:"radios,omitempty"`
+
+	// Zoneinfo lists the zones in the device's /usr/share/zoneinfo, empty
+	// when no zoneinfo package is installed or it wasn't read
+	Zoneinfo []string `json:"zoneinfo,omitempty"`
+
+	// DefaultConfig is the model's factory default UCI state in the flat
+	// form of uci show, e.g. "system.@system[0].hostname": "OpenWrt"
+	DefaultConfig map[string]string `json:"default_config,omitempty"`
+}
+
+// LoadSchema reads a device schema from a JSON file, such as the ones in
+// deviceSchemas
+func LoadSchema(path string) (*DeviceSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device schema: %w", err)
+	}
+
+	var schema DeviceSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("failed to parse device schema: %w", err)
+	}
+
+	return &schema, nil
 }
 
 // Port represents a network port on the device
@@ -31,6 +62,10 @@ type Radio struct {
 	Type string `json:"type"`
 	Path string `json:"path"`
 	Band string `json:"band"`
+
+	// Capabilities probed from the radio, empty when unknown
+	Channels []int    `json:"channels,omitempty"`
+	HTModes  []string `json:"htmodes,omitempty"`
 }
 
 // BoardJSON represents the board.json structure
@@ -91,20 +126,22 @@ func GetDeviceSchema(deviceConfig *config.DeviceConfig) (*DeviceSchema, error) {
 	}
 
 	// Connect via SSH
-	client, err := ssh.Connect(
-		deviceConfig.IPAddr,
-		deviceConfig.ProvisioningConfig.SSHAuth.Username,
-		deviceConfig.ProvisioningConfig.SSHAuth.Password,
-	)
+	client, err := ssh.ConnectDevice(deviceConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to device: %w", err)
 	}
 	defer client.Close()
 
+	return GetDeviceSchemaFromClient(client, deviceConfig)
+}
+
+// GetDeviceSchemaFromClient retrieves the schema for a device using an
+// existing SSH client
+func GetDeviceSchemaFromClient(client ssh.SSHExecutor, deviceConfig *config.DeviceConfig) (*DeviceSchema, error) {
 	// Get board.json
-	boardJSON, err := getBoardJSON(client)
+	boardJSON, err := ReadBoardJSON(client)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get board.json: %w", err)
+		return nil, err
 	}
 
 	// Get radios
@@ -119,12 +156,38 @@ func GetDeviceSchema(deviceConfig *config.DeviceConfig) (*DeviceSchema, error) {
 		return nil, fmt.Errorf("failed to get config sections: %w", err)
 	}
 
-	// Get version
-	version, err := getDeviceVersion(client)
+	// Get version, target and architecture
+	release, err := GetDeviceRelease(client)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get device version: %w", err)
 	}
 
+	zoneinfo := getZoneinfo(client)
+
+	ports, isSwConfig, err := boardPorts(boardJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s at %s", err, deviceConfig.ModelID, deviceConfig.IPAddr)
+	}
+
+	schema := &DeviceSchema{
+		Name:           deviceConfig.ModelID,
+		Version:        release.Version,
+		Revision:       release.Revision,
+		Target:         release.Target,
+		Arch:           release.Arch,
+		SwConfig:       isSwConfig,
+		ConfigSections: configSections,
+		Ports:          ports,
+		Radios:         radios,
+		Zoneinfo:       zoneinfo,
+	}
+
+	return schema, nil
+}
+
+// boardPorts returns the ports board.json describes and whether the device
+// uses swconfig
+func boardPorts(boardJSON *BoardJSON) ([]Port, bool, error) {
 	// Determine if this is a swconfig device
 	isSwConfig := len(boardJSON.Switch) > 0
 
@@ -186,7 +249,7 @@ func GetDeviceSchema(deviceConfig *config.DeviceConfig) (*DeviceSchema, error) {
 	}
 
 	if len(ports) == 0 {
-		return nil, fmt.Errorf("found no ports for %s at %s", deviceConfig.ModelID, deviceConfig.IPAddr)
+		return nil, false, errors.New("found no ports")
 	}
 
 	if isSwConfig {
@@ -198,37 +261,66 @@ func GetDeviceSchema(deviceConfig *config.DeviceConfig) (*DeviceSchema, error) {
 			}
 		}
 		if !hasCPUPort {
-			return nil, fmt.Errorf("found no CPU port for swConfig device %s at %s", deviceConfig.ModelID, deviceConfig.IPAddr)
+			return nil, false, errors.New("found no swConfig CPU port")
 		}
 	}
 
-	schema := &DeviceSchema{
-		Name:           deviceConfig.ModelID,
-		Version:        version,
-		SwConfig:       isSwConfig,
-		ConfigSections: configSections,
-		Ports:          ports,
-		Radios:         radios,
-	}
-
-	return schema, nil
+	return ports, isSwConfig, nil
 }
 
-func getBoardJSON(client *ssh.Client) (*BoardJSON, error) {
+// ReadBoardJSON reads and parses the device's /etc/board.json, with errors
+// that say what is wrong, e.g. when the device isn't running OpenWrt
+func ReadBoardJSON(client ssh.SSHExecutor) (*BoardJSON, error) {
 	output, err := client.Execute("cat /etc/board.json")
 	if err != nil {
+		if strings.Contains(output, "No such file") {
+			return nil, fmt.Errorf("the device has no /etc/board.json, so it doesn't look like an OpenWrt device")
+		}
 		return nil, fmt.Errorf("failed to read /etc/board.json: %w", err)
 	}
 
+	return ParseBoardJSON(output)
+}
+
+// ParseBoardJSON parses the contents of /etc/board.json
+func ParseBoardJSON(output string) (*BoardJSON, error) {
+	if strings.TrimSpace(output) == "" {
+		return nil, fmt.Errorf("/etc/board.json on the device is empty; it is generated on first boot, so try rebooting the device")
+	}
+
 	var boardJSON BoardJSON
 	if err := json.Unmarshal([]byte(output), &boardJSON); err != nil {
-		return nil, fmt.Errorf("failed to parse board.json: %w", err)
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(output)):
+			line, column := position(output, int64(len(output)))
+			return nil, fmt.Errorf("/etc/board.json on the device is truncated at line %d, column %d", line, column)
+		case errors.As(err, &syntaxErr):
+			// Offset is just past the offending character
+			line, column := position(output, syntaxErr.Offset-1)
+			return nil, fmt.Errorf("/etc/board.json on the device is malformed at line %d, column %d: %v", line, column, err)
+		case errors.As(err, &typeErr):
+			return nil, fmt.Errorf("/etc/board.json on the device has a %s where a %s was expected at %s", typeErr.Value, typeErr.Type, typeErr.Field)
+		}
+		return nil, fmt.Errorf("failed to parse /etc/board.json: %w", err)
 	}
 
 	return &boardJSON, nil
 }
 
-func getRadios(client *ssh.Client) ([]Radio, error) {
+// position returns the 1-based line and column of the byte at offset in s
+func position(s string, offset int64) (int, int) {
+	if offset > int64(len(s)) {
+		offset = int64(len(s))
+	}
+	before := s[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := int(offset) - strings.LastIndex(before, "\n")
+	return line, column
+}
+
+func getRadios(client ssh.SSHExecutor) ([]Radio, error) {
 	output, err := client.Execute(`ubus call uci get '{"config": "wireless", "type": "wifi-device"}'`)
 	if err != nil {
 		// No wireless devices is not an error
@@ -251,13 +343,38 @@ func getRadios(client *ssh.Client) ([]Radio, error) {
 			Path: info.Path,
 			Band: info.Band,
 		}
+		probeRadioCapabilities(client, &radio)
 		radios = append(radios, radio)
 	}
 
+	// ubus returns the sections as an object, so order them by name
+	sort.Slice(radios, func(i, j int) bool {
+		return radios[i].Name < radios[j].Name
+	})
+
 	return radios, nil
 }
 
-func getConfigSections(client *ssh.Client) (map[string][]string, error) {
+// getZoneinfo lists the zones installed in /usr/share/zoneinfo, e.g.
+// Europe/London
+func getZoneinfo(client ssh.SSHExecutor) []string {
+	output, err := client.Execute("find /usr/share/zoneinfo -type f")
+	if err != nil {
+		return nil
+	}
+
+	var zones []string
+	for _, line := range splitLines(output) {
+		if zone, ok := strings.CutPrefix(strings.TrimSpace(line), "/usr/share/zoneinfo/"); ok && zone != "" {
+			zones = append(zones, zone)
+		}
+	}
+	sort.Strings(zones)
+
+	return zones
+}
+
+func getConfigSections(client ssh.SSHExecutor) (map[string][]string, error) {
 	// Get list of all config files
 	_, err := client.Execute("ls /etc/config")
 	if err != nil {
@@ -272,23 +389,131 @@ func getConfigSections(client *ssh.Client) (map[string][]string, error) {
 	return sections, nil
 }
 
-func getDeviceVersion(client *ssh.Client) (string, error) {
+// Release is the OpenWrt release metadata from /etc/openwrt_release
+type Release struct {
+	// Version is the release, e.g. 23.05.0
+	Version string
+	// Revision is the source revision, e.g. r23497-6637af95aa
+	Revision string
+	// Target is the target and subtarget, e.g. ramips/mt7621
+	Target string
+	// Arch is the package architecture, e.g. mipsel_24kc
+	Arch string
+}
+
+// GetDeviceRelease reads the OpenWrt release metadata from /etc/openwrt_release
+func GetDeviceRelease(client ssh.SSHExecutor) (*Release, error) {
 	output, err := client.Execute("cat /etc/openwrt_release")
 	if err != nil {
-		return "", fmt.Errorf("failed to read /etc/openwrt_release: %w", err)
+		return nil, fmt.Errorf("failed to read /etc/openwrt_release: %w", err)
 	}
 
-	// Parse the version from the output
-	// DISTRIB_RELEASE='23.05.0'
-	lines := splitLines(output)
-	for _, line := range lines {
-		if len(line) > 16 && line[:16] == "DISTRIB_RELEASE=" {
-			version := line[17 : len(line)-1] // Remove quotes
-			return version, nil
+	return ParseRelease(output)
+}
+
+// ParseRelease parses the shell variables of /etc/openwrt_release, e.g.
+// DISTRIB_RELEASE='23.05.0'
+func ParseRelease(output string) (*Release, error) {
+	release := &Release{}
+	for _, line := range splitLines(output) {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `'"`)
+
+		switch key {
+		case "DISTRIB_RELEASE":
+			release.Version = value
+		case "DISTRIB_REVISION":
+			release.Revision = value
+		case "DISTRIB_TARGET":
+			release.Target = value
+		case "DISTRIB_ARCH":
+			release.Arch = value
+		}
+	}
+
+	if release.Version == "" {
+		return nil, fmt.Errorf("failed to find DISTRIB_RELEASE in /etc/openwrt_release")
+	}
+
+	return release, nil
+}
+
+// GetDeviceVersion reads the OpenWrt release version from /etc/openwrt_release
+func GetDeviceVersion(client ssh.SSHExecutor) (string, error) {
+	release, err := GetDeviceRelease(client)
+	if err != nil {
+		return "", err
+	}
+	return release.Version, nil
+}
+
+// GetArchitecture returns the device's package architecture, i.e. the
+// highest priority architecture reported by opkg print-architecture
+func GetArchitecture(client ssh.SSHExecutor) (string, error) {
+	output, err := client.Execute("opkg print-architecture")
+	if err != nil {
+		return "", fmt.Errorf("failed to read package architectures: %w", err)
+	}
+
+	arch := ""
+	bestPriority := -1
+	for name, priority := range parseArchitectures(output) {
+		if name == "all" || name == "noarch" {
+			continue
+		}
+		if priority > bestPriority || (priority == bestPriority && name < arch) {
+			arch = name
+			bestPriority = priority
+		}
+	}
+
+	if arch == "" {
+		return "", fmt.Errorf("no architecture reported by opkg")
+	}
+
+	return arch, nil
+}
+
+// GetArchitectures returns every package architecture the device accepts,
+// including all and noarch
+func GetArchitectures(client ssh.SSHExecutor) ([]string, error) {
+	output, err := client.Execute("opkg print-architecture")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package architectures: %w", err)
+	}
+
+	var archs []string
+	for name := range parseArchitectures(output) {
+		archs = append(archs, name)
+	}
+	sort.Strings(archs)
+
+	if len(archs) == 0 {
+		return nil, fmt.Errorf("no architecture reported by opkg")
+	}
+
+	return archs, nil
+}
+
+// parseArchitectures parses opkg print-architecture output into a map of
+// architecture to priority
+func parseArchitectures(output string) map[string]int {
+	archs := make(map[string]int)
+
+	// Format: "arch mipsel_24kc 10"
+	for _, line := range splitLines(output) {
+		var name string
+		var priority int
+		if _, err := fmt.Sscanf(line, "arch %s %d", &name, &priority); err != nil {
+			continue
 		}
+		archs[name] = priority
 	}
 
-	return "", fmt.Errorf("failed to find DISTRIB_RELEASE in /etc/openwrt_release")
+	return archs
 }
 
 func splitLines(s string) []string {
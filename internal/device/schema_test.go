@@ -0,0 +1,111 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/condition"
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+func TestParseRelease(t *testing.T) {
+	release, err := ParseRelease(`DISTRIB_ID='OpenWrt'
+DISTRIB_RELEASE='23.05.2'
+DISTRIB_REVISION='r23630-842932a63d'
+DISTRIB_TARGET='ramips/mt7621'
+DISTRIB_ARCH='mipsel_24kc'
+DISTRIB_DESCRIPTION='OpenWrt 23.05.2 r23630-842932a63d'
+DISTRIB_TAINTS=''
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse release: %v", err)
+	}
+
+	expected := Release{Version: "23.05.2", Revision: "r23630-842932a63d", Target: "ramips/mt7621", Arch: "mipsel_24kc"}
+	if *release != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *release)
+	}
+
+	if _, err := ParseRelease("DISTRIB_ID='OpenWrt'\n"); err == nil {
+		t.Error("Expected error for a release without DISTRIB_RELEASE")
+	}
+}
+
+func TestTargetCondition(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{{ModelID: "ubnt,edgerouter-x", Hostname: "router"}},
+		PackageProfiles: []config.PackageProfile{
+			{If: config.Ptr(`device.target == "ramips/mt7621"`), Packages: []string{"kmod-mt7621-extra"}},
+			{If: config.Ptr(`device.arch == "aarch64_cortex-a53"`), Packages: []string{"kmod-arm-extra"}},
+		},
+	}
+	schema := &DeviceSchema{Name: "ubnt,edgerouter-x", Version: "23.05.2", Target: "ramips/mt7621", Arch: "mipsel_24kc"}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], schema)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	if len(state.PackagesToInstall) != 1 || state.PackagesToInstall[0].Name != "kmod-mt7621-extra" {
+		t.Errorf("Expected only the mt7621 package, got %+v", state.PackagesToInstall)
+	}
+
+	matches, err := condition.Evaluate(config.Ptr(`device.arch == "mipsel_24kc"`), &condition.ConditionContext{
+		DeviceConfig: &oncConfig.Devices[0],
+		DeviceSchema: &condition.DeviceSchema{Arch: schema.Arch},
+	})
+	if err != nil || !matches {
+		t.Errorf("Expected device.arch to match, got %t, %v", matches, err)
+	}
+}
+
+func TestReadBoardJSONErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		err      error
+		expected string
+	}{
+		{
+			name:     "missing",
+			output:   "cat: can't open '/etc/board.json': No such file or directory\n",
+			err:      fmt.Errorf("command failed: Process exited with status 1"),
+			expected: "doesn't look like an OpenWrt device",
+		},
+		{
+			name:     "empty",
+			output:   "\n",
+			expected: "/etc/board.json on the device is empty",
+		},
+		{
+			name:     "malformed",
+			output:   "{\n\t\"model\": {\n\t\t\"id\": \"ubnt,edgerouter-x\",,\n",
+			expected: "malformed at line 3, column 29",
+		},
+		{
+			name:     "truncated",
+			output:   "{\n\t\"model\": {\n\t\t\"id\": \"ubnt,edgerouter-x\"",
+			expected: "truncated at line 3, column 28",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+			mockClient.OnExecute = func(command string) (string, error) {
+				return tt.output, tt.err
+			}
+
+			_, err := ReadBoardJSON(mockClient)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected an error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	// A valid board.json parses
+	if boardJSON, err := ReadBoardJSON(ssh.NewMockClient("ubnt,edgerouter-x")); err != nil || boardJSON.Model.ID != "ubnt,edgerouter-x" {
+		t.Errorf("Expected the mock's board.json to parse, got %v, %v", boardJSON, err)
+	}
+}
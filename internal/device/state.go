@@ -3,10 +3,13 @@ package device
 import (
 	"encoding/json"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/drummonds/openwrt-configurator.git/internal/condition"
 	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/secret"
 	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
 	"github.com/drummonds/openwrt-configurator.git/internal/uci"
 )
@@ -17,17 +20,76 @@ type OpenWrtState struct {
 	PackagesToInstall     []uci.Package
 	PackagesToUninstall   []string
 	ConfigSectionsToReset map[string][]string
+
+	// ManagementInterface is the network interface the device is managed
+	// through. This interface's changes come at the end of the network
+	// config, which keeps its place in the apply order.
+	ManagementInterface string
+
+	// Files are written and PostCommands run after the UCI config is
+	// applied, for settings that aren't UCI
+	Files        []config.FileConfig
+	PostCommands []string
+
+	// InstalledPackages, when set, is used as the device's installed
+	// packages instead of reading them with opkg list-installed
+	InstalledPackages []uci.InstalledPackage
+
+	// RemovalDependents maps each package to uninstall to the installed
+	// packages that depend on it. When set, removals are ordered so
+	// dependents go first and are only forced when that isn't enough.
+	RemovalDependents map[string][]string
+
+	// RestartServices, when set, are the only init.d services restarted
+	// once the config is committed, instead of reload_config and the
+	// reloads of the changed configs' services
+	RestartServices []string
+
+	// SkipPackages leaves the device's packages alone, for config-only
+	// provisioning
+	SkipPackages bool
+
+	// Warnings are problems found while resolving the state that don't
+	// stop it being applied, e.g. deprecated option names
+	Warnings []string
+}
+
+// StateOptions are optional inputs to state generation
+type StateOptions struct {
+	// Secrets resolves ${secret.<name>} placeholders. When nil they are left
+	// in place, e.g. for offline validation.
+	Secrets secret.Resolver
+
+	// Executor, when set, lets conditions read the device's current uci
+	// values as uci.<config>.<section>.<option>
+	Executor ssh.SSHExecutor
 }
 
 // GetOpenWrtState generates the OpenWrt state for a device
 func GetOpenWrtState(oncConfig *config.ONCConfig, deviceConfig *config.DeviceConfig, deviceSchema *DeviceSchema) (*OpenWrtState, error) {
+	return GetOpenWrtStateWithOptions(oncConfig, deviceConfig, deviceSchema, StateOptions{})
+}
+
+// GetOpenWrtStateWithOptions generates the OpenWrt state for a device
+func GetOpenWrtStateWithOptions(oncConfig *config.ONCConfig, deviceConfig *config.DeviceConfig, deviceSchema *DeviceSchema, opts StateOptions) (*OpenWrtState, error) {
+	// Use the device's own port roles where board.json gets them wrong
+	deviceSchema, err := ApplyPortRoles(deviceSchema, deviceConfig.PortRoles)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx := &condition.ConditionContext{
 		DeviceConfig: deviceConfig,
 		DeviceSchema: &condition.DeviceSchema{
 			SwConfig: deviceSchema.SwConfig,
 			Version:  deviceSchema.Version,
+			Target:   deviceSchema.Target,
+			Arch:     deviceSchema.Arch,
 		},
 	}
+	if opts.Executor != nil {
+		ctx.Executor = opts.Executor
+	}
 
 	// Resolve config
 	openWrtConfig, err := resolveConfig(oncConfig, ctx)
@@ -35,23 +97,113 @@ func GetOpenWrtState(oncConfig *config.ONCConfig, deviceConfig *config.DeviceCon
 		return nil, fmt.Errorf("failed to resolve config: %w", err)
 	}
 
+	// Expand rule templates into a rule per port, then add the firewall
+	// rules of the bundles the device uses
+	if err := expandRuleTemplates(openWrtConfig); err != nil {
+		return nil, err
+	}
+	if err := applyFirewallBundles(openWrtConfig, oncConfig, ctx); err != nil {
+		return nil, err
+	}
+
+	// Interpolate ${device.*} and ${secret.*} references in string values
+	if err := interpolateConfig(openWrtConfig, deviceConfig, opts.Secrets); err != nil {
+		return nil, fmt.Errorf("failed to interpolate config: %w", err)
+	}
+
+	// Name generic radios after the device's radios of the same band,
+	// then use the option names the device's OpenWrt version expects
+	warnings := assignRadios(openWrtConfig, deviceSchema.Radios)
+	warnings = append(warnings, applyOptionAliases(openWrtConfig, deviceSchema.Version)...)
+
+	// Tag the CPU port on swconfig VLANs that leave it out
+	tagCPUPorts(openWrtConfig, deviceSchema)
+
+	// Add interfaces to the firewall zones they name
+	if err := assignInterfaceZones(openWrtConfig); err != nil {
+		return nil, fmt.Errorf("failed to assign interface zones: %w", err)
+	}
+
 	// Get packages
-	packagesToInstall, packagesToUninstall := resolvePackages(oncConfig, ctx)
+	packagesToInstall, packagesToUninstall, err := resolvePackages(oncConfig, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get files and commands for settings that aren't UCI
+	files, postCommands, err := resolveFiles(oncConfig, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve files: %w", err)
+	}
 
 	// Get config sections to reset
-	configsToNotReset := resolveConfigsToNotReset(oncConfig, ctx)
+	configsToNotReset, err := resolveConfigsToNotReset(oncConfig, ctx)
+	if err != nil {
+		return nil, err
+	}
 	configSectionsToReset := getConfigSectionsToReset(deviceSchema, configsToNotReset)
 
+	// Keep the device's static leases when its dhcp hosts are reset
+	if oncConfig.PreserveDHCPHosts && slices.Contains(configSectionsToReset["dhcp"], "host") {
+		if opts.Executor == nil {
+			warnings = append(warnings, "preserve_dhcp_hosts: the device's dhcp hosts can only be read when connected, so they are not included")
+		} else if err := preserveDHCPHosts(openWrtConfig, opts.Executor); err != nil {
+			return nil, err
+		}
+	}
+
 	state := &OpenWrtState{
 		Config:                openWrtConfig,
 		PackagesToInstall:     packagesToInstall,
 		PackagesToUninstall:   packagesToUninstall,
 		ConfigSectionsToReset: configSectionsToReset,
+		Files:                 files,
+		PostCommands:          postCommands,
+		Warnings:              warnings,
 	}
 
 	return state, nil
 }
 
+// DecodeConfig converts a resolved OpenWrt config back into the typed config
+func DecodeConfig(openWrtConfig map[string]any) (*config.ConfigConfig, error) {
+	data, err := json.Marshal(openWrtConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config.ConfigConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// ManagedConfigs returns the configs a configuration can manage on a
+// device, before conditions leave any out, e.g. to read them from the
+// device while its state is resolved
+func ManagedConfigs(oncConfig *config.ONCConfig) ([]string, error) {
+	configData, err := json.Marshal(oncConfig.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	var configMap map[string]json.RawMessage
+	if err := json.Unmarshal(configData, &configMap); err != nil {
+		return nil, err
+	}
+
+	var configs []string
+	for configKey := range configMap {
+		if configKey != "extra" {
+			configs = append(configs, configKey)
+		}
+	}
+	sort.Strings(configs)
+	return configs, nil
+}
+
 func resolveConfig(oncConfig *config.ONCConfig, ctx *condition.ConditionContext) (map[string]any, error) {
 	resolved := make(map[string]any)
 
@@ -78,7 +230,10 @@ func resolveConfig(oncConfig *config.ONCConfig, ctx *condition.ConditionContext)
 		}
 
 		// Apply conditions to the config object
-		appliedConfig := applyObject(configObj, ctx)
+		appliedConfig, err := applyObject(configObj, ctx)
+		if err != nil {
+			return nil, err
+		}
 		if len(appliedConfig) == 0 {
 			continue
 		}
@@ -102,7 +257,10 @@ func resolveConfig(oncConfig *config.ONCConfig, ctx *condition.ConditionContext)
 					continue
 				}
 
-				resolvedSection := applyObject(sectionMap, ctx)
+				resolvedSection, err := applyObject(sectionMap, ctx)
+				if err != nil {
+					return nil, err
+				}
 				if len(resolvedSection) > 0 {
 					resolvedSectionList = append(resolvedSectionList, resolvedSection)
 				}
@@ -121,7 +279,7 @@ func resolveConfig(oncConfig *config.ONCConfig, ctx *condition.ConditionContext)
 	return resolved, nil
 }
 
-func applyObject(obj map[string]any, ctx *condition.ConditionContext) map[string]any {
+func applyObject(obj map[string]any, ctx *condition.ConditionContext) (map[string]any, error) {
 	// Check if condition
 	var conditionStr *string
 	if ifVal, ok := obj[".if"]; ok {
@@ -130,9 +288,12 @@ func applyObject(obj map[string]any, ctx *condition.ConditionContext) map[string
 		}
 	}
 
-	matches := condition.Evaluate(conditionStr, ctx)
+	matches, err := condition.Evaluate(conditionStr, ctx)
+	if err != nil {
+		return nil, err
+	}
 	if !matches {
-		return make(map[string]any)
+		return make(map[string]any), nil
 	}
 
 	// Apply overrides
@@ -160,10 +321,20 @@ func applyObject(obj map[string]any, ctx *condition.ConditionContext) map[string
 					}
 				}
 
-				if condition.Evaluate(overrideCondition, ctx) {
+				matches, err := condition.Evaluate(overrideCondition, ctx)
+				if err != nil {
+					return nil, err
+				}
+				if matches {
 					if overrideData, ok := overrideMap["override"].(map[string]any); ok {
 						for k, v := range overrideData {
-							result[k] = v
+							// A key ending in + appends to the list
+							// instead of replacing it
+							if base, ok := strings.CutSuffix(k, "+"); ok {
+								result[base] = appendList(result[base], v)
+							} else {
+								result[k] = v
+							}
 						}
 					}
 				}
@@ -171,34 +342,134 @@ func applyObject(obj map[string]any, ctx *condition.ConditionContext) map[string
 		}
 	}
 
-	return result
+	return result, nil
 }
 
-func resolvePackages(oncConfig *config.ONCConfig, ctx *condition.ConditionContext) ([]uci.Package, []string) {
-	var allPackages []string
+// appendList appends value, a list or a single item, to the list existing.
+// A single existing value is treated as a list of one.
+func appendList(existing, value any) []any {
+	var list []any
+	switch existing := existing.(type) {
+	case nil:
+	case []any:
+		list = append(list, existing...)
+	default:
+		list = append(list, existing)
+	}
 
-	for _, profile := range oncConfig.PackageProfiles {
-		if condition.Evaluate(profile.If, ctx) {
-			allPackages = append(allPackages, profile.Packages...)
+	if items, ok := value.([]any); ok {
+		return append(list, items...)
+	}
+	return append(list, value)
+}
+
+// assignInterfaceZones appends each interface carrying a zone hint to the
+// network list of the named firewall zone, and removes the hint
+func assignInterfaceZones(openWrtConfig map[string]any) error {
+	interfaces := getSections(openWrtConfig, "network", "interface")
+	zones := getSections(openWrtConfig, "firewall", "zone")
+
+	for _, iface := range interfaces {
+		zoneVal, ok := iface["zone"]
+		if !ok {
+			continue
+		}
+		delete(iface, "zone")
+
+		zoneName, ok := zoneVal.(string)
+		if !ok {
+			return fmt.Errorf("zone of interface %v must be a string", iface[".name"])
 		}
+		ifaceName, ok := iface[".name"].(string)
+		if !ok {
+			return fmt.Errorf("interface with zone %s has no name", zoneName)
+		}
+
+		var zone map[string]any
+		for _, z := range zones {
+			if z["name"] == zoneName || (z["name"] == nil && z[".name"] == zoneName) {
+				zone = z
+				break
+			}
+		}
+		if zone == nil {
+			return fmt.Errorf("interface %s references unknown firewall zone %s", ifaceName, zoneName)
+		}
+
+		var networks []any
+		switch v := zone["network"].(type) {
+		case []any:
+			networks = v
+		case string:
+			networks = []any{v}
+		}
+
+		present := false
+		for _, network := range networks {
+			if network == ifaceName {
+				present = true
+				break
+			}
+		}
+		if !present {
+			networks = append(networks, ifaceName)
+		}
+		zone["network"] = networks
 	}
 
-	// Deduplicate
-	packageSet := make(map[string]bool)
-	for _, pkg := range allPackages {
-		packageSet[pkg] = true
+	return nil
+}
+
+// getSections returns the resolved sections of the given type
+func getSections(openWrtConfig map[string]any, configKey, sectionKey string) []map[string]any {
+	configMap, ok := openWrtConfig[configKey].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	sections, ok := configMap[sectionKey].([]any)
+	if !ok {
+		return nil
+	}
+
+	var result []map[string]any
+	for _, section := range sections {
+		if sectionMap, ok := section.(map[string]any); ok {
+			result = append(result, sectionMap)
+		}
+	}
+
+	return result
+}
+
+func resolvePackages(oncConfig *config.ONCConfig, ctx *condition.ConditionContext) ([]uci.Package, []string, error) {
+	// Deduplicate, keeping the lowest priority a package is listed with
+	priorities := make(map[string]int)
+	for _, profile := range oncConfig.PackageProfiles {
+		matches, err := condition.Evaluate(profile.If, ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !matches {
+			continue
+		}
+		for _, pkg := range profile.Packages {
+			if priority, ok := priorities[pkg]; !ok || profile.Priority < priority {
+				priorities[pkg] = profile.Priority
+			}
+		}
 	}
 
 	var install []uci.Package
 	var uninstall []string
 
-	for pkg := range packageSet {
+	for pkg, priority := range priorities {
 		if len(pkg) > 0 && pkg[0] == '-' {
 			uninstall = append(uninstall, pkg[1:])
 		} else {
 			// Check if package has version specifier
 			parts := strings.Split(pkg, "@")
-			p := uci.Package{Name: parts[0]}
+			p := uci.Package{Name: parts[0], Priority: priority}
 			if len(parts) > 1 {
 				p.Version = parts[1]
 			}
@@ -206,19 +477,26 @@ func resolvePackages(oncConfig *config.ONCConfig, ctx *condition.ConditionContex
 		}
 	}
 
-	return install, uninstall
+	sort.Strings(uninstall)
+	uci.SortPackages(install)
+
+	return install, uninstall, nil
 }
 
-func resolveConfigsToNotReset(oncConfig *config.ONCConfig, ctx *condition.ConditionContext) []string {
+func resolveConfigsToNotReset(oncConfig *config.ONCConfig, ctx *condition.ConditionContext) ([]string, error) {
 	var configs []string
 
 	for _, item := range oncConfig.ConfigsToNotReset {
-		if condition.Evaluate(item.If, ctx) {
+		matches, err := condition.Evaluate(item.If, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
 			configs = append(configs, item.Configs...)
 		}
 	}
 
-	return configs
+	return configs, nil
 }
 
 func getConfigSectionsToReset(deviceSchema *DeviceSchema, configsToNotReset []string) map[string][]string {
@@ -255,34 +533,170 @@ func getConfigSectionsToReset(deviceSchema *DeviceSchema, configsToNotReset []st
 func GetDeviceScript(state *OpenWrtState, sshClient ssh.SSHExecutor) ([]string, error) {
 	var commands []string
 
-	// Get installed packages if SSH client is provided
-	var installedPackages []uci.InstalledPackage
-	if sshClient != nil {
-		output, err := sshClient.Execute("opkg list-installed")
-		if err == nil {
-			installedPackages = parseInstalledPackages(output)
+	if !state.SkipPackages {
+		// Get installed packages if they aren't assumed and an SSH client
+		// is provided
+		installedPackages := state.InstalledPackages
+		if installedPackages == nil && sshClient != nil {
+			output, err := sshClient.Execute("opkg list-installed")
+			if err == nil {
+				installedPackages = parseInstalledPackages(output)
+			}
+		}
+
+		// Generate package commands
+		packageCommands := uci.GetPackageCommands(state.PackagesToInstall, state.PackagesToUninstall, installedPackages, state.RemovalDependents)
+		commands = append(commands, packageCommands...)
+	}
+
+	// Only restart the radios whose config changes, leaving the wireless
+	// config alone when nothing in it does
+	configs := getScriptConfigs(state)
+	var radios []string
+	targetedWireless := false
+	if sshClient != nil && state.Config["wireless"] != nil {
+		radios, targetedWireless = changedRadios(sshClient, state)
+		if targetedWireless && len(radios) == 0 {
+			configs = slices.DeleteFunc(configs, func(configKey string) bool { return configKey == "wireless" })
 		}
 	}
 
-	// Generate package commands
-	packageCommands := uci.GetPackageCommands(state.PackagesToInstall, state.PackagesToUninstall, installedPackages)
-	commands = append(commands, packageCommands...)
+	// Reset, set and commit each config in turn, so a failure part way
+	// through never leaves another config half applied. When we're managed
+	// through one of the network's interfaces, that interface's changes go
+	// at the end of the network.
+	for _, configKey := range configs {
+		configCommands := generateConfigCommands(configKey, state.Config[configKey])
+		if configKey == "network" && state.ManagementInterface != "" {
+			other, management := uci.SplitSectionCommands(configCommands, "network."+state.ManagementInterface)
+			configCommands = append(other, management...)
+		}
 
-	// Generate reset commands
-	resetCommands := uci.GetResetCommands(state.ConfigSectionsToReset)
-	commands = append(commands, resetCommands...)
+		commands = append(commands, uci.GetConfigResetCommands(configKey, state.ConfigSectionsToReset[configKey])...)
+		commands = append(commands, configCommands...)
+		commands = append(commands, fmt.Sprintf("uci commit %s", configKey))
+	}
 
-	// Generate UCI commands
-	uciCommands := uci.GenerateCommands(state.Config)
-	commands = append(commands, uciCommands...)
+	// Reload once everything is committed, then restart the services that
+	// reload_config alone leaves stale. Builds without reload_config get
+	// the config change events it would have sent instead.
+	var reloads []string
+	for _, reload := range getServiceReloads(configs) {
+		if reload == "wifi reload" && targetedWireless {
+			for _, radio := range radios {
+				reloads = append(reloads, fmt.Sprintf("wifi up %s", radio))
+			}
+			continue
+		}
+		reloads = append(reloads, reload)
+	}
+	switch {
+	case len(state.RestartServices) > 0:
+		for _, service := range state.RestartServices {
+			commands = append(commands, fmt.Sprintf("/etc/init.d/%s restart", service))
+		}
+	case sshClient == nil || hasReloadConfig(sshClient):
+		commands = append(commands, "reload_config")
+		commands = append(commands, reloads...)
+	default:
+		commands = append(commands, getConfigChangeEvents(configs)...)
+		commands = append(commands, reloads...)
+	}
 
-	// Add commit and reload commands
-	commands = append(commands, "uci commit")
-	commands = append(commands, "reload_config")
+	// Then write files and run the commands for everything that isn't UCI
+	commands = append(commands, getFileCommands(state.Files)...)
+	commands = append(commands, state.PostCommands...)
 
 	return commands, nil
 }
 
+// serviceReloads lists the commands needed for changes to a config to take
+// effect beyond reload_config, in the order they are run. The firewall init
+// script runs fw4 reload on fw4 devices and fw3 reload on older ones.
+var serviceReloads = []struct {
+	config  string
+	command string
+}{
+	{"network", "/etc/init.d/network reload"},
+	{"firewall", "/etc/init.d/firewall reload"},
+	{"wireless", "wifi reload"},
+}
+
+// getServiceReloads returns the service reload commands for the changed configs
+func getServiceReloads(configs []string) []string {
+	changed := make(map[string]bool)
+	for _, configKey := range configs {
+		changed[configKey] = true
+	}
+
+	var commands []string
+	for _, reload := range serviceReloads {
+		if changed[reload.config] {
+			commands = append(commands, reload.command)
+		}
+	}
+
+	return commands
+}
+
+// hasReloadConfig reports whether the device has reload_config, which some
+// minimal and very old builds lack
+func hasReloadConfig(client ssh.SSHExecutor) bool {
+	_, err := client.Execute("which reload_config")
+	return err == nil
+}
+
+// getConfigChangeEvents returns the procd config change events for the
+// changed configs, which is what reload_config sends
+func getConfigChangeEvents(configs []string) []string {
+	var commands []string
+	for _, configKey := range configs {
+		commands = append(commands, fmt.Sprintf(`ubus call service event '{"type":"config.change","data":{"package":"%s"}}'`, configKey))
+	}
+	return commands
+}
+
+// configPhases is the order configs are applied in, following their
+// dependencies: dhcp and firewall refer to network interfaces, and radios
+// bind to networks that must already be committed. Other configs follow in
+// alphabetical order.
+var configPhases = []string{"network", "dhcp", "firewall", "wireless"}
+
+// getScriptConfigs returns the configs that are reset or set by the script,
+// in the order they are applied
+func getScriptConfigs(state *OpenWrtState) []string {
+	configSet := make(map[string]bool)
+	for configKey := range state.ConfigSectionsToReset {
+		configSet[configKey] = true
+	}
+	for configKey := range state.Config {
+		configSet[configKey] = true
+	}
+
+	configs := make([]string, 0, len(configSet))
+	for configKey := range configSet {
+		configs = append(configs, configKey)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		pi, pj := configPhase(configs[i]), configPhase(configs[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return configs[i] < configs[j]
+	})
+
+	return configs
+}
+
+// configPhase returns the position of a config in configPhases, with
+// configs outside it coming last
+func configPhase(configKey string) int {
+	if i := slices.Index(configPhases, configKey); i >= 0 {
+		return i
+	}
+	return len(configPhases)
+}
+
 func parseInstalledPackages(output string) []uci.InstalledPackage {
 	var packages []uci.InstalledPackage
 
@@ -0,0 +1,841 @@
+package device
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// getSection returns the resolved section with the given name
+func getSection(t *testing.T, state *OpenWrtState, configKey, sectionKey, name string) map[string]any {
+	t.Helper()
+
+	configMap, ok := state.Config[configKey].(map[string]any)
+	if !ok {
+		t.Fatalf("Config %s not found in state", configKey)
+	}
+	sections, ok := configMap[sectionKey].([]any)
+	if !ok {
+		t.Fatalf("Section type %s.%s not found in state", configKey, sectionKey)
+	}
+	for _, section := range sections {
+		sectionMap := section.(map[string]any)
+		if sectionMap[".name"] == name {
+			return sectionMap
+		}
+	}
+	t.Fatalf("Section %s.%s not found in state", configKey, name)
+	return nil
+}
+
+func TestInterpolateTemplatedSSID(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:  "tplink,eap245-v3",
+				Hostname: "ap-1",
+				Tags:     map[string]any{"site": "london"},
+			},
+		},
+		Config: config.ConfigConfig{
+			Wireless: &config.WirelessConfig{
+				WifiIface: []config.WifiIfaceSection{
+					{
+						Name: config.Ptr("guest"),
+						SSID: config.Ptr("Guest-${device.tag.site}"),
+					},
+				},
+			},
+		},
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	section := getSection(t, state, "wireless", "wifi-iface", "guest")
+	if section["ssid"] != "Guest-london" {
+		t.Errorf("Expected ssid 'Guest-london', got '%v'", section["ssid"])
+	}
+}
+
+func TestInterpolateTemplatedIPAddr(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:  "ubnt,edgerouter-x",
+				Hostname: "router",
+				Tags:     map[string]any{"subnet": 20},
+			},
+		},
+		Config: config.ConfigConfig{
+			Network: &config.NetworkConfig{
+				Interface: []config.InterfaceSection{
+					{
+						Name:   config.Ptr("lan"),
+						Proto:  config.Ptr("static"),
+						IPAddr: config.Ptr("10.${device.tag.subnet}.0.1"),
+					},
+				},
+			},
+		},
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	section := getSection(t, state, "network", "interface", "lan")
+	if section["ipaddr"] != "10.20.0.1" {
+		t.Errorf("Expected ipaddr '10.20.0.1', got '%v'", section["ipaddr"])
+	}
+}
+
+func TestInterpolateUnknownTag(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:  "ubnt,edgerouter-x",
+				Hostname: "router",
+				Tags:     map[string]any{},
+			},
+		},
+		Config: config.ConfigConfig{
+			System: &config.SystemConfig{
+				System: []config.SystemSection{
+					{
+						Name:     config.Ptr("system"),
+						Hostname: config.Ptr("${device.tag.missing}"),
+					},
+				},
+			},
+		},
+	}
+
+	_, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err == nil {
+		t.Fatal("Expected error for unknown tag reference")
+	}
+	if !strings.Contains(err.Error(), "missing") {
+		t.Errorf("Expected error to name the missing tag, got: %v", err)
+	}
+}
+
+func TestInterpolateSecret(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "tplink,eap245-v3", Hostname: "ap-1"},
+		},
+		Config: config.ConfigConfig{
+			Wireless: &config.WirelessConfig{
+				WifiIface: []config.WifiIfaceSection{
+					{
+						Name: config.Ptr("guest"),
+						Key:  config.Ptr("${secret.guest_key}"),
+					},
+				},
+			},
+		},
+	}
+
+	// Without a resolver the placeholder is kept
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	if key := getSection(t, state, "wireless", "wifi-iface", "guest")["key"]; key != "${secret.guest_key}" {
+		t.Errorf("Expected the placeholder to be kept, got '%v'", key)
+	}
+
+	secrets := func(name string) (string, bool, error) {
+		return "hunter22", name == "guest_key", nil
+	}
+	state, err = GetOpenWrtStateWithOptions(oncConfig, &oncConfig.Devices[0], &DeviceSchema{}, StateOptions{Secrets: secrets})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	if key := getSection(t, state, "wireless", "wifi-iface", "guest")["key"]; key != "hunter22" {
+		t.Errorf("Expected the resolved secret, got '%v'", key)
+	}
+}
+
+func TestInterfaceZoneAssignment(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:  "ubnt,edgerouter-x",
+				Hostname: "router",
+			},
+		},
+		Config: config.ConfigConfig{
+			Network: &config.NetworkConfig{
+				Interface: []config.InterfaceSection{
+					{
+						Name:  config.Ptr("iot"),
+						Proto: config.Ptr("static"),
+						Zone:  config.Ptr("lan"),
+					},
+				},
+			},
+			Firewall: &config.FirewallConfig{
+				Zone: []config.ZoneSection{
+					{
+						Name:     config.Ptr("lan"),
+						ZoneName: config.Ptr("lan"),
+						Network:  []string{"lan"},
+					},
+				},
+			},
+		},
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	zone := getSection(t, state, "firewall", "zone", "lan")
+	networks, ok := zone["network"].([]any)
+	if !ok || len(networks) != 2 || networks[0] != "lan" || networks[1] != "iot" {
+		t.Errorf("Expected zone networks [lan iot], got %v", zone["network"])
+	}
+
+	iface := getSection(t, state, "network", "interface", "iot")
+	if _, ok := iface["zone"]; ok {
+		t.Error("Expected zone hint to be removed from the interface")
+	}
+
+	// Unknown zones are rejected
+	oncConfig.Config.Network.Interface[0].Zone = config.Ptr("dmz")
+	if _, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{}); err == nil {
+		t.Error("Expected error for unknown zone")
+	}
+}
+
+func TestDeviceScriptCommitsPerConfig(t *testing.T) {
+	state := &OpenWrtState{
+		Config: map[string]any{
+			"network": map[string]any{
+				"interface": []any{
+					map[string]any{".name": "lan", "proto": "static"},
+				},
+			},
+			"firewall": map[string]any{
+				"zone": []any{
+					map[string]any{".name": "lan", "input": "ACCEPT"},
+				},
+			},
+		},
+		ConfigSectionsToReset: map[string][]string{
+			"firewall": {"zone"},
+		},
+	}
+
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+
+	expected := []string{
+		"uci set network.lan=interface",
+		"uci set network.lan.proto='static'",
+		"uci commit network",
+		"while uci -q delete firewall.@zone[0]; do :; done",
+		"uci set firewall.lan=zone",
+		"uci set firewall.lan.input='ACCEPT'",
+		"uci commit firewall",
+		"reload_config",
+		"/etc/init.d/network reload",
+		"/etc/init.d/firewall reload",
+	}
+
+	if strings.Join(commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected script:\n%s\nexpected:\n%s", strings.Join(commands, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestDeviceScriptPhaseOrder(t *testing.T) {
+	for _, managementInterface := range []string{"", "lan"} {
+		state := &OpenWrtState{
+			PackagesToInstall: []uci.Package{{Name: "wpad-basic-mbedtls"}},
+			Config: map[string]any{
+				"wireless": map[string]any{
+					"wifi-iface": []any{
+						map[string]any{".name": "default_radio0", "network": "lan"},
+					},
+				},
+				"system": map[string]any{
+					"system": []any{
+						map[string]any{".name": "@system[0]", "hostname": "ap"},
+					},
+				},
+				"network": map[string]any{
+					"interface": []any{
+						map[string]any{".name": "lan", "proto": "static"},
+						map[string]any{".name": "wan", "proto": "dhcp"},
+					},
+				},
+				"dhcp": map[string]any{
+					"dhcp": []any{
+						map[string]any{".name": "lan", "interface": "lan"},
+					},
+				},
+			},
+			ManagementInterface: managementInterface,
+		}
+
+		commands, err := GetDeviceScript(state, nil)
+		if err != nil {
+			t.Fatalf("Failed to get device script: %v", err)
+		}
+
+		index := func(command string) int {
+			for i, c := range commands {
+				if c == command {
+					return i
+				}
+			}
+			t.Fatalf("Missing command %q in script:\n%s", command, strings.Join(commands, "\n"))
+			return -1
+		}
+
+		// Packages, then network committed before dhcp and wireless are set,
+		// then the other configs. The management interface's changes end
+		// the network, which keeps its place.
+		order := []string{
+			"opkg install wpad-basic-mbedtls",
+			"uci commit network",
+			"uci set dhcp.lan.interface='lan'",
+			"uci set wireless.default_radio0.network='lan'",
+			"uci commit wireless",
+			"uci set system.@system[0].hostname='ap'",
+		}
+		if managementInterface != "" {
+			order = append([]string{"uci set network.wan.proto='dhcp'", "uci set network.lan.proto='static'"}, order[1:]...)
+		}
+		for i := 1; i < len(order); i++ {
+			if index(order[i-1]) > index(order[i]) {
+				t.Errorf("Expected %q before %q in script:\n%s", order[i-1], order[i], strings.Join(commands, "\n"))
+			}
+		}
+	}
+}
+
+func TestDeviceScriptChangedRadios(t *testing.T) {
+	state := &OpenWrtState{
+		Config: map[string]any{
+			"wireless": map[string]any{
+				"wifi-device": []any{
+					map[string]any{".name": "radio0", "band": "2g", "channel": "1"},
+					map[string]any{".name": "radio1", "band": "5g", "channel": "36"},
+				},
+				"wifi-iface": []any{
+					map[string]any{".name": "default_radio0", "device": "radio0", "ssid": "home"},
+					map[string]any{".name": "default_radio1", "device": "radio1", "ssid": "home-5g"},
+				},
+			},
+		},
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show wireless"] = `wireless.radio0=wifi-device
+wireless.radio0.band='2g'
+wireless.radio0.channel='1'
+wireless.radio1=wifi-device
+wireless.radio1.band='5g'
+wireless.radio1.channel='36'
+wireless.default_radio0=wifi-iface
+wireless.default_radio0.device='radio0'
+wireless.default_radio0.ssid='home'
+wireless.default_radio1=wifi-iface
+wireless.default_radio1.device='radio1'
+wireless.default_radio1.ssid='old-5g'
+`
+
+	// Only the 5GHz SSID changes, so only radio1 is restarted
+	commands, err := GetDeviceScript(state, mockClient)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+	if !slices.Contains(commands, "wifi up radio1") {
+		t.Errorf("Expected radio1 to be restarted:\n%s", strings.Join(commands, "\n"))
+	}
+	if slices.Contains(commands, "wifi up radio0") || slices.Contains(commands, "wifi reload") {
+		t.Errorf("Expected radio0 to be left alone:\n%s", strings.Join(commands, "\n"))
+	}
+
+	// Nothing changes, so the wireless config isn't touched at all
+	mockClient.Responses["uci show wireless"] = strings.Replace(mockClient.Responses["uci show wireless"], "old-5g", "home-5g", 1)
+	commands, err = GetDeviceScript(state, mockClient)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+	for _, cmd := range commands {
+		if strings.Contains(cmd, "wireless") || strings.HasPrefix(cmd, "wifi") {
+			t.Errorf("Unexpected wireless command for an unchanged config: %s", cmd)
+		}
+	}
+}
+
+func TestDeviceScriptWirelessReload(t *testing.T) {
+	state := &OpenWrtState{
+		Config: map[string]any{
+			"wireless": map[string]any{
+				"wifi-iface": []any{
+					map[string]any{".name": "default_radio0", "ssid": "home"},
+				},
+			},
+		},
+	}
+
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+
+	if commands[len(commands)-1] != "wifi reload" {
+		t.Errorf("Expected script to end with wifi reload, got:\n%s", strings.Join(commands, "\n"))
+	}
+	for _, cmd := range commands {
+		if strings.Contains(cmd, "firewall reload") || strings.Contains(cmd, "network reload") {
+			t.Errorf("Unexpected reload for unchanged config: %s", cmd)
+		}
+	}
+}
+
+func TestPackagePriority(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		PackageProfiles: []config.PackageProfile{
+			{Packages: []string{"wireguard-tools", "kmod-wireguard"}},
+			{Packages: []string{"kmod-wireguard"}, Priority: -1},
+		},
+	}
+	deviceConfig := &config.DeviceConfig{ModelID: "ubnt,edgerouter-x"}
+
+	state, err := GetOpenWrtState(oncConfig, deviceConfig, &DeviceSchema{Name: "ubnt,edgerouter-x"})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+
+	if commands[1] != "opkg install kmod-wireguard" || commands[2] != "opkg install wireguard-tools" {
+		t.Errorf("Expected kmod-wireguard to be installed first, got:\n%s", strings.Join(commands, "\n"))
+	}
+}
+
+func TestDeviceScriptRerun(t *testing.T) {
+	state := &OpenWrtState{
+		Config: map[string]any{
+			"network": map[string]any{
+				"interface": []any{
+					map[string]any{".name": "lan", "proto": "static", "ipaddr": "10.0.0.1", "dns": []any{"1.1.1.1", "8.8.8.8"}},
+				},
+			},
+		},
+		SkipPackages: true,
+	}
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get script: %v", err)
+	}
+
+	// Running the script again leaves lists as they were, not doubled
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	for run := 0; run < 2; run++ {
+		for _, cmd := range commands {
+			if _, err := mockClient.Execute(cmd); err != nil {
+				t.Fatalf("Command failed: %s: %v", cmd, err)
+			}
+		}
+		if dns := mockClient.GetUCIValue("network", "lan", "dns"); dns != "1.1.1.1 8.8.8.8" {
+			t.Errorf("Run %d: expected dns '1.1.1.1 8.8.8.8', got '%s'", run+1, dns)
+		}
+	}
+}
+
+func TestDeviceScriptWithoutReloadConfig(t *testing.T) {
+	state := &OpenWrtState{
+		Config: map[string]any{
+			"network": map[string]any{
+				"interface": []any{
+					map[string]any{".name": "lan", "proto": "static", "ipaddr": "10.0.0.1"},
+				},
+			},
+			"system": map[string]any{
+				"system": []any{
+					map[string]any{".name": "system", "hostname": "router"},
+				},
+			},
+		},
+		SkipPackages: true,
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.FailOnCommand = "which reload_config"
+	commands, err := GetDeviceScript(state, mockClient)
+	if err != nil {
+		t.Fatalf("Failed to get script: %v", err)
+	}
+	script := strings.Join(commands, "\n") + "\n"
+
+	if strings.Contains(script, "reload_config\n") {
+		t.Errorf("Expected no reload_config on a device without it:\n%s", script)
+	}
+	for _, expected := range []string{
+		`ubus call service event '{"type":"config.change","data":{"package":"network"}}'`,
+		`ubus call service event '{"type":"config.change","data":{"package":"system"}}'`,
+		"/etc/init.d/network reload",
+	} {
+		if !strings.Contains(script, expected+"\n") {
+			t.Errorf("Expected %q in script:\n%s", expected, script)
+		}
+	}
+
+	// reload_config is used where it exists
+	commands, err = GetDeviceScript(state, ssh.NewMockClient("ubnt,edgerouter-x"))
+	if err != nil {
+		t.Fatalf("Failed to get script: %v", err)
+	}
+	if script := strings.Join(commands, "\n"); !strings.Contains(script, "reload_config") || strings.Contains(script, "ubus call service event") {
+		t.Errorf("Expected reload_config to be used:\n%s", script)
+	}
+}
+
+// TestProtoNoneInterface tests that an interface that is only brought up,
+// and a bridge with no interface at all, generate minimal commands
+func TestProtoNoneInterface(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Config: config.ConfigConfig{
+			Network: &config.NetworkConfig{
+				Device: []config.DeviceSection{
+					{Name: config.Ptr("br_trunk"), DeviceName: config.Ptr("br-trunk"), Type: config.Ptr("bridge"), Ports: []string{"lan1", "lan2"}},
+					{Name: config.Ptr("br_spare"), DeviceName: config.Ptr("br-spare"), Type: config.Ptr("bridge"), Ports: []string{"lan3"}},
+				},
+				Interface: []config.InterfaceSection{
+					{Name: config.Ptr("trunk"), Device: config.Ptr("br-trunk"), Proto: config.Ptr("none")},
+				},
+			},
+		},
+	}
+	deviceConfig := &config.DeviceConfig{ModelID: "ubnt,edgerouter-x", Hostname: "router"}
+
+	state, err := GetOpenWrtState(oncConfig, deviceConfig, &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	expected := []string{
+		"uci set network.br_trunk=device",
+		"uci set network.br_trunk.name='br-trunk'",
+		"uci -q delete network.br_trunk.ports || true",
+		"uci add_list network.br_trunk.ports='lan1'",
+		"uci add_list network.br_trunk.ports='lan2'",
+		"uci set network.br_trunk.type='bridge'",
+		"uci set network.br_spare=device",
+		"uci set network.br_spare.name='br-spare'",
+		"uci -q delete network.br_spare.ports || true",
+		"uci add_list network.br_spare.ports='lan3'",
+		"uci set network.br_spare.type='bridge'",
+		"uci set network.trunk=interface",
+		"uci set network.trunk.device='br-trunk'",
+		"uci set network.trunk.proto='none'",
+	}
+	commands := generateConfigCommands("network", state.Config["network"])
+	if strings.Join(commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected commands:\n%s\nexpected:\n%s", strings.Join(commands, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestListOverrides(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [
+			{ "model_id": "ubnt,edgerouter-x", "hostname": "router", "tags": { "role": "router" } }
+		],
+		"config": {
+			"network": {
+				"interface": [
+					{
+						".name": "lan",
+						"dns": ["1.1.1.1"],
+						".overrides": [
+							{ ".if": "device.tag.role == 'router'", "override": { "dns+": ["9.9.9.9"] } },
+							{ ".if": "device.tag.role == 'router'", "override": { "dns+": "149.112.112.112" } },
+							{ ".if": "device.tag.role == 'ap'", "override": { "dns+": ["192.168.1.1"] } }
+						]
+					},
+					{
+						".name": "wan",
+						"dns": ["1.1.1.1"],
+						".overrides": [
+							{ ".if": "device.tag.role == 'router'", "override": { "dns": ["8.8.8.8"] } }
+						]
+					}
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	// dns+ appends to the list, in override order
+	lan := getSection(t, state, "network", "interface", "lan")
+	if dns := fmt.Sprint(lan["dns"]); dns != "[1.1.1.1 9.9.9.9 149.112.112.112]" {
+		t.Errorf("Expected appended dns servers, got %s", dns)
+	}
+	if _, ok := lan["dns+"]; ok {
+		t.Error("Expected the dns+ key not to be emitted")
+	}
+
+	// dns replaces it
+	wan := getSection(t, state, "network", "interface", "wan")
+	if dns := fmt.Sprint(wan["dns"]); dns != "[8.8.8.8]" {
+		t.Errorf("Expected replaced dns servers, got %s", dns)
+	}
+}
+
+func TestDHCPv6PrefixDelegation(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [{ "model_id": "ubnt,edgerouter-x", "hostname": "router" }],
+		"config": {
+			"network": {
+				"interface": [
+					{ ".name": "wan6", "device": "eth0", "proto": "dhcpv6", "reqaddress": "try", "reqprefix": "56" },
+					{ ".name": "lan", "device": "br-lan", "proto": "static", "ipaddr": "192.168.1.1", "ip6class": ["wan6"], "ip6ifaceid": "::1" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get script: %v", err)
+	}
+	script := strings.Join(commands, "\n")
+
+	for _, expected := range []string{
+		"uci set network.wan6.reqaddress='try'",
+		"uci set network.wan6.reqprefix='56'",
+		"uci add_list network.lan.ip6class='wan6'",
+		"uci set network.lan.ip6ifaceid='::1'",
+	} {
+		if !strings.Contains(script, expected+"\n") {
+			t.Errorf("Expected %q in script:\n%s", expected, script)
+		}
+	}
+}
+
+func TestSectionConditionsInArrays(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [{ "model_id": "ubnt,edgerouter-x", "hostname": "router", "tags": { "role": "router" } }],
+		"config": {
+			"firewall": {
+				"rule": [
+					{ ".name": "allow_ssh", ".if": "device.tag.role == 'router'", "src": "wan", "dest_port": "22", "target": "ACCEPT" },
+					{ ".name": "allow_mesh", ".if": "device.tag.role == 'ap'", "src": "wan", "dest_port": "4305", "target": "ACCEPT" },
+					{ ".if": "device.tag.role == 'router'", "src": "wan", "proto": "icmp", "target": "ACCEPT" },
+					{ ".if": "device.tag.role == 'ap'", "src": "wan", "proto": "igmp", "target": "ACCEPT" }
+				]
+			},
+			"dhcp": {
+				"host": [
+					{ ".name": "printer", ".if": "device.tag.role == 'router'", "name": "printer", "mac": "00:11:22:33:44:55", "ip": "192.168.1.20" },
+					{ ".name": "camera", ".if": "device.tag.role == 'ap'", "name": "camera", "mac": "00:11:22:33:44:66", "ip": "192.168.1.21" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	// Only the matching named and anonymous rules are kept
+	rules := state.Config["firewall"].(map[string]any)["rule"].([]any)
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %v", rules)
+	}
+	if rules[0].(map[string]any)[".name"] != "allow_ssh" {
+		t.Errorf("Expected the named router rule, got %v", rules[0])
+	}
+	if rule := rules[1].(map[string]any); rule[".name"] != nil || rule["proto"] != "icmp" {
+		t.Errorf("Expected the anonymous router rule, got %v", rule)
+	}
+
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get script: %v", err)
+	}
+	script := strings.Join(commands, "\n")
+	for _, expected := range []string{
+		"uci set firewall.allow_ssh=rule",
+		"uci set dhcp.printer=host",
+		"uci set dhcp.printer.mac='00:11:22:33:44:55'",
+	} {
+		if !strings.Contains(script, expected+"\n") {
+			t.Errorf("Expected %q in script:\n%s", expected, script)
+		}
+	}
+	if strings.Contains(script, "allow_mesh") || strings.Contains(script, "camera") {
+		t.Errorf("Expected sections for other roles to be dropped:\n%s", script)
+	}
+}
+
+func TestSystemConsoleOptions(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [{ "model_id": "ubnt,edgerouter-x", "hostname": "router" }],
+		"config": {
+			"system": {
+				"system": [
+					{ ".name": "system", "hostname": "router", "ttylogin": true, "compat_version": "1.1" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get script: %v", err)
+	}
+	script := strings.Join(commands, "\n")
+
+	for _, expected := range []string{
+		"uci set system.system.ttylogin='1'",
+		"uci set system.system.compat_version='1.1'",
+	} {
+		if !strings.Contains(script, expected+"\n") {
+			t.Errorf("Expected %q in script:\n%s", expected, script)
+		}
+	}
+}
+
+func TestNetworkRule(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [{ "model_id": "ubnt,edgerouter-x", "hostname": "router" }],
+		"config": {
+			"network": {
+				"rule": [
+					{ ".name": "guest_via_wan2", "src": "192.168.2.0/24", "lookup": "100", "priority": 1000 }
+				]
+			},
+			"firewall": {
+				"rule": [
+					{ ".name": "allow_ssh", "src": "wan", "proto": "tcp", "dest_port": "22", "target": "ACCEPT" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get script: %v", err)
+	}
+	script := strings.Join(commands, "\n")
+
+	// The routing rule goes to the network config, separate from firewall rules
+	for _, expected := range []string{
+		"uci set network.guest_via_wan2=rule",
+		"uci set network.guest_via_wan2.src='192.168.2.0/24'",
+		"uci set network.guest_via_wan2.lookup='100'",
+		"uci set network.guest_via_wan2.priority='1000'",
+		"uci set firewall.allow_ssh=rule",
+	} {
+		if !strings.Contains(script, expected+"\n") {
+			t.Errorf("Expected %q in script:\n%s", expected, script)
+		}
+	}
+	if strings.Contains(script, "firewall.guest_via_wan2") || strings.Contains(script, "network.allow_ssh") {
+		t.Errorf("Expected network and firewall rules to stay apart:\n%s", script)
+	}
+}
+
+func TestExtraConfigCommands(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [{"model_id": "ubnt,edgerouter-x", "hostname": "router", "tags": {"role": "router"}}],
+		"config": {
+			"adblock": {
+				".if": "device.tag.role == 'router'",
+				"adblock": {".name": "global", "adb_enabled": true, "adb_maxqueue": 4, "adb_sources": ["adguard", "oisd"]}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	commands := generateConfigCommands("adblock", state.Config["adblock"])
+	expected := []string{
+		"uci set adblock.global=adblock",
+		"uci set adblock.global.adb_enabled='1'",
+		"uci set adblock.global.adb_maxqueue='4'",
+		"uci -q delete adblock.global.adb_sources || true",
+		"uci add_list adblock.global.adb_sources='adguard'",
+		"uci add_list adblock.global.adb_sources='oisd'",
+	}
+	if !slices.Equal(commands, expected) {
+		t.Errorf("Expected %v, got %v", expected, commands)
+	}
+}
+
+func TestManagedConfigs(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Config: config.ConfigConfig{
+			System: &config.SystemConfig{},
+			Extra:  map[string]any{"sqm": map[string]any{}},
+		},
+	}
+
+	configs, err := ManagedConfigs(oncConfig)
+	if err != nil {
+		t.Fatalf("Failed to list managed configs: %v", err)
+	}
+	if strings.Join(configs, " ") != "sqm system" {
+		t.Errorf("Expected sqm and system, got %v", configs)
+	}
+}
@@ -0,0 +1,47 @@
+package device
+
+import (
+	"strings"
+)
+
+// tagCPUPorts adds the schema's CPU port, tagged, to every switch_vlan of a
+// swconfig device that doesn't already include a CPU port, e.g. "0 1 2"
+// becomes "0 1 2 6t". Without it the VLAN's traffic never reaches the
+// CPU's ethN.<vlan> interface.
+func tagCPUPorts(openWrtConfig map[string]any, deviceSchema *DeviceSchema) {
+	if !deviceSchema.SwConfig {
+		return
+	}
+
+	var cpuPorts []string
+	for _, port := range deviceSchema.Ports {
+		if port.SwConfigCPUName != nil {
+			cpuPorts = append(cpuPorts, strings.TrimPrefix(port.Name, "eth"))
+		}
+	}
+	if len(cpuPorts) == 0 {
+		return
+	}
+
+	for _, section := range getSections(openWrtConfig, "network", "switch_vlan") {
+		ports, ok := section["ports"].(string)
+		if !ok || hasSwitchPort(ports, cpuPorts) {
+			continue
+		}
+		section["ports"] = strings.TrimSpace(ports + " " + cpuPorts[0] + "t")
+	}
+}
+
+// hasSwitchPort reports whether a switch_vlan ports string includes any of
+// the port numbers, tagged ("6t"), untagged or as the default VLAN ("6*")
+func hasSwitchPort(ports string, numbers []string) bool {
+	for _, port := range strings.Fields(ports) {
+		number := strings.TrimRight(port, "tu*")
+		for _, n := range numbers {
+			if number == n {
+				return true
+			}
+		}
+	}
+	return false
+}
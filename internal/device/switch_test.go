@@ -0,0 +1,70 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestTagCPUPorts(t *testing.T) {
+	oncConfig, err := config.Parse([]byte(`{
+		"devices": [
+			{ "model_id": "tplink,archer-c7-v2", "hostname": "router" }
+		],
+		"config": {
+			"network": {
+				"switch": [
+					{ ".name": "switch0", "name": "switch0", "reset": true, "enable_vlan": true }
+				],
+				"switch_vlan": [
+					{ ".name": "vlan1", "device": "switch0", "vlan": 1, "ports": "2 3 4 5" },
+					{ ".name": "vlan3", "device": "switch0", "vlan": 3, "ports": "4t 5t" },
+					{ ".name": "vlan2", "device": "switch0", "vlan": 2, "ports": "1 0t" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	cpu := "eth0"
+	lan, wan := "lan", "wan"
+	schema := &DeviceSchema{
+		SwConfig: true,
+		Ports: []Port{
+			{Name: "eth0", SwConfigCPUName: &cpu},
+			{Name: "eth1", DefaultRole: &wan},
+			{Name: "eth2", DefaultRole: &lan},
+			{Name: "eth3", DefaultRole: &lan},
+			{Name: "eth4", DefaultRole: &lan},
+			{Name: "eth5", DefaultRole: &lan},
+		},
+	}
+
+	state, err := GetOpenWrtState(oncConfig, &oncConfig.Devices[0], schema)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	expected := map[string]string{
+		"vlan1": "2 3 4 5 0t",
+		"vlan3": "4t 5t 0t",
+		"vlan2": "1 0t", // Already tagged
+	}
+	for name, ports := range expected {
+		if vlan := getSection(t, state, "network", "switch_vlan", name); vlan["ports"] != ports {
+			t.Errorf("Expected %s ports %q, got %v", name, ports, vlan["ports"])
+		}
+	}
+
+	// DSA devices are left alone
+	schema.SwConfig = false
+	state, err = GetOpenWrtState(oncConfig, &oncConfig.Devices[0], schema)
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	if vlan := getSection(t, state, "network", "switch_vlan", "vlan1"); vlan["ports"] != "2 3 4 5" {
+		t.Errorf("Expected DSA ports to be unchanged, got %v", vlan["ports"])
+	}
+}
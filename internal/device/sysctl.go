@@ -0,0 +1,62 @@
+package device
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// SysctlPath is the file the configured sysctl settings are written to
+const SysctlPath = "/etc/sysctl.d/90-openwrt-configurator.conf"
+
+// sysctlKeyPattern matches sysctl paths such as net.ipv4.ip_forward, or
+// net/ipv4/conf/eth0.2/rp_filter for names containing dots
+var sysctlKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+([./][A-Za-z0-9_@:-]+)+$`)
+
+// getSysctlFile returns the sysctl.d file for the settings and the command
+// applying it
+func getSysctlFile(settings map[string]string) (config.FileConfig, []string, error) {
+	keys := uci.SortedKeys(settings)
+
+	var content strings.Builder
+	content.WriteString("# Generated by openwrt-configurator\n")
+	for _, key := range keys {
+		value := settings[key]
+		if !sysctlKeyPattern.MatchString(key) {
+			return config.FileConfig{}, nil, fmt.Errorf("invalid sysctl key %q, expected a path like net.ipv4.ip_forward", key)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return config.FileConfig{}, nil, fmt.Errorf("invalid value for sysctl %s: contains a newline", key)
+		}
+		fmt.Fprintf(&content, "%s = %s\n", key, value)
+	}
+
+	file := config.FileConfig{
+		Path:    SysctlPath,
+		Content: content.String(),
+	}
+	return file, []string{fmt.Sprintf("sysctl -p %s", SysctlPath)}, nil
+}
+
+// ParseSysctlConf parses the key = value lines of a sysctl.conf file,
+// skipping blank lines and comments
+func ParseSysctlConf(content string) map[string]string {
+	settings := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		// A leading - ignores errors setting the key
+		key = strings.TrimPrefix(strings.TrimSpace(key), "-")
+		settings[key] = strings.TrimSpace(value)
+	}
+	return settings
+}
@@ -0,0 +1,70 @@
+package device
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestSysctl(t *testing.T) {
+	router := "device.tag.role == 'router'"
+	oncConfig := &config.ONCConfig{
+		Sysctl: []config.SysctlConfig{
+			{Settings: map[string]string{"net.ipv4.ip_forward": "0", "net.netfilter.nf_conntrack_max": "16384"}},
+			{If: &router, Settings: map[string]string{"net.ipv4.ip_forward": "1"}},
+		},
+	}
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "router",
+		Tags:     map[string]any{"role": "router"},
+	}
+
+	state, err := GetOpenWrtState(oncConfig, deviceConfig, &DeviceSchema{})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	// The router's forwarding setting overrides the default
+	expected := "# Generated by openwrt-configurator\n" +
+		"net.ipv4.ip_forward = 1\n" +
+		"net.netfilter.nf_conntrack_max = 16384\n"
+	if len(state.Files) != 1 || state.Files[0].Path != "/etc/sysctl.d/90-openwrt-configurator.conf" {
+		t.Fatalf("Expected the sysctl file, got %v", state.Files)
+	}
+	if state.Files[0].Content != expected {
+		t.Errorf("Unexpected sysctl file:\n%s", state.Files[0].Content)
+	}
+
+	commands, err := GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+	apply := slices.Index(commands, "sysctl -p /etc/sysctl.d/90-openwrt-configurator.conf")
+	if apply == -1 {
+		t.Fatalf("Expected the sysctl file to be applied: %v", commands)
+	}
+	if apply < slices.Index(commands, "chmod 0644 '/etc/sysctl.d/90-openwrt-configurator.conf'") {
+		t.Errorf("Expected the sysctl file to be written before it is applied: %v", commands)
+	}
+}
+
+func TestSysctlInvalidKey(t *testing.T) {
+	for _, key := range []string{"ip_forward", "net.ipv4.", "net ipv4 ip_forward", "net.ipv4.ip_forward\nkernel.panic"} {
+		oncConfig := &config.ONCConfig{
+			Sysctl: []config.SysctlConfig{{Settings: map[string]string{key: "1"}}},
+		}
+		deviceConfig := &config.DeviceConfig{ModelID: "ubnt,edgerouter-x", Hostname: "router"}
+		if _, err := GetOpenWrtState(oncConfig, deviceConfig, &DeviceSchema{}); err == nil {
+			t.Errorf("Expected an error for sysctl key %q", key)
+		}
+	}
+}
+
+func TestParseSysctlConf(t *testing.T) {
+	settings := ParseSysctlConf("# Defaults\n\nnet.ipv4.ip_forward=1\n-net.ipv6.conf.all.forwarding = 1\n; old\n")
+	if len(settings) != 2 || settings["net.ipv4.ip_forward"] != "1" || settings["net.ipv6.conf.all.forwarding"] != "1" {
+		t.Errorf("Unexpected settings: %v", settings)
+	}
+}
@@ -0,0 +1,121 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/secret"
+)
+
+// interpolateConfig replaces ${...} references in every string value of the
+// resolved config, so a shared config can be personalised per device.
+// ${secret.<name>} references are resolved with secrets, or left in place
+// when it is nil.
+func interpolateConfig(openWrtConfig map[string]any, deviceConfig *config.DeviceConfig, secrets secret.Resolver) error {
+	for configKey, configValue := range openWrtConfig {
+		value, err := interpolateValue(configValue, deviceConfig, secrets)
+		if err != nil {
+			return fmt.Errorf("%s: %w", configKey, err)
+		}
+		openWrtConfig[configKey] = value
+	}
+	return nil
+}
+
+func interpolateValue(value any, deviceConfig *config.DeviceConfig, secrets secret.Resolver) (any, error) {
+	switch v := value.(type) {
+	case string:
+		return interpolateString(v, deviceConfig, secrets)
+	case []any:
+		for i, item := range v {
+			interpolated, err := interpolateValue(item, deviceConfig, secrets)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = interpolated
+		}
+		return v, nil
+	case map[string]any:
+		for k, item := range v {
+			interpolated, err := interpolateValue(item, deviceConfig, secrets)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			v[k] = interpolated
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+// interpolateString expands references such as ${device.hostname},
+// ${device.tag.site} or ${secret.wifi_key} in s
+func interpolateString(s string, deviceConfig *config.DeviceConfig, secrets secret.Resolver) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var result strings.Builder
+	rest := s
+	for {
+		start := strings.Index(rest, "${")
+		if start == -1 {
+			result.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end == -1 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+		end += start
+
+		ref := strings.TrimSpace(rest[start+2 : end])
+		var value string
+		var err error
+		if name, ok := strings.CutPrefix(ref, "secret."); ok {
+			if secrets == nil {
+				value = rest[start : end+1]
+			} else {
+				value, err = secret.Resolve(secrets, name)
+			}
+		} else {
+			value, err = lookupReference(ref, deviceConfig)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		result.WriteString(rest[:start])
+		result.WriteString(value)
+		rest = rest[end+1:]
+	}
+
+	return result.String(), nil
+}
+
+func lookupReference(ref string, deviceConfig *config.DeviceConfig) (string, error) {
+	switch ref {
+	case "device.hostname":
+		return deviceConfig.Hostname, nil
+	case "device.ipaddr":
+		return deviceConfig.IPAddr, nil
+	case "device.model_id":
+		return deviceConfig.ModelID, nil
+	}
+
+	if tagKey, ok := strings.CutPrefix(ref, "device.tag."); ok {
+		tagValue, ok := deviceConfig.Tags[tagKey]
+		if !ok {
+			return "", fmt.Errorf("unknown tag %q referenced for device %s", tagKey, deviceConfig.Hostname)
+		}
+		switch tagValue.(type) {
+		case []any, []string, map[string]any:
+			return "", fmt.Errorf("tag %q of device %s is not a scalar value", tagKey, deviceConfig.Hostname)
+		}
+		return fmt.Sprintf("%v", tagValue), nil
+	}
+
+	return "", fmt.Errorf("unknown reference ${%s}", ref)
+}
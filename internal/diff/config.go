@@ -0,0 +1,219 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// KindRemove is an item or option that the new config no longer declares
+const KindRemove = "remove"
+
+// ConfigChange is a single difference between two configs. Path names the
+// changed item, e.g. devices[my-router] or config.network.interface[lan].ipaddr,
+// with named sections and devices identified by name rather than position.
+type ConfigChange struct {
+	Kind string
+	Path string
+	// Old and New are JSON values, empty for whole items added or removed
+	Old string
+	New string
+}
+
+// String formats the change for human-readable output
+func (c ConfigChange) String() string {
+	switch c.Kind {
+	case KindAdd:
+		if c.New == "" {
+			return "+ " + c.Path
+		}
+		return fmt.Sprintf("+ %s: %s", c.Path, c.New)
+	case KindRemove:
+		if c.Old == "" {
+			return "- " + c.Path
+		}
+		return fmt.Sprintf("- %s: %s", c.Path, c.Old)
+	default:
+		return fmt.Sprintf("~ %s: %s -> %s", c.Path, c.Old, c.New)
+	}
+}
+
+// Configs compares two configs offline, e.g. before and after a change under
+// review. Both are canonicalized first, so key order, section order and
+// formatting don't show up as changes. Metadata is ignored.
+func Configs(oldConfig, newConfig *config.ONCConfig) ([]ConfigChange, error) {
+	oldValue, err := canonicalValue(oldConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to canonicalize old config: %w", err)
+	}
+	newValue, err := canonicalValue(newConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to canonicalize new config: %w", err)
+	}
+	delete(oldValue, "metadata")
+	delete(newValue, "metadata")
+
+	var changes []ConfigChange
+	compareValues(&changes, "", oldValue, newValue)
+	return changes, nil
+}
+
+func canonicalValue(oncConfig *config.ONCConfig) (map[string]any, error) {
+	data, err := config.Canonicalize(oncConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var value map[string]any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func compareValues(changes *[]ConfigChange, path string, oldValue, newValue any) {
+	oldMap, oldIsMap := oldValue.(map[string]any)
+	newMap, newIsMap := newValue.(map[string]any)
+	if oldIsMap && newIsMap {
+		compareMaps(changes, path, oldMap, newMap)
+		return
+	}
+
+	oldList, oldIsList := oldValue.([]any)
+	newList, newIsList := newValue.([]any)
+	if oldIsList && newIsList {
+		compareLists(changes, path, oldList, newList)
+		return
+	}
+
+	if oldJSON, newJSON := jsonValue(oldValue), jsonValue(newValue); oldJSON != newJSON {
+		*changes = append(*changes, ConfigChange{Kind: KindChange, Path: path, Old: oldJSON, New: newJSON})
+	}
+}
+
+func compareMaps(changes *[]ConfigChange, path string, oldMap, newMap map[string]any) {
+	keys := make(map[string]bool)
+	for key := range oldMap {
+		keys[key] = true
+	}
+	for key := range newMap {
+		keys[key] = true
+	}
+	for _, key := range uci.SortedKeys(keys) {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+
+		oldValue, inOld := oldMap[key]
+		newValue, inNew := newMap[key]
+		switch {
+		case !inOld:
+			*changes = append(*changes, ConfigChange{Kind: KindAdd, Path: keyPath, New: jsonValue(newValue)})
+		case !inNew:
+			*changes = append(*changes, ConfigChange{Kind: KindRemove, Path: keyPath, Old: jsonValue(oldValue)})
+		default:
+			compareValues(changes, keyPath, oldValue, newValue)
+		}
+	}
+}
+
+// compareLists compares named items (sections and devices) by name, other
+// objects by position and plain values, such as package lists, as sets
+func compareLists(changes *[]ConfigChange, path string, oldList, newList []any) {
+	oldItems, oldNamed := namedItems(oldList)
+	newItems, newNamed := namedItems(newList)
+	if oldNamed && newNamed {
+		for _, name := range uci.SortedKeys(oldItems) {
+			if _, ok := newItems[name]; !ok {
+				*changes = append(*changes, ConfigChange{Kind: KindRemove, Path: fmt.Sprintf("%s[%s]", path, name)})
+			}
+		}
+		for _, name := range uci.SortedKeys(newItems) {
+			itemPath := fmt.Sprintf("%s[%s]", path, name)
+			if oldItem, ok := oldItems[name]; ok {
+				compareValues(changes, itemPath, oldItem, newItems[name])
+			} else {
+				*changes = append(*changes, ConfigChange{Kind: KindAdd, Path: itemPath})
+			}
+		}
+		return
+	}
+
+	if hasObjects(oldList) || hasObjects(newList) {
+		for i := 0; i < len(oldList) || i < len(newList); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(oldList):
+				*changes = append(*changes, ConfigChange{Kind: KindAdd, Path: itemPath, New: jsonValue(newList[i])})
+			case i >= len(newList):
+				*changes = append(*changes, ConfigChange{Kind: KindRemove, Path: itemPath, Old: jsonValue(oldList[i])})
+			default:
+				compareValues(changes, itemPath, oldList[i], newList[i])
+			}
+		}
+		return
+	}
+
+	oldSet := make(map[string]bool)
+	for _, item := range oldList {
+		oldSet[jsonValue(item)] = true
+	}
+	newSet := make(map[string]bool)
+	for _, item := range newList {
+		newSet[jsonValue(item)] = true
+	}
+	for _, item := range oldList {
+		if value := jsonValue(item); !newSet[value] {
+			*changes = append(*changes, ConfigChange{Kind: KindRemove, Path: path, Old: value})
+		}
+	}
+	for _, item := range newList {
+		if value := jsonValue(item); !oldSet[value] {
+			*changes = append(*changes, ConfigChange{Kind: KindAdd, Path: path, New: value})
+		}
+	}
+}
+
+// namedItems keys a list's items by their .name, or hostname for devices,
+// reporting false when any item is unnamed or a name is repeated
+func namedItems(list []any) (map[string]any, bool) {
+	items := make(map[string]any)
+	for _, item := range list {
+		itemMap, ok := item.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		name, ok := itemMap[".name"].(string)
+		if !ok {
+			name, ok = itemMap["hostname"].(string)
+		}
+		if !ok || name == "" {
+			return nil, false
+		}
+		if _, ok := items[name]; ok {
+			return nil, false
+		}
+		items[name] = item
+	}
+	return items, true
+}
+
+func hasObjects(list []any) bool {
+	for _, item := range list {
+		if _, ok := item.(map[string]any); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
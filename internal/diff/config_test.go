@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestConfigs(t *testing.T) {
+	oldConfig, err := config.Parse([]byte(`{
+		"devices": [
+			{ "hostname": "router", "model_id": "ubnt,edgerouter-x", "ipaddr": "10.0.0.1" },
+			{ "hostname": "old-ap", "model_id": "tplink,archer-c50-v4", "ipaddr": "10.0.0.2" }
+		],
+		"package_profiles": [{ "packages": ["tcpdump"] }],
+		"config": {
+			"network": {
+				"interface": [
+					{ ".name": "lan", "proto": "static", "ipaddr": "10.0.0.1" },
+					{ ".name": "wan", "proto": "dhcp" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConfig, err := config.Parse([]byte(`{
+		"devices": [
+			{ "hostname": "router", "model_id": "ubnt,edgerouter-x", "ipaddr": "10.0.0.1" }
+		],
+		"package_profiles": [{ "packages": ["tcpdump", "wireguard-tools"] }],
+		"config": {
+			"network": {
+				"interface": [
+					{ ".name": "wan", "proto": "dhcp" },
+					{ ".name": "lan", "proto": "static", "ipaddr": "10.0.1.1" }
+				]
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Configs(oldConfig, newConfig)
+	if err != nil {
+		t.Fatalf("Configs failed: %v", err)
+	}
+
+	expected := []string{
+		`~ config.network.interface[lan].ipaddr: "10.0.0.1" -> "10.0.1.1"`,
+		`- devices[old-ap]`,
+		`+ package_profiles[0].packages: "wireguard-tools"`,
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %d: %v", len(expected), len(changes), changes)
+	}
+	for i, change := range changes {
+		if change.String() != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], change)
+		}
+	}
+
+	// Identical configs have no changes
+	if changes, _ := Configs(newConfig, newConfig); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+}
@@ -0,0 +1,255 @@
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/redact"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// Change kinds
+const (
+	// KindAdd is an option that is intended but missing on the device
+	KindAdd = "add"
+	// KindChange is an option whose device value differs from the intended one
+	KindChange = "change"
+	// KindUnmanaged is an option on the device that the config doesn't declare
+	KindUnmanaged = "unmanaged"
+)
+
+// Change is a single difference between the intended and actual UCI state
+type Change struct {
+	Kind    string
+	Config  string
+	Section string
+	Option  string
+	Old     string
+	New     string
+}
+
+// Key returns the UCI path of the change, e.g. network.lan.ipaddr
+func (c Change) Key() string {
+	key := c.Config + "." + c.Section
+	if c.Option != "" {
+		key += "." + c.Option
+	}
+	return key
+}
+
+// String formats the change for human-readable output
+func (c Change) String() string {
+	switch c.Kind {
+	case KindAdd:
+		return fmt.Sprintf("+ %s='%s'", c.Key(), c.New)
+	case KindChange:
+		return fmt.Sprintf("~ %s: '%s' -> '%s'", c.Key(), c.Old, c.New)
+	default:
+		return fmt.Sprintf("? %s='%s' (unmanaged)", c.Key(), c.Old)
+	}
+}
+
+// Finding converts the change into a report finding for the given device
+func (c Change) Finding(deviceName string) report.Finding {
+	return report.Finding{
+		Severity: report.SeverityInfo,
+		Rule:     c.Kind,
+		Device:   deviceName,
+		Config:   c.Config,
+		Section:  c.Section,
+		Message:  c.String(),
+	}
+}
+
+// Redact returns the changes with the values of sensitive options masked
+func Redact(changes []Change, redactor *redact.Redactor) []Change {
+	if redactor == nil {
+		return changes
+	}
+	redacted := make([]Change, len(changes))
+	for i, change := range changes {
+		change.Old = redactor.Text(redactor.Redact(change.Old, change.Key()))
+		change.New = redactor.Text(redactor.Redact(change.New, change.Key()))
+		redacted[i] = change
+	}
+	return redacted
+}
+
+// Compare compares intended and actual flat UCI state (see uci.Flatten and
+// uci.ParseShow). Options on the device that aren't intended are only
+// reported when includeUnmanaged is set.
+func Compare(intended, actual map[string]string, includeUnmanaged bool) []Change {
+	var changes []Change
+
+	for _, key := range uci.SortedKeys(intended) {
+		newValue := intended[key]
+		oldValue, ok := actual[key]
+		if !ok {
+			changes = append(changes, newChange(KindAdd, key, "", newValue))
+		} else if oldValue != newValue {
+			changes = append(changes, newChange(KindChange, key, oldValue, newValue))
+		}
+	}
+
+	if includeUnmanaged {
+		for _, key := range uci.SortedKeys(actual) {
+			if _, ok := intended[key]; !ok {
+				changes = append(changes, newChange(KindUnmanaged, key, actual[key], ""))
+			}
+		}
+	}
+
+	return changes
+}
+
+func newChange(kind, key, oldValue, newValue string) Change {
+	parts := strings.SplitN(key, ".", 3)
+	change := Change{
+		Kind: kind,
+		Old:  oldValue,
+		New:  newValue,
+	}
+	change.Config = parts[0]
+	if len(parts) > 1 {
+		change.Section = parts[1]
+	}
+	if len(parts) > 2 {
+		change.Option = parts[2]
+	}
+	return change
+}
+
+// readConcurrency is the most configs read at once over one connection,
+// well below the session limits of dropbear and OpenSSH
+const readConcurrency = 4
+
+// ReadDeviceConfig reads the given configs from the device in flat form,
+// several at once in their own sessions. A config that doesn't exist on the
+// device reads as empty.
+func ReadDeviceConfig(client ssh.SSHExecutor, configs []string) map[string]string {
+	return flattenShow(showConfigs(client, configs))
+}
+
+// Reading is a read of a device's configs started ahead of resolving its
+// state, so it overlaps other reads over the same connection
+type Reading struct {
+	done    chan struct{}
+	outputs map[string]string
+}
+
+// ReadAhead starts reading the given configs from the device. Device and
+// Drift use what it read and only read the configs it missed.
+func ReadAhead(client ssh.SSHExecutor, configs []string) *Reading {
+	r := &Reading{done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		r.outputs = showConfigs(client, configs)
+	}()
+	return r
+}
+
+// showConfigs returns the uci show output of each of the given configs,
+// reading several at once. A config that doesn't exist has no output.
+func showConfigs(client ssh.SSHExecutor, configs []string) map[string]string {
+	outputs := make([]string, len(configs))
+	sem := make(chan struct{}, readConcurrency)
+	var wg sync.WaitGroup
+	for i, configKey := range configs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, configKey string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			output, err := client.Execute(fmt.Sprintf("uci show %s", configKey))
+			if err == nil {
+				outputs[i] = output
+			}
+		}(i, configKey)
+	}
+	wg.Wait()
+
+	shown := make(map[string]string, len(configs))
+	for i, configKey := range configs {
+		shown[configKey] = outputs[i]
+	}
+	return shown
+}
+
+// flattenShow merges uci show outputs into flat form
+func flattenShow(outputs map[string]string) map[string]string {
+	actual := make(map[string]string)
+	for _, output := range outputs {
+		for key, value := range uci.ParseShow(output) {
+			actual[key] = value
+		}
+	}
+	return actual
+}
+
+// Device compares the intended state with the device's current UCI config,
+// using the configs ahead read when it isn't nil
+func Device(client ssh.SSHExecutor, state *device.OpenWrtState, ahead *Reading) []Change {
+	intended, actual := readState(client, state, ahead)
+	return Compare(intended, actual, false)
+}
+
+// Drift compares the intended state with the device's current UCI config,
+// also reporting options on the device that the config doesn't declare,
+// e.g. ones changed by hand through LuCI. It uses the configs ahead read
+// when it isn't nil.
+func Drift(client ssh.SSHExecutor, state *device.OpenWrtState, ahead *Reading) []Change {
+	intended, actual := readState(client, state, ahead)
+	return Compare(intended, actual, true)
+}
+
+// Summary summarizes a device's drift as "in sync" or "drifted"
+func Summary(changes []Change) string {
+	if len(changes) == 0 {
+		return "in sync"
+	}
+	return fmt.Sprintf("drifted (%d changes)", len(changes))
+}
+
+// readState returns the intended and actual state of the configs the
+// intended state manages, in flat form. Configs read ahead aren't read
+// again, and ones the state doesn't manage are left out.
+func readState(client ssh.SSHExecutor, state *device.OpenWrtState, ahead *Reading) (intended, actual map[string]string) {
+	outputs := make(map[string]string)
+	if ahead != nil {
+		<-ahead.done
+	}
+
+	var configs []string
+	for configKey := range state.Config {
+		configs = append(configs, configKey)
+	}
+	sort.Strings(configs)
+
+	var missing []string
+	for _, configKey := range configs {
+		if output, ok := ahead.output(configKey); ok {
+			outputs[configKey] = output
+		} else {
+			missing = append(missing, configKey)
+		}
+	}
+	for configKey, output := range showConfigs(client, missing) {
+		outputs[configKey] = output
+	}
+
+	return uci.Flatten(state.Config), flattenShow(outputs)
+}
+
+// output returns what was read of a config, if it was read
+func (r *Reading) output(configKey string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	output, ok := r.outputs[configKey]
+	return output, ok
+}
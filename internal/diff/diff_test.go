@@ -0,0 +1,214 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+func TestCompare(t *testing.T) {
+	intended := map[string]string{
+		"network.lan":        "interface",
+		"network.lan.proto":  "static",
+		"network.lan.ipaddr": "10.0.0.1",
+		"network.lan.dns":    "1.1.1.1 8.8.8.8",
+	}
+	actual := map[string]string{
+		"network.lan":         "interface",
+		"network.lan.proto":   "static",
+		"network.lan.ipaddr":  "192.168.1.1",
+		"network.lan.netmask": "255.255.255.0",
+	}
+
+	changes := Compare(intended, actual, false)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %d: %v", len(changes), changes)
+	}
+
+	if changes[0].Kind != KindAdd || changes[0].Key() != "network.lan.dns" {
+		t.Errorf("Expected dns to be added, got %v", changes[0])
+	}
+	if changes[1].Kind != KindChange || changes[1].Old != "192.168.1.1" || changes[1].New != "10.0.0.1" {
+		t.Errorf("Expected ipaddr change, got %v", changes[1])
+	}
+
+	changes = Compare(intended, actual, true)
+	if len(changes) != 3 || changes[2].Kind != KindUnmanaged || changes[2].Option != "netmask" {
+		t.Errorf("Expected unmanaged netmask, got %v", changes)
+	}
+}
+
+func TestDevice(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.OnExecute = func(command string) (string, error) {
+		if command == "uci show system" {
+			return `system.system=system
+system.system.hostname='old-name'
+system.system.timezone='UTC'
+`, nil
+		}
+		return "", nil
+	}
+
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"system": map[string]any{
+				"system": []any{
+					map[string]any{
+						".name":    "system",
+						"hostname": "new-name",
+						"timezone": "UTC",
+					},
+				},
+			},
+		},
+	}
+
+	changes := Device(mockClient, state, nil)
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change, got %d: %v", len(changes), changes)
+	}
+	if changes[0].String() != "~ system.system.hostname: 'old-name' -> 'new-name'" {
+		t.Errorf("Unexpected change: %s", changes[0])
+	}
+}
+
+func TestDrift(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show system"] = `system.system=system
+system.system.hostname='changed-by-hand'
+system.system.timezone='UTC'
+system.system.log_size='64'
+`
+
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"system": map[string]any{
+				"system": []any{
+					map[string]any{
+						".name":    "system",
+						"hostname": "router",
+						"timezone": "UTC",
+					},
+				},
+			},
+		},
+	}
+
+	changes := Drift(mockClient, state, nil)
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %d: %v", len(changes), changes)
+	}
+	if changes[0].String() != "~ system.system.hostname: 'changed-by-hand' -> 'router'" {
+		t.Errorf("Unexpected change: %s", changes[0])
+	}
+	if changes[1].Kind != KindUnmanaged || changes[1].Key() != "system.system.log_size" {
+		t.Errorf("Expected unmanaged log_size, got %s", changes[1])
+	}
+	if Summary(changes) != "drifted (2 changes)" {
+		t.Errorf("Unexpected summary: %s", Summary(changes))
+	}
+
+	// Undoing the hand edits brings it back in sync
+	mockClient.Responses["uci show system"] = `system.system=system
+system.system.hostname='router'
+system.system.timezone='UTC'
+`
+	if changes := Drift(mockClient, state, nil); Summary(changes) != "in sync" {
+		t.Errorf("Expected in sync, got %v", changes)
+	}
+}
+
+// TestDriftReadAhead tests that configs read ahead aren't read again, and
+// ones the state doesn't manage aren't reported
+func TestDriftReadAhead(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show system"] = "system.system=system\nsystem.system.hostname='router'\n"
+	mockClient.Responses["uci show dhcp"] = "dhcp.lan=dhcp\ndhcp.lan.interface='lan'\n"
+	mockClient.Responses["uci show network"] = "network.lan=interface\nnetwork.lan.proto='dhcp'\n"
+
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"system": map[string]any{
+				"system": []any{map[string]any{".name": "system", "hostname": "router"}},
+			},
+			"network": map[string]any{
+				"interface": []any{map[string]any{".name": "lan", "proto": "static"}},
+			},
+		},
+	}
+
+	// dhcp was left out by conditions and network wasn't read ahead
+	ahead := ReadAhead(mockClient, []string{"dhcp", "system"})
+	changes := Drift(mockClient, state, ahead)
+	if len(changes) != 1 || changes[0].Key() != "network.lan.proto" {
+		t.Errorf("Expected only the network change, got %v", changes)
+	}
+
+	reads := make(map[string]int)
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		reads[cmd]++
+	}
+	if reads["uci show system"] != 1 || reads["uci show dhcp"] != 1 || reads["uci show network"] != 1 {
+		t.Errorf("Expected each config to be read once, got %v", reads)
+	}
+}
+
+func TestReadDeviceConfigConcurrently(t *testing.T) {
+	configs := []string{"dhcp", "dropbear", "firewall", "missing", "network", "system", "wireless"}
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.OnExecute = func(command string) (string, error) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		// Hold each read so the others overlap it
+		time.Sleep(20 * time.Millisecond)
+
+		configKey := strings.TrimPrefix(command, "uci show ")
+		if configKey == "missing" {
+			return "", fmt.Errorf("uci: Entry not found")
+		}
+		return fmt.Sprintf("%[1]s.main=%[1]s\n%[1]s.main.name='%[1]s'\n", configKey), nil
+	}
+
+	actual := ReadDeviceConfig(mockClient, configs)
+
+	if maxInFlight < 2 || maxInFlight > readConcurrency {
+		t.Errorf("Expected between 2 and %d reads at once, got %d", readConcurrency, maxInFlight)
+	}
+	if len(mockClient.GetExecutedCommands()) != len(configs) {
+		t.Errorf("Expected one read per config, got %v", mockClient.GetExecutedCommands())
+	}
+
+	// Every config read is assembled, and the missing one reads as empty
+	if len(actual) != 2*(len(configs)-1) {
+		t.Errorf("Expected %d options, got %v", 2*(len(configs)-1), actual)
+	}
+	for _, configKey := range configs {
+		value, ok := actual[configKey+".main.name"]
+		if configKey == "missing" {
+			if ok {
+				t.Errorf("Expected nothing from the missing config, got %s", value)
+			}
+			continue
+		}
+		if value != configKey {
+			t.Errorf("Expected %s.main.name to be %s, got %q", configKey, configKey, value)
+		}
+	}
+}
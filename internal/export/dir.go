@@ -0,0 +1,70 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// WriteConfigDir writes the exported configs to dir in the /etc/config
+// format, one file per config named after it, e.g. dir/network. It returns
+// the paths of the written files.
+func WriteConfigDir(dir string, cfg config.ConfigConfig) ([]string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	var configMap map[string]any
+	if err := json.Unmarshal(data, &configMap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	configKeys := uci.SortedKeys(configMap)
+
+	var paths []string
+	for _, configKey := range configKeys {
+		sections, ok := configMap[configKey].(map[string]any)
+		if !ok {
+			continue
+		}
+		unnameAnonymous(sections)
+
+		path := filepath.Join(dir, configKey)
+		if err := os.WriteFile(path, []byte(uci.RenderConfig(sections)), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s config: %w", configKey, err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// unnameAnonymous drops the uci show names of anonymous sections, e.g.
+// @system[0], which aren't valid section names in a config file, so they
+// are written anonymous again
+func unnameAnonymous(sections map[string]any) {
+	for _, value := range sections {
+		list, ok := value.([]any)
+		if !ok {
+			continue
+		}
+		for _, item := range list {
+			section, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if name, ok := section[".name"].(string); ok && strings.HasPrefix(name, "@") {
+				delete(section, ".name")
+			}
+		}
+	}
+}
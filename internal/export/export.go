@@ -1,40 +1,128 @@
 package export
 
 import (
-	"encoding/json"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/drummonds/openwrt-configurator.git/internal/config"
 	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/plugin"
 	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
 )
 
+// Options controls what is exported from a device
+type Options struct {
+	// NoFacts disables populating the device tags with facts read from the
+	// device (board, version, arch)
+	NoFacts bool
+
+	// Config limits the export to a single config (e.g. network). Only that
+	// field of the exported ConfigConfig is populated and no package
+	// profile is included.
+	Config string
+
+	// SSHAlgorithms overrides the SSH algorithms used to connect. They are
+	// also written to the exported provisioning config.
+	SSHAlgorithms *config.SSHAlgorithms
+
+	// PrivateKeyPath and PrivateKeyPassphrase authenticate with a key
+	// instead of, or before, the password. They are also written to the
+	// exported provisioning config.
+	PrivateKeyPath       string
+	PrivateKeyPassphrase string
+
+	// Port is the device's SSH port (default 22). It is also written to the
+	// exported provisioning config.
+	Port int
+
+	// StrictHostKeyChecking and KnownHostsFile control how the device's host
+	// key is checked, as in the provisioning config they are written to
+	StrictHostKeyChecking string
+	KnownHostsFile        string
+
+	// NoMetadata leaves out the provenance metadata, e.g. for exports that
+	// should be identical when the device config hasn't changed
+	NoMetadata bool
+
+	// Defaults, when set, limits the export to the options that differ
+	// from the schema's factory default config. It must be the schema of
+	// the device's model.
+	Defaults *device.DeviceSchema
+
+	// ToolVersion is the version of openwrt-configurator recorded in the
+	// metadata
+	ToolVersion string
+
+	// Backend selects how configs are read: BackendUCI (the default) or
+	// BackendUbus
+	Backend string
+}
+
+// now is replaced in tests
+var now = time.Now
+
+// requiredConfigs must be exported successfully; other configs may not
+// exist on every device and are skipped when they can't be read
+var requiredConfigs = map[string]bool{
+	"system":  true,
+	"network": true,
+}
+
+func init() {
+	// The built-in configs are exported through the plugin registry like
+	// any other handler
+	plugin.Register("system", plugin.Handler{ParseExport: func(output string) (any, error) {
+		info, err := parseSystemConfig(output)
+		if err != nil {
+			return nil, err
+		}
+		return info.Config, nil
+	}})
+	plugin.Register("network", plugin.Handler{ParseExport: func(output string) (any, error) {
+		return parseNetworkConfig(output)
+	}})
+	plugin.Register("wireless", plugin.Handler{ParseExport: func(output string) (any, error) {
+		return parseWirelessConfig(output)
+	}})
+	plugin.Register("dropbear", plugin.Handler{ParseExport: func(output string) (any, error) {
+		return parseDropbearConfig(output)
+	}})
+	plugin.Register("dhcp", plugin.Handler{ParseExport: func(output string) (any, error) {
+		return parseDHCPConfig(output)
+	}})
+}
+
 // ExportConfig reads configuration from an OpenWRT device and exports it as JSON
 // If modelID is empty, it will be auto-detected from the device's board.json
-func ExportConfig(modelID, ipAddr, username, password string) (*config.ONCConfig, error) {
+func ExportConfig(modelID, ipAddr, username, password string, opts Options) (*config.ONCConfig, error) {
 	// Connect to device
-	client, err := ssh.Connect(ipAddr, username, password)
+	client, err := ssh.Connect(ipAddr, opts.Port, username, password, ssh.OptionsFromConfig(&config.ProvisioningConfig{
+		SSHAuth: config.SSHAuth{
+			PrivateKeyPath:       opts.PrivateKeyPath,
+			PrivateKeyPassphrase: opts.PrivateKeyPassphrase,
+		},
+		SSHAlgorithms:         opts.SSHAlgorithms,
+		StrictHostKeyChecking: opts.StrictHostKeyChecking,
+		KnownHostsFile:        opts.KnownHostsFile,
+	}))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to device: %w", err)
 	}
 	defer client.Close()
 
-	return ExportConfigFromClient(client, modelID, ipAddr, username, password)
+	return ExportConfigFromClient(client, modelID, ipAddr, username, password, opts)
 }
 
 // ExportConfigFromClient reads configuration from an OpenWRT device using an existing SSH client
 // If modelID is empty, it will be auto-detected from the device's board.json
-func ExportConfigFromClient(client ssh.SSHExecutor, modelID, ipAddr, username, password string) (*config.ONCConfig, error) {
+func ExportConfigFromClient(client ssh.SSHExecutor, modelID, ipAddr, username, password string, opts Options) (*config.ONCConfig, error) {
 	// Get board.json to detect/verify device model
-	boardOutput, err := client.Execute("cat /etc/board.json")
+	boardJSON, err := device.ReadBoardJSON(client)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read board.json: %w", err)
-	}
-
-	var boardJSON device.BoardJSON
-	if err := json.Unmarshal([]byte(boardOutput), &boardJSON); err != nil {
-		return nil, fmt.Errorf("failed to parse board.json: %w", err)
+		return nil, err
 	}
 
 	// Auto-detect model ID if not provided
@@ -42,70 +130,174 @@ func ExportConfigFromClient(client ssh.SSHExecutor, modelID, ipAddr, username, p
 		modelID = boardJSON.Model.ID
 	}
 
-	// Read system configuration
-	systemConfig, err := readSystemConfig(client)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read system config: %w", err)
+	var defaults map[string]string
+	if opts.Defaults != nil {
+		if opts.Defaults.Name != boardJSON.Model.ID {
+			return nil, fmt.Errorf("defaults are for model %s, but the device is a %s", opts.Defaults.Name, boardJSON.Model.ID)
+		}
+		defaults = opts.Defaults.DefaultConfig
 	}
 
-	// Read network configuration
-	networkConfig, err := readNetworkConfig(client)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read network config: %w", err)
+	exportable := plugin.ExportConfigs()
+	if opts.Config != "" && !slices.Contains(exportable, opts.Config) {
+		return nil, fmt.Errorf("unsupported config %q, expected one of: %s", opts.Config, strings.Join(exportable, ", "))
 	}
 
-	// Read wireless configuration
-	wirelessConfig, err := readWirelessConfig(client)
-	if err != nil {
-		// Wireless may not exist on all devices
-		wirelessConfig = nil
+	backend := opts.Backend
+	if backend == "" {
+		backend = BackendUCI
+	}
+	if backend != BackendUCI && backend != BackendUbus {
+		return nil, fmt.Errorf("unsupported backend %q, expected %s or %s", backend, BackendUCI, BackendUbus)
 	}
 
-	// Read dropbear configuration
-	dropbearConfig, err := readDropbearConfig(client)
+	// Read system configuration, always needed for the hostname
+	hostname, err := readHostname(client, backend)
 	if err != nil {
-		// Non-fatal, may not exist
-		dropbearConfig = nil
+		return nil, fmt.Errorf("failed to read system config: %w", err)
+	}
+
+	// Read each config through its registered handler
+	var configConfig config.ConfigConfig
+	for _, configKey := range exportable {
+		if opts.Config != "" && opts.Config != configKey {
+			continue
+		}
+
+		var value any
+		if backend == BackendUbus {
+			value, err = readUbusConfig(client, configKey, defaults)
+		} else {
+			value, err = exportConfig(client, configKey, defaults)
+		}
+		if err != nil {
+			if requiredConfigs[configKey] || opts.Config == configKey {
+				return nil, fmt.Errorf("failed to read %s config: %w", configKey, err)
+			}
+			// Non-fatal, may not exist on this device
+			continue
+		}
+
+		if err := configConfig.Set(configKey, value); err != nil {
+			return nil, err
+		}
 	}
 
 	// Read installed packages
-	packages, err := readInstalledPackages(client)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read installed packages: %w", err)
+	var packageProfiles []config.PackageProfile
+	if opts.Config == "" {
+		packages, err := readInstalledPackages(client)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read installed packages: %w", err)
+		}
+		packageProfiles = []config.PackageProfile{
+			{
+				Packages: packages,
+			},
+		}
+	}
+
+	// Read sysctl settings, which aren't UCI
+	var sysctls []config.SysctlConfig
+	if opts.Config == "" {
+		if settings := readSysctls(client); len(settings) > 0 {
+			sysctls = []config.SysctlConfig{{Settings: settings}}
+		}
+	}
+
+	// Read device facts into tags
+	tags := make(map[string]any)
+	if !opts.NoFacts {
+		tags = readDeviceFacts(client, boardJSON)
 	}
 
 	// Build ONCConfig
 	oncConfig := &config.ONCConfig{
+		SchemaVersion: config.SchemaVersion,
 		Devices: []config.DeviceConfig{
 			{
 				ModelID:  boardJSON.Model.ID,
 				IPAddr:   ipAddr,
-				Hostname: systemConfig.Hostname,
-				Tags:     make(map[string]any),
+				Hostname: hostname,
+				Tags:     tags,
 				ProvisioningConfig: &config.ProvisioningConfig{
 					SSHAuth: config.SSHAuth{
-						Username: username,
-						Password: password,
+						Username:             username,
+						Password:             password,
+						PrivateKeyPath:       opts.PrivateKeyPath,
+						PrivateKeyPassphrase: opts.PrivateKeyPassphrase,
 					},
+					SSHAlgorithms:         opts.SSHAlgorithms,
+					Port:                  opts.Port,
+					StrictHostKeyChecking: opts.StrictHostKeyChecking,
+					KnownHostsFile:        opts.KnownHostsFile,
 				},
 			},
 		},
-		PackageProfiles: []config.PackageProfile{
-			{
-				Packages: packages,
-			},
-		},
-		Config: config.ConfigConfig{
-			System:   systemConfig.Config,
-			Network:  networkConfig,
-			Wireless: wirelessConfig,
-			Dropbear: dropbearConfig,
-		},
+		PackageProfiles: packageProfiles,
+		Config:          configConfig,
+		Sysctl:          sysctls,
+	}
+
+	if !opts.NoMetadata {
+		oncConfig.Metadata = &config.Metadata{
+			ExportedAt:  now().UTC().Format(time.RFC3339),
+			ToolVersion: opts.ToolVersion,
+			SourceIP:    ipAddr,
+			ModelID:     boardJSON.Model.ID,
+		}
+		if version, err := device.GetDeviceVersion(client); err == nil {
+			oncConfig.Metadata.Version = version
+		}
 	}
 
 	return oncConfig, nil
 }
 
+// exportConfig reads a config from the device and parses it with its
+// registered handler, leaving out options with their default value when
+// defaults are given
+func exportConfig(client ssh.SSHExecutor, configKey string, defaults map[string]string) (any, error) {
+	handler, ok := plugin.Lookup(configKey)
+	if !ok || handler.ParseExport == nil {
+		return nil, fmt.Errorf("no export handler for config %s", configKey)
+	}
+
+	output, err := client.Execute(fmt.Sprintf("uci show %s", configKey))
+	if err != nil {
+		return nil, err
+	}
+	if defaults != nil {
+		output = uci.FilterDefaults(output, defaults)
+	}
+
+	return handler.ParseExport(output)
+}
+
+// readHostname reads the device's hostname with the given backend
+func readHostname(client ssh.SSHExecutor, backend string) (string, error) {
+	if backend == BackendUbus {
+		value, err := readUbusConfig(client, "system", nil)
+		if err != nil {
+			return "", err
+		}
+		if systemConfig, ok := value.(*config.SystemConfig); ok {
+			for _, section := range systemConfig.System {
+				if section.Hostname != nil {
+					return *section.Hostname, nil
+				}
+			}
+		}
+		return "", nil
+	}
+
+	systemConfig, err := readSystemConfig(client)
+	if err != nil {
+		return "", err
+	}
+	return systemConfig.Hostname, nil
+}
+
 // SystemInfo holds basic system information
 type SystemInfo struct {
 	Hostname string
@@ -118,6 +310,11 @@ func readSystemConfig(client ssh.SSHExecutor) (*SystemInfo, error) {
 		return nil, err
 	}
 
+	return parseSystemConfig(output)
+}
+
+func parseSystemConfig(output string) (*SystemInfo, error) {
+
 	lines := strings.Split(output, "\n")
 	sections := make(map[string]map[string]string)
 	var hostname string
@@ -164,17 +361,29 @@ func readSystemConfig(client ssh.SSHExecutor) (*SystemInfo, error) {
 		}
 
 		section := config.SystemSection{
-			Name: strPtr(sectionName),
+			Name: config.Ptr(sectionName),
 		}
 
 		if h, ok := fields["hostname"]; ok {
-			section.Hostname = strPtr(h)
+			section.Hostname = config.Ptr(h)
 		}
 		if tz, ok := fields["timezone"]; ok {
-			section.Timezone = strPtr(tz)
+			section.Timezone = config.Ptr(tz)
 		}
 		if zn, ok := fields["zonename"]; ok {
-			section.Zonename = strPtr(zn)
+			section.Zonename = config.Ptr(zn)
+		}
+		if ttylogin, ok := fields["ttylogin"]; ok {
+			section.TTYLogin = parseBool(ttylogin)
+		}
+		if seed, ok := fields["urandom_seed"]; ok {
+			section.URandomSeed = parseBool(seed)
+		}
+		if compat, ok := fields["compat_version"]; ok {
+			section.CompatVersion = config.Ptr(compat)
+		}
+		if buffersize, ok := fields["buffersize"]; ok {
+			section.Buffersize = parseInt(buffersize)
 		}
 
 		systemSections = append(systemSections, section)
@@ -196,8 +405,19 @@ func readNetworkConfig(client ssh.SSHExecutor) (*config.NetworkConfig, error) {
 		return nil, err
 	}
 
+	return parseNetworkConfig(output)
+}
+
+func parseNetworkConfig(output string) (*config.NetworkConfig, error) {
+
 	lines := strings.Split(output, "\n")
 	interfaces := make(map[string]map[string]string)
+	interfaceLists := make(map[string]map[string][]string)
+
+	// Policy routing rules, in order since it is significant
+	ruleTypes := make(map[string]string)
+	var ruleOrder []string
+	rules := make(map[string]map[string]string)
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -215,6 +435,12 @@ func readNetworkConfig(client ssh.SSHExecutor) (*config.NetworkConfig, error) {
 		value := strings.Trim(parts[1], "'\"")
 
 		keyParts := strings.Split(key, ".")
+		if len(keyParts) == 2 && (value == "rule" || value == "rule6") {
+			ruleTypes[keyParts[1]] = value
+			ruleOrder = append(ruleOrder, keyParts[1])
+			rules[keyParts[1]] = make(map[string]string)
+			continue
+		}
 		if len(keyParts) < 3 {
 			continue
 		}
@@ -222,6 +448,11 @@ func readNetworkConfig(client ssh.SSHExecutor) (*config.NetworkConfig, error) {
 		section := keyParts[1]
 		field := keyParts[2]
 
+		if _, ok := ruleTypes[section]; ok {
+			rules[section][field] = value
+			continue
+		}
+
 		// Skip type definitions (e.g., network.lan=interface)
 		if field == "interface" || field == "device" && value == "device" {
 			continue
@@ -231,6 +462,12 @@ func readNetworkConfig(client ssh.SSHExecutor) (*config.NetworkConfig, error) {
 			interfaces[section] = make(map[string]string)
 		}
 		interfaces[section][field] = value
+		if field == "ip6class" {
+			if interfaceLists[section] == nil {
+				interfaceLists[section] = make(map[string][]string)
+			}
+			interfaceLists[section][field] = uci.ParseShowValue(parts[1])
+		}
 	}
 
 	// Build NetworkConfig
@@ -242,31 +479,73 @@ func readNetworkConfig(client ssh.SSHExecutor) (*config.NetworkConfig, error) {
 		}
 
 		section := config.InterfaceSection{
-			Name: strPtr(sectionName),
+			Name: config.Ptr(sectionName),
 		}
 
 		if proto, ok := fields["proto"]; ok {
-			section.Proto = strPtr(proto)
+			section.Proto = config.Ptr(proto)
 		}
 		if device, ok := fields["device"]; ok {
-			section.Device = strPtr(device)
+			section.Device = config.Ptr(device)
 		}
 		if ipaddr, ok := fields["ipaddr"]; ok {
-			section.IPAddr = strPtr(ipaddr)
+			section.IPAddr = config.Ptr(ipaddr)
 		}
 		if netmask, ok := fields["netmask"]; ok {
-			section.Netmask = strPtr(netmask)
+			section.Netmask = config.Ptr(netmask)
 		}
 		if gateway, ok := fields["gateway"]; ok {
-			section.Gateway = strPtr(gateway)
+			section.Gateway = config.Ptr(gateway)
+		}
+		if mtu, ok := fields["mtu"]; ok {
+			section.MTU = parseInt(mtu)
+		}
+		if reqaddress, ok := fields["reqaddress"]; ok {
+			section.ReqAddress = config.Ptr(reqaddress)
+		}
+		if reqprefix, ok := fields["reqprefix"]; ok {
+			section.ReqPrefix = config.Ptr(reqprefix)
+		}
+		if ip6ifaceid, ok := fields["ip6ifaceid"]; ok {
+			section.IP6IfaceID = config.Ptr(ip6ifaceid)
 		}
+		section.IP6Class = interfaceLists[sectionName]["ip6class"]
 
 		interfaceSections = append(interfaceSections, section)
 	}
 
-	return &config.NetworkConfig{
+	networkConfig := &config.NetworkConfig{
 		Interface: interfaceSections,
-	}, nil
+	}
+
+	// Anonymous rules keep their @rule[i] names so applying the export sets
+	// them instead of adding new ones
+	for _, sectionName := range ruleOrder {
+		fields := rules[sectionName]
+		section := config.NetworkRuleSection{Name: config.Ptr(sectionName)}
+		for field, target := range map[string]**string{
+			"in":     &section.In,
+			"out":    &section.Out,
+			"src":    &section.Src,
+			"dest":   &section.Dest,
+			"lookup": &section.Lookup,
+		} {
+			if value, ok := fields[field]; ok {
+				*target = config.Ptr(value)
+			}
+		}
+		if priority, ok := fields["priority"]; ok {
+			section.Priority = parseInt(priority)
+		}
+
+		if ruleTypes[sectionName] == "rule6" {
+			networkConfig.Rule6 = append(networkConfig.Rule6, section)
+		} else {
+			networkConfig.Rule = append(networkConfig.Rule, section)
+		}
+	}
+
+	return networkConfig, nil
 }
 
 func readWirelessConfig(client ssh.SSHExecutor) (*config.WirelessConfig, error) {
@@ -275,6 +554,11 @@ func readWirelessConfig(client ssh.SSHExecutor) (*config.WirelessConfig, error)
 		return nil, err
 	}
 
+	return parseWirelessConfig(output)
+}
+
+func parseWirelessConfig(output string) (*config.WirelessConfig, error) {
+
 	if strings.TrimSpace(output) == "" {
 		return nil, fmt.Errorf("no wireless configuration")
 	}
@@ -282,6 +566,18 @@ func readWirelessConfig(client ssh.SSHExecutor) (*config.WirelessConfig, error)
 	lines := strings.Split(output, "\n")
 	devices := make(map[string]map[string]string)
 	ifaces := make(map[string]map[string]string)
+	ifaceLists := make(map[string]map[string][]string)
+
+	// Sections are told apart by their type lines, e.g.
+	// wireless.default_radio0=wifi-iface, as names such as default_radio0
+	// don't say
+	sectionTypes := make(map[string]string)
+	for _, line := range lines {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if keyParts := strings.Split(key, "."); ok && len(keyParts) == 2 {
+			sectionTypes[keyParts[1]] = strings.Trim(value, "'\"")
+		}
+	}
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -305,16 +601,23 @@ func readWirelessConfig(client ssh.SSHExecutor) (*config.WirelessConfig, error)
 		section := keyParts[1]
 		field := keyParts[2]
 
-		if strings.Contains(section, "radio") && field != "wifi-device" {
+		switch sectionTypes[section] {
+		case "wifi-device":
 			if devices[section] == nil {
 				devices[section] = make(map[string]string)
 			}
 			devices[section][field] = value
-		} else if (strings.Contains(section, "default_") || strings.Contains(section, "iface")) && field != "wifi-iface" {
+		case "wifi-iface":
 			if ifaces[section] == nil {
 				ifaces[section] = make(map[string]string)
 			}
 			ifaces[section][field] = value
+			if field == "maclist" {
+				if ifaceLists[section] == nil {
+					ifaceLists[section] = make(map[string][]string)
+				}
+				ifaceLists[section][field] = uci.ParseShowValue(parts[1])
+			}
 		}
 	}
 
@@ -322,17 +625,17 @@ func readWirelessConfig(client ssh.SSHExecutor) (*config.WirelessConfig, error)
 	var deviceSections []config.WifiDeviceSection
 	for sectionName, fields := range devices {
 		section := config.WifiDeviceSection{
-			Name: strPtr(sectionName),
+			Name: config.Ptr(sectionName),
 		}
 
 		if t, ok := fields["type"]; ok {
-			section.Type = strPtr(t)
+			section.Type = config.Ptr(t)
 		}
 		if band, ok := fields["band"]; ok {
-			section.Band = strPtr(band)
+			section.Band = config.Ptr(band)
 		}
 		if channel, ok := fields["channel"]; ok {
-			section.Channel = strPtr(channel)
+			section.Channel = config.Ptr(channel)
 		}
 
 		deviceSections = append(deviceSections, section)
@@ -341,24 +644,28 @@ func readWirelessConfig(client ssh.SSHExecutor) (*config.WirelessConfig, error)
 	var ifaceSections []config.WifiIfaceSection
 	for sectionName, fields := range ifaces {
 		section := config.WifiIfaceSection{
-			Name: strPtr(sectionName),
+			Name: config.Ptr(sectionName),
 		}
 
 		if device, ok := fields["device"]; ok {
 			section.Device = device
 		}
 		if mode, ok := fields["mode"]; ok {
-			section.Mode = strPtr(mode)
+			section.Mode = config.Ptr(mode)
 		}
 		if ssid, ok := fields["ssid"]; ok {
-			section.SSID = strPtr(ssid)
+			section.SSID = config.Ptr(ssid)
 		}
 		if encryption, ok := fields["encryption"]; ok {
-			section.Encryption = strPtr(encryption)
+			section.Encryption = config.Ptr(encryption)
 		}
 		if network, ok := fields["network"]; ok {
-			section.Network = strPtr(network)
+			section.Network = config.Ptr(network)
 		}
+		if macfilter, ok := fields["macfilter"]; ok {
+			section.Macfilter = config.Ptr(macfilter)
+		}
+		section.Maclist = ifaceLists[sectionName]["maclist"]
 
 		ifaceSections = append(ifaceSections, section)
 	}
@@ -379,6 +686,11 @@ func readDropbearConfig(client ssh.SSHExecutor) (*config.DropbearConfig, error)
 		return nil, err
 	}
 
+	return parseDropbearConfig(output)
+}
+
+func parseDropbearConfig(output string) (*config.DropbearConfig, error) {
+
 	lines := strings.Split(output, "\n")
 	sections := make(map[string]map[string]string)
 
@@ -422,14 +734,14 @@ func readDropbearConfig(client ssh.SSHExecutor) (*config.DropbearConfig, error)
 		}
 
 		section := config.DropbearSection{
-			Name: strPtr(sectionName),
+			Name: config.Ptr(sectionName),
 		}
 
 		if pa, ok := fields["PasswordAuth"]; ok {
-			section.PasswordAuth = strPtr(pa)
+			section.PasswordAuth = config.Ptr(pa)
 		}
 		if rpa, ok := fields["RootPasswordAuth"]; ok {
-			section.RootPasswordAuth = strPtr(rpa)
+			section.RootPasswordAuth = config.Ptr(rpa)
 		}
 		if port, ok := fields["Port"]; ok {
 			if p := parseInt(port); p != nil {
@@ -449,6 +761,186 @@ func readDropbearConfig(client ssh.SSHExecutor) (*config.DropbearConfig, error)
 	}, nil
 }
 
+// parseDHCPConfig parses the dnsmasq, dhcp pool, odhcpd and static lease
+// sections of a dhcp config, in the order they appear. Options without a
+// field are kept in the section's Extra.
+func parseDHCPConfig(output string) (*config.DHCPConfig, error) {
+	lines := strings.Split(output, "\n")
+	var order []string
+	sectionTypes := make(map[string]string)
+	sections := make(map[string]map[string]string)
+	lists := make(map[string]map[string][]string)
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key := parts[0]
+		value := strings.Trim(parts[1], "'\"")
+
+		// Type definitions, e.g. dhcp.lan=dhcp
+		keyParts := strings.Split(key, ".")
+		if len(keyParts) == 2 {
+			section := keyParts[1]
+			if _, ok := sectionTypes[section]; !ok {
+				order = append(order, section)
+			}
+			sectionTypes[section] = value
+			sections[section] = make(map[string]string)
+			lists[section] = make(map[string][]string)
+			continue
+		}
+		if len(keyParts) < 3 || sections[keyParts[1]] == nil {
+			continue
+		}
+
+		section := keyParts[1]
+		field := keyParts[2]
+		sections[section][field] = value
+		lists[section][field] = uci.ParseShowValue(parts[1])
+	}
+
+	// Anonymous sections, e.g. the default dnsmasq, keep their @type[i]
+	// names so applying the export sets them instead of adding new ones
+	dhcpConfig := &config.DHCPConfig{}
+	for _, sectionName := range order {
+		fields := sections[sectionName]
+		switch sectionTypes[sectionName] {
+		case "dnsmasq":
+			section := config.DnsmasqSection{Name: config.Ptr(sectionName)}
+			if v, ok := fields["domainneeded"]; ok {
+				section.DomainNeeded = parseBool(v)
+			}
+			if v, ok := fields["boguspriv"]; ok {
+				section.Boguspriv = parseBool(v)
+			}
+			if v, ok := fields["localservice"]; ok {
+				section.LocalService = parseBool(v)
+			}
+			section.Extra = extraOptions(fields, lists[sectionName], "domainneeded", "boguspriv", "localservice")
+			dhcpConfig.Dnsmasq = append(dhcpConfig.Dnsmasq, section)
+
+		case "dhcp":
+			section := config.DHCPSection{Name: config.Ptr(sectionName)}
+			if v, ok := fields["interface"]; ok {
+				section.Interface = config.Ptr(v)
+			}
+			if v, ok := fields["start"]; ok {
+				section.Start = parseInt(v)
+			}
+			if v, ok := fields["limit"]; ok {
+				section.Limit = parseInt(v)
+			}
+			if v, ok := fields["leasetime"]; ok {
+				section.Leasetime = config.Ptr(v)
+			}
+			section.DHCPOption = lists[sectionName]["dhcp_option"]
+			if v, ok := fields["ignore"]; ok {
+				section.Ignore = parseBool(v)
+			}
+			if v, ok := fields["dhcpv4"]; ok {
+				section.DHCPv4 = config.Ptr(v)
+			}
+			section.Extra = extraOptions(fields, lists[sectionName], "interface", "start", "limit", "leasetime", "dhcp_option", "ignore", "dhcpv4")
+			dhcpConfig.DHCP = append(dhcpConfig.DHCP, section)
+
+		case "odhcpd":
+			section := config.OdhcpdSection{Name: config.Ptr(sectionName)}
+			if v, ok := fields["maindhcp"]; ok {
+				section.Maindhcp = parseBool(v)
+			}
+			if v, ok := fields["leasefile"]; ok {
+				section.Leasefile = config.Ptr(v)
+			}
+			if v, ok := fields["leasetrigger"]; ok {
+				section.Leasetrigger = config.Ptr(v)
+			}
+			section.Extra = extraOptions(fields, lists[sectionName], "maindhcp", "leasefile", "leasetrigger")
+			dhcpConfig.Odhcpd = append(dhcpConfig.Odhcpd, section)
+
+		case "host":
+			section := config.HostSection{Name: config.Ptr(sectionName)}
+			if v, ok := fields["name"]; ok {
+				section.HostName = config.Ptr(v)
+			}
+			if v, ok := fields["mac"]; ok {
+				section.MAC = config.Ptr(v)
+			}
+			if v, ok := fields["ip"]; ok {
+				section.IP = config.Ptr(v)
+			}
+			if v, ok := fields["leasetime"]; ok {
+				section.Leasetime = config.Ptr(v)
+			}
+			if v, ok := fields["dns"]; ok {
+				section.DNS = parseBool(v)
+			}
+			section.Extra = extraOptions(fields, lists[sectionName], "name", "mac", "ip", "leasetime", "dns")
+			dhcpConfig.Host = append(dhcpConfig.Host, section)
+		}
+	}
+
+	if len(dhcpConfig.Dnsmasq) == 0 && len(dhcpConfig.DHCP) == 0 && len(dhcpConfig.Odhcpd) == 0 && len(dhcpConfig.Host) == 0 {
+		return nil, fmt.Errorf("no dhcp configuration found")
+	}
+
+	return dhcpConfig, nil
+}
+
+// extraOptions returns the options of a section that aren't among the
+// known ones, as values or lists of values, or nil when there are none
+func extraOptions(fields map[string]string, lists map[string][]string, known ...string) map[string]any {
+	var extra map[string]any
+	for field, value := range fields {
+		if slices.Contains(known, field) {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]any)
+		}
+		if items := lists[field]; len(items) > 1 {
+			extra[field] = items
+		} else {
+			extra[field] = value
+		}
+	}
+	return extra
+}
+
+// readDeviceFacts collects well-known device facts so exported configs can
+// use them in conditions straight away. Facts that can't be read are skipped.
+func readDeviceFacts(client ssh.SSHExecutor, boardJSON *device.BoardJSON) map[string]any {
+	facts := make(map[string]any)
+
+	if boardJSON.Model.ID != "" {
+		facts["board"] = boardJSON.Model.ID
+	}
+
+	if version, err := device.GetDeviceVersion(client); err == nil {
+		facts["version"] = version
+	}
+
+	if arch, err := device.GetArchitecture(client); err == nil {
+		facts["arch"] = arch
+	}
+
+	return facts
+}
+
+// readSysctls reads the settings of /etc/sysctl.conf and of the file
+// provisioning writes. Either may be missing.
+func readSysctls(client ssh.SSHExecutor) map[string]string {
+	output, _ := client.Execute(fmt.Sprintf("cat /etc/sysctl.conf %s 2>/dev/null", device.SysctlPath))
+	return device.ParseSysctlConf(output)
+}
+
 func readInstalledPackages(client ssh.SSHExecutor) ([]string, error) {
 	output, err := client.Execute("opkg list-installed")
 	if err != nil {
@@ -473,10 +965,6 @@ func readInstalledPackages(client ssh.SSHExecutor) ([]string, error) {
 	return packages, nil
 }
 
-func strPtr(s string) *string {
-	return &s
-}
-
 func parseInt(s string) *int {
 	var i int
 	if _, err := fmt.Sscanf(s, "%d", &i); err == nil {
@@ -484,3 +972,18 @@ func parseInt(s string) *int {
 	}
 	return nil
 }
+
+// parseBool parses a UCI boolean, which may be written as 1/0, on/off,
+// yes/no, true/false or enabled/disabled
+func parseBool(s string) *bool {
+	var b bool
+	switch strings.ToLower(s) {
+	case "1", "on", "yes", "true", "enabled":
+		b = true
+	case "0", "off", "no", "false", "disabled":
+		b = false
+	default:
+		return nil
+	}
+	return &b
+}
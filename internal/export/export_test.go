@@ -1,10 +1,19 @@
 package export
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
 	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
 )
 
 func TestExportConfig(t *testing.T) {
@@ -70,7 +79,7 @@ dropbear.@dropbear[0].Port='22'
 	}
 
 	// Export configuration using the mock client
-	oncConfig, err := ExportConfigFromClient(mockClient, "ubnt,edgerouter-x", "192.168.1.1", "root", "password")
+	oncConfig, err := ExportConfigFromClient(mockClient, "ubnt,edgerouter-x", "192.168.1.1", "root", "password", Options{})
 	if err != nil {
 		t.Fatalf("Failed to export config: %v", err)
 	}
@@ -177,6 +186,8 @@ func TestReadSystemConfig(t *testing.T) {
 system.@system[0].hostname='my-router'
 system.@system[0].timezone='America/New_York'
 system.@system[0].zonename='EST5EDT'
+system.@system[0].ttylogin='1'
+system.@system[0].compat_version='1.1'
 `, nil
 		}
 		return "", nil
@@ -203,6 +214,10 @@ system.@system[0].zonename='EST5EDT'
 	if section.Timezone == nil || *section.Timezone != "America/New_York" {
 		t.Error("Timezone not correctly parsed")
 	}
+
+	if section.TTYLogin == nil || !*section.TTYLogin || section.CompatVersion == nil || *section.CompatVersion != "1.1" {
+		t.Errorf("ttylogin and compat_version not correctly parsed: %+v", section)
+	}
 }
 
 func TestReadNetworkConfig(t *testing.T) {
@@ -313,7 +328,7 @@ network.lan.ipaddr='192.168.1.1'
 	}
 
 	// Export configuration WITHOUT providing model ID (empty string)
-	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password")
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{})
 	if err != nil {
 		t.Fatalf("Failed to export config: %v", err)
 	}
@@ -332,3 +347,593 @@ network.lan.ipaddr='192.168.1.1'
 		t.Errorf("Expected hostname 'auto-detect-test', got '%s'", device.Hostname)
 	}
 }
+
+func TestExportConfigFacts(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Version = "23.05.2"
+
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+
+	tags := oncConfig.Devices[0].Tags
+	if tags["version"] != "23.05.2" {
+		t.Errorf("Expected version tag '23.05.2', got '%v'", tags["version"])
+	}
+	if tags["board"] != "ubnt,edgerouter-x" {
+		t.Errorf("Expected board tag 'ubnt,edgerouter-x', got '%v'", tags["board"])
+	}
+	if tags["arch"] != "mipsel_24kc" {
+		t.Errorf("Expected arch tag 'mipsel_24kc', got '%v'", tags["arch"])
+	}
+
+	// Facts can be disabled
+	oncConfig, err = ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{NoFacts: true})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+	if len(oncConfig.Devices[0].Tags) != 0 {
+		t.Errorf("Expected no tags with facts disabled, got %v", oncConfig.Devices[0].Tags)
+	}
+}
+
+func TestExportConfigSysctl(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["cat /etc/sysctl.conf /etc/sysctl.d/90-openwrt-configurator.conf 2>/dev/null"] = "# Local settings\nnet.ipv4.ip_forward=1\n"
+
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+	if len(oncConfig.Sysctl) != 1 || oncConfig.Sysctl[0].Settings["net.ipv4.ip_forward"] != "1" {
+		t.Errorf("Expected the sysctl settings to be exported, got %v", oncConfig.Sysctl)
+	}
+}
+
+func TestExportConfigSingleConfig(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show system"] = `system.@system[0]=system
+system.@system[0].hostname='test-router'
+`
+	mockClient.Responses["uci show network"] = `network.lan=interface
+network.lan.proto='static'
+network.lan.ipaddr='192.168.1.1'
+`
+	mockClient.Responses["uci show dropbear"] = `dropbear.@dropbear[0]=dropbear
+dropbear.@dropbear[0].Port='22'
+`
+
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{Config: "network"})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+
+	cfg := oncConfig.Config
+	if cfg.Network == nil || len(cfg.Network.Interface) != 1 {
+		t.Fatalf("Expected network config with one interface, got %+v", cfg.Network)
+	}
+	if cfg.System != nil || cfg.Firewall != nil || cfg.DHCP != nil || cfg.Wireless != nil || cfg.Dropbear != nil {
+		t.Errorf("Expected only network config, got %+v", cfg)
+	}
+	if oncConfig.PackageProfiles != nil {
+		t.Errorf("Expected no package profiles, got %v", oncConfig.PackageProfiles)
+	}
+	if oncConfig.Devices[0].Hostname != "test-router" {
+		t.Errorf("Expected hostname 'test-router', got '%s'", oncConfig.Devices[0].Hostname)
+	}
+
+	if _, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{Config: "bogus"}); err == nil {
+		t.Error("Expected error for unsupported config")
+	}
+}
+
+func TestExportConfigMetadata(t *testing.T) {
+	originalNow := now
+	defer func() { now = originalNow }()
+	now = func() time.Time {
+		return time.Date(2024, 3, 1, 12, 30, 0, 0, time.FixedZone("CET", 3600))
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Version = "23.05.2"
+
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{ToolVersion: "1.2.3"})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+
+	expected := config.Metadata{
+		ExportedAt:  "2024-03-01T11:30:00Z",
+		ToolVersion: "1.2.3",
+		SourceIP:    "192.168.1.1",
+		ModelID:     "ubnt,edgerouter-x",
+		Version:     "23.05.2",
+	}
+	if oncConfig.Metadata == nil || *oncConfig.Metadata != expected {
+		t.Fatalf("Unexpected metadata: %+v", oncConfig.Metadata)
+	}
+
+	// Provisioning the exported config ignores the metadata
+	data, err := json.Marshal(oncConfig)
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	withMetadata, err := config.Parse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse exported config: %v", err)
+	}
+	if withMetadata.Metadata == nil {
+		t.Fatal("Expected metadata to survive a round trip")
+	}
+	withoutMetadata := *withMetadata
+	withoutMetadata.Metadata = nil
+
+	schema := &device.DeviceSchema{Name: "ubnt,edgerouter-x", Version: "23.05.2"}
+	var scripts [][]string
+	for _, cfg := range []*config.ONCConfig{withMetadata, &withoutMetadata} {
+		state, err := device.GetOpenWrtState(cfg, &cfg.Devices[0], schema)
+		if err != nil {
+			t.Fatalf("Failed to get state: %v", err)
+		}
+		script, err := device.GetDeviceScript(state, nil)
+		if err != nil {
+			t.Fatalf("Failed to get script: %v", err)
+		}
+		scripts = append(scripts, script)
+	}
+	if len(scripts[0]) == 0 || !slices.Equal(scripts[0], scripts[1]) {
+		t.Errorf("Expected metadata not to change the script:\n%v\n%v", scripts[0], scripts[1])
+	}
+
+	// Metadata can be disabled
+	oncConfig, err = ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{NoMetadata: true})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+	if oncConfig.Metadata != nil {
+		t.Errorf("Expected no metadata, got %+v", oncConfig.Metadata)
+	}
+}
+
+func TestExportConfigChangedOnly(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show system"] = `system.@system[0]=system
+system.@system[0].hostname='my-router'
+system.@system[0].timezone='UTC'
+system.@system[0].ttylogin='0'
+`
+	mockClient.Responses["uci show network"] = `network.loopback=interface
+network.loopback.device='lo'
+network.loopback.proto='static'
+network.loopback.ipaddr='127.0.0.1'
+network.loopback.netmask='255.0.0.0'
+network.lan=interface
+network.lan.device='br-lan'
+network.lan.proto='static'
+network.lan.ipaddr='10.0.0.1'
+network.lan.netmask='255.255.255.0'
+network.wan=interface
+network.wan.device='eth0'
+network.wan.proto='dhcp'
+`
+
+	schema := &device.DeviceSchema{
+		Name: "ubnt,edgerouter-x",
+		DefaultConfig: map[string]string{
+			"system.@system[0]":          "system",
+			"system.@system[0].hostname": "OpenWrt",
+			"system.@system[0].timezone": "UTC",
+			"system.@system[0].ttylogin": "0",
+			"network.loopback":           "interface",
+			"network.loopback.device":    "lo",
+			"network.loopback.proto":     "static",
+			"network.loopback.ipaddr":    "127.0.0.1",
+			"network.loopback.netmask":   "255.0.0.0",
+			"network.lan":                "interface",
+			"network.lan.device":         "br-lan",
+			"network.lan.proto":          "static",
+			"network.lan.ipaddr":         "192.168.1.1",
+			"network.lan.netmask":        "255.255.255.0",
+			"network.wan":                "interface",
+			"network.wan.device":         "eth0",
+			"network.wan.proto":          "dhcp",
+		},
+	}
+
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{Defaults: schema})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+
+	// Only the hostname and the lan address differ from the defaults
+	system := oncConfig.Config.System.System
+	if len(system) != 1 || system[0].Hostname == nil || *system[0].Hostname != "my-router" || system[0].Timezone != nil {
+		t.Errorf("Expected only the hostname to be exported, got %+v", system)
+	}
+	interfaces := oncConfig.Config.Network.Interface
+	if len(interfaces) != 1 || *interfaces[0].Name != "lan" || interfaces[0].IPAddr == nil || *interfaces[0].IPAddr != "10.0.0.1" {
+		t.Fatalf("Expected only the lan address to be exported, got %+v", interfaces)
+	}
+	if interfaces[0].Proto != nil || interfaces[0].Device != nil || interfaces[0].Netmask != nil {
+		t.Errorf("Expected default lan options to be left out, got %+v", interfaces[0])
+	}
+
+	// Defaults for another model are rejected
+	schema.Name = "tplink,archer-c50-v4"
+	if _, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{Defaults: schema}); err == nil {
+		t.Error("Expected an error for defaults of another model")
+	}
+}
+
+func TestReadNetworkRules(t *testing.T) {
+	networkConfig, err := parseNetworkConfig(`network.lan=interface
+network.lan.proto='static'
+network.lan.ipaddr='192.168.2.1'
+network.@rule[0]=rule
+network.@rule[0].src='192.168.2.0/24'
+network.@rule[0].lookup='100'
+network.@rule[0].priority='1000'
+network.wan2_out=rule
+network.wan2_out.out='wan2'
+network.wan2_out.lookup='wan2'
+network.@rule6[0]=rule6
+network.@rule6[0].in='lan'
+network.@rule6[0].lookup='100'
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse network config: %v", err)
+	}
+
+	// Rules aren't mistaken for interfaces
+	if len(networkConfig.Interface) != 1 || *networkConfig.Interface[0].Name != "lan" {
+		t.Errorf("Expected only the lan interface, got %+v", networkConfig.Interface)
+	}
+
+	if len(networkConfig.Rule) != 2 {
+		t.Fatalf("Expected 2 rules, got %+v", networkConfig.Rule)
+	}
+	rule := networkConfig.Rule[0]
+	if *rule.Name != "@rule[0]" || *rule.Src != "192.168.2.0/24" || *rule.Lookup != "100" || *rule.Priority != 1000 {
+		t.Errorf("Unexpected source rule: %+v", rule)
+	}
+	if rule := networkConfig.Rule[1]; *rule.Name != "wan2_out" || *rule.Out != "wan2" {
+		t.Errorf("Unexpected named rule: %+v", rule)
+	}
+	if len(networkConfig.Rule6) != 1 || *networkConfig.Rule6[0].Name != "@rule6[0]" || *networkConfig.Rule6[0].In != "lan" {
+		t.Errorf("Unexpected rule6 sections: %+v", networkConfig.Rule6)
+	}
+}
+
+func TestReadDHCPv6Options(t *testing.T) {
+	networkConfig, err := parseNetworkConfig(`network.lan=interface
+network.lan.proto='static'
+network.lan.ipaddr='192.168.1.1'
+network.lan.ip6class='wan6' 'local'
+network.lan.ip6ifaceid='::1'
+network.wan6=interface
+network.wan6.device='eth0'
+network.wan6.proto='dhcpv6'
+network.wan6.reqaddress='try'
+network.wan6.reqprefix='56'
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse network config: %v", err)
+	}
+
+	for _, iface := range networkConfig.Interface {
+		switch *iface.Name {
+		case "lan":
+			if len(iface.IP6Class) != 2 || iface.IP6Class[0] != "wan6" || iface.IP6Class[1] != "local" || *iface.IP6IfaceID != "::1" {
+				t.Errorf("Unexpected lan IPv6 options: %v %v", iface.IP6Class, iface.IP6IfaceID)
+			}
+		case "wan6":
+			if *iface.ReqAddress != "try" || *iface.ReqPrefix != "56" {
+				t.Errorf("Unexpected wan6 DHCPv6 options: %v %v", *iface.ReqAddress, *iface.ReqPrefix)
+			}
+		}
+	}
+}
+
+func TestReadWirelessMACFilter(t *testing.T) {
+	wirelessConfig, err := parseWirelessConfig(`wireless.radio0=wifi-device
+wireless.radio0.type='mac80211'
+wireless.default_radio0=wifi-iface
+wireless.default_radio0.device='radio0'
+wireless.default_radio0.ssid='Office'
+wireless.default_radio0.macfilter='allow'
+wireless.default_radio0.maclist='00:11:22:33:44:55' '66:77:88:99:aa:bb'
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse wireless config: %v", err)
+	}
+
+	if len(wirelessConfig.WifiIface) != 1 {
+		t.Fatalf("Expected one wifi-iface, got %+v", wirelessConfig.WifiIface)
+	}
+	iface := wirelessConfig.WifiIface[0]
+	if iface.Macfilter == nil || *iface.Macfilter != "allow" {
+		t.Errorf("Expected macfilter allow, got %v", iface.Macfilter)
+	}
+	if strings.Join(iface.Maclist, " ") != "00:11:22:33:44:55 66:77:88:99:aa:bb" {
+		t.Errorf("Unexpected maclist %v", iface.Maclist)
+	}
+}
+
+func TestParseDHCPConfig(t *testing.T) {
+	output := `dhcp.@dnsmasq[0]=dnsmasq
+dhcp.@dnsmasq[0].domainneeded='1'
+dhcp.@dnsmasq[0].boguspriv='1'
+dhcp.@dnsmasq[0].localservice='1'
+dhcp.@dnsmasq[0].domain='lan'
+dhcp.lan=dhcp
+dhcp.lan.interface='lan'
+dhcp.lan.start='100'
+dhcp.lan.limit='150'
+dhcp.lan.leasetime='12h'
+dhcp.lan.dhcpv4='server'
+dhcp.lan.dhcp_option='6,10.0.0.53' '42,10.0.0.1'
+dhcp.wan=dhcp
+dhcp.wan.interface='wan'
+dhcp.wan.ignore='1'
+dhcp.odhcpd=odhcpd
+dhcp.odhcpd.maindhcp='0'
+dhcp.odhcpd.leasefile='/tmp/hosts/odhcpd'
+dhcp.odhcpd.leasetrigger='/usr/sbin/odhcpd-update'
+dhcp.printer=host
+dhcp.printer.name='printer'
+dhcp.printer.mac='00:11:22:33:44:55'
+dhcp.printer.ip='192.168.1.20'
+dhcp.printer.tag='known' 'printers'
+`
+
+	dhcpConfig, err := parseDHCPConfig(output)
+	if err != nil {
+		t.Fatalf("Failed to parse dhcp config: %v", err)
+	}
+
+	// The anonymous dnsmasq keeps its uci show name, and options without a
+	// field are kept
+	if len(dhcpConfig.Dnsmasq) != 1 || *dhcpConfig.Dnsmasq[0].Name != "@dnsmasq[0]" || !*dhcpConfig.Dnsmasq[0].DomainNeeded || !*dhcpConfig.Dnsmasq[0].LocalService {
+		t.Errorf("Unexpected dnsmasq sections: %+v", dhcpConfig.Dnsmasq)
+	}
+	if domain := dhcpConfig.Dnsmasq[0].Extra["domain"]; domain != "lan" {
+		t.Errorf("Expected the dnsmasq domain to be kept, got %v", dhcpConfig.Dnsmasq[0].Extra)
+	}
+
+	if len(dhcpConfig.DHCP) != 2 {
+		t.Fatalf("Expected the lan and wan pools, got %+v", dhcpConfig.DHCP)
+	}
+	lan, wan := dhcpConfig.DHCP[0], dhcpConfig.DHCP[1]
+	if *lan.Name != "lan" || *lan.Interface != "lan" || *lan.Start != 100 || *lan.Limit != 150 || *lan.Leasetime != "12h" || *lan.DHCPv4 != "server" {
+		t.Errorf("Unexpected lan pool: %+v", lan)
+	}
+	if strings.Join(lan.DHCPOption, " ") != "6,10.0.0.53 42,10.0.0.1" {
+		t.Errorf("Unexpected lan dhcp_option: %v", lan.DHCPOption)
+	}
+	if *wan.Name != "wan" || wan.Ignore == nil || !*wan.Ignore || wan.Start != nil {
+		t.Errorf("Unexpected wan pool: %+v", wan)
+	}
+
+	if len(dhcpConfig.Odhcpd) != 1 || *dhcpConfig.Odhcpd[0].Maindhcp || *dhcpConfig.Odhcpd[0].Leasefile != "/tmp/hosts/odhcpd" {
+		t.Errorf("Unexpected odhcpd sections: %+v", dhcpConfig.Odhcpd)
+	}
+	if len(dhcpConfig.Host) != 1 || *dhcpConfig.Host[0].HostName != "printer" || *dhcpConfig.Host[0].MAC != "00:11:22:33:44:55" || *dhcpConfig.Host[0].IP != "192.168.1.20" {
+		t.Errorf("Unexpected host sections: %+v", dhcpConfig.Host)
+	}
+	if tags, _ := dhcpConfig.Host[0].Extra["tag"].([]string); strings.Join(tags, " ") != "known printers" {
+		t.Errorf("Expected the host's tag list to be kept, got %v", dhcpConfig.Host[0].Extra)
+	}
+
+	// The kept options are exported alongside the typed ones
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show dhcp"] = output
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "", Options{NoFacts: true})
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	exported, err := json.Marshal(oncConfig.Config.DHCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(exported), `{".name":"@dnsmasq[0]","boguspriv":true,"domain":"lan",`) {
+		t.Errorf("Expected the exported dnsmasq to keep its domain, got %s", exported)
+	}
+
+	// A device without a dhcp config is exported without one
+	mockClient.Responses["uci show dhcp"] = ""
+	oncConfig, err = ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "", Options{NoFacts: true})
+	if err != nil {
+		t.Fatalf("Export failed without a dhcp config: %v", err)
+	}
+	if oncConfig.Config.DHCP != nil {
+		t.Errorf("Expected no dhcp config, got %+v", oncConfig.Config.DHCP)
+	}
+}
+
+func TestExportConfigUbus(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses[`ubus call uci get '{"config": "system"}'`] = `{
+	"values": {
+		"cfg01e48a": {".anonymous": true, ".type": "system", ".name": "cfg01e48a", ".index": 0, "hostname": "ubus-router", "ttylogin": "0"}
+	}
+}`
+	mockClient.Responses[`ubus call uci get '{"config": "network"}'`] = `{
+	"values": {
+		"wan": {".anonymous": false, ".type": "interface", ".name": "wan", ".index": 2, "device": "eth0", "proto": "dhcp", "mtu": "1492", "dns": ["1.1.1.1", "9.9.9.9"]},
+		"cfg030f15": {".anonymous": true, ".type": "device", ".name": "cfg030f15", ".index": 0, "name": "br-lan", "type": "bridge", "ports": ["lan0", "lan1"]},
+		"lan": {".anonymous": false, ".type": "interface", ".name": "lan", ".index": 1, "device": "br-lan", "proto": "static", "ipaddr": "192.168.1.1", "netmask": "255.255.255.0"}
+	}
+}`
+
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{Config: "network", Backend: BackendUbus})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+	if oncConfig.Devices[0].Hostname != "ubus-router" {
+		t.Errorf("Expected hostname 'ubus-router', got '%s'", oncConfig.Devices[0].Hostname)
+	}
+
+	network := oncConfig.Config.Network
+	if network == nil || len(network.Interface) != 2 {
+		t.Fatalf("Expected network config with two interfaces, got %+v", network)
+	}
+
+	// Sections keep their config order
+	lan, wan := network.Interface[0], network.Interface[1]
+	if *lan.Name != "lan" || *lan.IPAddr != "192.168.1.1" {
+		t.Errorf("Unexpected lan interface: %+v", lan)
+	}
+	if *wan.Name != "wan" || wan.MTU == nil || *wan.MTU != 1492 {
+		t.Errorf("Expected wan mtu 1492, got %+v", wan)
+	}
+	if len(wan.DNS) != 2 || wan.DNS[1] != "9.9.9.9" {
+		t.Errorf("Expected wan dns list, got %v", wan.DNS)
+	}
+
+	if len(network.Device) != 1 {
+		t.Fatalf("Expected one device, got %+v", network.Device)
+	}
+	dev := network.Device[0]
+	if *dev.Name != "@device[0]" || *dev.DeviceName != "br-lan" || len(dev.Ports) != 2 {
+		t.Errorf("Unexpected anonymous device: %+v", dev)
+	}
+
+	if _, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{Backend: "bogus"}); err == nil {
+		t.Error("Expected error for unsupported backend")
+	}
+}
+
+func TestDropbearKeyCaseRoundTrip(t *testing.T) {
+	dropbearConfig, err := parseDropbearConfig(`dropbear.main=dropbear
+dropbear.main.PasswordAuth='off'
+dropbear.main.RootPasswordAuth='on'
+dropbear.main.Port='2222'
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse dropbear config: %v", err)
+	}
+
+	// Through a config file and back
+	data, err := json.Marshal(dropbearConfig)
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	if !strings.Contains(string(data), `"PasswordAuth":"off"`) || !strings.Contains(string(data), `"RootPasswordAuth":"on"`) {
+		t.Errorf("Expected case-sensitive keys in %s", data)
+	}
+	var loaded config.DropbearConfig
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Failed to unmarshal config: %v", err)
+	}
+
+	commands, err := uci.GenerateSectionCommands("dropbear", "dropbear", &loaded.Dropbear[0])
+	if err != nil {
+		t.Fatalf("Failed to generate commands: %v", err)
+	}
+	for _, expected := range []string{
+		"uci set dropbear.main.PasswordAuth='off'",
+		"uci set dropbear.main.RootPasswordAuth='on'",
+	} {
+		if !slices.Contains(commands, expected) {
+			t.Errorf("Expected %q in %v", expected, commands)
+		}
+	}
+
+	// The ubus backend keeps the case too
+	value, err := decodeUbusConfig("dropbear", map[string]any{
+		"dropbear": []any{map[string]any{".name": "main", "PasswordAuth": "off"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to decode ubus config: %v", err)
+	}
+	if section := value.(*config.DropbearConfig).Dropbear[0]; section.PasswordAuth == nil || *section.PasswordAuth != "off" {
+		t.Errorf("Expected PasswordAuth off, got %+v", section)
+	}
+}
+
+func TestWriteConfigDir(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show system"] = `system.@system[0]=system
+system.@system[0].hostname='test-router'
+system.@system[0].ttylogin='0'
+`
+	mockClient.Responses["uci show network"] = `network.lan=interface
+network.lan.device='br-lan'
+network.lan.proto='static'
+network.lan.ipaddr='192.168.1.1'
+network.lan.ip6class='local' 'wan6'
+`
+
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "etc-config")
+	paths, err := WriteConfigDir(dir, oncConfig.Config)
+	if err != nil {
+		t.Fatalf("Failed to write config dir: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		files[filepath.Base(path)] = string(data)
+	}
+
+	expected := map[string]string{
+		"system": "config system\n\toption hostname 'test-router'\n\toption ttylogin '0'\n",
+		"network": "config interface 'lan'\n\toption device 'br-lan'\n\tlist ip6class 'local'\n\tlist ip6class 'wan6'\n" +
+			"\toption ipaddr '192.168.1.1'\n\toption proto 'static'\n",
+	}
+	for name, content := range expected {
+		if files[name] != content {
+			t.Errorf("Expected %s file:\n%s\ngot:\n%s", name, content, files[name])
+		}
+	}
+
+	// Every line is a section or an option
+	for name, content := range files {
+		for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+			if line != "" && !strings.HasPrefix(line, "config ") && !strings.HasPrefix(line, "\toption ") && !strings.HasPrefix(line, "\tlist ") {
+				t.Errorf("Invalid line in %s: %q", name, line)
+			}
+		}
+	}
+}
+
+func TestFilterMapDefaults(t *testing.T) {
+	sections, err := parseUbusConfig(`{
+	"values": {
+		"loopback": {".anonymous": false, ".type": "interface", ".index": 0, "device": "lo", "proto": "static"},
+		"lan": {".anonymous": false, ".type": "interface", ".index": 1, "proto": "static", "ipaddr": "10.0.0.1", "dns": ["1.1.1.1", "9.9.9.9"]},
+		"cfg030f15": {".anonymous": true, ".type": "device", ".index": 2, "name": "br-lan"}
+	}
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filterMapDefaults("network", sections, map[string]string{
+		"network.loopback":        "interface",
+		"network.loopback.device": "lo",
+		"network.loopback.proto":  "static",
+		"network.lan":             "interface",
+		"network.lan.proto":       "static",
+		"network.lan.ipaddr":      "192.168.1.1",
+		"network.lan.dns":         "1.1.1.1 9.9.9.9",
+	})
+
+	// Only the lan address differs, and the device isn't in the defaults
+	expected := map[string]any{
+		"interface": []any{map[string]any{".name": "lan", "ipaddr": "10.0.0.1"}},
+		"device":    []any{map[string]any{".name": "@device[0]", "name": "br-lan"}},
+	}
+	if !reflect.DeepEqual(sections, expected) {
+		t.Errorf("Expected %v, got %v", expected, sections)
+	}
+}
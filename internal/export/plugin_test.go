@@ -0,0 +1,88 @@
+package export
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/plugin"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+func TestCustomPluginHandler(t *testing.T) {
+	// A toy handler that records when it is used
+	var generated, parsed bool
+	plugin.Register("custom", plugin.Handler{
+		GenerateCommands: func(configKey string, configValue any) []string {
+			generated = true
+			return uci.GenerateConfigCommands(configKey, configValue)
+		},
+		ParseExport: func(output string) (any, error) {
+			parsed = true
+			section := map[string]any{".name": "main"}
+			for key, value := range uci.ParseShow(output) {
+				if option, ok := strings.CutPrefix(key, "custom.main."); ok {
+					section[option] = value
+				}
+			}
+			return map[string]any{"custom": []any{section}}, nil
+		},
+	})
+	t.Cleanup(func() { plugin.Unregister("custom") })
+
+	// Export
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show custom"] = `custom.main=custom
+custom.main.greeting='hello'
+custom.main.level='3'
+`
+	oncConfig, err := ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "password", Options{})
+	if err != nil {
+		t.Fatalf("Failed to export config: %v", err)
+	}
+
+	if !parsed {
+		t.Error("Expected the custom handler to parse the export")
+	}
+	if _, ok := oncConfig.Config.Extra["custom"]; !ok {
+		t.Fatalf("Expected custom config to be exported, got %v", oncConfig.Config.Extra)
+	}
+
+	// The custom config survives a JSON round trip
+	data, err := json.Marshal(oncConfig)
+	if err != nil {
+		t.Fatalf("Failed to marshal config: %v", err)
+	}
+	roundTripped, err := config.Parse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	// Generation
+	state, err := device.GetOpenWrtState(roundTripped, &roundTripped.Devices[0], &device.DeviceSchema{Name: "ubnt,edgerouter-x"})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	commands, err := device.GetDeviceScript(state, nil)
+	if err != nil {
+		t.Fatalf("Failed to get device script: %v", err)
+	}
+
+	if !generated {
+		t.Error("Expected the custom handler to generate commands")
+	}
+	for _, expected := range []string{
+		"uci set custom.main=custom",
+		"uci set custom.main.greeting='hello'",
+		"uci set custom.main.level='3'",
+		"uci commit custom",
+	} {
+		if !slices.Contains(commands, expected) {
+			t.Errorf("Expected command %q in script:\n%s", expected, strings.Join(commands, "\n"))
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// Export backends
+const (
+	// BackendUCI parses the output of uci show
+	BackendUCI = "uci"
+	// BackendUbus unmarshals the JSON of ubus call uci get, avoiding the
+	// quoting and list handling of uci show
+	BackendUbus = "ubus"
+)
+
+// ubusConfigResponse is the ubus uci get response for a whole config
+type ubusConfigResponse struct {
+	Values map[string]map[string]any `json:"values"`
+}
+
+// readUbusConfig reads a config with ubus and decodes it into its typed
+// config, leaving out options with their default value when defaults are
+// given
+func readUbusConfig(client ssh.SSHExecutor, configKey string, defaults map[string]string) (any, error) {
+	output, err := client.Execute(fmt.Sprintf(`ubus call uci get '{"config": "%s"}'`, configKey))
+	if err != nil {
+		return nil, err
+	}
+
+	sections, err := parseUbusConfig(output)
+	if err != nil {
+		return nil, err
+	}
+	if defaults != nil {
+		filterMapDefaults(configKey, sections, defaults)
+	}
+
+	return decodeUbusConfig(configKey, sections)
+}
+
+// parseUbusConfig parses a ubus uci get response into section type ->
+// sections, in config order. Anonymous sections are named as uci show names
+// them, e.g. @system[0].
+func parseUbusConfig(output string) (map[string]any, error) {
+	var response ubusConfigResponse
+	if err := json.Unmarshal([]byte(output), &response); err != nil {
+		return nil, fmt.Errorf("failed to parse ubus response: %w", err)
+	}
+
+	type indexed struct {
+		name    string
+		index   float64
+		section map[string]any
+	}
+	var ordered []indexed
+	for name, section := range response.Values {
+		index, _ := section[".index"].(float64)
+		ordered = append(ordered, indexed{name: name, index: index, section: section})
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].index != ordered[j].index {
+			return ordered[i].index < ordered[j].index
+		}
+		return ordered[i].name < ordered[j].name
+	})
+
+	sections := make(map[string]any)
+	counts := make(map[string]int)
+	for _, item := range ordered {
+		sectionType, _ := item.section[".type"].(string)
+		if sectionType == "" {
+			continue
+		}
+
+		name := item.name
+		if anonymous, _ := item.section[".anonymous"].(bool); anonymous {
+			name = fmt.Sprintf("@%s[%d]", sectionType, counts[sectionType])
+		}
+		counts[sectionType]++
+
+		section := map[string]any{".name": name}
+		for key, value := range item.section {
+			if !strings.HasPrefix(key, ".") {
+				section[key] = value
+			}
+		}
+
+		list, _ := sections[sectionType].([]any)
+		sections[sectionType] = append(list, section)
+	}
+
+	return sections, nil
+}
+
+// decodeUbusConfig converts the sections of a built-in config into its
+// typed config, converting the string option values to the field types.
+// Other configs are returned as they are.
+func decodeUbusConfig(configKey string, sections map[string]any) (any, error) {
+	configType, ok := builtinConfigType(configKey)
+	if !ok {
+		return sections, nil
+	}
+
+	for sectionType, value := range sections {
+		sectionStruct, ok := sectionStructType(configType, sectionType)
+		if !ok {
+			continue
+		}
+		for _, section := range value.([]any) {
+			coerceOptions(section.(map[string]any), sectionStruct)
+		}
+	}
+
+	data, err := json.Marshal(sections)
+	if err != nil {
+		return nil, err
+	}
+	typed := reflect.New(configType)
+	if err := json.Unmarshal(data, typed.Interface()); err != nil {
+		return nil, fmt.Errorf("failed to decode %s config: %w", configKey, err)
+	}
+
+	return typed.Interface(), nil
+}
+
+// builtinConfigType returns the struct type of a built-in config, e.g.
+// config.NetworkConfig for network
+func builtinConfigType(configKey string) (reflect.Type, bool) {
+	typ := reflect.TypeOf(config.ConfigConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if jsonName(field) == configKey && field.Type.Kind() == reflect.Ptr {
+			return field.Type.Elem(), true
+		}
+	}
+	return nil, false
+}
+
+// sectionStructType returns the struct type of a config's sections of the
+// given type, e.g. config.InterfaceSection for interface
+func sectionStructType(configType reflect.Type, sectionType string) (reflect.Type, bool) {
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		if jsonName(field) == sectionType && field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			return field.Type.Elem(), true
+		}
+	}
+	return nil, false
+}
+
+// coerceOptions converts ubus option values, which are all strings or
+// lists of strings, to the types of the section struct's fields. Values
+// that don't convert are dropped.
+func coerceOptions(section map[string]any, sectionStruct reflect.Type) {
+	for i := 0; i < sectionStruct.NumField(); i++ {
+		field := sectionStruct.Field(i)
+		name := jsonName(field)
+		value, ok := section[name]
+		if !ok || strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		kind := field.Type.Kind()
+		if kind == reflect.Ptr {
+			kind = field.Type.Elem().Kind()
+		}
+		s, isString := value.(string)
+
+		switch {
+		case kind == reflect.Int && isString:
+			if n, err := strconv.Atoi(s); err == nil {
+				section[name] = n
+			} else {
+				delete(section, name)
+			}
+		case kind == reflect.Bool && isString:
+			if b := parseBool(s); b != nil {
+				section[name] = *b
+			} else {
+				delete(section, name)
+			}
+		case kind == reflect.Slice && isString:
+			section[name] = []string{s}
+		case kind == reflect.String && !isString:
+			if list, ok := value.([]any); ok {
+				items := make([]string, 0, len(list))
+				for _, item := range list {
+					items = append(items, fmt.Sprintf("%v", item))
+				}
+				section[name] = strings.Join(items, " ")
+			}
+		}
+	}
+}
+
+// filterMapDefaults removes the options that have their default value, and
+// sections left with no options whose type is also the default, as
+// uci.FilterDefaults does for uci show output
+func filterMapDefaults(configKey string, sections map[string]any, defaults map[string]string) {
+	changed := uci.FilterFlatDefaults(uci.Flatten(map[string]any{configKey: sections}), defaults)
+	for sectionType, value := range sections {
+		var kept []any
+		for _, item := range value.([]any) {
+			section := item.(map[string]any)
+			identifier := fmt.Sprintf("%s.%s", configKey, section[".name"])
+			if _, ok := changed[identifier]; !ok {
+				continue
+			}
+
+			for key := range section {
+				if _, ok := changed[identifier+"."+key]; !ok && !strings.HasPrefix(key, ".") {
+					delete(section, key)
+				}
+			}
+			kept = append(kept, section)
+		}
+
+		if len(kept) == 0 {
+			delete(sections, sectionType)
+		} else {
+			sections[sectionType] = kept
+		}
+	}
+}
+
+func jsonName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
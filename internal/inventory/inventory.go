@@ -0,0 +1,196 @@
+package inventory
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/validate"
+)
+
+// Device statuses
+const (
+	// StatusCollected means the device's facts were read
+	StatusCollected = "collected"
+	// StatusFailed means the device couldn't be read, e.g. it was unreachable
+	StatusFailed = "failed"
+	// StatusSkipped means the device has no IP address or provisioning config
+	StatusSkipped = "skipped"
+)
+
+// connect is replaced in tests
+var connect = func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+	return ssh.ConnectDevice(deviceConfig)
+}
+
+// Options controls how facts are collected
+type Options struct {
+	// Parallel is the number of devices read at once. Zero or one reads
+	// devices one at a time.
+	Parallel int
+}
+
+// Facts are what is known about a single device. Facts that couldn't be
+// read are left empty.
+type Facts struct {
+	Device string `json:"device"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	Model   string `json:"model,omitempty"`
+	Version string `json:"version,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	MAC     string `json:"mac,omitempty"`
+
+	// UptimeSeconds is how long the device has been up
+	UptimeSeconds int64 `json:"uptime_seconds,omitempty"`
+	// FreeSpaceKB is the space left on the writable overlay
+	FreeSpaceKB int64 `json:"free_space_kb,omitempty"`
+
+	RadioBands []string `json:"radio_bands,omitempty"`
+	Packages   int      `json:"packages,omitempty"`
+}
+
+// Report is the facts of every enabled device, in config order
+type Report struct {
+	Devices []Facts `json:"devices"`
+}
+
+// Collect reads the facts of every enabled device without changing them. A
+// device that can't be read is reported as failed rather than stopping the
+// run.
+func Collect(oncConfig *config.ONCConfig, opts Options) *Report {
+	var devices []config.DeviceConfig
+	for _, dev := range oncConfig.Devices {
+		if dev.Enabled == nil || *dev.Enabled {
+			devices = append(devices, dev)
+		}
+	}
+
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]Facts, len(devices))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := range devices {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = collectDevice(&devices[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return &Report{Devices: results}
+}
+
+func collectDevice(dev *config.DeviceConfig) Facts {
+	facts := Facts{Device: validate.DeviceName(dev)}
+	if dev.IPAddr == "" || dev.ProvisioningConfig == nil {
+		facts.Status = StatusSkipped
+		return facts
+	}
+
+	client, err := connect(dev)
+	if err != nil {
+		facts.Status, facts.Error = StatusFailed, fmt.Sprintf("failed to connect: %v", err)
+		return facts
+	}
+	defer client.Close()
+
+	schema, err := device.GetDeviceSchemaFromClient(client, dev)
+	if err != nil {
+		facts.Status, facts.Error = StatusFailed, fmt.Sprintf("failed to get device schema: %v", err)
+		return facts
+	}
+
+	// The schema is named after the configured model, so read the device's
+	// own from board.json in case they differ
+	boardJSON, err := device.ReadBoardJSON(client)
+	if err != nil {
+		facts.Status, facts.Error = StatusFailed, err.Error()
+		return facts
+	}
+
+	facts.Status = StatusCollected
+	facts.Model = boardJSON.Model.ID
+	facts.Version = schema.Version
+	facts.Target = schema.Target
+	facts.Arch = schema.Arch
+	for _, radio := range schema.Radios {
+		if radio.Band != "" && !slices.Contains(facts.RadioBands, radio.Band) {
+			facts.RadioBands = append(facts.RadioBands, radio.Band)
+		}
+	}
+	sort.Strings(facts.RadioBands)
+
+	facts.MAC = readMAC(client)
+	facts.UptimeSeconds = readUptime(client)
+	facts.FreeSpaceKB = readFreeSpace(client)
+	facts.Packages = countPackages(client)
+
+	return facts
+}
+
+// readMAC reads the MAC address of the lan bridge, or of eth0 on devices
+// without one
+func readMAC(client ssh.SSHExecutor) string {
+	output, _ := client.Execute("cat /sys/class/net/br-lan/address 2>/dev/null || cat /sys/class/net/eth0/address")
+	return strings.TrimSpace(output)
+}
+
+func readUptime(client ssh.SSHExecutor) int64 {
+	output, err := client.Execute("cat /proc/uptime")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0
+	}
+	uptime, _ := strconv.ParseFloat(fields[0], 64)
+	return int64(uptime)
+}
+
+// readFreeSpace reads the available kilobytes of the overlay, or of / on
+// devices without one
+func readFreeSpace(client ssh.SSHExecutor) int64 {
+	output, err := client.Execute("df -k /overlay 2>/dev/null || df -k /")
+	if err != nil {
+		return 0
+	}
+	// Filesystem 1K-blocks Used Available Use% Mounted on
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 4 {
+		return 0
+	}
+	available, _ := strconv.ParseInt(fields[3], 10, 64)
+	return available
+}
+
+func countPackages(client ssh.SSHExecutor) int {
+	output, err := client.Execute("opkg list-installed")
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count
+}
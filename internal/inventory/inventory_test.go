@@ -0,0 +1,72 @@
+package inventory
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+func TestCollect(t *testing.T) {
+	disabled := false
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "ubnt,edgerouter-x", Hostname: "router", IPAddr: "192.168.1.1", ProvisioningConfig: &config.ProvisioningConfig{}},
+			{ModelID: "ubnt,edgerouter-x", Hostname: "ap", IPAddr: "192.168.1.2", ProvisioningConfig: &config.ProvisioningConfig{}},
+			{ModelID: "ubnt,edgerouter-x", Hostname: "spare"},
+			{ModelID: "ubnt,edgerouter-x", Hostname: "old", Enabled: &disabled},
+		},
+	}
+
+	original := connect
+	defer func() { connect = original }()
+
+	// The ap is unreachable
+	connect = func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		if deviceConfig.Hostname == "ap" {
+			return nil, fmt.Errorf("connection refused")
+		}
+		client := ssh.NewMockClient(deviceConfig.ModelID)
+		client.Version = "23.05.2"
+		client.Responses[`ubus call uci get '{"config": "wireless", "type": "wifi-device"}'`] = `{"values": {
+			"radio0": {".name": "radio0", "type": "mac80211", "band": "2g"},
+			"radio1": {".name": "radio1", "type": "mac80211", "band": "5g"}
+		}}`
+		client.Responses["cat /sys/class/net/br-lan/address 2>/dev/null || cat /sys/class/net/eth0/address"] = "74:83:c2:00:11:22\n"
+		client.Responses["cat /proc/uptime"] = "3600.52 7000.10\n"
+		client.Responses["df -k /overlay 2>/dev/null || df -k /"] = "Filesystem           1K-blocks      Used Available Use% Mounted on\n" +
+			"/dev/ubi0_1             234964      1372    228724   1% /overlay\n"
+		return client, nil
+	}
+
+	rep := Collect(oncConfig, Options{Parallel: 2})
+	if len(rep.Devices) != 3 {
+		t.Fatalf("Expected 3 enabled devices, got %+v", rep.Devices)
+	}
+
+	router := rep.Devices[0]
+	if router.Device != "router" || router.Status != StatusCollected {
+		t.Fatalf("Expected the router's facts, got %+v", router)
+	}
+	if router.Model != "ubnt,edgerouter-x" || router.Version != "23.05.2" || router.Arch != "mipsel_24kc" {
+		t.Errorf("Unexpected model, version or arch: %+v", router)
+	}
+	if router.MAC != "74:83:c2:00:11:22" || router.UptimeSeconds != 3600 || router.FreeSpaceKB != 228724 {
+		t.Errorf("Unexpected MAC, uptime or free space: %+v", router)
+	}
+	if !slices.Equal(router.RadioBands, []string{"2g", "5g"}) {
+		t.Errorf("Unexpected radio bands: %v", router.RadioBands)
+	}
+	if router.Packages != len(ssh.NewMockClient("").InstalledPkgs) {
+		t.Errorf("Expected %d packages, got %d", len(ssh.NewMockClient("").InstalledPkgs), router.Packages)
+	}
+
+	if rep.Devices[1].Status != StatusFailed || rep.Devices[1].Error != "failed to connect: connection refused" {
+		t.Errorf("Expected the ap to fail, got %+v", rep.Devices[1])
+	}
+	if rep.Devices[2].Status != StatusSkipped {
+		t.Errorf("Expected the spare to be skipped, got %+v", rep.Devices[2])
+	}
+}
@@ -0,0 +1,207 @@
+package migrate
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/version"
+)
+
+// dsaVersion is the first OpenWrt release with DSA bridge-vlan support
+const dsaVersion = "21.02"
+
+// DefaultBridge is the bridge device the VLANs are configured on when none is given
+const DefaultBridge = "br-lan"
+
+// vlanDeviceRe matches swconfig VLAN devices such as eth0.2
+var vlanDeviceRe = regexp.MustCompile(`^[^.]+\.(\d+)$`)
+
+// DSAOptions describes the target of a swconfig to DSA migration
+type DSAOptions struct {
+	// PortMap maps switch port numbers to the target's DSA port names.
+	// Switch ports without an entry are treated as CPU ports and dropped.
+	PortMap map[int]string
+
+	// Bridge is the bridge device the VLANs are configured on, DefaultBridge
+	// when empty
+	Bridge string
+
+	// Version is the target OpenWrt version, which must support DSA. Empty
+	// skips the check.
+	Version string
+
+	// Ports, when set, are the target model's DSA ports, which every mapped
+	// port must be one of
+	Ports []string
+}
+
+// SwConfigToDSA converts a swconfig network config, with its switch and
+// switch_vlan sections, into the equivalent DSA config: one bridge over the
+// mapped ports with a bridge-vlan section per VLAN. Interfaces on a VLAN
+// device (eth0.2), or on a bridge over one, move to the matching bridge VLAN
+// device (br-lan.2). Other sections are kept as they are. The returned
+// warnings list the switch ports that were dropped.
+func SwConfigToDSA(network *config.NetworkConfig, opts DSAOptions) (*config.NetworkConfig, []string, error) {
+	if opts.Version != "" && !version.AtLeast(opts.Version, dsaVersion) {
+		return nil, nil, fmt.Errorf("DSA requires OpenWrt %s or newer, target is %s", dsaVersion, opts.Version)
+	}
+	if len(opts.Ports) > 0 {
+		for num, name := range opts.PortMap {
+			if !slices.Contains(opts.Ports, name) {
+				return nil, nil, fmt.Errorf("switch port %d maps to %s, which the target doesn't have (ports: %s)",
+					num, name, strings.Join(opts.Ports, ", "))
+			}
+		}
+	}
+
+	bridge := opts.Bridge
+	if bridge == "" {
+		bridge = DefaultBridge
+	}
+	bridgeSection := strings.ReplaceAll(bridge, "-", "_")
+
+	migrated := *network
+	migrated.Switch = nil
+	migrated.SwitchVlan = nil
+	migrated.Device = nil
+	migrated.Interface = nil
+	migrated.BridgeVlan = append([]config.BridgeVlanSection{}, network.BridgeVlan...)
+
+	// Convert each switch VLAN to a bridge VLAN over the mapped ports
+	var warnings []string
+	vlans := make(map[int]bool)
+	bridgePorts := make(map[int]string)
+	dropped := make(map[string]bool)
+	for i, switchVlan := range network.SwitchVlan {
+		if switchVlan.Vlan == nil {
+			return nil, nil, fmt.Errorf("switch_vlan[%d] has no vlan", i)
+		}
+		vid := *switchVlan.Vlan
+		if vlans[vid] {
+			return nil, nil, fmt.Errorf("vlan %d is configured more than once", vid)
+		}
+		vlans[vid] = true
+
+		switchName := "switch"
+		if switchVlan.Device != nil {
+			switchName = *switchVlan.Device
+		}
+
+		var ports []string
+		if switchVlan.Ports != nil {
+			for _, port := range strings.Fields(*switchVlan.Ports) {
+				tagged := strings.HasSuffix(strings.TrimSuffix(port, "*"), "t")
+				num, err := strconv.Atoi(strings.TrimRight(port, "t*"))
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid port %q in vlan %d", port, vid)
+				}
+
+				name, ok := opts.PortMap[num]
+				if !ok {
+					key := fmt.Sprintf("%s port %d", switchName, num)
+					if !dropped[key] {
+						dropped[key] = true
+						warnings = append(warnings, fmt.Sprintf("%s has no DSA port, treating it as a CPU port", key))
+					}
+					continue
+				}
+
+				bridgePorts[num] = name
+				if tagged {
+					ports = append(ports, name+":t")
+				} else {
+					ports = append(ports, name+":u*")
+				}
+			}
+		}
+
+		migrated.BridgeVlan = append(migrated.BridgeVlan, config.BridgeVlanSection{
+			Name:   config.Ptr(fmt.Sprintf("%s_vlan%d", bridgeSection, vid)),
+			Device: config.Ptr(bridge),
+			Vlan:   config.Ptr(vid),
+			Ports:  ports,
+		})
+	}
+
+	// vlanDevice returns the bridge VLAN device replacing a swconfig VLAN
+	// device, or "" when name isn't one
+	vlanDevice := func(name string) string {
+		match := vlanDeviceRe.FindStringSubmatch(name)
+		if match == nil {
+			return ""
+		}
+		vid, _ := strconv.Atoi(match[1])
+		if !vlans[vid] {
+			return ""
+		}
+		return fmt.Sprintf("%s.%d", bridge, vid)
+	}
+
+	// Bridges over a single VLAN device become that bridge VLAN device
+	redirects := make(map[string]string)
+	for _, dev := range network.Device {
+		var vlanPorts []string
+		for _, port := range dev.Ports {
+			if target := vlanDevice(port); target != "" {
+				vlanPorts = append(vlanPorts, target)
+			}
+		}
+
+		name := ""
+		if dev.DeviceName != nil {
+			name = *dev.DeviceName
+		}
+		switch {
+		case len(vlanPorts) == 0 && name == bridge:
+			return nil, nil, fmt.Errorf("device %s already exists, choose another bridge", bridge)
+		case len(vlanPorts) == 0:
+			migrated.Device = append(migrated.Device, dev)
+		case len(vlanPorts) == 1 && len(dev.Ports) == 1:
+			redirects[name] = vlanPorts[0]
+		default:
+			return nil, nil, fmt.Errorf("device %s bridges switch VLANs with other ports, which has no bridge-vlan equivalent", name)
+		}
+	}
+
+	var nums []int
+	for num := range bridgePorts {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	var ports []string
+	for _, num := range nums {
+		ports = append(ports, bridgePorts[num])
+	}
+	migrated.Device = append([]config.DeviceSection{{
+		Name:       config.Ptr(bridgeSection),
+		DeviceName: config.Ptr(bridge),
+		Type:       config.Ptr("bridge"),
+		Ports:      ports,
+	}}, migrated.Device...)
+
+	// Point interfaces at the bridge VLAN devices, using device rather
+	// than ifname as DSA releases expect
+	for _, iface := range network.Interface {
+		if iface.Ifname != nil {
+			if iface.Device == nil {
+				iface.Device = iface.Ifname
+			}
+			iface.Ifname = nil
+		}
+		if iface.Device != nil {
+			if target, ok := redirects[*iface.Device]; ok {
+				iface.Device = config.Ptr(target)
+			} else if target := vlanDevice(*iface.Device); target != "" {
+				iface.Device = config.Ptr(target)
+			}
+		}
+		migrated.Interface = append(migrated.Interface, iface)
+	}
+
+	return &migrated, warnings, nil
+}
@@ -0,0 +1,83 @@
+package migrate
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestSwConfigToDSA(t *testing.T) {
+	var network config.NetworkConfig
+	err := json.Unmarshal([]byte(`{
+		"switch": [
+			{ ".name": "switch0", "name": "switch0", "reset": true, "enable_vlan": true }
+		],
+		"switch_vlan": [
+			{ ".name": "vlan_lan", "device": "switch0", "vlan": 1, "ports": "0t 1 2 3 4" },
+			{ ".name": "vlan_wan", "device": "switch0", "vlan": 2, "ports": "0t 5" }
+		],
+		"device": [
+			{ ".name": "br_lan", "name": "br-lan", "type": "bridge", "ports": ["eth0.1"] }
+		],
+		"interface": [
+			{ ".name": "lan", "device": "br-lan", "proto": "static", "ipaddr": "192.168.1.1", "netmask": "255.255.255.0" },
+			{ ".name": "wan", "ifname": "eth0.2", "proto": "dhcp" }
+		]
+	}`), &network)
+	if err != nil {
+		t.Fatalf("Failed to parse network config: %v", err)
+	}
+
+	migrated, warnings, err := SwConfigToDSA(&network, DSAOptions{
+		PortMap: map[int]string{1: "lan1", 2: "lan2", 3: "lan3", 4: "lan4", 5: "wan"},
+		Version: "23.05.0",
+		Ports:   []string{"lan1", "lan2", "lan3", "lan4", "wan"},
+	})
+	if err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+
+	data, err := json.Marshal(migrated)
+	if err != nil {
+		t.Fatalf("Failed to marshal migrated config: %v", err)
+	}
+
+	var actual, expected any
+	if err := json.Unmarshal(data, &actual); err != nil {
+		t.Fatalf("Failed to parse migrated config: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{
+		"device": [
+			{ ".name": "br_lan", "name": "br-lan", "type": "bridge", "ports": ["lan1", "lan2", "lan3", "lan4", "wan"] }
+		],
+		"bridge-vlan": [
+			{ ".name": "br_lan_vlan1", "device": "br-lan", "vlan": 1, "ports": ["lan1:u*", "lan2:u*", "lan3:u*", "lan4:u*"] },
+			{ ".name": "br_lan_vlan2", "device": "br-lan", "vlan": 2, "ports": ["wan:u*"] }
+		],
+		"interface": [
+			{ ".name": "lan", "device": "br-lan.1", "proto": "static", "ipaddr": "192.168.1.1", "netmask": "255.255.255.0" },
+			{ ".name": "wan", "device": "br-lan.2", "proto": "dhcp" }
+		]
+	}`), &expected); err != nil {
+		t.Fatalf("Failed to parse expected config: %v", err)
+	}
+
+	actualJSON, _ := json.Marshal(actual)
+	expectedJSON, _ := json.Marshal(expected)
+	if string(actualJSON) != string(expectedJSON) {
+		t.Errorf("Unexpected migrated config:\n got: %s\nwant: %s", actualJSON, expectedJSON)
+	}
+
+	if len(warnings) != 1 || warnings[0] != "switch0 port 0 has no DSA port, treating it as a CPU port" {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	// Targets without DSA and unknown ports are rejected
+	if _, _, err := SwConfigToDSA(&network, DSAOptions{Version: "19.07.10"}); err == nil {
+		t.Error("Expected an error for a release without DSA")
+	}
+	if _, _, err := SwConfigToDSA(&network, DSAOptions{PortMap: map[int]string{1: "eth9"}, Ports: []string{"lan1"}}); err == nil {
+		t.Error("Expected an error for a port the target doesn't have")
+	}
+}
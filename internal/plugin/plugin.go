@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"sort"
+	"sync"
+)
+
+// GenerateFunc generates the UCI commands for a resolved config
+type GenerateFunc func(configKey string, configValue any) []string
+
+// ParseExportFunc parses `uci show <config>` output into the value stored
+// for the config in an exported ConfigConfig
+type ParseExportFunc func(output string) (any, error)
+
+// Handler provides custom generation and export logic for a config
+type Handler struct {
+	GenerateCommands GenerateFunc
+	ParseExport      ParseExportFunc
+}
+
+var (
+	mu       sync.RWMutex
+	handlers = make(map[string]Handler)
+)
+
+// Register registers a handler for a config name. Only the functions set on
+// the handler are replaced, so generation and export can be registered
+// separately.
+func Register(configKey string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	existing := handlers[configKey]
+	if handler.GenerateCommands != nil {
+		existing.GenerateCommands = handler.GenerateCommands
+	}
+	if handler.ParseExport != nil {
+		existing.ParseExport = handler.ParseExport
+	}
+	handlers[configKey] = existing
+}
+
+// Unregister removes the handler for a config name
+func Unregister(configKey string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(handlers, configKey)
+}
+
+// Lookup returns the handler registered for a config name
+func Lookup(configKey string) (Handler, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	handler, ok := handlers[configKey]
+	return handler, ok
+}
+
+// ExportConfigs returns the names of the configs with an export parser, in
+// sorted order
+func ExportConfigs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var configs []string
+	for configKey, handler := range handlers {
+		if handler.ParseExport != nil {
+			configs = append(configs, configKey)
+		}
+	}
+	sort.Strings(configs)
+
+	return configs
+}
@@ -0,0 +1,110 @@
+package provision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/redact"
+)
+
+// AuditRecord is the local record of what was applied to a device
+type AuditRecord struct {
+	Time     string `json:"time"`
+	Hostname string `json:"hostname"`
+	IPAddr   string `json:"ipaddr"`
+	ModelID  string `json:"model_id"`
+
+	// Commands are the commands that were run, including any secret values
+	// they set unless redaction is on
+	Commands []string `json:"commands"`
+
+	PackagesInstalled []string `json:"packages_installed,omitempty"`
+	PackagesRemoved   []string `json:"packages_removed,omitempty"`
+
+	// ConfigHash is the SHA-256 of the device's resolved config, to tell
+	// whether two runs applied the same config
+	ConfigHash string `json:"config_hash"`
+}
+
+// auditNow is replaced in tests
+var auditNow = time.Now
+
+// newAuditRecord records the commands applied to a device, with the secret
+// values they set masked by redactor
+func newAuditRecord(deviceConfig *config.DeviceConfig, state *device.OpenWrtState, commands []string, redactor *redact.Redactor) (*AuditRecord, error) {
+	configJSON, err := json.Marshal(state.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash config: %w", err)
+	}
+	hash := sha256.Sum256(configJSON)
+
+	record := &AuditRecord{
+		Time:       auditNow().UTC().Format(time.RFC3339),
+		Hostname:   deviceConfig.Hostname,
+		IPAddr:     deviceConfig.IPAddr,
+		ModelID:    deviceConfig.ModelID,
+		Commands:   make([]string, 0, len(commands)),
+		ConfigHash: "sha256:" + hex.EncodeToString(hash[:]),
+	}
+	for _, cmd := range commands {
+		record.Commands = append(record.Commands, redactor.Command(cmd))
+
+		fields := strings.Fields(cmd)
+		if len(fields) < 3 || fields[0] != "opkg" {
+			continue
+		}
+		var packages []string
+		for _, field := range fields[2:] {
+			if !strings.HasPrefix(field, "-") {
+				packages = append(packages, field)
+			}
+		}
+		switch fields[1] {
+		case "install":
+			record.PackagesInstalled = append(record.PackagesInstalled, packages...)
+		case "remove":
+			record.PackagesRemoved = append(record.PackagesRemoved, packages...)
+		}
+	}
+
+	return record, nil
+}
+
+// writeAuditRecord writes the record of a device's commands as
+// <hostname>-<time>.json in dir, returning its path. Records can contain
+// secrets, so only the owner can read them.
+func writeAuditRecord(dir string, deviceConfig *config.DeviceConfig, state *device.OpenWrtState, commands []string, redactor *redact.Redactor) (string, error) {
+	record, err := newAuditRecord(deviceConfig, state, commands, redactor)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create audit directory: %w", err)
+	}
+
+	name := record.Hostname
+	if name == "" {
+		name = record.IPAddr
+	}
+	timestamp := strings.ReplaceAll(record.Time, ":", "")
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.json", name, timestamp))
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write audit record: %w", err)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,73 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+// Apply modes
+const (
+	// ApplyModeAuto resets factory default devices and merges into
+	// configured ones
+	ApplyModeAuto = "auto"
+	// ApplyModeReset clears the schema's config sections before setting the
+	// config, so nothing configured by hand survives
+	ApplyModeReset = "reset"
+	// ApplyModeMerge only sets the config, keeping the device's other
+	// sections
+	ApplyModeMerge = "merge"
+)
+
+// factoryLANAddress is the lan address of a freshly flashed device
+const factoryLANAddress = "192.168.1.1"
+
+// detectFactoryDefaults reports whether the device looks freshly flashed:
+// the factory hostname and lan address and no packages installed since. It
+// returns why the device isn't at its defaults otherwise. Values that can't
+// be read don't count against the device.
+func detectFactoryDefaults(client ssh.SSHExecutor) (bool, string) {
+	if output, err := client.Execute("uci -q get system.@system[0].hostname"); err == nil {
+		if hostname := strings.TrimSpace(output); hostname != "" && hostname != factoryHostname {
+			return false, fmt.Sprintf("hostname is %s", hostname)
+		}
+	}
+
+	if output, err := client.Execute("uci -q get network.lan.ipaddr"); err == nil {
+		if ipAddr := strings.TrimSpace(output); ipAddr != "" && ipAddr != factoryLANAddress {
+			return false, fmt.Sprintf("lan address is %s", ipAddr)
+		}
+	}
+
+	// Packages installed after flashing have their control files in the
+	// overlay rather than the read-only root
+	if output, err := client.Execute("ls /overlay/upper/usr/lib/opkg/info/ 2>/dev/null | grep '\\.control$'"); err == nil {
+		var packages []string
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			if name := strings.TrimSuffix(line, ".control"); name != "" {
+				packages = append(packages, name)
+			}
+		}
+		if len(packages) > 0 {
+			return false, fmt.Sprintf("packages were installed: %s", strings.Join(packages, ", "))
+		}
+	}
+
+	return true, ""
+}
+
+// applyMode resolves the mode a device is provisioned in, detecting factory
+// defaults when the mode is auto
+func applyMode(client ssh.SSHExecutor, mode string) string {
+	if mode != "" && mode != ApplyModeAuto {
+		return mode
+	}
+
+	if factory, reason := detectFactoryDefaults(client); !factory {
+		fmt.Printf("Device is already configured (%s), merging into its config.\n", reason)
+		return ApplyModeMerge
+	}
+	fmt.Println("Device is at factory defaults, resetting its config.")
+	return ApplyModeReset
+}
@@ -0,0 +1,49 @@
+package provision
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/redact"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+// logreadCommand follows the system log, starting from its last line
+const logreadCommand = "logread -f -l 1"
+
+// logOutput and logSettleTime are replaced in tests. Services log for a
+// little while after reloading, so the log is followed for logSettleTime
+// after the last command.
+var (
+	logOutput     io.Writer = os.Stdout
+	logSettleTime           = 2 * time.Second
+)
+
+// followLog echoes the device's log lines as they are written, returning a
+// function that stops following it. Stopping more than once is harmless.
+func followLog(client ssh.SSHExecutor, redactor *redact.Redactor) func() {
+	streamer, ok := client.(ssh.Streamer)
+	if !ok {
+		fmt.Println("Warning: unable to follow the device log: the connection can't stream output")
+		return func() {}
+	}
+
+	stop, err := streamer.Stream(logreadCommand, func(line string) {
+		fmt.Fprintf(logOutput, "log: %s\n", redactor.Text(line))
+	})
+	if err != nil {
+		fmt.Printf("Warning: unable to follow the device log: %v\n", err)
+		return func() {}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			time.Sleep(logSettleTime)
+			stop()
+		})
+	}
+}
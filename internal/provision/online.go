@@ -0,0 +1,44 @@
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+// onlinePollInterval is how often waitOnline checks, replaced in tests
+var onlinePollInterval = 2 * time.Second
+
+// waitOnline polls the device until it is online or the timeout elapses
+func waitOnline(client ssh.SSHExecutor, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if isOnline(client) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("device not online after %s", timeout)
+		}
+		time.Sleep(onlinePollInterval)
+	}
+}
+
+// isOnline reports whether the wan interface is up. Devices without one,
+// such as access points, are online when they can reach the package feeds.
+func isOnline(client ssh.SSHExecutor) bool {
+	output, err := client.ExecuteWithError("ubus call network.interface.wan status")
+	if err != nil {
+		_, err := client.ExecuteWithError("ping -c 1 -W 2 downloads.openwrt.org")
+		return err == nil
+	}
+
+	var status struct {
+		Up bool `json:"up"`
+	}
+	if err := json.Unmarshal([]byte(output), &status); err != nil {
+		return false
+	}
+	return status.Up
+}
@@ -0,0 +1,89 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// validateOnDevice stages the UCI changes of a script without committing
+// them, returning the ones uci refuses, then reverts everything it
+// staged. Only staging is checked: uci accepts almost any value there, so
+// this catches malformed commands and missing sections, not values a
+// service would refuse once the config is committed and reloaded. Configs that don't exist on the device yet, e.g. ones a package
+// install creates, are skipped. Reverting would also throw away changes
+// already staged on the device, e.g. pending LuCI edits, so a config with
+// any is an error and nothing is staged.
+func validateOnDevice(client ssh.SSHExecutor, commands []string) ([]string, error) {
+	var rejected []string
+	var staged []string
+	exists := make(map[string]bool)
+
+	for _, cmd := range commands {
+		configKey := uci.CommandConfig(cmd)
+		if configKey == "" {
+			continue
+		}
+
+		if _, checked := exists[configKey]; !checked {
+			_, err := client.Execute(fmt.Sprintf("uci -q show %s", configKey))
+			exists[configKey] = err == nil
+			if err != nil {
+				fmt.Printf("Skipping %s: the config doesn't exist on the device yet.\n", configKey)
+				continue
+			}
+
+			pending, err := client.Execute(fmt.Sprintf("uci changes %s", configKey))
+			if err != nil {
+				revertStaged(client, staged)
+				return nil, fmt.Errorf("failed to read staged %s changes: %w", configKey, err)
+			}
+			if strings.TrimSpace(pending) != "" {
+				revertStaged(client, staged)
+				return nil, fmt.Errorf("%s has uncommitted changes on the device, e.g. from LuCI; commit or revert them before validating on the device", configKey)
+			}
+			staged = append(staged, configKey)
+		}
+		if !exists[configKey] {
+			continue
+		}
+
+		if output, err := client.ExecuteWithError(cmd); err != nil {
+			message := strings.TrimSpace(output)
+			if message == "" {
+				message = err.Error()
+			}
+			rejected = append(rejected, fmt.Sprintf("%s: %s", cmd, message))
+		}
+	}
+
+	revertStaged(client, staged)
+
+	return rejected, nil
+}
+
+// revertStaged reverts the changes staged to the given configs
+func revertStaged(client ssh.SSHExecutor, configs []string) {
+	for _, revertCmd := range getRevertCommands(configs) {
+		_, _ = client.Execute(revertCmd)
+	}
+}
+
+// checkServices checks that each service has an init.d script on the device
+func checkServices(client ssh.SSHExecutor, services []string) error {
+	var missing []string
+	for _, service := range services {
+		if service == "" || strings.ContainsAny(service, "/ '") {
+			return fmt.Errorf("invalid service name %q", service)
+		}
+		if _, err := client.Execute(fmt.Sprintf("test -x /etc/init.d/%s", service)); err != nil {
+			missing = append(missing, service)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("services not found in /etc/init.d: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
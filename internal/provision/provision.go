@@ -1,16 +1,153 @@
 package provision
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
 	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/drummonds/openwrt-configurator.git/internal/condition"
 	"github.com/drummonds/openwrt-configurator.git/internal/config"
 	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/redact"
+	"github.com/drummonds/openwrt-configurator.git/internal/secret"
 	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// Hostname check modes
+const (
+	// HostnameCheckWarn prints a warning when the device's hostname doesn't match
+	HostnameCheckWarn = "warn"
+	// HostnameCheckRefuse aborts provisioning when the device's hostname doesn't match
+	HostnameCheckRefuse = "refuse"
+)
+
+// Device error modes
+const (
+	// DeviceErrorStop stops starting devices after the first one fails
+	DeviceErrorStop = "stop"
+	// DeviceErrorContinue provisions every device it can and reports all
+	// the failures at the end
+	DeviceErrorContinue = "continue"
+)
+
+// factoryHostname is the hostname of a freshly flashed device, which is
+// never treated as a mismatch
+const factoryHostname = "OpenWrt"
+
+// Options controls how devices are provisioned
+type Options struct {
+	// HostnameCheck compares the device's current hostname with the
+	// configured one before applying, to catch a config being sent to the
+	// wrong host of the same model. Empty disables the check.
+	HostnameCheck string
+
+	// ContinueOnError logs failing commands and carries on instead of
+	// reverting, then reports every failure at the end
+	ContinueOnError bool
+
+	// AssumeInstalled, when not nil, is used as the list of installed
+	// packages instead of reading it from the device, e.g. on devices
+	// where opkg can't run
+	AssumeInstalled []string
+
+	// SkipPackages leaves packages alone and only applies the config
+	SkipPackages bool
+
+	// Parallel is the number of devices provisioned at once. Devices still
+	// wait for the devices they depend on. Zero or one provisions devices
+	// one at a time.
+	Parallel int
+
+	// WaitOnline waits up to this long for the device to be online before
+	// installing packages, e.g. after a reboot or WAN change. Zero disables
+	// the wait.
+	WaitOnline time.Duration
+
+	// FollowLog echoes the device's log while the config is applied, so
+	// errors such as a service failing to reload are shown as they happen
+	FollowLog bool
+
+	// ValidateOnDevice stages the changes on the device and reverts them
+	// before applying anything, so changes uci refuses to stage stop the
+	// run with nothing changed. Nothing is committed, so values a service
+	// would reject aren't caught.
+	ValidateOnDevice bool
+
+	// CompatibleModels are model ids every device may have instead of its
+	// configured one, in addition to the device's compatible_models
+	CompatibleModels []string
+
+	// ApplyMode is ApplyModeReset, ApplyModeMerge or ApplyModeAuto, which
+	// is the default
+	ApplyMode string
+
+	// RestartServices are init.d services every device restarts after
+	// provisioning instead of the default reloads, in addition to the
+	// device's restart_services
+	RestartServices []string
+
+	// AuditDir, when set, is where a JSON record of what was applied is
+	// written for every successfully provisioned device
+	AuditDir string
+
+	// RateLimit is the most SSH connections opened per second across all
+	// devices, pacing parallel provisioning. Zero doesn't limit them.
+	RateLimit float64
+
+	// Tags, when set, restricts provisioning to the devices whose tags
+	// match all of them. Their dependencies on other devices are taken as
+	// already met.
+	Tags []condition.TagFilter
+
+	// DeviceErrors is DeviceErrorStop, the default, or DeviceErrorContinue
+	// to carry on past devices that fail, e.g. ones that are unreachable
+	DeviceErrors string
+
+	// DryRun verifies each device and prints the commands provisioning
+	// would run, worked out from its installed packages and apply mode,
+	// without running them
+	DryRun bool
+
+	// Redactor, when set, masks secret values in the commands, device
+	// output and errors that are printed. The commands run are unchanged.
+	Redactor *redact.Redactor
+}
+
+// getSchema and connect are replaced in tests
+var (
+	getSchema = device.GetDeviceSchema
+	connect   = func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		return ssh.ConnectDevice(deviceConfig)
+	}
 )
 
 // ProvisionConfig provisions configuration to all enabled devices
-func ProvisionConfig(oncConfig *config.ONCConfig) error {
+func ProvisionConfig(oncConfig *config.ONCConfig, opts Options) error {
+	switch opts.HostnameCheck {
+	case "", HostnameCheckWarn, HostnameCheckRefuse:
+	default:
+		return fmt.Errorf("invalid hostname check mode: %s", opts.HostnameCheck)
+	}
+	switch opts.ApplyMode {
+	case "", ApplyModeAuto, ApplyModeReset, ApplyModeMerge:
+	default:
+		return fmt.Errorf("invalid apply mode: %s", opts.ApplyMode)
+	}
+	if opts.RateLimit < 0 {
+		return fmt.Errorf("invalid rate limit: %v", opts.RateLimit)
+	}
+	switch opts.DeviceErrors {
+	case "", DeviceErrorStop, DeviceErrorContinue:
+	default:
+		return fmt.Errorf("invalid device error mode: %s", opts.DeviceErrors)
+	}
+	keepGoing := opts.DeviceErrors == DeviceErrorContinue
+
 	// Get enabled devices
 	var enabledDevices []config.DeviceConfig
 	for _, dev := range oncConfig.Devices {
@@ -19,125 +156,480 @@ func ProvisionConfig(oncConfig *config.ONCConfig) error {
 		}
 	}
 
-	// Get device schemas
+	if len(opts.Tags) > 0 {
+		if err := checkDependencies(enabledDevices); err != nil {
+			return err
+		}
+		selected, err := condition.SelectDevices(enabledDevices, opts.Tags)
+		if err != nil {
+			return err
+		}
+		enabledDevices = selected
+	}
+
+	secrets, err := secret.NewResolver(oncConfig.Secrets)
+	if err != nil {
+		return err
+	}
+	secrets = opts.Redactor.Resolver(secrets)
+
+	// Get device schemas. When carrying on past failures, a missing schema
+	// only fails the devices of that model.
 	deviceSchemas := make(map[string]*device.DeviceSchema)
+	schemaErrs := make(map[string]error)
 	for _, dev := range enabledDevices {
-		schema, err := device.GetDeviceSchema(&dev)
+		schema, err := getSchema(&dev)
 		if err != nil {
-			return fmt.Errorf("failed to get device schema for %s: %w", dev.ModelID, err)
+			err = fmt.Errorf("failed to get device schema for %s: %w", dev.ModelID, err)
+			if !keepGoing {
+				return err
+			}
+			schemaErrs[dev.ModelID] = err
+			continue
 		}
 		deviceSchemas[dev.ModelID] = schema
 	}
 
-	// Provision each device
-	for _, dev := range enabledDevices {
+	limiter := newRateLimiter(opts.RateLimit)
+
+	// Provision each device once the devices it depends on are done
+	err = scheduleDevices(enabledDevices, opts.Parallel, keepGoing, func(dev *config.DeviceConfig) error {
 		if dev.IPAddr == "" || dev.ProvisioningConfig == nil {
 			fmt.Printf("Skipping device %s: no IP address or provisioning config\n", dev.Hostname)
-			continue
+			return nil
 		}
 
+		if err := schemaErrs[dev.ModelID]; err != nil {
+			return fmt.Errorf("failed to provision device %s: %w", dev.Hostname, err)
+		}
 		schema := deviceSchemas[dev.ModelID]
 		if schema == nil {
 			return fmt.Errorf("device schema not found for device: %s@%s", dev.ModelID, dev.IPAddr)
 		}
 
-		// Get state
-		state, err := device.GetOpenWrtState(oncConfig, &dev, schema)
-		if err != nil {
-			return fmt.Errorf("failed to get state for device %s: %w", dev.Hostname, err)
-		}
-
 		// Provision
-		if err := provisionDevice(&dev, schema, state); err != nil {
+		limiter.wait()
+		if err := provisionDevice(oncConfig, dev, schema, secrets, opts); err != nil {
 			return fmt.Errorf("failed to provision device %s: %w", dev.Hostname, err)
 		}
-	}
 
-	return nil
+		return nil
+	})
+
+	// Say how many of the devices failed, one per line
+	if joined, ok := err.(interface{ Unwrap() []error }); ok && keepGoing {
+		return fmt.Errorf("%d of %d device(s) failed:\n%w", len(joined.Unwrap()), len(enabledDevices), err)
+	}
+	return err
 }
 
-func provisionDevice(deviceConfig *config.DeviceConfig, deviceSchema *device.DeviceSchema, state *device.OpenWrtState) error {
+func provisionDevice(oncConfig *config.ONCConfig, deviceConfig *config.DeviceConfig, deviceSchema *device.DeviceSchema, secrets secret.Resolver, opts Options) error {
 	fmt.Printf("Provisioning %s@%s...\n", deviceConfig.ProvisioningConfig.SSHAuth.Username, deviceConfig.IPAddr)
 
 	// Connect via SSH
 	fmt.Println("Connecting over SSH...")
-	client, err := ssh.Connect(
-		deviceConfig.IPAddr,
-		deviceConfig.ProvisioningConfig.SSHAuth.Username,
-		deviceConfig.ProvisioningConfig.SSHAuth.Password,
-	)
+	client, err := connect(deviceConfig)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 	defer client.Close()
-	fmt.Println("Connected.")
+	if provider, ok := client.(ssh.AddrProvider); ok && ssh.IsHostName(deviceConfig.IPAddr) && provider.RemoteAddr() != nil {
+		fmt.Printf("Connected to %s (%s).\n", deviceConfig.IPAddr, provider.RemoteAddr())
+	} else {
+		fmt.Println("Connected.")
+	}
+
+	// Get state once connected, so conditions can read the device's
+	// current uci values
+	state, err := device.GetOpenWrtStateWithOptions(oncConfig, deviceConfig, deviceSchema, device.StateOptions{Secrets: secrets, Executor: client})
+	if err != nil {
+		var conditionErr *condition.Error
+		if errors.As(err, &conditionErr) {
+			return conditionErr
+		}
+		return fmt.Errorf("failed to get state: %w", err)
+	}
+
+	for _, warning := range state.Warnings {
+		fmt.Printf("Warning: %s\n", opts.Redactor.Text(warning))
+	}
+
+	return provisionWithClient(client, deviceConfig, state, opts)
+}
+
+// formatDryRun returns the commands a device would run under a header
+// naming it
+func formatDryRun(deviceConfig *config.DeviceConfig, commands []string, redactor *redact.Redactor) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s (%s): %d command(s), not run\n", deviceConfig.Hostname, deviceConfig.IPAddr, len(commands))
+	for _, cmd := range commands {
+		fmt.Fprintln(&b, redactor.Command(cmd))
+	}
+	if deviceConfig.RebootAfter {
+		fmt.Fprintln(&b, "# then reboot")
+	}
+	return b.String()
+}
+
+// provisionWithClient applies the state to a device over an established connection
+func provisionWithClient(client ssh.SSHExecutor, deviceConfig *config.DeviceConfig, state *device.OpenWrtState, opts Options) error {
+	opts.Redactor.Collect(state.Config)
 
 	// Verify device
 	fmt.Println("Verifying device...")
-	boardJSON, err := verifyDevice(client, deviceConfig.ModelID)
+	compatibleModels := append(append([]string{}, deviceConfig.CompatibleModels...), opts.CompatibleModels...)
+	boardJSON, err := verifyDevice(client, deviceConfig.ModelID, compatibleModels...)
 	if err != nil {
 		return fmt.Errorf("failed to verify device: %w", err)
 	}
 	if boardJSON.Model.ID != deviceConfig.ModelID {
-		return fmt.Errorf("mismatching device model id: expected %s but found %s in /etc/board.json",
-			deviceConfig.ModelID, boardJSON.Model.ID)
+		fmt.Printf("Warning: applying config for %s to compatible model %s\n", deviceConfig.ModelID, boardJSON.Model.ID)
+	}
+	if opts.HostnameCheck != "" {
+		if err := verifyHostname(client, deviceConfig.Hostname); err != nil {
+			if opts.HostnameCheck == HostnameCheckRefuse {
+				return err
+			}
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	if deviceConfig.ExpectedMAC != "" {
+		if err := verifyMAC(client, deviceConfig.ExpectedMAC); err != nil {
+			return err
+		}
 	}
 	fmt.Println("Verified.")
 
+	// Find the interface we're connected through so its changes end the
+	// network config, preferring the session's own addresses over the
+	// configured one. A routed session is also at risk from the rest of the
+	// network config.
+	routed := false
+	if session, err := device.GetManagementSession(client); err == nil {
+		route := "routed"
+		if session.Direct() {
+			route = "direct"
+		}
+		routed = !session.Direct()
+		fmt.Printf("Managing device through interface %s (%s, %s).\n", session.Interface, session.Subnet, route)
+		state.ManagementInterface = session.Interface
+	} else if managementInterface, err := device.GetManagementInterface(client, deviceConfig.IPAddr); err == nil {
+		fmt.Printf("Managing device through interface %s.\n", managementInterface)
+		state.ManagementInterface = managementInterface
+	} else {
+		fmt.Printf("Warning: unable to detect management interface: %v\n", err)
+	}
+
+	if opts.SkipPackages {
+		state.SkipPackages = true
+	} else {
+		if opts.AssumeInstalled != nil {
+			state.InstalledPackages = make([]uci.InstalledPackage, 0, len(opts.AssumeInstalled))
+			for _, name := range opts.AssumeInstalled {
+				state.InstalledPackages = append(state.InstalledPackages, uci.InstalledPackage{Name: name})
+			}
+		}
+
+		// Catch packages opkg would reject for the wrong architecture
+		warnings, err := device.CheckPackageArchitectures(client, state.PackagesToInstall)
+		if err != nil {
+			fmt.Printf("Warning: unable to check package architectures: %v\n", err)
+		}
+		for _, warning := range warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+
+		// Show what else goes when removing packages with their dependents,
+		// and order the removals by their dependencies
+		dependents, err := device.GetPackageDependents(client, state.PackagesToUninstall)
+		if err != nil {
+			fmt.Printf("Warning: unable to check package dependents: %v\n", err)
+		} else {
+			state.RemovalDependents = dependents
+		}
+		for _, warning := range device.RemovalWarnings(state.PackagesToUninstall, dependents) {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+	}
+
+	// Restart only the listed services, which must exist on the device
+	var restartServices []string
+	for _, service := range append(append([]string{}, deviceConfig.RestartServices...), opts.RestartServices...) {
+		if !slices.Contains(restartServices, service) {
+			restartServices = append(restartServices, service)
+		}
+	}
+	if len(restartServices) > 0 {
+		if err := checkServices(client, restartServices); err != nil {
+			return err
+		}
+		state.RestartServices = restartServices
+	}
+
+	// Only reset the config sections of devices being bootstrapped, unless
+	// told otherwise
+	if applyMode(client, opts.ApplyMode) == ApplyModeMerge {
+		state.ConfigSectionsToReset = nil
+
+		// Wi-Fi interfaces without a key keep the one they have
+		for _, name := range device.PreserveWifiKeys(client, state) {
+			fmt.Printf("Keeping the device's key for wifi-iface %s.\n", name)
+		}
+	}
+
 	// Get commands
 	commands, err := device.GetDeviceScript(state, client)
 	if err != nil {
 		return fmt.Errorf("failed to get device script: %w", err)
 	}
 
+	// Print the script in one go, so parallel devices don't interleave
+	if opts.DryRun {
+		fmt.Print(formatDryRun(deviceConfig, commands, opts.Redactor))
+		return nil
+	}
+
+	if opts.ValidateOnDevice {
+		fmt.Println("Validating configuration on the device...")
+		rejected, err := validateOnDevice(client, commands)
+		if err != nil {
+			return fmt.Errorf("failed to validate on the device: %w", err)
+		}
+		if len(rejected) > 0 {
+			for _, rejection := range rejected {
+				fmt.Printf("Rejected: %s\n", opts.Redactor.Text(rejection))
+			}
+			return fmt.Errorf("device rejected %d change(s), nothing was applied", len(rejected))
+		}
+		fmt.Println("Validated.")
+	}
+
 	// Execute commands
 	fmt.Println("Setting configuration...")
-	revertCommands := getRevertCommands()
+	stopLog := func() {}
+	if opts.FollowLog {
+		stopLog = followLog(client, opts.Redactor)
+		defer stopLog()
+	}
+	// Only the configs with staged changes are reverted on failure, leaving
+	// the rest of the device alone
+	var touchedConfigs []string
+	touched := make(map[string]bool)
 
+	// Changes that can cut us off from the device are committed under a
+	// rollback, which is cancelled once we can reconnect after the reload
+	guarded := needsRollback(state.ManagementInterface, routed, commands)
+	var rollbackPID string
+	var armedAt time.Time
+
+	var failedCommands []string
+	var pendingCommands []string
+	installedBatch := false
 	for _, cmd := range commands {
+		// Earlier install batches may have pulled in later packages as
+		// dependencies
+		if strings.HasPrefix(cmd, "opkg install ") && installedBatch && opts.AssumeInstalled == nil {
+			pruned, dropped, err := device.PruneInstalled(client, cmd)
+			if err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+			if len(dropped) > 0 {
+				fmt.Printf("Already installed as dependencies: %s\n", strings.Join(dropped, ", "))
+			}
+			if pruned == "" {
+				continue
+			}
+			cmd = pruned
+		}
+
+		// Installs need the feeds, so give the WAN a chance to come up
+		if cmd == "opkg update;" && opts.WaitOnline > 0 {
+			fmt.Println("Waiting for the device to be online...")
+			if err := waitOnline(client, opts.WaitOnline); err != nil {
+				fmt.Printf("Warning: %v\n", err)
+			}
+		}
+
+		if guarded && rollbackPID == "" && strings.HasPrefix(cmd, "uci commit ") {
+			fmt.Printf("Arming a rollback in %s in case the device becomes unreachable...\n", rollbackTimeout)
+			pid, err := armRollback(client)
+			if err != nil {
+				for _, revertCmd := range getRevertCommands(touchedConfigs) {
+					_, _ = client.Execute(revertCmd)
+				}
+				return fmt.Errorf("failed to arm the rollback, nothing was committed: %w", err)
+			}
+			rollbackPID, armedAt = pid, time.Now()
+		}
+
+		// Catch sets the device silently ignored before they are committed
+		if configKey, ok := strings.CutPrefix(cmd, "uci commit "); ok {
+			discrepancies, err := verifyStagedChanges(client, configKey, pendingCommands)
+			if err != nil {
+				fmt.Printf("Warning: unable to verify staged %s changes: %v\n", configKey, err)
+			}
+			for _, discrepancy := range discrepancies {
+				fmt.Printf("Warning: staged change mismatch: %s\n", opts.Redactor.Text(discrepancy))
+			}
+			pendingCommands = nil
+		}
+
+		if configKey := uci.CommandConfig(cmd); configKey != "" && !touched[configKey] {
+			touched[configKey] = true
+			touchedConfigs = append(touchedConfigs, configKey)
+		}
+
 		output, err := client.ExecuteWithError(cmd)
 		if err != nil {
-			fmt.Printf("Command failed: %s\n", cmd)
-			fmt.Printf("Error: %s\n", output)
+			fmt.Printf("Command failed: %s\n", opts.Redactor.Command(cmd))
+			fmt.Printf("Error: %s\n", opts.Redactor.Text(output))
+
+			if opts.ContinueOnError {
+				failedCommands = append(failedCommands, opts.Redactor.Command(cmd))
+				continue
+			}
+
 			fmt.Println("Reverting...")
 
 			// Revert changes
-			for _, revertCmd := range revertCommands {
+			for _, revertCmd := range getRevertCommands(touchedConfigs) {
 				_, _ = client.Execute(revertCmd)
 			}
 
 			fmt.Println("Reverted.")
-			return fmt.Errorf("failed to execute command: %s", cmd)
+			if rollbackPID != "" {
+				fmt.Printf("The device restores the config it had before the run in %s.\n", time.Until(armedAt.Add(rollbackTimeout)).Round(time.Second))
+			}
+			return fmt.Errorf("failed to execute command: %s", opts.Redactor.Command(cmd))
+		}
+
+		pendingCommands = append(pendingCommands, cmd)
+		if strings.HasPrefix(cmd, "opkg install ") {
+			installedBatch = true
 		}
 	}
 
+	stopLog()
+
+	if rollbackPID != "" {
+		fmt.Println("Confirming the device is still reachable...")
+		if err := confirmApply(deviceConfig, rollbackPID, armedAt); err != nil {
+			return err
+		}
+		fmt.Println("Confirmed, rollback cancelled.")
+	}
+
+	if len(failedCommands) > 0 {
+		fmt.Printf("%d command(s) failed:\n", len(failedCommands))
+		for _, cmd := range failedCommands {
+			fmt.Printf("  %s\n", cmd)
+		}
+		return fmt.Errorf("%d command(s) failed: %s", len(failedCommands), strings.Join(failedCommands, "; "))
+	}
+
 	fmt.Println("Configuration set.")
+
+	if opts.AuditDir != "" {
+		if path, err := writeAuditRecord(opts.AuditDir, deviceConfig, state, commands, opts.Redactor); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		} else {
+			fmt.Printf("Audit record written to %s.\n", path)
+		}
+	}
+
+	// Only reboot once everything applied cleanly
+	if deviceConfig.RebootAfter {
+		fmt.Println("Rebooting...")
+		if err := rebootDevice(client, deviceConfig); err != nil {
+			return err
+		}
+		fmt.Println("Device is back.")
+	} else if modules := kernelModuleChanges(state); len(modules) > 0 && !state.SkipPackages {
+		fmt.Printf("Note: kernel modules changed (%s); set reboot_after if they need a reboot to take effect.\n", strings.Join(modules, ", "))
+	}
+
 	fmt.Println("Provisioning completed.")
 
 	return nil
 }
 
-func verifyDevice(client ssh.SSHExecutor, expectedModelID string) (*device.BoardJSON, error) {
-	output, err := client.Execute("cat /etc/board.json")
+// verifyMAC checks that the expected MAC address is one of the device's
+// hardware addresses
+func verifyMAC(client ssh.SSHExecutor, expectedMAC string) error {
+	expected, err := net.ParseMAC(expectedMAC)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read /etc/board.json: %w", err)
+		return fmt.Errorf("invalid expected MAC address %s: %w", expectedMAC, err)
+	}
+
+	output, err := client.Execute("cat /sys/class/net/*/address")
+	if err != nil {
+		return fmt.Errorf("failed to read MAC addresses: %w", err)
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if mac, err := net.ParseMAC(strings.TrimSpace(line)); err == nil && bytes.Equal(mac, expected) {
+			return nil
+		}
 	}
 
-	var boardJSON device.BoardJSON
-	if err := json.Unmarshal([]byte(output), &boardJSON); err != nil {
-		return nil, fmt.Errorf("failed to parse board.json: %w", err)
+	return fmt.Errorf("device MAC mismatch: %s is not one of the device's addresses", expected)
+}
+
+// verifyDevice checks the device's model id is the expected one or one of
+// the compatible ones
+func verifyDevice(client ssh.SSHExecutor, expectedModelID string, compatibleModels ...string) (*device.BoardJSON, error) {
+	boardJSON, err := device.ReadBoardJSON(client)
+	if err != nil {
+		return nil, err
 	}
 
-	if boardJSON.Model.ID != expectedModelID {
+	if boardJSON.Model.ID != expectedModelID && !slices.Contains(compatibleModels, boardJSON.Model.ID) {
 		return nil, fmt.Errorf("device model mismatch: expected %s, got %s", expectedModelID, boardJSON.Model.ID)
 	}
 
-	return &boardJSON, nil
+	return boardJSON, nil
+}
+
+// verifyHostname checks that the device's current hostname matches the
+// expected one. A device still using the factory hostname always passes.
+func verifyHostname(client ssh.SSHExecutor, expectedHostname string) error {
+	if expectedHostname == "" {
+		return nil
+	}
+
+	output, err := client.Execute("uci -q get system.@system[0].hostname")
+	if err != nil {
+		return fmt.Errorf("failed to read hostname: %w", err)
+	}
+
+	hostname := strings.TrimSpace(output)
+	if hostname == "" || hostname == factoryHostname || hostname == expectedHostname {
+		return nil
+	}
+
+	return fmt.Errorf("device hostname mismatch: expected %s, got %s", expectedHostname, hostname)
+}
+
+// verifyStagedChanges compares the changes uci has staged for a config with
+// the set and add_list commands run for it, returning any that are missing
+// or staged with a different value
+func verifyStagedChanges(client ssh.SSHExecutor, configKey string, commands []string) ([]string, error) {
+	output, err := client.Execute(fmt.Sprintf("uci changes %s", configKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staged changes: %w", err)
+	}
+
+	current := func(key string) string {
+		value, _ := client.Execute(fmt.Sprintf("uci -q get %s", key))
+		return strings.TrimSpace(value)
+	}
+
+	return uci.CompareChanges(uci.ExpectedChanges(commands, configKey), uci.ParseChanges(output), current), nil
 }
 
-func getRevertCommands() []string {
-	// These are the common configs that should be reverted
-	configs := []string{"system", "network", "firewall", "dhcp", "wireless", "dropbear"}
+// getRevertCommands returns the commands discarding the staged changes to
+// the given configs
+func getRevertCommands(configs []string) []string {
 	var commands []string
 
 	for _, cfg := range configs {
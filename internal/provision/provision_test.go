@@ -1,12 +1,24 @@
 package provision
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/drummonds/openwrt-configurator.git/internal/config"
 	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/export"
+	"github.com/drummonds/openwrt-configurator.git/internal/redact"
 	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
 )
 
 // TestFactoryResetProvisionBasic tests provisioning to a factory reset device
@@ -81,13 +93,13 @@ func TestFactoryResetProvisionBasic(t *testing.T) {
 	// Verify commit was called
 	hasCommit := false
 	for _, cmd := range mockClient.GetExecutedCommands() {
-		if cmd == "uci commit" {
+		if cmd == "uci commit system" {
 			hasCommit = true
 			break
 		}
 	}
 	if !hasCommit {
-		t.Error("Expected 'uci commit' to be executed")
+		t.Error("Expected 'uci commit system' to be executed")
 	}
 }
 
@@ -425,7 +437,1395 @@ func TestFactoryResetBoardJSON(t *testing.T) {
 	}
 }
 
+// TestManagementInterfaceLast tests that changes to the interface the
+// device is managed through come last in the network's single commit, under
+// a rollback that is cancelled once the device answers again
+func TestManagementInterfaceLast(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["ubus call network.interface dump"] = `{"interface": [
+		{"interface": "wan", "up": true, "l3_device": "eth0", "ipv4-address": [{"address": "203.0.113.5", "mask": 24}]},
+		{"interface": "lan", "up": true, "l3_device": "br-lan", "ipv4-address": [{"address": "192.168.1.1", "mask": 24}]}
+	]}`
+	armCommand := fmt.Sprintf("(sleep 180; cp %s/* /etc/config/ && reload_config) >/dev/null 2>&1 & echo $!", rollbackDir)
+	mockClient.Responses[armCommand] = "4242\n"
+
+	confirmClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	stubDevices(t, nil, func(*config.DeviceConfig) (ssh.SSHExecutor, error) { return confirmClient, nil })
+	stub(t, &confirmPollInterval, time.Millisecond)
+
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:  "ubnt,edgerouter-x",
+				Hostname: "test-router",
+				IPAddr:   "192.168.1.1",
+			},
+		},
+		Config: config.ConfigConfig{
+			Network: &config.NetworkConfig{
+				Interface: []config.InterfaceSection{
+					{Name: stringPtr("lan"), Proto: stringPtr("static"), IPAddr: stringPtr("192.168.1.1")},
+					{Name: stringPtr("wan"), Proto: stringPtr("dhcp")},
+				},
+			},
+			System: &config.SystemConfig{
+				System: []config.SystemSection{
+					{Name: stringPtr("system"), Hostname: stringPtr("test-router")},
+				},
+			},
+		},
+	}
+
+	deviceConfig := &oncConfig.Devices[0]
+	state, err := device.GetOpenWrtState(oncConfig, deviceConfig, &device.DeviceSchema{Name: "ubnt,edgerouter-x"})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{}); err != nil {
+		t.Fatalf("Failed to provision: %v", err)
+	}
+
+	if state.ManagementInterface != "lan" {
+		t.Errorf("Expected management interface 'lan', got '%s'", state.ManagementInterface)
+	}
+
+	// The lan commands come after the other network changes, and the
+	// network is committed once, after the rollback is armed
+	lastOther, firstLan, armed := -1, -1, -1
+	var networkCommits []int
+	executed := mockClient.GetExecutedCommands()
+	for i, cmd := range executed {
+		switch {
+		case strings.HasPrefix(cmd, "uci set network.lan"):
+			if firstLan == -1 {
+				firstLan = i
+			}
+		case strings.HasPrefix(cmd, "uci set network."):
+			lastOther = i
+		case cmd == "uci commit network":
+			networkCommits = append(networkCommits, i)
+		case cmd == armCommand:
+			armed = i
+		}
+	}
+	if firstLan == -1 || firstLan < lastOther {
+		t.Errorf("Expected management interface commands last, got: %v", executed)
+	}
+	if len(networkCommits) != 1 || networkCommits[0] < firstLan {
+		t.Errorf("Expected a single network commit after the lan changes, got: %v", executed)
+	}
+	if armed == -1 || len(networkCommits) == 0 || armed > networkCommits[0] {
+		t.Errorf("Expected the rollback to be armed before the first commit, got: %v", executed)
+	}
+
+	if lanProto := mockClient.GetUCIValue("network", "lan", "proto"); lanProto != "static" {
+		t.Errorf("Expected lan proto 'static', got '%s'", lanProto)
+	}
+
+	// The rollback is cancelled over a new connection
+	cancel := confirmClient.GetExecutedCommands()
+	if len(cancel) != 1 || cancel[0] != "kill 4242 && rm -rf "+rollbackDir {
+		t.Errorf("Expected the rollback to be cancelled, got %v", cancel)
+	}
+}
+
+// TestManagementRollbackUnconfirmed tests that provisioning fails, leaving
+// the rollback to restore the device, when it can't be reached again
+func TestManagementRollbackUnconfirmed(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["ubus call network.interface dump"] = `{"interface": [
+		{"interface": "lan", "up": true, "l3_device": "br-lan", "ipv4-address": [{"address": "192.168.1.1", "mask": 24}]}
+	]}`
+	mockClient.Responses[fmt.Sprintf("(sleep 0; cp %s/* /etc/config/ && reload_config) >/dev/null 2>&1 & echo $!", rollbackDir)] = "4242\n"
+
+	stubDevices(t, nil, func(*config.DeviceConfig) (ssh.SSHExecutor, error) { return nil, fmt.Errorf("connection refused") })
+	stub(t, &confirmPollInterval, time.Millisecond)
+	stub(t, &rollbackTimeout, 10*time.Millisecond)
+
+	deviceConfig := &config.DeviceConfig{ModelID: "ubnt,edgerouter-x", Hostname: "test-router", IPAddr: "192.168.1.1"}
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"network": map[string]any{
+				"interface": []any{
+					map[string]any{".name": "lan", "proto": "static", "ipaddr": "192.168.2.1"},
+				},
+			},
+		},
+		SkipPackages: true,
+	}
+
+	err := provisionWithClient(mockClient, deviceConfig, state, Options{})
+	if err == nil || !strings.Contains(err.Error(), "restores its previous config") {
+		t.Errorf("Expected the unconfirmed apply to fail, got %v", err)
+	}
+}
+
+// TestNeedsRollback tests that a direct session is only guarded against
+// management interface changes and a routed one against any network change
+func TestNeedsRollback(t *testing.T) {
+	commands := []string{"uci set network.wan.proto='dhcp'", "uci commit network"}
+	if needsRollback("lan", false, commands) {
+		t.Error("Expected no rollback for a direct session when lan doesn't change")
+	}
+	if !needsRollback("lan", true, commands) {
+		t.Error("Expected a rollback for a routed session when the network changes")
+	}
+	if !needsRollback("lan", false, append(commands, "uci set network.lan.ipaddr='192.168.2.1'")) {
+		t.Error("Expected a rollback when the management interface changes")
+	}
+	if needsRollback("", true, commands) {
+		t.Error("Expected no rollback without a management interface")
+	}
+}
+
 // Helper function
 func stringPtr(s string) *string {
 	return &s
 }
+
+// stub sets a package variable for the rest of the test
+func stub[T any](t *testing.T, variable *T, value T) {
+	t.Helper()
+	original := *variable
+	t.Cleanup(func() { *variable = original })
+	*variable = value
+}
+
+// stubDevices replaces how device schemas are read and devices connected to
+// for the rest of the test. A nil function keeps the real one.
+func stubDevices(t *testing.T, schemaFn func(*config.DeviceConfig) (*device.DeviceSchema, error), connectFn func(*config.DeviceConfig) (ssh.SSHExecutor, error)) {
+	t.Helper()
+	if schemaFn != nil {
+		stub(t, &getSchema, schemaFn)
+	}
+	if connectFn != nil {
+		stub(t, &connect, connectFn)
+	}
+}
+
+// modelSchema is a device schema with nothing but the device's model
+func modelSchema(deviceConfig *config.DeviceConfig) (*device.DeviceSchema, error) {
+	return &device.DeviceSchema{Name: deviceConfig.ModelID}, nil
+}
+
+func TestHostnameMismatch(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci -q get system.@system[0].hostname"] = "other-router\n"
+
+	if err := verifyHostname(mockClient, "test-router"); err == nil || !strings.Contains(err.Error(), "mismatch") {
+		t.Errorf("Expected hostname mismatch, got %v", err)
+	}
+
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{Config: map[string]any{}}
+
+	// Warn mode carries on
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{HostnameCheck: HostnameCheckWarn}); err != nil {
+		t.Errorf("Expected warn mode to continue, got %v", err)
+	}
+
+	// Refuse mode stops before anything is applied
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci -q get system.@system[0].hostname"] = "other-router\n"
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{HostnameCheck: HostnameCheckRefuse}); err == nil {
+		t.Error("Expected refuse mode to fail on hostname mismatch")
+	}
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if strings.HasPrefix(cmd, "uci commit") || cmd == "reload_config" {
+			t.Errorf("Unexpected command after refused hostname check: %s", cmd)
+		}
+	}
+
+	// A factory device always passes
+	mockClient.Responses["uci -q get system.@system[0].hostname"] = "OpenWrt\n"
+	if err := verifyHostname(mockClient, "test-router"); err != nil {
+		t.Errorf("Expected factory hostname to pass, got %v", err)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	newState := func() *device.OpenWrtState {
+		return &device.OpenWrtState{
+			Config: map[string]any{
+				"system": map[string]any{
+					"system": []any{
+						map[string]any{".name": "system", "hostname": "test-router"},
+					},
+				},
+			},
+			PackagesToUninstall: []string{"ppp"},
+		}
+	}
+
+	// Fail fast by default
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.FailOnCommand = "opkg remove"
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{}); err == nil {
+		t.Fatal("Expected provisioning to fail")
+	}
+	if mockClient.GetUCIValue("system", "system", "hostname") != "" {
+		t.Error("Expected no config to be set after a fail-fast failure")
+	}
+
+	// Best effort carries on and reports the failure at the end
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.FailOnCommand = "opkg remove"
+	err := provisionWithClient(mockClient, deviceConfig, newState(), Options{ContinueOnError: true})
+	if err == nil || !strings.Contains(err.Error(), "opkg remove") {
+		t.Fatalf("Expected the failed command to be reported, got %v", err)
+	}
+	if hostname := mockClient.GetUCIValue("system", "system", "hostname"); hostname != "test-router" {
+		t.Errorf("Expected later commands to run, got hostname '%s'", hostname)
+	}
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if strings.HasPrefix(cmd, "uci revert") {
+			t.Errorf("Unexpected revert with continue-on-error: %s", cmd)
+		}
+	}
+}
+
+// TestStagedChangeMismatch tests that sets missing from uci changes are
+// flagged before commit
+func TestStagedChangeMismatch(t *testing.T) {
+	commands := []string{
+		"uci set network.lan=interface",
+		"uci set network.lan.proto='static'",
+		"uci set network.lan.ipaddr='10.0.0.1'",
+		"uci add_list network.lan.dns='1.1.1.1'",
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	for _, cmd := range commands {
+		_, _ = mockClient.Execute(cmd)
+	}
+
+	discrepancies, err := verifyStagedChanges(mockClient, "network", commands)
+	if err != nil {
+		t.Fatalf("Failed to verify staged changes: %v", err)
+	}
+	if len(discrepancies) != 0 {
+		t.Errorf("Expected no discrepancies, got %v", discrepancies)
+	}
+
+	// The device rejected the ipaddr set without an error
+	mockClient.Responses["uci changes network"] = `network.lan=interface
+network.lan.proto='static'
+network.lan.dns+='1.1.1.1'`
+
+	discrepancies, err = verifyStagedChanges(mockClient, "network", commands)
+	if err != nil {
+		t.Fatalf("Failed to verify staged changes: %v", err)
+	}
+	if len(discrepancies) != 1 || discrepancies[0] != "network.lan.ipaddr: expected '10.0.0.1', staged ''" {
+		t.Errorf("Expected ipaddr discrepancy, got %v", discrepancies)
+	}
+
+	// A set that leaves the value unchanged isn't staged but isn't a discrepancy
+	mockClient.Responses["uci -q get network.lan.ipaddr"] = "10.0.0.1\n"
+	discrepancies, _ = verifyStagedChanges(mockClient, "network", commands)
+	if len(discrepancies) != 0 {
+		t.Errorf("Expected no discrepancies for unchanged value, got %v", discrepancies)
+	}
+}
+
+func TestAssumeInstalled(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	newState := func() *device.OpenWrtState {
+		return &device.OpenWrtState{
+			Config: map[string]any{},
+			PackagesToInstall: []uci.Package{
+				{Name: "luci"},
+				{Name: "ppp-mod-pppoe"},
+			},
+			PackagesToUninstall: []string{"odhcpd"},
+		}
+	}
+
+	// opkg can't run on the device, so the installed list is assumed
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.FailOnCommand = "opkg list-installed"
+	opts := Options{AssumeInstalled: []string{"ppp-mod-pppoe", "odhcpd"}}
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), opts); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	executed := mockClient.GetExecutedCommands()
+	if slices.Contains(executed, "opkg list-installed") {
+		t.Error("Expected the installed list not to be read from the device")
+	}
+	for _, cmd := range executed {
+		if strings.HasPrefix(cmd, "opkg install") && strings.Contains(cmd, "ppp-mod-pppoe") {
+			t.Errorf("Unexpected install of an assumed installed package: %s", cmd)
+		}
+	}
+	if !slices.Contains(executed, "opkg install luci") {
+		t.Errorf("Expected luci to be installed, got %v", executed)
+	}
+	if !slices.Contains(executed, "opkg remove odhcpd") {
+		t.Errorf("Expected odhcpd to be removed, got %v", executed)
+	}
+
+	// Skipping packages runs no opkg commands at all
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{SkipPackages: true}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if strings.HasPrefix(cmd, "opkg") {
+			t.Errorf("Unexpected opkg command with packages skipped: %s", cmd)
+		}
+	}
+}
+
+// TestRemovalDependents tests that the dependents of removed packages are
+// read before they are removed
+func TestRemovalDependents(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-ap",
+		IPAddr:   "192.168.1.2",
+	}
+	state := &device.OpenWrtState{
+		Config:              map[string]any{},
+		PackagesToUninstall: []string{"firewall4"},
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["opkg whatdepends firewall4"] = `Root set:
+  firewall4
+What depends on root set
+	luci-app-firewall 23.05.0	depends on firewall4
+`
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	executed := mockClient.GetExecutedCommands()
+	checked := slices.Index(executed, "opkg whatdepends firewall4")
+	removed := slices.Index(executed, "opkg remove --force-removal-of-dependent-packages firewall4")
+	if checked < 0 || removed < 0 || checked > removed {
+		t.Errorf("Expected dependents to be read before removal, got %v", executed)
+	}
+}
+
+// TestWaitOnline tests that packages are only installed once the WAN is up
+func TestWaitOnline(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{
+		Config:            map[string]any{},
+		PackagesToInstall: []uci.Package{{Name: "luci"}},
+	}
+
+	stub(t, &onlinePollInterval, time.Millisecond)
+
+	// The WAN reports down twice before coming up
+	base := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	polls := 0
+	mockClient.OnExecute = func(command string) (string, error) {
+		if command == "ubus call network.interface.wan status" {
+			polls++
+			return fmt.Sprintf(`{"interface": "wan", "up": %t}`, polls > 2), nil
+		}
+		if strings.HasPrefix(command, "opkg update") && polls < 3 {
+			return "", fmt.Errorf("opkg update ran before the WAN was up")
+		}
+		return base.Execute(command)
+	}
+
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{WaitOnline: time.Second}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	if polls != 3 {
+		t.Errorf("Expected 3 polls, got %d", polls)
+	}
+
+	// Nothing to install, so there is nothing to wait for
+	polls = 0
+	state.PackagesToInstall = nil
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{WaitOnline: time.Second}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	if polls != 0 {
+		t.Errorf("Expected no polls without installs, got %d", polls)
+	}
+}
+
+// TestFollowLog tests that the device log is echoed while the config is applied
+func TestFollowLog(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"firewall": map[string]any{
+				"rule": []any{
+					map[string]any{".name": "bad_rule", "src": "wan", "proto": "tcpp", "target": "ACCEPT"},
+				},
+			},
+		},
+	}
+
+	var output strings.Builder
+	stub[io.Writer](t, &logOutput, &output)
+	stub(t, &logSettleTime, 0)
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.StreamOutput[logreadCommand] = []string{
+		"Mon Mar  4 10:00:00 2024 user.notice firewall: Reloading firewall due to ifupdate of wan",
+		"Mon Mar  4 10:00:01 2024 daemon.err fw4: Section @rule[0] (bad_rule) specifies unknown protocol 'tcpp'",
+	}
+
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{FollowLog: true}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	if !strings.Contains(output.String(), "log: Mon Mar  4 10:00:01 2024 daemon.err fw4: Section @rule[0] (bad_rule) specifies unknown protocol 'tcpp'\n") {
+		t.Errorf("Expected the log lines to be echoed, got:\n%s", output.String())
+	}
+
+	// The log is followed from before the first commit
+	executed := mockClient.GetExecutedCommands()
+	followed := slices.Index(executed, logreadCommand)
+	committed := slices.Index(executed, "uci commit firewall")
+	if followed < 0 || committed < 0 || followed > committed {
+		t.Errorf("Expected the log to be followed before committing, got %v", executed)
+	}
+}
+
+// TestRevertTouchedConfigs tests that a failure only reverts the configs the
+// script changed
+func TestRevertTouchedConfigs(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"network": map[string]any{
+				"interface": []any{
+					map[string]any{".name": "lan", "proto": "static", "ipaddr": "10.0.0.1"},
+				},
+			},
+		},
+		SkipPackages: true,
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.FailOnCommand = "uci commit network"
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{}); err == nil {
+		t.Fatal("Expected provisioning to fail")
+	}
+
+	var reverts []string
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if strings.HasPrefix(cmd, "uci revert") {
+			reverts = append(reverts, cmd)
+		}
+	}
+	if len(reverts) != 1 || reverts[0] != "uci revert network" {
+		t.Errorf("Expected only network to be reverted, got %v", reverts)
+	}
+}
+
+// TestValidateOnDevice tests that an option the device rejects is reported
+// and the staged changes reverted before anything is applied
+func TestValidateOnDevice(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"network": map[string]any{
+				"interface": []any{
+					map[string]any{".name": "lan", "proto": "static", "ipaddr": "10.0.0.1", "mtu": "jumbo"},
+				},
+			},
+		},
+		SkipPackages: true,
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	base := ssh.NewMockClient("ubnt,edgerouter-x")
+	base.StagedChanges = mockClient.StagedChanges
+	mockClient.OnExecute = func(command string) (string, error) {
+		if command == "uci set network.lan.mtu='jumbo'" {
+			return "uci: Invalid argument", fmt.Errorf("exit status 1")
+		}
+		return base.Execute(command)
+	}
+
+	// Clearing a list option the device doesn't have isn't a rejection
+	rejected, err := validateOnDevice(mockClient, []string{
+		"uci set network.lan=interface",
+		"uci set network.lan.mtu='jumbo'",
+		"uci set network.lan.ipaddr='10.0.0.1'",
+		"uci -q delete network.lan.dns || true",
+		"uci add_list network.lan.dns='1.1.1.1'",
+		"uci commit network",
+	})
+	if err != nil {
+		t.Fatalf("Failed to validate: %v", err)
+	}
+	if len(rejected) != 1 || rejected[0] != "uci set network.lan.mtu='jumbo': uci: Invalid argument" {
+		t.Errorf("Expected the rejected option to be identified, got %v", rejected)
+	}
+
+	reverted := false
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if strings.HasPrefix(cmd, "uci commit") {
+			t.Errorf("Unexpected commit during validation: %s", cmd)
+		}
+		if cmd == "uci revert network" {
+			reverted = true
+		}
+	}
+	if !reverted || len(mockClient.StagedChanges["network"]) != 0 {
+		t.Errorf("Expected the staged network changes to be reverted, got %v", mockClient.StagedChanges)
+	}
+
+	// Provisioning stops before applying anything
+	mockClient.ExecutedCmds = nil
+	err = provisionWithClient(mockClient, deviceConfig, state, Options{ValidateOnDevice: true})
+	if err == nil || !strings.Contains(err.Error(), "rejected 1 change") {
+		t.Errorf("Expected the rejection to stop provisioning, got %v", err)
+	}
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if strings.HasPrefix(cmd, "uci commit") {
+			t.Errorf("Unexpected commit after a rejection: %s", cmd)
+		}
+	}
+}
+
+// TestValidateOnDevicePendingChanges tests that validation refuses to run
+// over changes already staged on the device, which its revert would discard
+func TestValidateOnDevicePendingChanges(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.StagedChanges["network"] = []string{"network.lan.ipaddr='192.168.2.1'"}
+
+	_, err := validateOnDevice(mockClient, []string{
+		"uci set system.@system[0].hostname='test-router'",
+		"uci set network.lan.mtu='1500'",
+	})
+	if err == nil || !strings.Contains(err.Error(), "network has uncommitted changes") {
+		t.Fatalf("Expected the pending network changes to stop validation, got %v", err)
+	}
+
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if cmd == "uci set network.lan.mtu='1500'" || cmd == "uci revert network" {
+			t.Errorf("Unexpected %q with changes pending", cmd)
+		}
+	}
+	if len(mockClient.StagedChanges["network"]) != 1 {
+		t.Errorf("Expected the pending change to survive, got %v", mockClient.StagedChanges)
+	}
+	if len(mockClient.StagedChanges["system"]) != 0 {
+		t.Errorf("Expected the system changes staged before the check to be reverted, got %v", mockClient.StagedChanges)
+	}
+}
+
+// TestCompatibleModels tests that a model mismatch is only allowed for the
+// compatible models
+func TestCompatibleModels(t *testing.T) {
+	newState := func() *device.OpenWrtState {
+		return &device.OpenWrtState{
+			Config: map[string]any{
+				"system": map[string]any{
+					"system": []any{
+						map[string]any{".name": "system", "hostname": "test-router"},
+					},
+				},
+			},
+			SkipPackages: true,
+		}
+	}
+	deviceConfig := &config.DeviceConfig{
+		ModelID:          "ubnt,edgerouter-x",
+		Hostname:         "test-router",
+		IPAddr:           "192.168.1.1",
+		CompatibleModels: []string{"ubnt,edgerouter-x-sfp"},
+	}
+
+	// A compatible model proceeds with a warning
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x-sfp")
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{}); err != nil {
+		t.Fatalf("Expected a compatible model to be provisioned, got %v", err)
+	}
+	if hostname := mockClient.GetUCIValue("system", "system", "hostname"); hostname != "test-router" {
+		t.Errorf("Expected the config to be applied, got hostname '%s'", hostname)
+	}
+
+	// Any other model is still refused
+	mockClient = ssh.NewMockClient("tplink,archer-c50-v4")
+	err := provisionWithClient(mockClient, deviceConfig, newState(), Options{})
+	if err == nil || !strings.Contains(err.Error(), "model mismatch") {
+		t.Fatalf("Expected a model mismatch error, got %v", err)
+	}
+	if mockClient.GetUCIValue("system", "system", "hostname") != "" {
+		t.Error("Expected no config to be applied to an incompatible model")
+	}
+
+	// unless it is allowed for every device
+	mockClient = ssh.NewMockClient("tplink,archer-c50-v4")
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{CompatibleModels: []string{"tplink,archer-c50-v4"}}); err != nil {
+		t.Errorf("Expected an allowed model to be provisioned, got %v", err)
+	}
+}
+
+func TestApplyModeAuto(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	newState := func() *device.OpenWrtState {
+		return &device.OpenWrtState{
+			Config: map[string]any{
+				"firewall": map[string]any{
+					"zone": []any{
+						map[string]any{".name": "lan", "input": "ACCEPT"},
+					},
+				},
+			},
+			ConfigSectionsToReset: map[string][]string{"firewall": {"zone"}},
+			SkipPackages:          true,
+		}
+	}
+	const reset = "while uci -q delete firewall.@zone[0]; do :; done"
+
+	// A factory device is reset
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci -q get system.@system[0].hostname"] = "OpenWrt\n"
+	mockClient.Responses["uci -q get network.lan.ipaddr"] = "192.168.1.1\n"
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	if !slices.Contains(mockClient.GetExecutedCommands(), reset) {
+		t.Error("Expected a factory device to be reset")
+	}
+
+	// A configured device is merged into
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci -q get system.@system[0].hostname"] = "test-router\n"
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	executed := mockClient.GetExecutedCommands()
+	if slices.Contains(executed, reset) {
+		t.Error("Expected a configured device not to be reset")
+	}
+	if !slices.Contains(executed, "uci set firewall.lan.input='ACCEPT'") {
+		t.Error("Expected the config to be merged")
+	}
+
+	// Installed packages also mean the device was configured
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["ls /overlay/upper/usr/lib/opkg/info/ 2>/dev/null | grep '\\.control$'"] = "luci.control\n"
+	if factory, reason := detectFactoryDefaults(mockClient); factory || reason != "packages were installed: luci" {
+		t.Errorf("Expected installed packages to be detected, got %v, %q", factory, reason)
+	}
+
+	// The mode can be forced
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci -q get system.@system[0].hostname"] = "test-router\n"
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{ApplyMode: ApplyModeReset}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	if !slices.Contains(mockClient.GetExecutedCommands(), reset) {
+		t.Error("Expected a forced reset")
+	}
+}
+
+func TestRestartServices(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:         "ubnt,edgerouter-x",
+		Hostname:        "test-router",
+		IPAddr:          "192.168.1.1",
+		RestartServices: []string{"network"},
+	}
+	newState := func() *device.OpenWrtState {
+		return &device.OpenWrtState{
+			Config: map[string]any{
+				"network": map[string]any{
+					"interface": []any{map[string]any{".name": "lan", "proto": "static"}},
+				},
+				"wireless": map[string]any{
+					"wifi-iface": []any{map[string]any{".name": "default_radio0", "ssid": "home"}},
+				},
+			},
+			SkipPackages: true,
+		}
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	opts := Options{RestartServices: []string{"dnsmasq", "network"}}
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), opts); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	var restarts []string
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		switch {
+		case strings.HasPrefix(cmd, "/etc/init.d/"), cmd == "reload_config", cmd == "wifi reload":
+			restarts = append(restarts, cmd)
+		}
+	}
+	expected := []string{"/etc/init.d/network restart", "/etc/init.d/dnsmasq restart"}
+	if !slices.Equal(restarts, expected) {
+		t.Errorf("Expected only %v, got %v", expected, restarts)
+	}
+
+	// A service the device doesn't have stops provisioning
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.FailOnCommand = "test -x /etc/init.d/network"
+	err := provisionWithClient(mockClient, deviceConfig, newState(), Options{})
+	if err == nil || !strings.Contains(err.Error(), "services not found in /etc/init.d: network") {
+		t.Errorf("Expected a missing service error, got %v", err)
+	}
+	if slices.Contains(mockClient.GetExecutedCommands(), "uci commit network") {
+		t.Error("Expected nothing to be applied")
+	}
+}
+
+func TestExpectedMAC(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:     "ubnt,edgerouter-x",
+		Hostname:    "test-router",
+		IPAddr:      "192.168.1.1",
+		ExpectedMAC: "74:83:C2:11:22:33",
+	}
+	newState := func() *device.OpenWrtState {
+		return &device.OpenWrtState{
+			Config: map[string]any{
+				"network": map[string]any{
+					"interface": []any{map[string]any{".name": "lan", "proto": "static"}},
+				},
+			},
+			SkipPackages: true,
+		}
+	}
+
+	// One of the device's addresses matches, in any case
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["cat /sys/class/net/*/address"] = "00:00:00:00:00:00\n74:83:c2:11:22:33\n74:83:c2:11:22:34\n"
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	if !slices.Contains(mockClient.GetExecutedCommands(), "uci commit network") {
+		t.Error("Expected the config to be applied")
+	}
+
+	// Another box at the same address is refused
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["cat /sys/class/net/*/address"] = "00:00:00:00:00:00\n74:83:c2:aa:bb:cc\n"
+	err := provisionWithClient(mockClient, deviceConfig, newState(), Options{})
+	if err == nil || !strings.Contains(err.Error(), "device MAC mismatch") {
+		t.Errorf("Expected a MAC mismatch error, got %v", err)
+	}
+	if slices.Contains(mockClient.GetExecutedCommands(), "uci commit network") {
+		t.Error("Expected nothing to be applied")
+	}
+}
+
+func TestAuditRecord(t *testing.T) {
+	stub(t, &auditNow, func() time.Time { return time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC) })
+
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"network": map[string]any{
+				"interface": []any{map[string]any{".name": "lan", "proto": "static"}},
+			},
+		},
+		PackagesToInstall:   []uci.Package{{Name: "luci"}},
+		PackagesToUninstall: []string{"ppp"},
+	}
+
+	dir := t.TempDir()
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{AuditDir: dir}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "test-router-2024-05-01T123000Z.json"))
+	if err != nil {
+		t.Fatalf("Failed to read audit record: %v", err)
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("Failed to parse audit record: %v", err)
+	}
+
+	if record.Time != "2024-05-01T12:30:00Z" || record.Hostname != "test-router" || record.ModelID != "ubnt,edgerouter-x" {
+		t.Errorf("Unexpected device fields: %+v", record)
+	}
+	if !slices.Contains(record.Commands, "uci set network.lan.proto='static'") {
+		t.Errorf("Expected the applied commands, got %v", record.Commands)
+	}
+	if !slices.Equal(record.PackagesInstalled, []string{"luci"}) || !slices.Equal(record.PackagesRemoved, []string{"ppp"}) {
+		t.Errorf("Unexpected package changes: %v, %v", record.PackagesInstalled, record.PackagesRemoved)
+	}
+	if !strings.HasPrefix(record.ConfigHash, "sha256:") || len(record.ConfigHash) != len("sha256:")+64 {
+		t.Errorf("Unexpected config hash: %s", record.ConfigHash)
+	}
+
+	// Redaction masks the secrets the commands set
+	state.Config["wireless"] = map[string]any{
+		"wifi-iface": []any{map[string]any{".name": "home", "device": "radio0", "ssid": "home", "key": "s3cret-psk"}},
+	}
+	auditNow = func() time.Time { return time.Date(2024, 5, 1, 12, 31, 0, 0, time.UTC) }
+	if err := provisionWithClient(ssh.NewMockClient("ubnt,edgerouter-x"), deviceConfig, state, Options{AuditDir: dir, Redactor: redact.New(nil)}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "test-router-2024-05-01T123100Z.json"))
+	if err != nil {
+		t.Fatalf("Failed to read audit record: %v", err)
+	}
+	if strings.Contains(string(data), "s3cret-psk") || !strings.Contains(string(data), "uci set wireless.home.key='"+redact.Mask+"'") {
+		t.Errorf("Expected the key to be masked in the audit record, got %s", data)
+	}
+}
+
+func TestMergePreservesWifiKeys(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-ap",
+		IPAddr:   "192.168.1.2",
+	}
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"wireless": map[string]any{
+				"wifi-iface": []any{
+					map[string]any{".name": "home", "device": "radio0", "ssid": "home", "encryption": "psk2"},
+					map[string]any{".name": "guest", "device": "radio0", "ssid": "guest", "encryption": "psk2", "key": "new-guest-key"},
+					map[string]any{".name": "office", "device": "radio0", "ssid": "office", "encryption": "psk2"},
+				},
+			},
+		},
+		SkipPackages: true,
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci -q get wireless.home.key"] = "existing-home-key\n"
+	mockClient.Responses["uci -q get wireless.guest.key"] = "old-guest-key\n"
+	mockClient.Responses["uci -q get wireless.office.key"] = "it's'; reboot; '\n"
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{ApplyMode: ApplyModeMerge}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	executed := mockClient.GetExecutedCommands()
+	// The omitted key keeps the device's key
+	if !slices.Contains(executed, "uci set wireless.home.key='existing-home-key'") {
+		t.Errorf("Expected the existing home key to be kept, got %v", executed)
+	}
+	// An explicit key overwrites, without reading the old one
+	if !slices.Contains(executed, "uci set wireless.guest.key='new-guest-key'") {
+		t.Errorf("Expected the guest key to be overwritten, got %v", executed)
+	}
+	if slices.Contains(executed, "uci -q get wireless.guest.key") {
+		t.Error("Expected the explicit key's old value not to be read")
+	}
+	// A key with quotes in it stays one shell argument
+	if !slices.Contains(executed, `uci set wireless.office.key='it'\''s'\''; reboot; '\'''`) {
+		t.Errorf("Expected the office key to be escaped, got %v", executed)
+	}
+	if key := mockClient.GetUCIValue("wireless", "office", "key"); key != "it's'; reboot; '" {
+		t.Errorf("Expected the office key to be kept as it was, got %q", key)
+	}
+}
+
+// TestExportMergeRoundTrip tests that merging a device's exported config
+// back into it sets its anonymous sections instead of adding new ones
+func TestExportMergeRoundTrip(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["uci show dhcp"] = `dhcp.@dnsmasq[0]=dnsmasq
+dhcp.@dnsmasq[0].domainneeded='1'
+dhcp.@dnsmasq[0].domain='lan'
+dhcp.lan=dhcp
+dhcp.lan.interface='lan'
+dhcp.lan.start='100'
+`
+	mockClient.Responses["uci show network"] = `network.lan=interface
+network.lan.proto='static'
+network.@rule[0]=rule
+network.@rule[0].src='192.168.2.0/24'
+network.@rule[0].lookup='100'
+network.@rule6[0]=rule6
+network.@rule6[0].lookup='100'
+`
+
+	oncConfig, err := export.ExportConfigFromClient(mockClient, "", "192.168.1.1", "root", "", export.Options{NoFacts: true})
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	deviceConfig := &oncConfig.Devices[0]
+	state, err := device.GetOpenWrtState(oncConfig, deviceConfig, &device.DeviceSchema{Name: deviceConfig.ModelID})
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	state.SkipPackages = true
+
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{ApplyMode: ApplyModeMerge}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	// Every section set is one the device already has
+	existing := map[string]bool{
+		"dhcp.@dnsmasq[0]": true, "dhcp.lan": true,
+		"network.lan": true, "network.@rule[0]": true, "network.@rule6[0]": true,
+	}
+	executed := mockClient.GetExecutedCommands()
+	for _, cmd := range executed {
+		if strings.HasPrefix(cmd, "uci add ") {
+			t.Errorf("Expected no sections to be added, got %s", cmd)
+		}
+		assignment, ok := strings.CutPrefix(cmd, "uci set ")
+		if !ok {
+			continue
+		}
+		key, _, _ := strings.Cut(assignment, "=")
+		if strings.Count(key, ".") == 1 && !existing[key] {
+			t.Errorf("Expected only existing sections to be set, got %s", cmd)
+		}
+	}
+	if !slices.Contains(executed, "uci set dhcp.@dnsmasq[0].domain='lan'") {
+		t.Errorf("Expected the dnsmasq domain to be set on the existing section, got %v", executed)
+	}
+}
+
+func TestRebootAfter(t *testing.T) {
+	stub(t, &rebootPollInterval, time.Millisecond)
+
+	deviceConfig := &config.DeviceConfig{
+		ModelID:     "ubnt,edgerouter-x",
+		Hostname:    "test-router",
+		IPAddr:      "192.168.1.1",
+		RebootAfter: true,
+	}
+	newState := func() *device.OpenWrtState {
+		return &device.OpenWrtState{
+			Config: map[string]any{
+				"network": map[string]any{
+					"interface": []any{map[string]any{".name": "lan", "proto": "static"}},
+				},
+			},
+			SkipPackages: true,
+		}
+	}
+	const rebootCommand = "(sleep 1; reboot) >/dev/null 2>&1 &"
+
+	// Reconnects see the old uptime until the device has rebooted
+	connects := 0
+	stubDevices(t, nil, func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		connects++
+		if connects == 1 {
+			return nil, fmt.Errorf("connection refused")
+		}
+		client := ssh.NewMockClient(deviceConfig.ModelID)
+		client.Responses["cat /proc/uptime"] = "4000.00 3900.00"
+		if connects > 2 {
+			client.Responses["cat /proc/uptime"] = "12.50 10.00"
+		}
+		return client, nil
+	})
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["cat /proc/uptime"] = "3600.00 3500.00"
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	commands := mockClient.GetExecutedCommands()
+	rebootIndex := slices.Index(commands, rebootCommand)
+	if rebootIndex == -1 || rebootIndex < slices.Index(commands, "uci commit network") {
+		t.Errorf("Expected a reboot after the commit, got %v", commands)
+	}
+	if connects != 3 {
+		t.Errorf("Expected to wait until the device came back rebooted, got %d connects", connects)
+	}
+
+	// A failed apply doesn't reboot
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["cat /proc/uptime"] = "3600.00 3500.00"
+	mockClient.FailOnCommand = "uci commit network"
+	if err := provisionWithClient(mockClient, deviceConfig, newState(), Options{}); err == nil {
+		t.Fatal("Expected provisioning to fail")
+	}
+	if slices.Contains(mockClient.GetExecutedCommands(), rebootCommand) {
+		t.Error("Expected no reboot after a failed commit")
+	}
+}
+
+func TestInstallSkipsDependencies(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{
+		Config: map[string]any{},
+		PackagesToInstall: []uci.Package{
+			{Name: "luci-app-a", Priority: 0},
+			{Name: "luci-lib-b", Priority: 1},
+			{Name: "luci-app-c", Priority: 1},
+		},
+	}
+
+	// Installing luci-app-a pulls in luci-lib-b
+	installed := []string{"base-files", "opkg"}
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	boardJSON, _ := mockClient.Execute("cat /etc/board.json")
+	mockClient.OnExecute = func(command string) (string, error) {
+		switch {
+		case command == "cat /etc/board.json":
+			return boardJSON, nil
+		case command == "opkg list-installed":
+			var output strings.Builder
+			for _, name := range installed {
+				fmt.Fprintf(&output, "%s - 1.0.0\n", name)
+			}
+			return output.String(), nil
+		case command == "opkg install luci-app-a":
+			installed = append(installed, "luci-app-a", "luci-lib-b")
+		case strings.HasPrefix(command, "opkg install "):
+			for _, name := range strings.Fields(strings.TrimPrefix(command, "opkg install ")) {
+				if slices.Contains(installed, name) {
+					return "", fmt.Errorf("%s is already installed", name)
+				}
+				installed = append(installed, name)
+			}
+		}
+		return "", nil
+	}
+
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	var installs []string
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if strings.HasPrefix(cmd, "opkg install ") {
+			installs = append(installs, cmd)
+		}
+	}
+	expected := []string{"opkg install luci-app-a", "opkg install luci-app-c"}
+	if !slices.Equal(installs, expected) {
+		t.Errorf("Expected installs %v, got %v", expected, installs)
+	}
+}
+
+// stubResolver resolves host names from a map
+type stubResolver map[string]string
+
+func (r stubResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if address, ok := r[host]; ok {
+		return []string{address}, nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func TestProvisionByHostName(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:            "ubnt,edgerouter-x",
+				Hostname:           "test-router",
+				IPAddr:             "router.lan",
+				ProvisioningConfig: &config.ProvisioningConfig{},
+			},
+		},
+	}
+
+	// Connect like ssh.Connect, dialing the address the name resolves to
+	stub[ssh.HostResolver](t, &ssh.Resolver, stubResolver{"router.lan": "192.168.1.1"})
+	stubDevices(t, modelSchema, func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		address, err := ssh.ResolveHost(deviceConfig.IPAddr)
+		if err != nil {
+			return nil, err
+		}
+		client := ssh.NewMockClient(deviceConfig.ModelID)
+		client.RemoteAddress = &net.TCPAddr{IP: net.ParseIP(address), Port: 22}
+		return client, nil
+	})
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	dir := t.TempDir()
+	err = ProvisionConfig(oncConfig, Options{AuditDir: dir})
+	w.Close()
+	os.Stdout = stdout
+	output, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	if !strings.Contains(string(output), "Connected to router.lan (192.168.1.1:22).") {
+		t.Errorf("Expected the resolved address to be logged:\n%s", output)
+	}
+
+	// The device is still identified by its host name
+	paths, _ := filepath.Glob(filepath.Join(dir, "test-router-*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("Expected an audit record, got %v", paths)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record AuditRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("Failed to parse audit record: %v", err)
+	}
+	if record.IPAddr != "router.lan" {
+		t.Errorf("Expected the audit record to keep the host name, got %s", record.IPAddr)
+	}
+
+	// A name that doesn't resolve fails to connect
+	oncConfig.Devices[0].IPAddr = "missing.lan"
+	if err := ProvisionConfig(oncConfig, Options{}); err == nil || !strings.Contains(err.Error(), "no such host missing.lan") {
+		t.Errorf("Expected a resolution error, got %v", err)
+	}
+}
+
+// TestDryRun tests that a dry run verifies the device and prints its script
+// without running it
+func TestDryRun(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"system": map[string]any{
+				"system": []any{
+					map[string]any{".name": "system", "hostname": "test-router"},
+				},
+			},
+		},
+		PackagesToInstall: []uci.Package{
+			{Name: "luci"},
+			{Name: "ppp-mod-pppoe"},
+		},
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses["opkg list-installed"] = "ppp-mod-pppoe - 2.4.9-1\n"
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	err = provisionWithClient(mockClient, deviceConfig, state, Options{DryRun: true})
+	w.Close()
+	os.Stdout = stdout
+	output, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+
+	executed := mockClient.GetExecutedCommands()
+	if !slices.Contains(executed, "cat /etc/board.json") {
+		t.Errorf("Expected the device to be verified, got %v", executed)
+	}
+	if !slices.Contains(executed, "opkg list-installed") {
+		t.Errorf("Expected the installed packages to be read, got %v", executed)
+	}
+	for _, cmd := range executed {
+		if strings.HasPrefix(cmd, "uci set") || strings.HasPrefix(cmd, "uci commit") || strings.HasPrefix(cmd, "opkg install") || cmd == "reload_config" {
+			t.Errorf("Unexpected command run in a dry run: %s", cmd)
+		}
+	}
+
+	printed := string(output)
+	if !strings.Contains(printed, "# test-router (192.168.1.1):") {
+		t.Errorf("Expected a header for the device:\n%s", printed)
+	}
+	if !strings.Contains(printed, "uci commit") {
+		t.Errorf("Expected the script to be printed:\n%s", printed)
+	}
+	if !strings.Contains(printed, "opkg install luci") || strings.Contains(printed, "ppp-mod-pppoe") {
+		t.Errorf("Expected only missing packages to be installed:\n%s", printed)
+	}
+}
+
+// TestProvisionInvalidCondition tests that a typo in a condition fails the
+// device with the condition named, instead of panicking
+func TestProvisionInvalidCondition(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:            "ubnt,edgerouter-x",
+				Hostname:           "router",
+				IPAddr:             "192.168.1.1",
+				Tags:               map[string]any{"role": "router"},
+				ProvisioningConfig: &config.ProvisioningConfig{},
+			},
+		},
+		PackageProfiles: []config.PackageProfile{
+			{If: stringPtr("device.tag.rol == 'router'"), Packages: []string{"tcpdump"}},
+		},
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	stubDevices(t, modelSchema, func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		return mockClient, nil
+	})
+
+	err := ProvisionConfig(oncConfig, Options{})
+	expected := `failed to provision device router: invalid condition in config: "device.tag.rol == 'router'": Invalid conditional parameter: device.tag.rol`
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+	for _, cmd := range mockClient.GetExecutedCommands() {
+		if strings.HasPrefix(cmd, "uci set") || strings.HasPrefix(cmd, "opkg install") {
+			t.Errorf("Unexpected command after an invalid condition: %s", cmd)
+		}
+	}
+}
+
+func TestRedactLogs(t *testing.T) {
+	const key = "hunter2-wifi-key"
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-ap",
+		IPAddr:   "192.168.1.2",
+	}
+	newState := func() *device.OpenWrtState {
+		return &device.OpenWrtState{
+			Config: map[string]any{
+				"wireless": map[string]any{
+					"wifi-iface": []any{
+						map[string]any{".name": "home", "ssid": "home", "encryption": "psk2", "key": key},
+					},
+				},
+			},
+		}
+	}
+
+	capture := func(run func() error) (string, error) {
+		stdout := os.Stdout
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = w
+		err = run()
+		w.Close()
+		os.Stdout = stdout
+		output, _ := io.ReadAll(r)
+		return string(output), err
+	}
+
+	// Dry run
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	printed, err := capture(func() error {
+		return provisionWithClient(mockClient, deviceConfig, newState(), Options{DryRun: true, Redactor: redact.New(nil)})
+	})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if strings.Contains(printed, key) || !strings.Contains(printed, "uci set wireless.home.key='"+redact.Mask+"'") {
+		t.Errorf("Expected the key to be masked in the dry run:\n%s", printed)
+	}
+
+	// A failing command whose error output and the device log repeat the key
+	var logged strings.Builder
+	stub[io.Writer](t, &logOutput, &logged)
+	stub(t, &logSettleTime, 0)
+
+	setKey := "uci set wireless.home.key='" + key + "'"
+	base := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient = ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.StreamOutput[logreadCommand] = []string{"hostapd: wlan0: invalid passphrase " + key}
+	mockClient.OnExecute = func(command string) (string, error) {
+		if command == setKey {
+			return "uci: Invalid argument " + key, fmt.Errorf("exit status 1")
+		}
+		return base.Execute(command)
+	}
+
+	printed, err = capture(func() error {
+		return provisionWithClient(mockClient, deviceConfig, newState(), Options{FollowLog: true, Redactor: redact.New(nil)})
+	})
+	if err == nil {
+		t.Fatal("Expected the failing command to fail provisioning")
+	}
+	for name, output := range map[string]string{"output": printed, "log": logged.String(), "error": err.Error()} {
+		if strings.Contains(output, key) {
+			t.Errorf("Expected the key to be masked in the %s:\n%s", name, output)
+		}
+		if !strings.Contains(output, redact.Mask) {
+			t.Errorf("Expected a mask in the %s:\n%s", name, output)
+		}
+	}
+
+	// The device is still sent the real key
+	if !slices.Contains(mockClient.GetExecutedCommands(), setKey) {
+		t.Errorf("Expected the real key to be set, got %v", mockClient.GetExecutedCommands())
+	}
+}
+
+// TestNewListOption tests that a list option the device doesn't have yet is
+// cleared without failing the run, as uci fails to delete missing options
+func TestNewListOption(t *testing.T) {
+	deviceConfig := &config.DeviceConfig{
+		ModelID:  "ubnt,edgerouter-x",
+		Hostname: "test-router",
+		IPAddr:   "192.168.1.1",
+	}
+	state := &device.OpenWrtState{
+		Config: map[string]any{
+			"network": map[string]any{
+				"interface": []any{
+					map[string]any{".name": "lan", "proto": "static", "dns": []any{"1.1.1.1", "9.9.9.9"}},
+				},
+			},
+		},
+	}
+
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	if _, err := mockClient.Execute("uci -q delete network.lan.dns"); err == nil {
+		t.Fatal("Expected the mock to fail deleting a missing option, like uci")
+	}
+
+	if err := provisionWithClient(mockClient, deviceConfig, state, Options{}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	if got := mockClient.GetUCIValue("network", "lan", "dns"); got != "1.1.1.1 9.9.9.9" {
+		t.Errorf("Expected the dns list to be set, got %q", got)
+	}
+}
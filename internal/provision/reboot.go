@@ -0,0 +1,86 @@
+package provision
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+// rebootPollInterval is how often waitForReboot tries to reconnect, and
+// rebootTimeout how long it waits for the device, replaced in tests
+var (
+	rebootPollInterval = 5 * time.Second
+	rebootTimeout      = 5 * time.Minute
+)
+
+// rebootDevice reboots the device and waits until it is back with a lower
+// uptime, so a device that didn't actually reboot isn't taken as done
+func rebootDevice(client ssh.SSHExecutor, deviceConfig *config.DeviceConfig) error {
+	before, err := readUptime(client)
+	if err != nil {
+		return fmt.Errorf("failed to read uptime: %w", err)
+	}
+
+	// Reboot in the background so the command returns before the
+	// connection drops
+	if _, err := client.Execute("(sleep 1; reboot) >/dev/null 2>&1 &"); err != nil {
+		return fmt.Errorf("failed to reboot: %w", err)
+	}
+
+	return waitForReboot(deviceConfig, before)
+}
+
+// waitForReboot reconnects to the device until it reports an uptime lower
+// than before or rebootTimeout elapses
+func waitForReboot(deviceConfig *config.DeviceConfig, before float64) error {
+	deadline := time.Now().Add(rebootTimeout)
+	for {
+		time.Sleep(rebootPollInterval)
+
+		if client, err := connect(deviceConfig); err == nil {
+			uptime, err := readUptime(client)
+			client.Close()
+			if err == nil && uptime < before {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("device not back after rebooting for %s", rebootTimeout)
+		}
+	}
+}
+
+// readUptime returns the device's uptime in seconds
+func readUptime(client ssh.SSHExecutor) (float64, error) {
+	output, err := client.Execute("cat /proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected uptime %q", output)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// kernelModuleChanges returns the kmod packages the state installs or
+// removes, which may need a reboot to take effect
+func kernelModuleChanges(state *device.OpenWrtState) []string {
+	var modules []string
+	for _, pkg := range state.PackagesToInstall {
+		if strings.HasPrefix(pkg.Name, "kmod-") {
+			modules = append(modules, pkg.Name)
+		}
+	}
+	for _, name := range state.PackagesToUninstall {
+		if strings.HasPrefix(name, "kmod-") {
+			modules = append(modules, name)
+		}
+	}
+	return modules
+}
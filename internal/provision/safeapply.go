@@ -0,0 +1,78 @@
+package provision
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// rollbackDir is where the device's config is backed up while a guarded
+// apply waits to be confirmed
+const rollbackDir = "/tmp/openwrt-configurator-rollback"
+
+// rollbackTimeout is how long the device waits for a guarded apply to be
+// confirmed before restoring its previous config, and confirmPollInterval
+// how often we try to reconnect to confirm it, replaced in tests
+var (
+	rollbackTimeout     = 3 * time.Minute
+	confirmPollInterval = 5 * time.Second
+)
+
+// needsRollback reports whether the commands can cut us off from the
+// device. A direct session only depends on the management interface, while
+// a routed one can lose its route with any network change.
+func needsRollback(managementInterface string, routed bool, commands []string) bool {
+	if managementInterface == "" {
+		return false
+	}
+	for _, cmd := range commands {
+		if routed && uci.CommandConfig(cmd) == "network" {
+			return true
+		}
+		if uci.IsSectionCommand(cmd, "network."+managementInterface) {
+			return true
+		}
+	}
+	return false
+}
+
+// armRollback backs up the device's committed config and starts a timer
+// on the device that restores it and reloads, returning the timer's pid
+func armRollback(client ssh.SSHExecutor) (string, error) {
+	if output, err := client.ExecuteWithError(fmt.Sprintf("rm -rf %[1]s && cp -a /etc/config %[1]s", rollbackDir)); err != nil {
+		return "", fmt.Errorf("failed to back up the config: %s", strings.TrimSpace(output))
+	}
+
+	output, err := client.ExecuteWithError(fmt.Sprintf("(sleep %d; cp %s/* /etc/config/ && reload_config) >/dev/null 2>&1 & echo $!", int(rollbackTimeout.Seconds()), rollbackDir))
+	pid := strings.TrimSpace(output)
+	if err != nil || pid == "" {
+		return "", fmt.Errorf("failed to start the rollback timer: %s", pid)
+	}
+	return pid, nil
+}
+
+// confirmApply reconnects to the device until it answers, then cancels the
+// rollback. A device that can't be reached in time restores its previous
+// config by itself.
+func confirmApply(deviceConfig *config.DeviceConfig, pid string, armedAt time.Time) error {
+	deadline := armedAt.Add(rollbackTimeout)
+	for {
+		time.Sleep(confirmPollInterval)
+
+		if client, err := connect(deviceConfig); err == nil {
+			output, err := client.ExecuteWithError(fmt.Sprintf("kill %s && rm -rf %s", pid, rollbackDir))
+			client.Close()
+			if err != nil {
+				return fmt.Errorf("failed to cancel the rollback, the device may have restored its previous config: %s", strings.TrimSpace(output))
+			}
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("device not reachable within %s of applying, it restores its previous config", rollbackTimeout)
+		}
+	}
+}
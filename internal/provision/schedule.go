@@ -0,0 +1,201 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// checkDependencies checks that every depends_on entry names another
+// enabled device and that the dependencies don't form a cycle
+func checkDependencies(devices []config.DeviceConfig) error {
+	byHostname := make(map[string]int)
+	for i, dev := range devices {
+		if dev.Hostname != "" {
+			byHostname[dev.Hostname] = i
+		}
+	}
+
+	for _, dev := range devices {
+		for _, dep := range dev.DependsOn {
+			if _, ok := byHostname[dep]; !ok {
+				return fmt.Errorf("device %s depends on unknown device %s", dev.Hostname, dep)
+			}
+		}
+	}
+
+	// Depth-first search, tracking the current path to report the cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(devices))
+	var path []string
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for j, hostname := range path {
+				if hostname == devices[i].Hostname {
+					start = j
+				}
+			}
+			cycle := append(path[start:], devices[i].Hostname)
+			return fmt.Errorf("device dependency cycle: %s", strings.Join(cycle, " -> "))
+		}
+
+		state[i] = visiting
+		path = append(path, devices[i].Hostname)
+		for _, dep := range devices[i].DependsOn {
+			if err := visit(byHostname[dep]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+
+		return nil
+	}
+
+	for i := range devices {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scheduleDevices runs run for every device, at most parallel at a time,
+// starting a device only once all the devices it depends on have finished.
+// Ready devices start in config order. After the first failure no more
+// devices are started and the error is returned once running ones finish,
+// unless keepGoing is set: then every device that can run does, and the
+// errors of failed devices and of the devices waiting on them are joined in
+// config order.
+func scheduleDevices(devices []config.DeviceConfig, parallel int, keepGoing bool, run func(dev *config.DeviceConfig) error) error {
+	if err := checkDependencies(devices); err != nil {
+		return err
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	type result struct {
+		index int
+		err   error
+	}
+
+	done := make(map[string]bool)
+	started := make([]bool, len(devices))
+	errs := make([]error, len(devices))
+	results := make(chan result)
+	running := 0
+	remaining := len(devices)
+	var firstErr error
+
+	ready := func(dev *config.DeviceConfig) bool {
+		for _, dep := range dev.DependsOn {
+			if !done[dep] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for remaining > 0 {
+		if firstErr == nil || keepGoing {
+			for i := range devices {
+				if running >= parallel {
+					break
+				}
+				if started[i] || !ready(&devices[i]) {
+					continue
+				}
+				started[i] = true
+				running++
+				go func(i int) {
+					results <- result{index: i, err: run(&devices[i])}
+				}(i)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		res := <-results
+		running--
+		remaining--
+		if res.err != nil {
+			errs[res.index] = res.err
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		done[devices[res.index].Hostname] = true
+	}
+
+	if !keepGoing {
+		return firstErr
+	}
+
+	// Devices never started are waiting on one that failed
+	for i := range devices {
+		if started[i] {
+			continue
+		}
+		for _, dep := range devices[i].DependsOn {
+			if !done[dep] {
+				errs[i] = fmt.Errorf("skipped device %s: depends on %s, which wasn't provisioned", devices[i].Hostname, dep)
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rateLimiter paces events to at most rate per second, e.g. SSH
+// connections when provisioning in parallel
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate events per second, or nil,
+// which never waits, when rate isn't positive
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until the next event is allowed
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(slot))
+}
@@ -0,0 +1,251 @@
+package provision
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/condition"
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+// TestDependsOnOrder tests that a device is provisioned after the devices
+// it depends on, even when listed first and provisioning in parallel
+func TestDependsOnOrder(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{
+				ModelID:            "ubnt,edgerouter-x",
+				Hostname:           "ap",
+				IPAddr:             "192.168.1.2",
+				ProvisioningConfig: &config.ProvisioningConfig{},
+				DependsOn:          []string{"router"},
+			},
+			{
+				ModelID:            "ubnt,edgerouter-x",
+				Hostname:           "router",
+				IPAddr:             "192.168.1.1",
+				ProvisioningConfig: &config.ProvisioningConfig{},
+			},
+		},
+		Config: config.ConfigConfig{
+			System: &config.SystemConfig{
+				System: []config.SystemSection{
+					{
+						Name:     stringPtr("system"),
+						Hostname: stringPtr("${device.hostname}"),
+					},
+				},
+			},
+		},
+	}
+
+	schemaFn := func(deviceConfig *config.DeviceConfig) (*device.DeviceSchema, error) {
+		return &device.DeviceSchema{
+			Name:           deviceConfig.ModelID,
+			ConfigSections: map[string][]string{"system": {"system"}},
+		}, nil
+	}
+
+	var mu sync.Mutex
+	var order []string
+	clients := make(map[string]*ssh.MockClient)
+	stubDevices(t, schemaFn, func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if deviceConfig.Hostname == "ap" {
+			router := clients["router"]
+			if router == nil {
+				return nil, fmt.Errorf("ap connected before router")
+			}
+			if !strings.Contains(strings.Join(router.GetExecutedCommands(), "\n"), "reload_config") {
+				return nil, fmt.Errorf("ap connected before router finished")
+			}
+		}
+
+		order = append(order, deviceConfig.Hostname)
+		client := ssh.NewMockClient(deviceConfig.ModelID)
+		clients[deviceConfig.Hostname] = client
+		return client, nil
+	})
+
+	if err := ProvisionConfig(oncConfig, Options{Parallel: 2}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	if strings.Join(order, ",") != "router,ap" {
+		t.Errorf("Expected router then ap, got %v", order)
+	}
+}
+
+// TestDependsOnCycle tests that dependency cycles and unknown devices are rejected
+func TestDependsOnCycle(t *testing.T) {
+	devices := []config.DeviceConfig{
+		{Hostname: "router", DependsOn: []string{"ap2"}},
+		{Hostname: "ap1", DependsOn: []string{"router"}},
+		{Hostname: "ap2", DependsOn: []string{"ap1"}},
+	}
+
+	err := checkDependencies(devices)
+	if err == nil || !strings.Contains(err.Error(), "router -> ap2 -> ap1 -> router") {
+		t.Errorf("Expected cycle error, got %v", err)
+	}
+
+	devices = []config.DeviceConfig{
+		{Hostname: "ap", DependsOn: []string{"missing"}},
+	}
+	if err := checkDependencies(devices); err == nil || !strings.Contains(err.Error(), "unknown device missing") {
+		t.Errorf("Expected unknown device error, got %v", err)
+	}
+}
+
+// TestRateLimit tests that connections are spaced by the configured rate
+// even when provisioning in parallel
+func TestRateLimit(t *testing.T) {
+	oncConfig := &config.ONCConfig{}
+	for i := 0; i < 4; i++ {
+		oncConfig.Devices = append(oncConfig.Devices, config.DeviceConfig{
+			ModelID:            "ubnt,edgerouter-x",
+			Hostname:           fmt.Sprintf("ap%d", i),
+			IPAddr:             fmt.Sprintf("192.168.1.%d", i+2),
+			ProvisioningConfig: &config.ProvisioningConfig{},
+		})
+	}
+
+	var mu sync.Mutex
+	var times []time.Time
+	stubDevices(t, modelSchema, func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		return ssh.NewMockClient(deviceConfig.ModelID), nil
+	})
+
+	const rate = 20
+	if err := ProvisionConfig(oncConfig, Options{Parallel: 4, RateLimit: rate}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+
+	if len(times) != 4 {
+		t.Fatalf("Expected 4 connections, got %d", len(times))
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	// Allow for timer slack
+	minGap := time.Second/rate - 5*time.Millisecond
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < minGap {
+			t.Errorf("Connection %d came %v after the previous one, expected at least %v", i, gap, minGap)
+		}
+	}
+}
+
+// TestDeviceErrorsContinue tests that with -on-device-error continue a
+// failing device doesn't stop the others, and every failure is reported
+func TestDeviceErrorsContinue(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "ubnt,edgerouter-x", Hostname: "ap-1", IPAddr: "192.168.1.2"},
+			{ModelID: "ubnt,edgerouter-x", Hostname: "router", IPAddr: "192.168.1.1"},
+			{ModelID: "unknown,model", Hostname: "ap-2", IPAddr: "192.168.1.3"},
+			{ModelID: "ubnt,edgerouter-x", Hostname: "ap-3", IPAddr: "192.168.1.4", DependsOn: []string{"ap-1"}},
+		},
+	}
+	for i := range oncConfig.Devices {
+		oncConfig.Devices[i].ProvisioningConfig = &config.ProvisioningConfig{}
+	}
+
+	schemaFn := func(deviceConfig *config.DeviceConfig) (*device.DeviceSchema, error) {
+		if deviceConfig.ModelID == "unknown,model" {
+			return nil, fmt.Errorf("no schema")
+		}
+		return &device.DeviceSchema{Name: deviceConfig.ModelID}, nil
+	}
+
+	var mu sync.Mutex
+	var connected []string
+	stubDevices(t, schemaFn, func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if deviceConfig.Hostname == "ap-1" {
+			return nil, fmt.Errorf("connection refused")
+		}
+		connected = append(connected, deviceConfig.Hostname)
+		return ssh.NewMockClient(deviceConfig.ModelID), nil
+	})
+
+	// By default the first failure stops the run
+	if err := ProvisionConfig(oncConfig, Options{}); err == nil || !strings.Contains(err.Error(), "no schema") {
+		t.Fatalf("Expected the schema error to stop provisioning, got %v", err)
+	}
+	if len(connected) != 0 {
+		t.Errorf("Expected no device to be provisioned, got %v", connected)
+	}
+
+	err := ProvisionConfig(oncConfig, Options{DeviceErrors: DeviceErrorContinue})
+	if err == nil {
+		t.Fatal("Expected the failed devices to be reported")
+	}
+	if strings.Join(connected, ",") != "router" {
+		t.Errorf("Expected the router to be provisioned, got %v", connected)
+	}
+
+	expected := []string{
+		"3 of 4 device(s) failed:",
+		"failed to provision device ap-1: failed to connect: connection refused",
+		"failed to provision device ap-2: failed to get device schema for unknown,model: no schema",
+		"skipped device ap-3: depends on ap-1, which wasn't provisioned",
+	}
+	if err.Error() != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected error:\n%v", err)
+	}
+
+	if err := ProvisionConfig(oncConfig, Options{DeviceErrors: "ignore"}); err == nil || !strings.Contains(err.Error(), "invalid device error mode") {
+		t.Errorf("Expected an invalid mode error, got %v", err)
+	}
+}
+
+// TestProvisionByTag tests that only devices with matching tags are
+// provisioned, without waiting on unselected devices they depend on
+func TestProvisionByTag(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "ubnt,edgerouter-x", Hostname: "router", IPAddr: "192.168.1.1", Tags: map[string]any{"role": "router"}},
+			{ModelID: "ubnt,edgerouter-x", Hostname: "ap-1", IPAddr: "192.168.1.2", Tags: map[string]any{"role": "ap"}, DependsOn: []string{"router"}},
+			{ModelID: "ubnt,edgerouter-x", Hostname: "ap-2", IPAddr: "192.168.1.3", Tags: map[string]any{"role": "ap"}, DependsOn: []string{"ap-1"}},
+		},
+	}
+	for i := range oncConfig.Devices {
+		oncConfig.Devices[i].ProvisioningConfig = &config.ProvisioningConfig{}
+	}
+
+	var connected []string
+	stubDevices(t, modelSchema, func(deviceConfig *config.DeviceConfig) (ssh.SSHExecutor, error) {
+		connected = append(connected, deviceConfig.Hostname)
+		return ssh.NewMockClient(deviceConfig.ModelID), nil
+	})
+
+	tags := []condition.TagFilter{{Key: "role", Value: "ap"}}
+	if err := ProvisionConfig(oncConfig, Options{Tags: tags}); err != nil {
+		t.Fatalf("Provisioning failed: %v", err)
+	}
+	if strings.Join(connected, ",") != "ap-1,ap-2" {
+		t.Errorf("Expected only the APs, in dependency order, got %v", connected)
+	}
+
+	// The config itself is left alone
+	if len(oncConfig.Devices[1].DependsOn) != 1 {
+		t.Errorf("Expected the config's depends_on to be kept, got %v", oncConfig.Devices[1].DependsOn)
+	}
+
+	tags = []condition.TagFilter{{Key: "role", Value: "switch"}}
+	if err := ProvisionConfig(oncConfig, Options{Tags: tags}); err == nil || !strings.Contains(err.Error(), "no enabled device matches role=switch") {
+		t.Errorf("Expected an error when no device matches, got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/secret"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// Mask replaces secret values in output
+const Mask = "********"
+
+// minSecretLength is the shortest value masked wherever it appears in text.
+// Shorter values are still masked in their own options, but masking every
+// "1" in a log would make it unreadable.
+const minSecretLength = 4
+
+// DefaultSensitiveOptions are the uci options holding secrets, as
+// config.section.option paths where * matches any section
+var DefaultSensitiveOptions = []string{
+	"wireless.*.key",
+	"wireless.*.key1",
+	"wireless.*.key2",
+	"wireless.*.key3",
+	"wireless.*.key4",
+	"wireless.*.sae_password",
+	"wireless.*.password",
+	"wireless.*.auth_secret",
+	"wireless.*.acct_secret",
+	"wireless.*.priv_key_pwd",
+	"network.*.password",
+	"network.*.private_key",
+	"network.*.preshared_key",
+	"network.*.key",
+	"ddns.*.password",
+}
+
+// Redactor masks secret values in output. The values of sensitive options
+// are masked where they are printed with their path, and once seen are
+// masked wherever else they appear, e.g. in a device's error output. A nil
+// Redactor masks nothing, so output is unchanged when redaction is off.
+type Redactor struct {
+	paths []string
+
+	mu     sync.Mutex
+	values map[string]bool
+}
+
+// New returns a redactor for the default sensitive options and the given
+// extra paths
+func New(extraPaths []string) *Redactor {
+	return &Redactor{
+		paths:  append(append([]string{}, DefaultSensitiveOptions...), extraPaths...),
+		values: make(map[string]bool),
+	}
+}
+
+// IsSensitive reports whether the option at fieldPath, config.section.option,
+// holds a secret
+func (r *Redactor) IsSensitive(fieldPath string) bool {
+	if r == nil {
+		return false
+	}
+	for _, pattern := range r.paths {
+		if matchPath(pattern, fieldPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches a config.section.option path against a pattern, where *
+// matches any one part
+func matchPath(pattern, fieldPath string) bool {
+	patternParts := strings.Split(pattern, ".")
+	pathParts := strings.SplitN(fieldPath, ".", len(patternParts))
+	if len(pathParts) != len(patternParts) {
+		return false
+	}
+	for i, part := range patternParts {
+		if part != "*" && part != pathParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Redact returns value, or Mask when fieldPath holds a secret, which is
+// then masked wherever else it appears
+func (r *Redactor) Redact(value, fieldPath string) string {
+	if value == "" || !r.IsSensitive(fieldPath) {
+		return value
+	}
+	r.Secret(value)
+	return Mask
+}
+
+// Secret masks a value wherever it appears, e.g. a resolved ${secret.<name>}
+func (r *Redactor) Secret(value string) {
+	if r == nil || value == "" {
+		return
+	}
+	r.mu.Lock()
+	r.values[value] = true
+	r.mu.Unlock()
+}
+
+// Text masks the secret values seen so far in s
+func (r *Redactor) Text(s string) string {
+	if r == nil {
+		return s
+	}
+	r.mu.Lock()
+	values := make([]string, 0, len(r.values))
+	for value := range r.values {
+		if len(value) >= minSecretLength {
+			values = append(values, value)
+		}
+	}
+	r.mu.Unlock()
+
+	// Longer values first, so a secret containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, Mask)
+	}
+	return s
+}
+
+// Error returns err with the secret values in its message masked
+func (r *Redactor) Error(err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	if message := r.Text(err.Error()); message != err.Error() {
+		return errors.New(message)
+	}
+	return err
+}
+
+// Command masks the value a uci set or add_list command gives a sensitive
+// option, and any secrets seen elsewhere in it
+func (r *Redactor) Command(cmd string) string {
+	if r == nil {
+		return cmd
+	}
+	for _, prefix := range []string{"uci set ", "uci add_list "} {
+		assignment, ok := strings.CutPrefix(cmd, prefix)
+		if !ok {
+			continue
+		}
+		fieldPath, value, ok := strings.Cut(assignment, "=")
+		if ok && r.IsSensitive(fieldPath) {
+			r.Secret(strings.Join(uci.ParseShowValue(value), " "))
+			return prefix + fieldPath + "='" + Mask + "'"
+		}
+	}
+	return r.Text(cmd)
+}
+
+// Collect finds the values of sensitive options in a resolved config, laid
+// out as config -> section type -> sections, so they are masked wherever
+// they appear. An option is sensitive by its section's name or type.
+func (r *Redactor) Collect(configs map[string]any) {
+	if r == nil {
+		return
+	}
+	for configKey, configValue := range configs {
+		sectionTypes, _ := configValue.(map[string]any)
+		for sectionType, sectionsValue := range sectionTypes {
+			sections, _ := sectionsValue.([]any)
+			for _, section := range sections {
+				sectionMap, _ := section.(map[string]any)
+				name, _ := sectionMap[".name"].(string)
+				for option, value := range sectionMap {
+					if strings.HasPrefix(option, ".") {
+						continue
+					}
+					if !r.IsSensitive(configKey+"."+sectionType+"."+option) && (name == "" || !r.IsSensitive(configKey+"."+name+"."+option)) {
+						continue
+					}
+					values, ok := value.([]any)
+					if !ok {
+						values = []any{value}
+					}
+					for _, v := range values {
+						r.Secret(fmt.Sprintf("%v", v))
+					}
+				}
+			}
+		}
+	}
+}
+
+// Resolver wraps a secret resolver so the secrets it resolves are masked
+func (r *Redactor) Resolver(resolve secret.Resolver) secret.Resolver {
+	if r == nil {
+		return resolve
+	}
+	return func(name string) (string, bool, error) {
+		value, ok, err := resolve(name)
+		if ok {
+			r.Secret(value)
+		}
+		return value, ok, err
+	}
+}
@@ -0,0 +1,84 @@
+package redact
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := New([]string{"ddns.myddns.secret"})
+
+	if got := r.Redact("s3cret-psk", "wireless.home.key"); got != Mask {
+		t.Errorf("Expected a Wi-Fi key to be masked, got %s", got)
+	}
+	if got := r.Redact("home", "wireless.home.ssid"); got != "home" {
+		t.Errorf("Expected an ssid to be left alone, got %s", got)
+	}
+	if !r.IsSensitive("ddns.myddns.secret") || r.IsSensitive("ddns.other.secret") {
+		t.Error("Expected the configured path to be sensitive, and only it")
+	}
+
+	// Values seen once are masked wherever they appear
+	if got := r.Text("hostapd: bad passphrase s3cret-psk"); got != "hostapd: bad passphrase "+Mask {
+		t.Errorf("Expected the key to be masked in text, got %s", got)
+	}
+	if got := r.Error(errors.New("failed: s3cret-psk")).Error(); got != "failed: "+Mask {
+		t.Errorf("Expected the key to be masked in errors, got %s", got)
+	}
+
+	commands := map[string]string{
+		"uci set wireless.guest.key='guest-psk'":     "uci set wireless.guest.key='" + Mask + "'",
+		"uci add_list network.wg0.private_key='abc'": "uci add_list network.wg0.private_key='" + Mask + "'",
+		"uci set wireless.guest.ssid='guest'":        "uci set wireless.guest.ssid='guest'",
+		"uci commit wireless":                        "uci commit wireless",
+	}
+	for cmd, expected := range commands {
+		if got := r.Command(cmd); got != expected {
+			t.Errorf("%s: expected %s, got %s", cmd, expected, got)
+		}
+	}
+
+	// An escaped value is masked as the value uci sees
+	r.Command(`uci set wireless.office.key='it'\''s-a-key'`)
+	if got := r.Text("bad passphrase it's-a-key"); got != "bad passphrase "+Mask {
+		t.Errorf("Expected the unescaped key to be masked, got %s", got)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	r := New([]string{"ddns.service.password"})
+	r.Collect(map[string]any{
+		"wireless": map[string]any{
+			"wifi-iface": []any{map[string]any{".name": "home", "ssid": "home", "key": "home-psk-1"}},
+		},
+		// Matched by section type
+		"ddns": map[string]any{
+			"service": []any{map[string]any{".name": "myddns", "password": "ddns-pass"}},
+		},
+	})
+
+	got := r.Text("home home-psk-1 ddns-pass")
+	if got != "home "+Mask+" "+Mask {
+		t.Errorf("Expected the collected values to be masked, got %s", got)
+	}
+
+	resolve := r.Resolver(func(name string) (string, bool, error) { return "from-vault", true, nil })
+	if _, _, err := resolve("wifi"); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Text("value from-vault"); !strings.Contains(got, Mask) {
+		t.Errorf("Expected a resolved secret to be masked, got %s", got)
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *Redactor
+	if got := r.Command("uci set wireless.home.key='psk'"); got != "uci set wireless.home.key='psk'" {
+		t.Errorf("Expected a nil redactor to leave commands alone, got %s", got)
+	}
+	r.Collect(map[string]any{})
+	if got := r.Text("psk"); got != "psk" {
+		t.Errorf("Expected a nil redactor to leave text alone, got %s", got)
+	}
+}
@@ -0,0 +1,88 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Severity levels for findings
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// Finding is a single validation finding or config change for a device
+type Finding struct {
+	Severity string `json:"severity"`
+	Rule     string `json:"rule,omitempty"`
+	Device   string `json:"device"`
+	Config   string `json:"config,omitempty"`
+	Section  string `json:"section,omitempty"`
+	Message  string `json:"message"`
+}
+
+// String formats the finding for human-readable output
+func (f Finding) String() string {
+	location := f.Device
+	if f.Config != "" {
+		location += ": " + f.Config
+		if f.Section != "" {
+			location += "." + f.Section
+		}
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Severity, location, f.Message)
+}
+
+// WriteJSONLine writes the finding as a single line of JSON
+func WriteJSONLine(w io.Writer, f Finding) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// HasErrors reports whether any finding has error severity
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Suppress drops the findings of the given rules
+func Suppress(findings []Finding, rules []string) []Finding {
+	if len(rules) == 0 {
+		return findings
+	}
+
+	suppressed := make(map[string]bool)
+	for _, rule := range rules {
+		suppressed[rule] = true
+	}
+
+	var kept []Finding
+	for _, f := range findings {
+		if !suppressed[f.Rule] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// Strict promotes warnings to errors, so a clean config can be enforced
+func Strict(findings []Finding) []Finding {
+	promoted := make([]Finding, len(findings))
+	for i, f := range findings {
+		if f.Severity == SeverityWarning {
+			f.Severity = SeverityError
+		}
+		promoted[i] = f
+	}
+	return promoted
+}
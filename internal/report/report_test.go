@@ -0,0 +1,50 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestWriteJSONLine(t *testing.T) {
+	findings := []Finding{
+		{Severity: SeverityError, Device: "router", Config: "network", Section: "lan", Message: "missing ipaddr"},
+		{Severity: SeverityWarning, Device: "router", Config: "firewall", Section: "wan", Message: "input is ACCEPT"},
+		{Severity: SeverityInfo, Device: "ap", Message: "in sync"},
+	}
+
+	var buf bytes.Buffer
+	for _, f := range findings {
+		if err := WriteJSONLine(&buf, f); err != nil {
+			t.Fatalf("Failed to write finding: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(findings) {
+		t.Fatalf("Expected %d lines, got %d", len(findings), len(lines))
+	}
+
+	for i, line := range lines {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Line %d is not valid JSON: %v", i, err)
+		}
+		for _, field := range []string{"severity", "device", "message"} {
+			if _, ok := record[field]; !ok {
+				t.Errorf("Line %d missing field %s: %s", i, field, line)
+			}
+		}
+		if record["severity"] != findings[i].Severity {
+			t.Errorf("Line %d: expected severity %s, got %v", i, findings[i].Severity, record["severity"])
+		}
+	}
+
+	// Config and section are included when known
+	var first map[string]any
+	_ = json.Unmarshal([]byte(lines[0]), &first)
+	if first["config"] != "network" || first["section"] != "lan" {
+		t.Errorf("Expected config and section fields, got %s", lines[0])
+	}
+}
@@ -0,0 +1,78 @@
+package secret
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// Resolver looks up the value of a ${secret.<name>} placeholder. ok is false
+// when the resolver doesn't hold the secret.
+type Resolver func(name string) (value string, ok bool, err error)
+
+// EnvPrefix prefixes the environment variables secrets are read from, e.g.
+// ${secret.wifi_key} reads OPENWRT_SECRET_WIFI_KEY
+const EnvPrefix = "OPENWRT_SECRET_"
+
+// Env resolves secrets from environment variables
+func Env(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(EnvVar(name))
+	return value, ok, nil
+}
+
+// EnvVar returns the environment variable a secret is read from
+func EnvVar(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name))
+}
+
+// Chain tries each resolver in turn and returns the first value found
+func Chain(resolvers ...Resolver) Resolver {
+	return func(name string) (string, bool, error) {
+		for _, resolve := range resolvers {
+			value, ok, err := resolve(name)
+			if err != nil || ok {
+				return value, ok, err
+			}
+		}
+		return "", false, nil
+	}
+}
+
+// NewResolver returns the resolver for a config's secrets: its encrypted
+// vault if it has one, then environment variables
+func NewResolver(secretsConfig *config.SecretsConfig) (Resolver, error) {
+	if secretsConfig == nil || secretsConfig.Vault == "" {
+		return Env, nil
+	}
+
+	key, err := KeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	vault, err := OpenVault(secretsConfig.Vault, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return Chain(vault.Resolve, Env), nil
+}
+
+// Resolve returns the value of a secret, failing if no resolver holds it
+func Resolve(resolve Resolver, name string) (string, error) {
+	value, ok, err := resolve(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %q: %w", name, err)
+	}
+	if !ok {
+		return "", fmt.Errorf("secret %q not found (set %s or add it to the vault)", name, EnvVar(name))
+	}
+	return value, nil
+}
@@ -0,0 +1,133 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// KeyEnv is the environment variable holding the base64 encoded 32 byte
+// vault key
+const KeyEnv = "OPENWRT_CONFIGURATOR_VAULT_KEY"
+
+// Vault holds secrets decrypted from an AES-256-GCM encrypted file
+type Vault struct {
+	secrets map[string]string
+}
+
+// vaultFile is the on-disk form of a vault. The plaintext is a JSON object
+// of secret names to values.
+type vaultFile struct {
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// KeyFromEnv reads the vault key from KeyEnv
+func KeyFromEnv() ([]byte, error) {
+	encoded := os.Getenv(KeyEnv)
+	if encoded == "" {
+		return nil, fmt.Errorf("vault key not set: %s is empty", KeyEnv)
+	}
+	return DecodeKey(encoded)
+}
+
+// DecodeKey decodes a base64 encoded vault key
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode vault key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("vault key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// OpenVault reads and decrypts a vault file
+func OpenVault(path string, key []byte) (*Vault, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault: %w", err)
+	}
+	return DecryptVault(data, key)
+}
+
+// DecryptVault decrypts the contents of a vault file
+func DecryptVault(data, key []byte) (*Vault, error) {
+	var file vaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse vault: %w", err)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse vault nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(file.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse vault ciphertext: %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid vault nonce length %d", len(nonce))
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault: wrong key or corrupted file")
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse vault secrets: %w", err)
+	}
+
+	return &Vault{secrets: secrets}, nil
+}
+
+// EncryptVault encrypts secrets into the contents of a vault file
+func EncryptVault(secrets map[string]string, key []byte) ([]byte, error) {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	file := vaultFile{
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, nil)),
+	}
+
+	return json.MarshalIndent(file, "", "  ")
+}
+
+// Resolve looks up a secret in the vault
+func (v *Vault) Resolve(name string) (string, bool, error) {
+	value, ok := v.secrets[name]
+	return value, ok, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
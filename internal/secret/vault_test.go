@@ -0,0 +1,70 @@
+package secret
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestVault(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	data, err := EncryptVault(map[string]string{"wifi_key": "correct horse"}, key)
+	if err != nil {
+		t.Fatalf("Failed to encrypt vault: %v", err)
+	}
+	if bytes.Contains(data, []byte("correct horse")) {
+		t.Fatal("Vault contains the plaintext secret")
+	}
+
+	vault, err := DecryptVault(data, key)
+	if err != nil {
+		t.Fatalf("Failed to decrypt vault: %v", err)
+	}
+	if value, err := Resolve(vault.Resolve, "wifi_key"); err != nil || value != "correct horse" {
+		t.Errorf("Expected the wifi key, got %q, %v", value, err)
+	}
+	if _, err := Resolve(vault.Resolve, "missing"); err == nil {
+		t.Error("Expected an error for a missing secret")
+	}
+
+	// A wrong key fails to decrypt
+	wrongKey := bytes.Repeat([]byte{2}, 32)
+	if _, err := DecryptVault(data, wrongKey); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Errorf("Expected a wrong key error, got %v", err)
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	data, err := EncryptVault(map[string]string{"wifi_key": "from-vault"}, key)
+	if err != nil {
+		t.Fatalf("Failed to encrypt vault: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "secrets.vault")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(KeyEnv, "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=")
+	t.Setenv("OPENWRT_SECRET_ADMIN_PASSWORD", "from-env")
+
+	resolve, err := NewResolver(&config.SecretsConfig{Vault: path})
+	if err != nil {
+		t.Fatalf("Failed to create resolver: %v", err)
+	}
+	if value, _ := Resolve(resolve, "wifi_key"); value != "from-vault" {
+		t.Errorf("Expected the vault secret, got %q", value)
+	}
+	if value, _ := Resolve(resolve, "admin-password"); value != "from-env" {
+		t.Errorf("Expected the env secret, got %q", value)
+	}
+
+	t.Setenv(KeyEnv, "AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=")
+	if _, err := NewResolver(&config.SecretsConfig{Vault: path}); err == nil {
+		t.Error("Expected an error opening the vault with the wrong key")
+	}
+}
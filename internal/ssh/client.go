@@ -1,9 +1,20 @@
 package ssh
 
 import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -14,24 +25,93 @@ type SSHExecutor interface {
 	Close() error
 }
 
+// AddrProvider is implemented by executors that know the addresses of
+// their connection
+type AddrProvider interface {
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+}
+
+// Streamer is implemented by executors that can stream the output of a
+// long running command, such as logread -f
+type Streamer interface {
+	// Stream runs command, calling onLine with each line of its output as
+	// it arrives, until the command exits or the returned stop function is
+	// called. onLine is called from another goroutine and not after stop
+	// returns.
+	Stream(command string, onLine func(line string)) (stop func(), err error)
+}
+
 // Client wraps an SSH client connection
 type Client struct {
 	client  *ssh.Client
 	session *ssh.Session
 }
 
-// Connect establishes an SSH connection to the specified host
-func Connect(host, username, password string) (*Client, error) {
-	config := &ssh.ClientConfig{
-		User: username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, use proper host key verification
-		Timeout:         10 * time.Second,
+// Options are optional settings for a connection
+type Options struct {
+	// Ciphers, KeyExchanges and HostKeyAlgorithms replace the algorithms
+	// offered when set, e.g. to enable legacy ones for old Dropbear builds
+	Ciphers           []string
+	KeyExchanges      []string
+	HostKeyAlgorithms []string
+
+	// PrivateKeyPath is a private key file to authenticate with before
+	// trying the password, decrypted with PrivateKeyPassphrase if needed
+	PrivateKeyPath       string
+	PrivateKeyPassphrase string
+
+	// StrictHostKeyChecking and KnownHostsPath control how the host key is
+	// checked, see hostKeyCallback
+	StrictHostKeyChecking string
+	KnownHostsPath        string
+}
+
+// OptionsFromConfig returns the connection options for a device's
+// provisioning config
+func OptionsFromConfig(provisioningConfig *config.ProvisioningConfig) Options {
+	var opts Options
+	if provisioningConfig != nil {
+		opts.PrivateKeyPath = provisioningConfig.SSHAuth.PrivateKeyPath
+		opts.PrivateKeyPassphrase = provisioningConfig.SSHAuth.PrivateKeyPassphrase
+		opts.StrictHostKeyChecking = provisioningConfig.StrictHostKeyChecking
+		opts.KnownHostsPath = provisioningConfig.KnownHostsFile
+	}
+	if provisioningConfig != nil && provisioningConfig.SSHAlgorithms != nil {
+		opts.Ciphers = provisioningConfig.SSHAlgorithms.Ciphers
+		opts.KeyExchanges = provisioningConfig.SSHAlgorithms.KeyExchanges
+		opts.HostKeyAlgorithms = provisioningConfig.SSHAlgorithms.HostKeyAlgorithms
 	}
+	return opts
+}
+
+// DefaultPort is the SSH port used when none is configured
+const DefaultPort = 22
 
-	client, err := ssh.Dial("tcp", host+":22", config)
+// Connect establishes an SSH connection to the specified host and port,
+// port 22 when port is 0
+func Connect(host string, port int, username, password string, opts Options) (*Client, error) {
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid SSH port %d", port)
+	}
+
+	clientConfig, err := newClientConfig(username, password, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dial the resolved address, but check the host key under the name
+	// the device is configured with
+	address, err := ResolveHost(host)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback := clientConfig.HostKeyCallback
+	clientConfig.HostKeyCallback = func(_ string, remote net.Addr, key ssh.PublicKey) error {
+		return hostKeyCallback(Address(host, port), remote, key)
+	}
+
+	client, err := ssh.Dial("tcp", Address(address, port), clientConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial: %w", err)
 	}
@@ -41,6 +121,158 @@ func Connect(host, username, password string) (*Client, error) {
 	}, nil
 }
 
+// ConnectDevice connects to a device using its provisioning config
+func ConnectDevice(deviceConfig *config.DeviceConfig) (*Client, error) {
+	if deviceConfig.ProvisioningConfig == nil {
+		return nil, fmt.Errorf("provisioning config not set for device %s", deviceConfig.Hostname)
+	}
+
+	return Connect(
+		deviceConfig.IPAddr,
+		deviceConfig.ProvisioningConfig.Port,
+		deviceConfig.ProvisioningConfig.SSHAuth.Username,
+		deviceConfig.ProvisioningConfig.SSHAuth.Password,
+		OptionsFromConfig(deviceConfig.ProvisioningConfig),
+	)
+}
+
+// HostResolver looks up the addresses of a host name
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Resolver resolves device host names, replaced in tests
+var Resolver HostResolver = net.DefaultResolver
+
+// resolveTimeout bounds how long resolving a host name may take
+const resolveTimeout = 10 * time.Second
+
+// ResolveHost returns the address to dial for a device's ipaddr: the
+// address itself when it is an IP address, otherwise the first address its
+// host name resolves to
+func ResolveHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if isIPAddress(host) {
+		return host, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	addresses, err := Resolver.LookupHost(ctx, host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	if len(addresses) == 0 {
+		return "", fmt.Errorf("failed to resolve %s: no addresses", host)
+	}
+	return addresses[0], nil
+}
+
+// IsHostName reports whether a device's ipaddr is a host name to be
+// resolved rather than an IP address
+func IsHostName(host string) bool {
+	return !isIPAddress(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+}
+
+// isIPAddress reports whether host is an IP address, including IPv6 ones
+// with a zone such as fe80::1%br-lan
+func isIPAddress(host string) bool {
+	address, _, _ := strings.Cut(host, "%")
+	return net.ParseIP(address) != nil
+}
+
+// Address joins a host and port to dial, bracketing IPv6 addresses, e.g.
+// [fd00::1]:22. A host that is already bracketed is accepted too.
+func Address(host string, port int) string {
+	if port == 0 {
+		port = DefaultPort
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+func newClientConfig(username, password string, opts Options) (*ssh.ClientConfig, error) {
+	// Try the key first, falling back to the password
+	var auth []ssh.AuthMethod
+	if opts.PrivateKeyPath != "" {
+		signer, err := readPrivateKey(opts.PrivateKeyPath, opts.PrivateKeyPassphrase)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password != "" || opts.PrivateKeyPath == "" {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	hostKeyCallback, err := newHostKeyCallback(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		User:              username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: opts.HostKeyAlgorithms,
+		Timeout:           10 * time.Second,
+	}
+	clientConfig.Ciphers = opts.Ciphers
+	clientConfig.KeyExchanges = opts.KeyExchanges
+
+	return clientConfig, nil
+}
+
+// readPrivateKey reads a PEM or OpenSSH private key, decrypting it with
+// passphrase if it is encrypted. A leading ~/ is the home directory.
+func readPrivateKey(path, passphrase string) (ssh.Signer, error) {
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	if passphrase == "" {
+		signer, err := ssh.ParsePrivateKey(data)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("private key %s is encrypted, set its passphrase", path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+		}
+		return signer, nil
+	}
+
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return nil, fmt.Errorf("wrong passphrase for private key %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// expandHome replaces a leading ~/ in path with the home directory
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find home directory: %w", err)
+	}
+	return filepath.Join(home, rest), nil
+}
+
 // Execute runs a command on the remote host and returns the output
 func (c *Client) Execute(command string) (string, error) {
 	session, err := c.client.NewSession()
@@ -69,6 +301,66 @@ func (c *Client) ExecuteWithError(command string) (string, error) {
 	return string(output), err
 }
 
+// streamStopTimeout bounds how long stopping a stream waits for its output
+// to end once the session is closed
+const streamStopTimeout = 2 * time.Second
+
+// Stream runs a command, calling onLine with each line of its output as it
+// arrives, until the command exits or stop is called
+func (c *Client) Stream(command string, onLine func(line string)) (func(), error) {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to read output: %w", err)
+	}
+
+	if err := session.Start(command); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to start command: %w", err)
+	}
+
+	var mu sync.Mutex
+	stopped := false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			mu.Lock()
+			if !stopped {
+				onLine(scanner.Text())
+			}
+			mu.Unlock()
+		}
+	}()
+
+	return func() {
+		session.Close()
+		select {
+		case <-done:
+		case <-time.After(streamStopTimeout):
+		}
+		mu.Lock()
+		stopped = true
+		mu.Unlock()
+	}, nil
+}
+
+// LocalAddr returns the local address of the connection
+func (c *Client) LocalAddr() net.Addr {
+	return c.client.LocalAddr()
+}
+
+// RemoteAddr returns the address of the device
+func (c *Client) RemoteAddr() net.Addr {
+	return c.client.RemoteAddr()
+}
+
 // Close closes the SSH connection
 func (c *Client) Close() error {
 	if c.client != nil {
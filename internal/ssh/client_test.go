@@ -0,0 +1,172 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLegacyAlgorithms(t *testing.T) {
+	var provisioningConfig config.ProvisioningConfig
+	err := json.Unmarshal([]byte(`{
+		"ssh_auth": { "username": "root", "password": "secret" },
+		"ssh_algorithms": {
+			"ciphers": ["aes128-ctr", "aes128-cbc"],
+			"key_exchanges": ["diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1"],
+			"host_key_algorithms": ["ssh-rsa"]
+		}
+	}`), &provisioningConfig)
+	if err != nil {
+		t.Fatalf("Failed to parse provisioning config: %v", err)
+	}
+
+	clientConfig, err := newClientConfig("root", "secret", OptionsFromConfig(&provisioningConfig))
+	if err != nil {
+		t.Fatalf("Failed to create client config: %v", err)
+	}
+
+	if !slices.Equal(clientConfig.Ciphers, []string{"aes128-ctr", "aes128-cbc"}) {
+		t.Errorf("Unexpected ciphers: %v", clientConfig.Ciphers)
+	}
+	if !slices.Equal(clientConfig.KeyExchanges, []string{"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1"}) {
+		t.Errorf("Unexpected key exchanges: %v", clientConfig.KeyExchanges)
+	}
+	if !slices.Equal(clientConfig.HostKeyAlgorithms, []string{"ssh-rsa"}) {
+		t.Errorf("Unexpected host key algorithms: %v", clientConfig.HostKeyAlgorithms)
+	}
+
+	// Without overrides the library defaults are used
+	clientConfig, err = newClientConfig("root", "secret", OptionsFromConfig(&config.ProvisioningConfig{}))
+	if err != nil {
+		t.Fatalf("Failed to create client config: %v", err)
+	}
+	if clientConfig.Ciphers != nil || clientConfig.KeyExchanges != nil || clientConfig.HostKeyAlgorithms != nil {
+		t.Errorf("Expected default algorithms, got %+v", clientConfig)
+	}
+}
+
+func TestPrivateKeyAuth(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeKey := func(name string, passphrase string) string {
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if passphrase != "" {
+			block, err = ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte(passphrase))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	plain := writeKey("id_ed25519", "")
+	encrypted := writeKey("id_ed25519_encrypted", "hunter2")
+
+	// A key is tried before the password, which is only offered when set
+	for _, opts := range []Options{
+		{PrivateKeyPath: plain},
+		{PrivateKeyPath: encrypted, PrivateKeyPassphrase: "hunter2"},
+	} {
+		clientConfig, err := newClientConfig("root", "", opts)
+		if err != nil {
+			t.Fatalf("Failed to create client config for %s: %v", opts.PrivateKeyPath, err)
+		}
+		if len(clientConfig.Auth) != 1 {
+			t.Errorf("Expected only key auth, got %d methods", len(clientConfig.Auth))
+		}
+	}
+	clientConfig, err := newClientConfig("root", "secret", Options{PrivateKeyPath: plain})
+	if err != nil {
+		t.Fatalf("Failed to create client config: %v", err)
+	}
+	if len(clientConfig.Auth) != 2 {
+		t.Errorf("Expected key and password auth, got %d methods", len(clientConfig.Auth))
+	}
+
+	for _, tt := range []struct {
+		opts     Options
+		expected string
+	}{
+		{Options{PrivateKeyPath: encrypted}, "is encrypted"},
+		{Options{PrivateKeyPath: encrypted, PrivateKeyPassphrase: "wrong"}, "wrong passphrase"},
+		{Options{PrivateKeyPath: filepath.Join(dir, "missing")}, "failed to read private key"},
+	} {
+		_, err := newClientConfig("root", "", tt.opts)
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("Expected an error containing %q, got %v", tt.expected, err)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	for _, tt := range []struct {
+		host     string
+		port     int
+		expected string
+	}{
+		{"192.168.1.1", 0, "192.168.1.1:22"},
+		{"192.168.1.1", 2222, "192.168.1.1:2222"},
+		{"router.lan", 2222, "router.lan:2222"},
+		{"fd00::1", 0, "[fd00::1]:22"},
+		{"[fd00::1]", 2222, "[fd00::1]:2222"},
+		{"fe80::1%br-lan", 22, "[fe80::1%br-lan]:22"},
+	} {
+		if address := Address(tt.host, tt.port); address != tt.expected {
+			t.Errorf("Address(%q, %d) = %q, expected %q", tt.host, tt.port, address, tt.expected)
+		}
+	}
+}
+
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addresses, ok := r[host]; ok {
+		return addresses, nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func TestResolveHost(t *testing.T) {
+	original := Resolver
+	defer func() { Resolver = original }()
+	Resolver = stubResolver{"router.lan": {"192.168.1.1", "fd00::1"}}
+
+	for _, tt := range []struct {
+		host     string
+		expected string
+	}{
+		{"router.lan", "192.168.1.1"},
+		{"192.168.1.2", "192.168.1.2"},
+		{"[fd00::2]", "fd00::2"},
+		{"fe80::1%br-lan", "fe80::1%br-lan"},
+	} {
+		address, err := ResolveHost(tt.host)
+		if err != nil || address != tt.expected {
+			t.Errorf("ResolveHost(%q) = %q, %v, expected %q", tt.host, address, err, tt.expected)
+		}
+	}
+	if _, err := ResolveHost("missing.lan"); err == nil || !strings.Contains(err.Error(), "failed to resolve missing.lan") {
+		t.Errorf("Expected a resolution error, got %v", err)
+	}
+
+	if !IsHostName("router.lan") || IsHostName("192.168.1.1") || IsHostName("[fd00::1]") {
+		t.Error("Expected only router.lan to be a host name")
+	}
+}
@@ -0,0 +1,113 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key checking modes, named after OpenSSH's StrictHostKeyChecking
+const (
+	// HostKeyAcceptNew records the keys of unknown hosts and rejects keys
+	// that differ from the recorded ones
+	HostKeyAcceptNew = "accept-new"
+	// HostKeyStrict only accepts hosts already in known_hosts
+	HostKeyStrict = "yes"
+	// HostKeyIgnore accepts any host key, e.g. on a trusted lab network
+	HostKeyIgnore = "no"
+)
+
+// defaultKnownHostsPath is used when no known_hosts file is configured
+const defaultKnownHostsPath = "~/.ssh/known_hosts"
+
+// knownHostsMu serializes reading and recording known hosts, as devices
+// may be connected to in parallel
+var knownHostsMu sync.Mutex
+
+// newHostKeyCallback returns the callback checking host keys against the
+// known_hosts file in the way opts.StrictHostKeyChecking asks for,
+// HostKeyAcceptNew by default
+func newHostKeyCallback(opts Options) (ssh.HostKeyCallback, error) {
+	mode := opts.StrictHostKeyChecking
+	if mode == "" {
+		mode = HostKeyAcceptNew
+	}
+	switch mode {
+	case HostKeyIgnore:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case HostKeyAcceptNew, HostKeyStrict:
+	default:
+		return nil, fmt.Errorf("invalid strict_host_key_checking %q, expected %s, %s or %s", mode, HostKeyAcceptNew, HostKeyStrict, HostKeyIgnore)
+	}
+
+	path := opts.KnownHostsPath
+	if path == "" {
+		path = defaultKnownHostsPath
+	}
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		err := checkKnownHost(path, hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) > 0 {
+			want := keyErr.Want[0]
+			return fmt.Errorf("host key mismatch for %s: the device offered %s %s but %s:%d has %s; "+
+				"if the device was reset or reinstalled, remove the old key with ssh-keygen -R %s",
+				hostname, key.Type(), fingerprint, want.Filename, want.Line, ssh.FingerprintSHA256(want.Key), knownhosts.Normalize(hostname))
+		}
+		if mode == HostKeyStrict {
+			return fmt.Errorf("unknown host key for %s: %s %s is not in %s; add it, or set strict_host_key_checking to %s to record it",
+				hostname, key.Type(), fingerprint, path, HostKeyAcceptNew)
+		}
+		return recordKnownHost(path, hostname, key)
+	}, nil
+}
+
+// checkKnownHost checks key against the known_hosts file. A missing file
+// knows no hosts.
+func checkKnownHost(path, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return &knownhosts.KeyError{}
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return fmt.Errorf("failed to read known hosts: %w", err)
+	}
+	return callback(hostname, remote, key)
+}
+
+// recordKnownHost appends the host's key to the known_hosts file, creating
+// it if needed
+func recordKnownHost(path, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create known hosts directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open known hosts: %w", err)
+	}
+	defer file.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := fmt.Fprintln(file, line); err != nil {
+		return fmt.Errorf("failed to record host key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	knownHosts := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	remote := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 22}
+	key, otherKey := newHostKey(t), newHostKey(t)
+
+	// Unknown hosts are rejected in strict mode
+	strict, err := newHostKeyCallback(Options{StrictHostKeyChecking: HostKeyStrict, KnownHostsPath: knownHosts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := strict("192.168.1.1:22", remote, key); err == nil || !strings.Contains(err.Error(), "unknown host key") {
+		t.Errorf("Expected an unknown host key error, got %v", err)
+	}
+
+	// By default they are pinned on first connect
+	acceptNew, err := newHostKeyCallback(Options{KnownHostsPath: knownHosts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acceptNew("192.168.1.1:22", remote, key); err != nil {
+		t.Fatalf("Expected the new host key to be accepted, got %v", err)
+	}
+	data, err := os.ReadFile(knownHosts)
+	if err != nil {
+		t.Fatalf("Expected the host key to be recorded: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "192.168.1.1 ssh-ed25519 ") {
+		t.Errorf("Unexpected known_hosts:\n%s", data)
+	}
+
+	// The pinned key is accepted, in strict mode too, and a changed one is
+	// rejected with its fingerprint
+	if err := acceptNew("192.168.1.1:22", remote, key); err != nil {
+		t.Errorf("Expected the pinned key to be accepted, got %v", err)
+	}
+	if err := strict("192.168.1.1:22", remote, key); err != nil {
+		t.Errorf("Expected the pinned key to be accepted in strict mode, got %v", err)
+	}
+	err = acceptNew("192.168.1.1:22", remote, otherKey)
+	if err == nil || !strings.Contains(err.Error(), "host key mismatch") || !strings.Contains(err.Error(), ssh.FingerprintSHA256(otherKey)) {
+		t.Errorf("Expected a host key mismatch error with the offered fingerprint, got %v", err)
+	}
+
+	// Other ports are separate hosts
+	if err := acceptNew("192.168.1.1:2222", &net.TCPAddr{IP: remote.IP, Port: 2222}, otherKey); err != nil {
+		t.Errorf("Expected the key on another port to be accepted, got %v", err)
+	}
+
+	ignore, err := newHostKeyCallback(Options{StrictHostKeyChecking: HostKeyIgnore, KnownHostsPath: knownHosts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ignore("192.168.1.1:22", remote, otherKey); err != nil {
+		t.Errorf("Expected any key to be accepted without checking, got %v", err)
+	}
+
+	if _, err := newHostKeyCallback(Options{StrictHostKeyChecking: "maybe"}); err == nil {
+		t.Error("Expected an error for an invalid mode")
+	}
+}
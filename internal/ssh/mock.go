@@ -3,57 +3,96 @@ package ssh
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"slices"
 	"strings"
+	"sync"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
 )
 
 // MockClient simulates an OpenWRT device SSH connection with factory reset state
 type MockClient struct {
 	// Configuration
 	ModelID       string
+	Version       string
+	Arch          string
 	InstalledPkgs []string
 
 	// State tracking
 	ExecutedCmds  []string
 	UCIState      map[string]map[string]map[string]string // config -> section -> key -> value
+	StagedChanges map[string][]string                     // config -> uncommitted changes, as uci changes lists them
 	FailOnCommand string                                  // If set, fail when this command is executed
+	Responses     map[string]string                       // Canned output for specific commands
+	LocalAddress  net.Addr                                // Reported by LocalAddr, nil if unknown
+	RemoteAddress net.Addr                                // Reported by RemoteAddr, nil if unknown
+	StreamOutput  map[string][]string                     // Lines streamed by Stream for specific commands
 
 	// Callbacks
 	OnExecute func(command string) (string, error)
+
+	// mu guards the state, so commands can be run from several goroutines
+	// like on a real connection
+	mu sync.Mutex
 }
 
 // NewMockClient creates a new mock SSH client with factory reset state
 func NewMockClient(modelID string) *MockClient {
 	return &MockClient{
 		ModelID:       modelID,
+		Version:       "23.05.0",
+		Arch:          "mipsel_24kc",
 		InstalledPkgs: getFactoryPackages(),
 		ExecutedCmds:  []string{},
 		UCIState:      make(map[string]map[string]map[string]string),
+		StagedChanges: make(map[string][]string),
+		Responses:     make(map[string]string),
+		StreamOutput:  make(map[string][]string),
 	}
 }
 
 // Execute simulates executing a command on a factory reset OpenWRT device
 func (m *MockClient) Execute(command string) (string, error) {
+	m.mu.Lock()
 	m.ExecutedCmds = append(m.ExecutedCmds, command)
+	m.mu.Unlock()
 
 	// Check if we should fail on this command
 	if m.FailOnCommand != "" && strings.Contains(command, m.FailOnCommand) {
 		return "", fmt.Errorf("mock error: command failed")
 	}
 
-	// Custom callback
+	// Custom callback, called unlocked as it may run further commands
 	if m.OnExecute != nil {
 		return m.OnExecute(command)
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Canned responses
+	if output, ok := m.Responses[command]; ok {
+		return output, nil
+	}
+
 	// Handle specific commands
 	if command == "cat /etc/board.json" {
 		return m.getBoardJSON(), nil
 	}
 
+	if command == "cat /etc/openwrt_release" {
+		return m.getOpenWrtRelease(), nil
+	}
+
 	if command == "opkg list-installed" {
 		return m.getInstalledPackages(), nil
 	}
 
+	if command == "opkg print-architecture" {
+		return fmt.Sprintf("arch all 1\narch noarch 1\narch %s 10\n", m.Arch), nil
+	}
+
 	// Handle UCI commands
 	if strings.HasPrefix(command, "uci set ") {
 		m.handleUCISet(command)
@@ -66,9 +105,19 @@ func (m *MockClient) Execute(command string) (string, error) {
 	}
 
 	if strings.HasPrefix(command, "uci commit") {
+		delete(m.StagedChanges, strings.TrimSpace(strings.TrimPrefix(command, "uci commit")))
+		return "", nil
+	}
+
+	if configKey, ok := strings.CutPrefix(command, "uci revert "); ok {
+		delete(m.StagedChanges, configKey)
 		return "", nil
 	}
 
+	if configKey, ok := strings.CutPrefix(command, "uci changes "); ok {
+		return strings.Join(m.StagedChanges[configKey], "\n"), nil
+	}
+
 	if command == "reload_config" {
 		return "", nil
 	}
@@ -88,8 +137,18 @@ func (m *MockClient) Execute(command string) (string, error) {
 		return "", nil
 	}
 
-	// Handle delete commands
-	if strings.Contains(command, "uci -q delete") {
+	// Handle delete commands, which fail like uci's when there is nothing
+	// to delete unless the failure is ignored
+	if rest, ok := strings.CutPrefix(command, "uci -q delete "); ok {
+		key, ignoreFailure := strings.CutSuffix(rest, " || true")
+		if !m.hasUCIKey(key) {
+			if ignoreFailure {
+				return "", nil
+			}
+			return "", fmt.Errorf("exit status 1")
+		}
+		m.stageChange(key, "-"+key)
+		m.handleUCIDelete(key)
 		return "", nil
 	}
 
@@ -101,6 +160,39 @@ func (m *MockClient) ExecuteWithError(command string) (string, error) {
 	return m.Execute(command)
 }
 
+// Stream simulates streaming a command's output, sending its StreamOutput
+// lines from another goroutine. Stop waits for every line to be sent.
+func (m *MockClient) Stream(command string, onLine func(line string)) (func(), error) {
+	m.mu.Lock()
+	m.ExecutedCmds = append(m.ExecutedCmds, command)
+	m.mu.Unlock()
+
+	if m.FailOnCommand != "" && strings.Contains(command, m.FailOnCommand) {
+		return nil, fmt.Errorf("mock error: command failed")
+	}
+
+	lines := m.StreamOutput[command]
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, line := range lines {
+			onLine(line)
+		}
+	}()
+
+	return func() { <-done }, nil
+}
+
+// LocalAddr returns the simulated local address of the connection
+func (m *MockClient) LocalAddr() net.Addr {
+	return m.LocalAddress
+}
+
+// RemoteAddr returns the simulated address of the device
+func (m *MockClient) RemoteAddr() net.Addr {
+	return m.RemoteAddress
+}
+
 // Close simulates closing the SSH connection
 func (m *MockClient) Close() error {
 	return nil
@@ -108,7 +200,9 @@ func (m *MockClient) Close() error {
 
 // GetExecutedCommands returns all executed commands
 func (m *MockClient) GetExecutedCommands() []string {
-	return m.ExecutedCmds
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.ExecutedCmds)
 }
 
 // GetUCIValue retrieves a UCI value from the mock state
@@ -149,6 +243,11 @@ func (m *MockClient) getBoardJSON() string {
 	return string(data)
 }
 
+// getOpenWrtRelease returns /etc/openwrt_release content
+func (m *MockClient) getOpenWrtRelease() string {
+	return fmt.Sprintf("DISTRIB_ID='OpenWrt'\nDISTRIB_RELEASE='%s'\nDISTRIB_ARCH='%s'\n", m.Version, m.Arch)
+}
+
 // getInstalledPackages returns factory reset installed packages
 func (m *MockClient) getInstalledPackages() string {
 	var output strings.Builder
@@ -196,7 +295,7 @@ func (m *MockClient) handleUCISet(command string) {
 	}
 
 	left := setPart[:eqIdx]
-	right := strings.Trim(setPart[eqIdx+1:], "'\"")
+	right := strings.Join(uci.ParseShowValue(setPart[eqIdx+1:]), " ")
 
 	// Parse left side: config.section or config.section.key
 	dotParts := strings.Split(left, ".")
@@ -214,6 +313,12 @@ func (m *MockClient) handleUCISet(command string) {
 		m.UCIState[config][section] = make(map[string]string)
 	}
 
+	if len(dotParts) == 2 {
+		m.stageChange(left, fmt.Sprintf("%s=%s", left, right))
+	} else {
+		m.stageChange(left, fmt.Sprintf("%s=%s", left, uci.QuoteValue(right)))
+	}
+
 	if len(dotParts) == 2 {
 		// Setting section type: config.section=type
 		m.UCIState[config][section]["_type"] = right
@@ -239,7 +344,7 @@ func (m *MockClient) handleUCIAddList(command string) {
 	}
 
 	left := addPart[:eqIdx]
-	right := strings.Trim(addPart[eqIdx+1:], "'\"")
+	right := strings.Join(uci.ParseShowValue(addPart[eqIdx+1:]), " ")
 
 	// Parse left side: config.section.key
 	dotParts := strings.Split(left, ".")
@@ -251,6 +356,8 @@ func (m *MockClient) handleUCIAddList(command string) {
 	section := dotParts[1]
 	key := dotParts[2]
 
+	m.stageChange(left, fmt.Sprintf("%s+=%s", left, uci.QuoteValue(right)))
+
 	if m.UCIState[config] == nil {
 		m.UCIState[config] = make(map[string]map[string]string)
 	}
@@ -267,6 +374,40 @@ func (m *MockClient) handleUCIAddList(command string) {
 	}
 }
 
+// handleUCIDelete removes an option or named section from the mock state
+func (m *MockClient) handleUCIDelete(key string) {
+	dotParts := strings.Split(key, ".")
+	switch len(dotParts) {
+	case 2:
+		delete(m.UCIState[dotParts[0]], dotParts[1])
+	case 3:
+		if section, ok := m.UCIState[dotParts[0]][dotParts[1]]; ok {
+			delete(section, dotParts[2])
+		}
+	}
+}
+
+// hasUCIKey reports whether a config.section or config.section.option key
+// exists
+func (m *MockClient) hasUCIKey(key string) bool {
+	dotParts := strings.Split(key, ".")
+	switch len(dotParts) {
+	case 2:
+		_, ok := m.UCIState[dotParts[0]][dotParts[1]]
+		return ok
+	case 3:
+		_, ok := m.UCIState[dotParts[0]][dotParts[1]][dotParts[2]]
+		return ok
+	}
+	return false
+}
+
+// stageChange records an uncommitted change to the config of key
+func (m *MockClient) stageChange(key, change string) {
+	config, _, _ := strings.Cut(key, ".")
+	m.StagedChanges[config] = append(m.StagedChanges[config], change)
+}
+
 // handleOpkgRemove removes packages from installed list
 func (m *MockClient) handleOpkgRemove(command string) {
 	// Parse: opkg remove --force-removal-of-dependent-packages pkg1 pkg2 ...
@@ -0,0 +1,88 @@
+package topology
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dotShapes are the Graphviz shapes used for each node kind
+var dotShapes = map[string]string{
+	KindPort:      "box",
+	KindDevice:    "box3d",
+	KindVlan:      "component",
+	KindInterface: "ellipse",
+	KindZone:      "hexagon",
+}
+
+// DOT renders the graph in Graphviz DOT format
+func (g *Graph) DOT(name string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(name))
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s];\n", dotQuote(node.ID), dotQuote(node.Label), dotShapes[node.Kind])
+	}
+	for _, edge := range g.Edges {
+		var attrs []string
+		if edge.Label != "" {
+			attrs = append(attrs, "label="+dotQuote(edge.Label))
+		}
+		if edge.Dashed {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(&b, "  %s -> %s", dotQuote(edge.From), dotQuote(edge.To))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+
+	// Mermaid IDs must be plain identifiers, so number the nodes
+	ids := make(map[string]string)
+	b.WriteString("flowchart LR\n")
+	for i, node := range g.Nodes {
+		ids[node.ID] = fmt.Sprintf("n%d", i)
+		label := strings.ReplaceAll(mermaidEscape(node.Label), "\n", "<br/>")
+		switch node.Kind {
+		case KindInterface:
+			fmt.Fprintf(&b, "  %s([\"%s\"])\n", ids[node.ID], label)
+		case KindZone:
+			fmt.Fprintf(&b, "  %s{{\"%s\"}}\n", ids[node.ID], label)
+		default:
+			fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[node.ID], label)
+		}
+	}
+	for _, edge := range g.Edges {
+		arrow := "-->"
+		if edge.Dashed {
+			arrow = "-.->"
+		}
+		if edge.Label != "" {
+			fmt.Fprintf(&b, "  %s %s|%s| %s\n", ids[edge.From], arrow, mermaidEscape(edge.Label), ids[edge.To])
+		} else {
+			fmt.Fprintf(&b, "  %s %s %s\n", ids[edge.From], arrow, ids[edge.To])
+		}
+	}
+
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
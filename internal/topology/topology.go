@@ -0,0 +1,195 @@
+package topology
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+// Node kinds
+const (
+	KindPort      = "port"
+	KindDevice    = "device"
+	KindVlan      = "vlan"
+	KindInterface = "interface"
+	KindZone      = "zone"
+)
+
+// Node is an element of the network, e.g. a port, bridge or firewall zone
+type Node struct {
+	ID    string
+	Kind  string
+	Label string
+}
+
+// Edge connects two nodes, e.g. a port to the bridge it is a member of
+type Edge struct {
+	From  string
+	To    string
+	Label string
+	// Dashed marks edges that carry policy rather than membership, i.e.
+	// firewall forwardings
+	Dashed bool
+}
+
+// Graph is the network topology of a single device
+type Graph struct {
+	Nodes []Node
+	Edges []Edge
+
+	nodes map[string]bool
+}
+
+// Build builds the topology graph of a resolved device config, connecting
+// ports to devices, devices to VLANs and interfaces, interfaces to firewall
+// zones and zones to each other through forwardings
+func Build(cfg *config.ConfigConfig) *Graph {
+	g := &Graph{nodes: make(map[string]bool)}
+
+	if cfg.Network != nil {
+		for _, dev := range cfg.Network.Device {
+			if dev.DeviceName == nil {
+				continue
+			}
+			deviceID := g.addNode(KindDevice, *dev.DeviceName, deviceLabel(dev))
+			for _, port := range dev.Ports {
+				portID := g.addNode(KindPort, port, port)
+				g.addEdge(portID, deviceID, "", false)
+			}
+		}
+
+		for _, vlan := range cfg.Network.BridgeVlan {
+			if vlan.Device == nil || vlan.Vlan == nil {
+				continue
+			}
+			deviceID := g.addNode(KindDevice, *vlan.Device, *vlan.Device)
+			vlanName := fmt.Sprintf("%s.%d", *vlan.Device, *vlan.Vlan)
+			vlanID := g.addNode(KindVlan, vlanName, fmt.Sprintf("%s\nVLAN %d", vlanName, *vlan.Vlan))
+			g.addEdge(deviceID, vlanID, "", false)
+			for _, port := range vlan.Ports {
+				name, tagging := splitPort(port)
+				portID := g.addNode(KindPort, name, name)
+				g.addEdge(portID, vlanID, tagging, false)
+			}
+		}
+
+		for _, vlan := range cfg.Network.SwitchVlan {
+			if vlan.Device == nil || vlan.Vlan == nil {
+				continue
+			}
+			vlanName := fmt.Sprintf("%s.%d", *vlan.Device, *vlan.Vlan)
+			vlanID := g.addNode(KindVlan, vlanName, fmt.Sprintf("%s\nVLAN %d", *vlan.Device, *vlan.Vlan))
+			if vlan.Ports == nil {
+				continue
+			}
+			for _, port := range strings.Fields(*vlan.Ports) {
+				tagging := "untagged"
+				if strings.HasSuffix(port, "t") {
+					port = strings.TrimSuffix(port, "t")
+					tagging = "tagged"
+				}
+				name := fmt.Sprintf("%s port %s", *vlan.Device, port)
+				portID := g.addNode(KindPort, name, name)
+				g.addEdge(portID, vlanID, tagging, false)
+			}
+		}
+
+		for _, iface := range cfg.Network.Interface {
+			if iface.Name == nil {
+				continue
+			}
+			ifaceID := g.addNode(KindInterface, *iface.Name, interfaceLabel(iface))
+			if iface.Device != nil {
+				deviceID := g.deviceNode(*iface.Device)
+				g.addEdge(deviceID, ifaceID, "", false)
+			}
+		}
+	}
+
+	if cfg.Firewall != nil {
+		for _, zone := range cfg.Firewall.Zone {
+			name := zoneName(zone)
+			if name == "" {
+				continue
+			}
+			zoneID := g.addNode(KindZone, name, "zone "+name)
+			for _, network := range zone.Network {
+				ifaceID := g.addNode(KindInterface, network, network)
+				g.addEdge(ifaceID, zoneID, "", false)
+			}
+		}
+
+		for _, forwarding := range cfg.Firewall.Forwarding {
+			if forwarding.Src == nil || forwarding.Dest == nil {
+				continue
+			}
+			srcID := g.addNode(KindZone, *forwarding.Src, "zone "+*forwarding.Src)
+			destID := g.addNode(KindZone, *forwarding.Dest, "zone "+*forwarding.Dest)
+			g.addEdge(srcID, destID, "forward", true)
+		}
+	}
+
+	return g
+}
+
+// addNode adds a node unless it already exists and returns its ID
+func (g *Graph) addNode(kind, name, label string) string {
+	id := kind + ":" + name
+	if !g.nodes[id] {
+		g.nodes[id] = true
+		g.Nodes = append(g.Nodes, Node{ID: id, Kind: kind, Label: label})
+	}
+	return id
+}
+
+// deviceNode returns the node an interface's device option refers to,
+// which is either a declared VLAN or a (possibly implicit) device
+func (g *Graph) deviceNode(name string) string {
+	if g.nodes[KindVlan+":"+name] {
+		return KindVlan + ":" + name
+	}
+	return g.addNode(KindDevice, name, name)
+}
+
+func (g *Graph) addEdge(from, to, label string, dashed bool) {
+	g.Edges = append(g.Edges, Edge{From: from, To: to, Label: label, Dashed: dashed})
+}
+
+func deviceLabel(dev config.DeviceSection) string {
+	if dev.Type != nil {
+		return fmt.Sprintf("%s\n(%s)", *dev.DeviceName, *dev.Type)
+	}
+	return *dev.DeviceName
+}
+
+func interfaceLabel(iface config.InterfaceSection) string {
+	label := *iface.Name
+	if iface.Proto != nil {
+		label += fmt.Sprintf("\n%s", *iface.Proto)
+	}
+	if iface.IPAddr != nil {
+		label += fmt.Sprintf(" %s", *iface.IPAddr)
+	}
+	return label
+}
+
+func zoneName(zone config.ZoneSection) string {
+	if zone.ZoneName != nil {
+		return *zone.ZoneName
+	}
+	if zone.Name != nil {
+		return *zone.Name
+	}
+	return ""
+}
+
+// splitPort splits a bridge VLAN port such as lan1:t* into its name and
+// tagging
+func splitPort(port string) (string, string) {
+	name, flags, ok := strings.Cut(port, ":")
+	if ok && strings.Contains(flags, "t") {
+		return name, "tagged"
+	}
+	return name, "untagged"
+}
@@ -0,0 +1,90 @@
+package topology
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+)
+
+func TestBuildBridgeVlans(t *testing.T) {
+	cfg := &config.ConfigConfig{
+		Network: &config.NetworkConfig{
+			Device: []config.DeviceSection{
+				{Name: stringPtr("br_lan"), DeviceName: stringPtr("br-lan"), Type: stringPtr("bridge"), Ports: []string{"lan1", "lan2"}},
+			},
+			BridgeVlan: []config.BridgeVlanSection{
+				{Name: stringPtr("vlan1"), Device: stringPtr("br-lan"), Vlan: intPtr(1), Ports: []string{"lan1", "lan2:t"}},
+				{Name: stringPtr("vlan10"), Device: stringPtr("br-lan"), Vlan: intPtr(10), Ports: []string{"lan2:t"}},
+			},
+			Interface: []config.InterfaceSection{
+				{Name: stringPtr("lan"), Device: stringPtr("br-lan.1"), Proto: stringPtr("static"), IPAddr: stringPtr("192.168.1.1")},
+				{Name: stringPtr("guest"), Device: stringPtr("br-lan.10"), Proto: stringPtr("static")},
+			},
+		},
+		Firewall: &config.FirewallConfig{
+			Zone: []config.ZoneSection{
+				{Name: stringPtr("lan"), Network: []string{"lan"}},
+				{Name: stringPtr("guest"), Network: []string{"guest"}},
+			},
+			Forwarding: []config.ForwardingSection{
+				{Src: stringPtr("guest"), Dest: stringPtr("lan")},
+			},
+		},
+	}
+
+	dot := Build(cfg).DOT("router")
+
+	expected := []string{
+		`digraph "router" {`,
+		`"device:br-lan" [label="br-lan\n(bridge)", shape=box3d];`,
+		`"vlan:br-lan.10" [label="br-lan.10\nVLAN 10", shape=component];`,
+		`"port:lan1" -> "device:br-lan";`,
+		`"port:lan2" -> "device:br-lan";`,
+		`"device:br-lan" -> "vlan:br-lan.1";`,
+		`"port:lan1" -> "vlan:br-lan.1" [label="untagged"];`,
+		`"port:lan2" -> "vlan:br-lan.10" [label="tagged"];`,
+		`"vlan:br-lan.1" -> "interface:lan";`,
+		`"vlan:br-lan.10" -> "interface:guest";`,
+		`"interface:guest" -> "zone:guest";`,
+		`"zone:guest" -> "zone:lan" [label="forward", style=dashed];`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(dot, line) {
+			t.Errorf("Expected DOT to contain %s, got:\n%s", line, dot)
+		}
+	}
+
+	// Ports are only declared once
+	if n := strings.Count(dot, `"port:lan2" [`); n != 1 {
+		t.Errorf("Expected port lan2 to be declared once, got %d", n)
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	cfg := &config.ConfigConfig{
+		Network: &config.NetworkConfig{
+			Interface: []config.InterfaceSection{
+				{Name: stringPtr("wan"), Device: stringPtr("eth0"), Proto: stringPtr("dhcp")},
+			},
+		},
+	}
+
+	mermaid := Build(cfg).Mermaid()
+
+	expected := "flowchart LR\n" +
+		"  n0([\"wan<br/>dhcp\"])\n" +
+		"  n1[\"eth0\"]\n" +
+		"  n1 --> n0\n"
+	if mermaid != expected {
+		t.Errorf("Unexpected Mermaid output:\n%s\nexpected:\n%s", mermaid, expected)
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,118 @@
+package uci
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseChanges parses `uci changes` output into the staged value of each
+// changed key, e.g. "network.lan.ipaddr" -> [10.0.0.1] or "network.lan" ->
+// [interface]. Items added with add_list are appended; deleted keys are
+// dropped.
+func ParseChanges(output string) map[string][]string {
+	staged := make(map[string][]string)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if key, ok := strings.CutPrefix(line, "-"); ok {
+			delete(staged, key)
+			continue
+		}
+
+		if key, value, ok := strings.Cut(line, "+="); ok && !strings.Contains(key, "=") {
+			staged[key] = append(staged[key], ParseShowValue(value)...)
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		staged[key] = ParseShowValue(value)
+	}
+
+	return staged
+}
+
+// ExpectedChanges returns the value each set and add_list command in
+// commands stages for the given config, in the same form as ParseChanges
+func ExpectedChanges(commands []string, configKey string) map[string][]string {
+	expected := make(map[string][]string)
+
+	for _, cmd := range commands {
+		var rest string
+		isList := false
+		if after, ok := strings.CutPrefix(cmd, "uci set "); ok {
+			rest = after
+		} else if after, ok := strings.CutPrefix(cmd, "uci add_list "); ok {
+			rest = after
+			isList = true
+		} else {
+			continue
+		}
+
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok || !strings.HasPrefix(key, configKey+".") {
+			continue
+		}
+
+		items := ParseShowValue(value)
+		if isList {
+			expected[key] = append(expected[key], items...)
+		} else {
+			expected[key] = []string{strings.Join(items, " ")}
+		}
+	}
+
+	return expected
+}
+
+// CompareChanges reports the expected changes that aren't staged. Keys
+// missing from the staged changes are looked up with current, which should
+// return the key's staged value, since uci doesn't record a set that leaves
+// the value unchanged.
+func CompareChanges(expected, staged map[string][]string, current func(key string) string) []string {
+	var discrepancies []string
+
+	keys := SortedKeys(expected)
+
+	for _, key := range keys {
+		want := strings.Join(expected[key], " ")
+		got, ok := staged[key]
+		if ok && containsItems(got, expected[key]) {
+			continue
+		}
+
+		value := current(key)
+		if containsItems(strings.Fields(value), strings.Fields(want)) {
+			continue
+		}
+		if ok {
+			value = strings.Join(got, " ")
+		}
+		discrepancies = append(discrepancies, fmt.Sprintf("%s: expected '%s', staged '%s'", key, want, value))
+	}
+
+	return discrepancies
+}
+
+// containsItems reports whether every item of want appears in items
+func containsItems(items, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, item := range items {
+			if item == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
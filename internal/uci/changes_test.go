@@ -0,0 +1,27 @@
+package uci
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChanges(t *testing.T) {
+	output := `network.lan=interface
+network.lan.ipaddr='192.168.1.1'
+network.lan.ipaddr='10.0.0.1'
+network.lan.dns+='1.1.1.1'
+network.lan.dns+='8.8.8.8'
+network.guest.proto='static'
+-network.guest.proto
+`
+
+	expected := map[string][]string{
+		"network.lan":        {"interface"},
+		"network.lan.ipaddr": {"10.0.0.1"},
+		"network.lan.dns":    {"1.1.1.1", "8.8.8.8"},
+	}
+
+	if staged := ParseChanges(output); !reflect.DeepEqual(staged, expected) {
+		t.Errorf("Expected %v, got %v", expected, staged)
+	}
+}
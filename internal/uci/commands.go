@@ -3,71 +3,239 @@ package uci
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 )
 
-// GenerateCommands generates UCI commands from OpenWrt config
+// GenerateCommands generates UCI commands from OpenWrt config, grouped by
+// config in a stable order
 func GenerateCommands(openWrtConfig map[string]any) []string {
 	var commands []string
 
-	for configKey, configValue := range openWrtConfig {
-		configMap, ok := configValue.(map[string]any)
+	for _, configKey := range SortedKeys(openWrtConfig) {
+		commands = append(commands, GenerateConfigCommands(configKey, openWrtConfig[configKey])...)
+	}
+
+	return commands
+}
+
+// GenerateConfigCommands generates the UCI commands for a single config
+func GenerateConfigCommands(configKey string, configValue any) []string {
+	var commands []string
+
+	configMap, ok := configValue.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	for _, sectionKey := range SortedKeys(configMap) {
+		sections, ok := configMap[sectionKey].([]any)
 		if !ok {
 			continue
 		}
 
-		for sectionKey, sectionValue := range configMap {
-			sections, ok := sectionValue.([]any)
+		for _, section := range sections {
+			sectionMap, ok := section.(map[string]any)
 			if !ok {
 				continue
 			}
 
-			for _, section := range sections {
-				sectionMap, ok := section.(map[string]any)
-				if !ok {
-					continue
-				}
+			commands = append(commands, GenerateSectionMapCommands(configKey, sectionKey, sectionMap)...)
+		}
+	}
 
-				// Get section name
-				sectionName, ok := sectionMap[".name"].(string)
-				if !ok {
-					continue
-				}
+	return commands
+}
 
-				identifier := fmt.Sprintf("%s.%s", configKey, sectionName)
+// GenerateSectionMapCommands generates the UCI commands for a single
+// section held as a generic map. Sections without a .name are skipped.
+func GenerateSectionMapCommands(configKey, sectionKey string, sectionMap map[string]any) []string {
+	var commands []string
 
-				// Create section
-				commands = append(commands, fmt.Sprintf("uci set %s=%s", identifier, sectionKey))
+	// Get section name
+	sectionName, ok := sectionMap[".name"].(string)
+	if !ok {
+		return nil
+	}
 
-				// Set all properties
-				for key, value := range sectionMap {
-					if key == ".name" {
-						continue
-					}
+	identifier := fmt.Sprintf("%s.%s", configKey, sectionName)
 
-					commands = append(commands, generatePropertyCommands(identifier, key, value)...)
-				}
-			}
+	// Create section
+	commands = append(commands, fmt.Sprintf("uci set %s=%s", identifier, sectionKey))
+
+	// Set all properties
+	for _, key := range SortedKeys(sectionMap) {
+		if key == ".name" {
+			continue
 		}
+
+		commands = append(commands, generatePropertyCommands(identifier, key, sectionMap[key])...)
 	}
 
 	return commands
 }
 
+// GenerateSectionCommands generates the UCI commands for a single section
+// held in a typed struct, using the json tags for option names and the field
+// types for formatting, so a *int emits an integer and a *bool emits 1/0
+// without guessing. Fields tagged "-" or with a dot-prefixed name (other
+// than .name) are not emitted, except for an Extra map of additional options.
+func GenerateSectionCommands(configKey, sectionKey string, section any) ([]string, error) {
+	val := reflect.ValueOf(section)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected struct, got %v", val.Kind())
+	}
+
+	options := make(map[string]reflect.Value)
+	sectionName := ""
+
+	typ := val.Type()
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Field(i)
+		typeField := typ.Field(i)
+
+		tagName := strings.Split(typeField.Tag.Get("json"), ",")[0]
+		if tagName == "-" && typeField.Name == "Extra" && field.Kind() == reflect.Map {
+			for _, key := range field.MapKeys() {
+				options[key.String()] = field.MapIndex(key)
+			}
+			continue
+		}
+		if tagName == "" || tagName == "-" || field.IsZero() {
+			continue
+		}
+
+		if tagName == ".name" {
+			if field.Kind() == reflect.Ptr {
+				field = field.Elem()
+			}
+			sectionName = field.String()
+			continue
+		}
+		if strings.HasPrefix(tagName, ".") {
+			continue
+		}
+
+		options[tagName] = field
+	}
+
+	if sectionName == "" {
+		return nil, fmt.Errorf("%s section has no .name", sectionKey)
+	}
+
+	identifier := fmt.Sprintf("%s.%s", configKey, sectionName)
+	commands := []string{fmt.Sprintf("uci set %s=%s", identifier, sectionKey)}
+
+	for _, key := range SortedKeys(options) {
+		field := options[key]
+		for field.Kind() == reflect.Ptr || field.Kind() == reflect.Interface {
+			field = field.Elem()
+		}
+
+		switch field.Kind() {
+		case reflect.Slice, reflect.Array:
+			commands = append(commands, deleteListCommand(identifier, key))
+			for i := 0; i < field.Len(); i++ {
+				commands = append(commands, fmt.Sprintf("uci add_list %s.%s=%s", identifier, key, QuoteValue(formatValue(field.Index(i)))))
+			}
+		default:
+			commands = append(commands, fmt.Sprintf("uci set %s.%s=%s", identifier, key, QuoteValue(formatValue(field))))
+		}
+	}
+
+	return commands, nil
+}
+
+// formatValue formats a typed value as a UCI option value
+func formatValue(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return "1"
+		}
+		return "0"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.String:
+		return v.String()
+	default:
+		return coerceValue(v.Interface())
+	}
+}
+
+// SplitSectionCommands separates the commands that create or modify the
+// section with the given identifier (e.g. network.lan) from the rest
+func SplitSectionCommands(commands []string, identifier string) (other, section []string) {
+	for _, cmd := range commands {
+		if IsSectionCommand(cmd, identifier) {
+			section = append(section, cmd)
+		} else {
+			other = append(other, cmd)
+		}
+	}
+	return other, section
+}
+
+// IsSectionCommand reports whether cmd sets an option of, or creates, the
+// section with the given identifier
+func IsSectionCommand(cmd, identifier string) bool {
+	for _, prefix := range []string{"uci set ", "uci add_list ", "uci -q delete "} {
+		rest, ok := strings.CutPrefix(cmd, prefix)
+		if !ok {
+			continue
+		}
+		if rest == identifier || strings.HasPrefix(rest, identifier+"=") || strings.HasPrefix(rest, identifier+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandConfig returns the config a command stages changes to, or "" when
+// it doesn't change UCI config, e.g. a commit or package command
+func CommandConfig(cmd string) string {
+	cmd = strings.TrimPrefix(cmd, "while ")
+	for _, prefix := range []string{"uci set ", "uci add_list ", "uci -q delete "} {
+		if rest, ok := strings.CutPrefix(cmd, prefix); ok {
+			configKey, _, _ := strings.Cut(rest, ".")
+			return configKey
+		}
+	}
+	return ""
+}
+
+// deleteListCommand clears a list option before its items are added. uci
+// fails to delete an option the device doesn't have yet, which is fine here.
+func deleteListCommand(identifier, key string) string {
+	return fmt.Sprintf("uci -q delete %s.%s || true", identifier, key)
+}
+
 func generatePropertyCommands(identifier, key string, value any) []string {
 	var commands []string
 
 	switch v := value.(type) {
 	case []any:
-		// Handle array values with add_list
+		// Handle array values with add_list, clearing the list first so
+		// re-running the commands doesn't duplicate its items
+		commands = append(commands, deleteListCommand(identifier, key))
 		for _, item := range v {
 			coerced := coerceValue(item)
-			commands = append(commands, fmt.Sprintf("uci add_list %s.%s='%s'", identifier, key, coerced))
+			commands = append(commands, fmt.Sprintf("uci add_list %s.%s=%s", identifier, key, QuoteValue(coerced)))
 		}
 	default:
 		// Handle single values
 		coerced := coerceValue(v)
-		commands = append(commands, fmt.Sprintf("uci set %s.%s='%s'", identifier, key, coerced))
+		commands = append(commands, fmt.Sprintf("uci set %s.%s=%s", identifier, key, QuoteValue(coerced)))
 	}
 
 	return commands
@@ -97,18 +265,29 @@ func coerceValue(value any) string {
 func GetResetCommands(configSectionsToReset map[string][]string) []string {
 	var commands []string
 
-	for configKey, sectionKeys := range configSectionsToReset {
-		for _, sectionKey := range sectionKeys {
-			cmd := fmt.Sprintf("while uci -q delete %s.@%s[0]; do :; done", configKey, sectionKey)
-			commands = append(commands, cmd)
-		}
+	for _, configKey := range SortedKeys(configSectionsToReset) {
+		commands = append(commands, GetConfigResetCommands(configKey, configSectionsToReset[configKey])...)
+	}
+
+	return commands
+}
+
+// GetConfigResetCommands generates commands to reset sections of a single config
+func GetConfigResetCommands(configKey string, sectionKeys []string) []string {
+	var commands []string
+
+	for _, sectionKey := range sectionKeys {
+		cmd := fmt.Sprintf("while uci -q delete %s.@%s[0]; do :; done", configKey, sectionKey)
+		commands = append(commands, cmd)
 	}
 
 	return commands
 }
 
-// GetPackageCommands generates opkg commands for package management
-func GetPackageCommands(packagesToInstall []Package, packagesToUninstall []string, installedPackages []InstalledPackage) []string {
+// GetPackageCommands generates opkg commands for package management.
+// removalDependents maps packages to uninstall to the installed packages that
+// depend on them; when nil, removals are forced as their order is unknown.
+func GetPackageCommands(packagesToInstall []Package, packagesToUninstall []string, installedPackages []InstalledPackage, removalDependents map[string][]string) []string {
 	var commands []string
 
 	// Filter packages that are already installed/uninstalled
@@ -148,38 +327,102 @@ func GetPackageCommands(packagesToInstall []Package, packagesToUninstall []strin
 		filteredUninstall = packagesToUninstall
 	}
 
-	// Generate uninstall commands
+	// Generate uninstall commands, removing dependents before the packages
+	// they depend on
 	if len(filteredUninstall) > 0 {
-		pkgList := ""
-		for i, pkg := range filteredUninstall {
-			if i > 0 {
-				pkgList += " "
-			}
-			pkgList += pkg
+		ordered, force := OrderRemovals(filteredUninstall, removalDependents)
+		if force {
+			commands = append(commands, fmt.Sprintf("opkg remove --force-removal-of-dependent-packages %s", strings.Join(ordered, " ")))
+		} else {
+			commands = append(commands, fmt.Sprintf("opkg remove %s", strings.Join(ordered, " ")))
 		}
-		commands = append(commands, fmt.Sprintf("opkg remove --force-removal-of-dependent-packages %s", pkgList))
 	}
 
-	// Generate install commands
+	// Generate install commands, one per priority so dependencies are
+	// installed before the packages that need them
 	if len(filteredInstall) > 0 {
 		commands = append(commands, "opkg update;")
-		pkgList := ""
-		for i, pkg := range filteredInstall {
-			if i > 0 {
-				pkgList += " "
+
+		sorted := make([]Package, len(filteredInstall))
+		copy(sorted, filteredInstall)
+		SortPackages(sorted)
+
+		var names []string
+		for i, pkg := range sorted {
+			names = append(names, pkg.Name)
+			if i == len(sorted)-1 || sorted[i+1].Priority != pkg.Priority {
+				commands = append(commands, fmt.Sprintf("opkg install %s", strings.Join(names, " ")))
+				names = nil
 			}
-			pkgList += pkg.Name
 		}
-		commands = append(commands, fmt.Sprintf("opkg install %s", pkgList))
 	}
 
 	return commands
 }
 
+// OrderRemovals orders packages for removal so each package's dependents
+// are removed before it. It reports whether the removal must still be
+// forced: when the dependents are unknown, depend on each other in a cycle,
+// or include packages that aren't being removed.
+func OrderRemovals(packages []string, dependents map[string][]string) ([]string, bool) {
+	if dependents == nil {
+		return packages, true
+	}
+
+	removing := make(map[string]bool)
+	for _, name := range packages {
+		removing[name] = true
+	}
+
+	force := false
+	ordered := make([]string, 0, len(packages))
+	visiting := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		if visiting[name] {
+			force = true
+			return
+		}
+		visiting[name] = true
+		for _, dependent := range dependents[name] {
+			if removing[dependent] {
+				visit(dependent)
+			} else {
+				force = true
+			}
+		}
+		visiting[name] = false
+		visited[name] = true
+		ordered = append(ordered, name)
+	}
+	for _, name := range packages {
+		visit(name)
+	}
+
+	return ordered, force
+}
+
 // Package represents a package to install
 type Package struct {
 	Name    string
 	Version string
+
+	// Priority orders installation, lowest first
+	Priority int
+}
+
+// SortPackages sorts packages into install order: by priority, then name
+func SortPackages(packages []Package) {
+	sort.SliceStable(packages, func(i, j int) bool {
+		if packages[i].Priority != packages[j].Priority {
+			return packages[i].Priority < packages[j].Priority
+		}
+		return packages[i].Name < packages[j].Name
+	})
 }
 
 // InstalledPackage represents an installed package
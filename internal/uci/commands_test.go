@@ -0,0 +1,141 @@
+package uci_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+func TestGenerateSectionCommandsMatchesMap(t *testing.T) {
+	name := "switch0"
+	switchName := "switch0"
+	reset := true
+	enableVlan := false
+
+	section := config.SwitchSection{
+		Name:       &name,
+		SwitchName: &switchName,
+		Reset:      &reset,
+		EnableVlan: &enableVlan,
+	}
+
+	typed, err := uci.GenerateSectionCommands("network", "switch", &section)
+	if err != nil {
+		t.Fatalf("GenerateSectionCommands failed: %v", err)
+	}
+
+	generic := uci.GenerateSectionMapCommands("network", "switch", map[string]any{
+		".name":       "switch0",
+		"name":        "switch0",
+		"reset":       true,
+		"enable_vlan": false,
+	})
+
+	if !reflect.DeepEqual(typed, generic) {
+		t.Errorf("Typed and map generation differ:\n%v\n%v", typed, generic)
+	}
+}
+
+func TestGenerateSectionCommandsTypes(t *testing.T) {
+	name := "main"
+	port := 2222
+
+	commands, err := uci.GenerateSectionCommands("dropbear", "dropbear", &config.DropbearSection{
+		Name: &name,
+		Port: &port,
+	})
+	if err != nil {
+		t.Fatalf("GenerateSectionCommands failed: %v", err)
+	}
+
+	expected := []string{
+		"uci set dropbear.main=dropbear",
+		"uci set dropbear.main.Port='2222'",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected %v, got %v", expected, commands)
+	}
+
+	if _, err := uci.GenerateSectionCommands("dropbear", "dropbear", &config.DropbearSection{}); err == nil {
+		t.Error("Expected error for section without .name")
+	}
+}
+
+func TestGenerateSectionCommandsMACFilter(t *testing.T) {
+	name := "guest"
+	macfilter := "allow"
+
+	commands, err := uci.GenerateSectionCommands("wireless", "wifi-iface", &config.WifiIfaceSection{
+		Name:      &name,
+		Macfilter: &macfilter,
+		Maclist:   []string{"00:11:22:33:44:55", "66:77:88:99:aa:bb"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateSectionCommands failed: %v", err)
+	}
+
+	expected := []string{
+		"uci set wireless.guest=wifi-iface",
+		"uci set wireless.guest.macfilter='allow'",
+		"uci -q delete wireless.guest.maclist || true",
+		"uci add_list wireless.guest.maclist='00:11:22:33:44:55'",
+		"uci add_list wireless.guest.maclist='66:77:88:99:aa:bb'",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected %v, got %v", expected, commands)
+	}
+}
+
+func TestGetPackageRemoveOrder(t *testing.T) {
+	// luci-app-firewall depends on firewall4, which depends on kmod-nft-core
+	packages := []string{"kmod-nft-core", "firewall4", "luci-app-firewall"}
+	dependents := map[string][]string{
+		"kmod-nft-core":     {"firewall4"},
+		"firewall4":         {"luci-app-firewall"},
+		"luci-app-firewall": nil,
+	}
+
+	commands := uci.GetPackageCommands(nil, packages, nil, dependents)
+	expected := []string{"opkg remove luci-app-firewall firewall4 kmod-nft-core"}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected %v, got %v", expected, commands)
+	}
+
+	// A dependent that stays installed still needs the removal forced
+	dependents["kmod-nft-core"] = []string{"firewall4", "kmod-nft-offload"}
+	commands = uci.GetPackageCommands(nil, packages, nil, dependents)
+	expected = []string{"opkg remove --force-removal-of-dependent-packages luci-app-firewall firewall4 kmod-nft-core"}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected %v, got %v", expected, commands)
+	}
+
+	// As does a cycle
+	ordered, force := uci.OrderRemovals([]string{"a", "b"}, map[string][]string{"a": {"b"}, "b": {"a"}})
+	if !force || len(ordered) != 2 {
+		t.Errorf("Expected a forced removal of both packages, got %v, %v", ordered, force)
+	}
+}
+
+func TestGetPackageCommandsOrder(t *testing.T) {
+	packages := []uci.Package{
+		{Name: "wireguard-tools", Priority: 10},
+		{Name: "tcpdump"},
+		{Name: "kmod-wireguard", Priority: -10},
+		{Name: "luci-proto-wireguard", Priority: 10},
+		{Name: "htop"},
+	}
+
+	commands := uci.GetPackageCommands(packages, nil, nil, nil)
+
+	expected := []string{
+		"opkg update;",
+		"opkg install kmod-wireguard",
+		"opkg install htop tcpdump",
+		"opkg install luci-proto-wireguard wireguard-tools",
+	}
+	if !reflect.DeepEqual(commands, expected) {
+		t.Errorf("Expected %v, got %v", expected, commands)
+	}
+}
@@ -0,0 +1,72 @@
+package uci
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RenderConfig renders a resolved config in the native /etc/config file
+// format, e.g.
+//
+//	config zone 'lan'
+//		option name 'lan'
+//		list network 'lan'
+//
+// Sections are ordered like the generated commands. Sections without a
+// .name are rendered anonymous.
+func RenderConfig(configValue any) string {
+	configMap, ok := configValue.(map[string]any)
+	if !ok {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, sectionKey := range SortedKeys(configMap) {
+		sections, ok := configMap[sectionKey].([]any)
+		if !ok {
+			continue
+		}
+
+		for _, section := range sections {
+			sectionMap, ok := section.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			renderSection(&b, sectionKey, sectionMap)
+		}
+	}
+
+	return b.String()
+}
+
+func renderSection(b *strings.Builder, sectionKey string, sectionMap map[string]any) {
+	if name, ok := sectionMap[".name"].(string); ok {
+		fmt.Fprintf(b, "config %s %s\n", sectionKey, QuoteValue(name))
+	} else {
+		fmt.Fprintf(b, "config %s\n", sectionKey)
+	}
+
+	for _, key := range SortedKeys(sectionMap) {
+		if strings.HasPrefix(key, ".") {
+			continue
+		}
+
+		if list, ok := sectionMap[key].([]any); ok {
+			for _, item := range list {
+				fmt.Fprintf(b, "\tlist %s %s\n", key, QuoteValue(coerceValue(item)))
+			}
+		} else {
+			fmt.Fprintf(b, "\toption %s %s\n", key, QuoteValue(coerceValue(sectionMap[key])))
+		}
+	}
+}
+
+// QuoteValue single quotes a value the way uci export does, which is also
+// safe to pass through the shell
+func QuoteValue(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,63 @@
+package uci
+
+import "testing"
+
+func TestRenderConfig(t *testing.T) {
+	firewall := map[string]any{
+		"defaults": []any{
+			map[string]any{
+				"input":     "ACCEPT",
+				"syn_flood": true,
+			},
+		},
+		"zone": []any{
+			map[string]any{
+				".name":   "lan",
+				"name":    "lan",
+				"network": []any{"lan", "guest"},
+				"input":   "ACCEPT",
+			},
+			map[string]any{
+				".name":   "wan",
+				"name":    "wan",
+				"network": []any{"wan"},
+				"masq":    true,
+				"mtu_fix": 1,
+			},
+		},
+		"rule": []any{
+			map[string]any{
+				".name":  "allow_ping",
+				"name":   "Allow-Ping's",
+				"src":    "wan",
+				"target": "ACCEPT",
+			},
+		},
+	}
+
+	expected := `config defaults
+	option input 'ACCEPT'
+	option syn_flood '1'
+
+config rule 'allow_ping'
+	option name 'Allow-Ping'\''s'
+	option src 'wan'
+	option target 'ACCEPT'
+
+config zone 'lan'
+	option input 'ACCEPT'
+	option name 'lan'
+	list network 'lan'
+	list network 'guest'
+
+config zone 'wan'
+	option masq '1'
+	option mtu_fix '1'
+	option name 'wan'
+	list network 'wan'
+`
+
+	if rendered := RenderConfig(firewall); rendered != expected {
+		t.Errorf("Unexpected rendering:\n%s\nexpected:\n%s", rendered, expected)
+	}
+}
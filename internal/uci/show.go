@@ -0,0 +1,190 @@
+package uci
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseShow parses `uci show` output into a flat map of
+// "config.section" -> section type and "config.section.option" -> value.
+// List values are joined with a single space.
+func ParseShow(output string) map[string]string {
+	result := make(map[string]string)
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		result[parts[0]] = strings.Join(ParseShowValue(parts[1]), " ")
+	}
+
+	return result
+}
+
+// ParseShowValue splits a `uci show` value into its list items, removing
+// the quoting and escaping, e.g. `'a' 'b'` -> [a b]
+func ParseShowValue(value string) []string {
+	var items []string
+	var current strings.Builder
+	inQuotes := false
+	hasItem := false
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\'':
+			inQuotes = !inQuotes
+			hasItem = true
+		case c == '\\' && !inQuotes && i+1 < len(value):
+			i++
+			current.WriteByte(value[i])
+			hasItem = true
+		case c == ' ' && !inQuotes:
+			if hasItem {
+				items = append(items, current.String())
+				current.Reset()
+				hasItem = false
+			}
+		default:
+			current.WriteByte(c)
+			hasItem = true
+		}
+	}
+
+	if hasItem {
+		items = append(items, current.String())
+	}
+
+	return items
+}
+
+// FilterDefaults removes the lines of `uci show` output that match the
+// defaults, given in the flat form of ParseShow, leaving only the options
+// that were changed. A section's line is kept while any of its options are,
+// or when the section isn't in the defaults.
+func FilterDefaults(output string, defaults map[string]string) string {
+	type showLine struct {
+		key, text string
+	}
+
+	var lines []showLine
+	flat := make(map[string]string)
+	for _, text := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(text), "=")
+		if !ok {
+			continue
+		}
+		lines = append(lines, showLine{key: key, text: strings.TrimSpace(text)})
+		flat[key] = strings.Join(ParseShowValue(value), " ")
+	}
+
+	changed := FilterFlatDefaults(flat, defaults)
+	var kept []string
+	for _, line := range lines {
+		if _, ok := changed[line.key]; ok {
+			kept = append(kept, line.text)
+		}
+	}
+	if len(kept) == 0 {
+		return ""
+	}
+
+	return strings.Join(kept, "\n") + "\n"
+}
+
+// FilterFlatDefaults is FilterDefaults for a config in the flat form of
+// ParseShow, returning the entries that aren't defaults
+func FilterFlatDefaults(flat map[string]string, defaults map[string]string) map[string]string {
+	isDefault := func(key, value string) bool {
+		defaultValue, ok := defaults[key]
+		return ok && defaultValue == value
+	}
+
+	changedSections := make(map[string]bool)
+	for key, value := range flat {
+		if parts := strings.SplitN(key, ".", 3); len(parts) == 3 && !isDefault(key, value) {
+			changedSections[parts[0]+"."+parts[1]] = true
+		}
+	}
+
+	changed := make(map[string]string)
+	for key, value := range flat {
+		isSection := strings.Count(key, ".") == 1
+		if !isDefault(key, value) || (isSection && changedSections[key]) {
+			changed[key] = value
+		}
+	}
+	return changed
+}
+
+// Flatten converts a resolved OpenWrt config into the same flat form as
+// ParseShow, so it can be compared with a device's current state.
+// Sections without a .name are skipped.
+func Flatten(openWrtConfig map[string]any) map[string]string {
+	result := make(map[string]string)
+
+	for configKey, configValue := range openWrtConfig {
+		configMap, ok := configValue.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		for sectionKey, sectionValue := range configMap {
+			sections, ok := sectionValue.([]any)
+			if !ok {
+				continue
+			}
+
+			for _, section := range sections {
+				sectionMap, ok := section.(map[string]any)
+				if !ok {
+					continue
+				}
+
+				sectionName, ok := sectionMap[".name"].(string)
+				if !ok {
+					continue
+				}
+
+				identifier := fmt.Sprintf("%s.%s", configKey, sectionName)
+				result[identifier] = sectionKey
+
+				for key, value := range sectionMap {
+					if strings.HasPrefix(key, ".") {
+						continue
+					}
+
+					if list, ok := value.([]any); ok {
+						var items []string
+						for _, item := range list {
+							items = append(items, coerceValue(item))
+						}
+						result[identifier+"."+key] = strings.Join(items, " ")
+					} else {
+						result[identifier+"."+key] = coerceValue(value)
+					}
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// SortedKeys returns the keys of a map in sorted order
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,71 @@
+package validate
+
+import (
+	"fmt"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+// checkDHCPCoverage checks that the lan interface, and every static
+// interface with a dhcp pool, is served by dnsmasq or odhcpd. dnsmasq
+// serves pools that aren't ignored unless odhcpd is the main DHCP server,
+// in which case odhcpd only serves pools with dhcpv4 server.
+func checkDHCPCoverage(cfg *config.ConfigConfig, _ *device.DeviceSchema) []report.Finding {
+	if cfg.DHCP == nil || cfg.Network == nil {
+		return nil
+	}
+
+	odhcpdMain := false
+	for _, section := range cfg.DHCP.Odhcpd {
+		if section.Maindhcp != nil && *section.Maindhcp {
+			odhcpdMain = true
+		}
+	}
+
+	pools := make(map[string]int)
+	for i, pool := range cfg.DHCP.DHCP {
+		if pool.Interface != nil {
+			pools[*pool.Interface] = i
+		}
+	}
+
+	var findings []report.Finding
+	for i, iface := range cfg.Network.Interface {
+		if iface.Name == nil || iface.Proto == nil || *iface.Proto != "static" || iface.IPAddr == nil {
+			continue
+		}
+		index, hasPool := pools[*iface.Name]
+		if !hasPool && *iface.Name != "lan" {
+			continue
+		}
+
+		finding := report.Finding{
+			Severity: report.SeverityWarning,
+			Rule:     "dhcp-coverage",
+			Config:   "dhcp",
+		}
+		if !hasPool {
+			finding.Config = "network"
+			finding.Section = sectionName("interface", i, iface.Name)
+			finding.Message = "lan has no dhcp pool, so clients won't get an address"
+			findings = append(findings, finding)
+			continue
+		}
+
+		pool := cfg.DHCP.DHCP[index]
+		finding.Section = sectionName("dhcp", index, pool.Name)
+		switch {
+		case pool.Ignore != nil && *pool.Ignore:
+			finding.Message = fmt.Sprintf("dhcp pool for %s is ignored, so clients won't get an address", *iface.Name)
+		case odhcpdMain && (pool.DHCPv4 == nil || *pool.DHCPv4 != "server"):
+			finding.Message = fmt.Sprintf("odhcpd is the main DHCP server, so dnsmasq doesn't serve %s, but the pool doesn't set dhcpv4 server", *iface.Name)
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+
+	return findings
+}
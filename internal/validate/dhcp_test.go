@@ -0,0 +1,57 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+func TestCheckDHCPCoverage(t *testing.T) {
+	cfg := &config.ConfigConfig{
+		Network: &config.NetworkConfig{
+			Interface: []config.InterfaceSection{
+				{Name: stringPtr("lan"), Proto: stringPtr("static"), IPAddr: stringPtr("192.168.1.1")},
+				{Name: stringPtr("wan"), Proto: stringPtr("dhcp")},
+			},
+		},
+		DHCP: &config.DHCPConfig{
+			DHCP: []config.DHCPSection{
+				{Name: stringPtr("wan"), Interface: stringPtr("wan"), Ignore: boolPtr(true)},
+			},
+		},
+	}
+
+	findings := checkDHCPCoverage(cfg, nil)
+	if len(findings) != 1 {
+		t.Fatalf("Expected 1 finding, got %d: %v", len(findings), findings)
+	}
+	if findings[0].Section != "lan" || findings[0].Severity != report.SeverityWarning || !strings.Contains(findings[0].Message, "no dhcp pool") {
+		t.Errorf("Expected missing pool warning for lan, got %v", findings[0])
+	}
+
+	// A dnsmasq pool covers lan
+	cfg.DHCP.DHCP = append(cfg.DHCP.DHCP, config.DHCPSection{Name: stringPtr("lan"), Interface: stringPtr("lan")})
+	if findings := checkDHCPCoverage(cfg, nil); len(findings) != 0 {
+		t.Errorf("Expected lan to be covered, got %v", findings)
+	}
+
+	// With odhcpd as the main DHCP server the pool needs dhcpv4 server
+	cfg.DHCP.Odhcpd = []config.OdhcpdSection{{Name: stringPtr("odhcpd"), Maindhcp: boolPtr(true)}}
+	findings = checkDHCPCoverage(cfg, nil)
+	if len(findings) != 1 || !strings.Contains(findings[0].Message, "dhcpv4 server") {
+		t.Errorf("Expected dhcpv4 warning, got %v", findings)
+	}
+	cfg.DHCP.DHCP[1].DHCPv4 = stringPtr("server")
+	if findings := checkDHCPCoverage(cfg, nil); len(findings) != 0 {
+		t.Errorf("Expected lan to be covered by odhcpd, got %v", findings)
+	}
+
+	// An ignored pool isn't served by either
+	cfg.DHCP.DHCP[1].Ignore = boolPtr(true)
+	findings = checkDHCPCoverage(cfg, nil)
+	if len(findings) != 1 || !strings.Contains(findings[0].Message, "ignored") {
+		t.Errorf("Expected ignored pool warning, got %v", findings)
+	}
+}
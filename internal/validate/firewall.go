@@ -0,0 +1,109 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+// validPolicies are the policies accepted by zone input, output and forward
+var validPolicies = map[string]bool{
+	"ACCEPT": true,
+	"REJECT": true,
+	"DROP":   true,
+}
+
+// checkFirewallZones warns about common firewall zone misconfigurations
+func checkFirewallZones(cfg *config.ConfigConfig, _ *device.DeviceSchema) []report.Finding {
+	if cfg.Firewall == nil {
+		return nil
+	}
+
+	interfaces := make(map[string]config.InterfaceSection)
+	if cfg.Network != nil {
+		for _, iface := range cfg.Network.Interface {
+			if iface.Name != nil {
+				interfaces[*iface.Name] = iface
+			}
+		}
+	}
+
+	var findings []report.Finding
+	for i, zone := range cfg.Firewall.Zone {
+		section := sectionName("zone", i, zone.Name)
+		warn := func(rule, message string) {
+			findings = append(findings, report.Finding{
+				Severity: report.SeverityWarning,
+				Rule:     rule,
+				Config:   "firewall",
+				Section:  section,
+				Message:  message,
+			})
+		}
+
+		policies := []struct {
+			option string
+			value  *string
+		}{
+			{"input", zone.Input},
+			{"output", zone.Output},
+			{"forward", zone.Forward},
+		}
+		for _, policy := range policies {
+			if policy.value != nil && !validPolicies[*policy.value] {
+				warn("zone-policy", fmt.Sprintf("%s policy %q is not one of ACCEPT, REJECT or DROP; the firewall will refuse to load the zone", policy.option, *policy.value))
+			}
+		}
+
+		masq := zone.Masq != nil && *zone.Masq
+		hasGateway := false
+		for _, network := range zone.Network {
+			if iface, ok := interfaces[network]; ok && providesGateway(iface) {
+				hasGateway = true
+			}
+		}
+
+		if masq && len(zone.Network) == 0 {
+			warn("zone-masq", "zone masquerades but covers no network, so no traffic is ever NATed")
+		} else if masq && !hasGateway {
+			warn("zone-masq", "zone masquerades but none of its networks has a gateway; masquerading is normally only enabled on the upstream (wan) zone")
+		}
+
+		wanLike := masq || hasGateway || zoneName(zone) == "wan"
+		if wanLike && zone.Input != nil && *zone.Input == "ACCEPT" {
+			warn("zone-input", "upstream zone accepts all input, exposing every service on the device to the upstream network; use REJECT or DROP and open ports with rules")
+		}
+	}
+
+	return findings
+}
+
+// zoneName returns the zone's name option, falling back to its section name
+func zoneName(zone config.ZoneSection) string {
+	if zone.ZoneName != nil {
+		return *zone.ZoneName
+	}
+	if zone.Name != nil {
+		return *zone.Name
+	}
+	return ""
+}
+
+// providesGateway reports whether an interface has a default gateway, either
+// configured statically or learned from a dynamic protocol
+func providesGateway(iface config.InterfaceSection) bool {
+	if iface.Gateway != nil && *iface.Gateway != "" {
+		return true
+	}
+	if iface.Proto == nil {
+		return false
+	}
+	switch strings.ToLower(*iface.Proto) {
+	case "dhcp", "dhcpv6", "pppoe", "pppoa", "3g", "qmi", "ncm":
+		return true
+	}
+	return false
+}
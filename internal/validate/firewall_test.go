@@ -0,0 +1,71 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+func TestCheckFirewallZones(t *testing.T) {
+	masq := true
+	cfg := &config.ConfigConfig{
+		Network: &config.NetworkConfig{
+			Interface: []config.InterfaceSection{
+				{Name: stringPtr("lan"), Proto: stringPtr("static"), IPAddr: stringPtr("192.168.1.1")},
+				{Name: stringPtr("wan"), Proto: stringPtr("dhcp")},
+			},
+		},
+		Firewall: &config.FirewallConfig{
+			Zone: []config.ZoneSection{
+				{Name: stringPtr("lan"), Network: []string{"lan"}, Input: stringPtr("ACCEPT"), Forward: stringPtr("ACCEPT")},
+				{Name: stringPtr("wan"), Network: []string{"wan"}, Input: stringPtr("ACCEPT"), Masq: &masq},
+				{Name: stringPtr("nat"), Masq: &masq},
+				{Name: stringPtr("guest"), Forward: stringPtr("allow")},
+			},
+		},
+	}
+
+	findings := checkFirewallZones(cfg, nil)
+
+	expected := map[string]string{
+		"wan":   "zone-input",
+		"nat":   "zone-masq",
+		"guest": "zone-policy",
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("Expected %d findings, got %d: %v", len(expected), len(findings), findings)
+	}
+	for _, f := range findings {
+		if expected[f.Section] != f.Rule {
+			t.Errorf("Unexpected finding: %v", f)
+		}
+		if f.Severity != report.SeverityWarning {
+			t.Errorf("Expected warning, got %v", f)
+		}
+	}
+}
+
+func TestValidateFirewallZones(t *testing.T) {
+	masq := true
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "ubnt,edgerouter-x", Hostname: "router"},
+		},
+		Config: config.ConfigConfig{
+			Firewall: &config.FirewallConfig{
+				Zone: []config.ZoneSection{
+					{Name: stringPtr("wan"), Input: stringPtr("ACCEPT"), Masq: &masq},
+				},
+			},
+		},
+	}
+
+	rules := make(map[string]bool)
+	for _, f := range ValidateConfig(oncConfig) {
+		rules[f.Rule] = true
+	}
+	if !rules["zone-masq"] || !rules["zone-input"] {
+		t.Errorf("Expected zone-masq and zone-input findings, got %v", rules)
+	}
+}
@@ -0,0 +1,181 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+// MTU limits accepted by validation
+const (
+	minMTU   = 576
+	maxMTU   = 9216
+	pppoeMTU = 1492
+)
+
+// checkMTU checks interface and device MTUs are in range and that PPPoE
+// interfaces leave room for the PPPoE header
+func checkMTU(cfg *config.ConfigConfig, _ *device.DeviceSchema) []report.Finding {
+	if cfg.Network == nil {
+		return nil
+	}
+
+	var findings []report.Finding
+	checkRange := func(sectionKey string, i int, name *string, mtu *int) {
+		if mtu == nil || (*mtu >= minMTU && *mtu <= maxMTU) {
+			return
+		}
+		findings = append(findings, report.Finding{
+			Severity: report.SeverityError,
+			Rule:     "mtu",
+			Config:   "network",
+			Section:  sectionName(sectionKey, i, name),
+			Message:  fmt.Sprintf("mtu %d is outside %d..%d", *mtu, minMTU, maxMTU),
+		})
+	}
+
+	for i, dev := range cfg.Network.Device {
+		checkRange("device", i, dev.Name, dev.MTU)
+	}
+
+	for i, iface := range cfg.Network.Interface {
+		checkRange("interface", i, iface.Name, iface.MTU)
+
+		if iface.Proto != nil && *iface.Proto == "pppoe" && iface.MTU != nil && *iface.MTU > pppoeMTU && *iface.MTU <= maxMTU {
+			findings = append(findings, report.Finding{
+				Severity: report.SeverityWarning,
+				Rule:     "mtu",
+				Config:   "network",
+				Section:  sectionName("interface", i, iface.Name),
+				Message:  fmt.Sprintf("mtu %d exceeds %d, the most a PPPoE link can carry over a 1500 byte ethernet MTU; large transfers may stall", *iface.MTU, pppoeMTU),
+			})
+		}
+	}
+
+	return findings
+}
+
+// sectionName returns the name used to identify a section in findings
+func sectionName(sectionKey string, i int, name *string) string {
+	if name != nil {
+		return *name
+	}
+	return fmt.Sprintf("@%s[%d]", sectionKey, i)
+}
+
+// checkInterfaceAddressing checks static interfaces have an address and
+// that interfaces with proto none, e.g. bridge members or VLAN trunks that
+// only need to be brought up, don't set addressing that would be ignored
+func checkInterfaceAddressing(cfg *config.ConfigConfig, _ *device.DeviceSchema) []report.Finding {
+	if cfg.Network == nil {
+		return nil
+	}
+
+	var findings []report.Finding
+	for i, iface := range cfg.Network.Interface {
+		if iface.Proto == nil {
+			continue
+		}
+
+		switch *iface.Proto {
+		case "static":
+			if iface.IPAddr == nil && len(iface.IP6Addr) == 0 {
+				findings = append(findings, report.Finding{
+					Severity: report.SeverityError,
+					Rule:     "interface-address",
+					Config:   "network",
+					Section:  sectionName("interface", i, iface.Name),
+					Message:  "static interface has no ipaddr or ip6addr",
+				})
+			}
+		case "none":
+			var ignored []string
+			if iface.IPAddr != nil {
+				ignored = append(ignored, "ipaddr")
+			}
+			if len(iface.IP6Addr) > 0 {
+				ignored = append(ignored, "ip6addr")
+			}
+			if iface.Netmask != nil {
+				ignored = append(ignored, "netmask")
+			}
+			if iface.Gateway != nil {
+				ignored = append(ignored, "gateway")
+			}
+			if len(iface.DNS) > 0 {
+				ignored = append(ignored, "dns")
+			}
+			if len(ignored) > 0 {
+				findings = append(findings, report.Finding{
+					Severity: report.SeverityWarning,
+					Rule:     "interface-address",
+					Config:   "network",
+					Section:  sectionName("interface", i, iface.Name),
+					Message:  fmt.Sprintf("proto none ignores %s", strings.Join(ignored, ", ")),
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+// checkBridgeVlanPorts checks that a bridge port is untagged in at most one
+// bridge-vlan and is the primary VLAN (PVID, the * flag) of at most one.
+// Trunk ports may be tagged (:t) in any number of VLANs.
+func checkBridgeVlanPorts(cfg *config.ConfigConfig, _ *device.DeviceSchema) []report.Finding {
+	if cfg.Network == nil {
+		return nil
+	}
+
+	type portKey struct{ bridge, port string }
+	untagged := make(map[portKey]int)
+	pvid := make(map[portKey]int)
+
+	var findings []report.Finding
+	for i, bridgeVlan := range cfg.Network.BridgeVlan {
+		if bridgeVlan.Vlan == nil {
+			continue
+		}
+		var bridge string
+		if bridgeVlan.Device != nil {
+			bridge = *bridgeVlan.Device
+		}
+
+		for _, port := range bridgeVlan.Ports {
+			name, flags, _ := strings.Cut(port, ":")
+			key := portKey{bridge, name}
+			if !strings.Contains(flags, "t") {
+				if vlan, ok := untagged[key]; ok && vlan != *bridgeVlan.Vlan {
+					findings = append(findings, report.Finding{
+						Severity: report.SeverityError,
+						Rule:     "bridge-vlan",
+						Config:   "network",
+						Section:  sectionName("bridge-vlan", i, bridgeVlan.Name),
+						Message:  fmt.Sprintf("port %s is untagged in both VLAN %d and VLAN %d; tag it (%s:t) in all but one", name, vlan, *bridgeVlan.Vlan, name),
+					})
+				} else {
+					untagged[key] = *bridgeVlan.Vlan
+				}
+			}
+			if strings.Contains(flags, "*") {
+				if vlan, ok := pvid[key]; ok && vlan != *bridgeVlan.Vlan {
+					findings = append(findings, report.Finding{
+						Severity: report.SeverityError,
+						Rule:     "bridge-vlan",
+						Config:   "network",
+						Section:  sectionName("bridge-vlan", i, bridgeVlan.Name),
+						Message:  fmt.Sprintf("port %s is the primary VLAN (*) of both VLAN %d and VLAN %d", name, vlan, *bridgeVlan.Vlan),
+					})
+				} else {
+					pvid[key] = *bridgeVlan.Vlan
+				}
+			}
+		}
+	}
+
+	return findings
+}
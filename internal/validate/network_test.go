@@ -0,0 +1,109 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+func TestCheckMTU(t *testing.T) {
+	jumbo := 9000
+	tooBig := 10000
+	pppoe := 1500
+
+	cfg := &config.ConfigConfig{
+		Network: &config.NetworkConfig{
+			Device: []config.DeviceSection{
+				{Name: stringPtr("br_lan"), DeviceName: stringPtr("br-lan"), MTU: &jumbo},
+				{Name: stringPtr("eth1"), DeviceName: stringPtr("eth1"), MTU: &tooBig},
+			},
+			Interface: []config.InterfaceSection{
+				{Name: stringPtr("lan"), Device: stringPtr("br-lan"), MTU: &jumbo},
+				{Name: stringPtr("wan"), Proto: stringPtr("pppoe"), MTU: &pppoe},
+			},
+		},
+	}
+
+	findings := checkMTU(cfg, nil)
+	if len(findings) != 2 {
+		t.Fatalf("Expected 2 findings, got %d: %v", len(findings), findings)
+	}
+
+	if findings[0].Section != "eth1" || findings[0].Severity != report.SeverityError {
+		t.Errorf("Expected out of range error for eth1, got %v", findings[0])
+	}
+	if findings[1].Section != "wan" || findings[1].Severity != report.SeverityWarning {
+		t.Errorf("Expected PPPoE warning for wan, got %v", findings[1])
+	}
+}
+
+func TestCheckInterfaceAddressing(t *testing.T) {
+	cfg := &config.ConfigConfig{
+		Network: &config.NetworkConfig{
+			Device: []config.DeviceSection{
+				{Name: stringPtr("br_trunk"), DeviceName: stringPtr("br-trunk"), Type: stringPtr("bridge"), Ports: []string{"lan1", "lan2"}},
+			},
+			Interface: []config.InterfaceSection{
+				{Name: stringPtr("trunk"), Device: stringPtr("br-trunk"), Proto: stringPtr("none")},
+				{Name: stringPtr("lan"), Device: stringPtr("br-lan"), Proto: stringPtr("static"), IPAddr: stringPtr("10.0.0.1")},
+				{Name: stringPtr("lan6"), Device: stringPtr("br-lan"), Proto: stringPtr("static"), IP6Addr: []string{"fd00::1/64"}},
+			},
+		},
+	}
+
+	if findings := checkInterfaceAddressing(cfg, nil); len(findings) != 0 {
+		t.Errorf("Expected proto none and addressed static interfaces to pass, got %v", findings)
+	}
+
+	cfg.Network.Interface = []config.InterfaceSection{
+		{Name: stringPtr("trunk"), Device: stringPtr("br-trunk"), Proto: stringPtr("none"), IPAddr: stringPtr("10.0.0.1")},
+		{Name: stringPtr("lan"), Device: stringPtr("br-lan"), Proto: stringPtr("static")},
+	}
+
+	findings := checkInterfaceAddressing(cfg, nil)
+	if len(findings) != 2 {
+		t.Fatalf("Expected 2 findings, got %d: %v", len(findings), findings)
+	}
+	if findings[0].Section != "trunk" || findings[0].Severity != report.SeverityWarning {
+		t.Errorf("Expected ignored address warning for trunk, got %v", findings[0])
+	}
+	if findings[1].Section != "lan" || findings[1].Severity != report.SeverityError {
+		t.Errorf("Expected missing address error for lan, got %v", findings[1])
+	}
+}
+
+func TestCheckBridgeVlanPorts(t *testing.T) {
+	vlan1, vlan10, vlan20 := 1, 10, 20
+
+	// lan4 is a trunk carrying all three VLANs tagged, lan1 is an access
+	// port in VLAN 1 and lan2 in VLAN 10
+	cfg := &config.ConfigConfig{
+		Network: &config.NetworkConfig{
+			BridgeVlan: []config.BridgeVlanSection{
+				{Name: stringPtr("lan_vlan"), Device: stringPtr("br-lan"), Vlan: &vlan1, Ports: []string{"lan1:u*", "lan4:t"}},
+				{Name: stringPtr("iot_vlan"), Device: stringPtr("br-lan"), Vlan: &vlan10, Ports: []string{"lan2", "lan4:t"}},
+				{Name: stringPtr("guest_vlan"), Device: stringPtr("br-lan"), Vlan: &vlan20, Ports: []string{"lan4:t"}},
+			},
+		},
+	}
+	if findings := checkBridgeVlanPorts(cfg, nil); len(findings) != 0 {
+		t.Errorf("Expected a valid trunk to pass, got %v", findings)
+	}
+
+	// lan2 is untagged in VLANs 10 and 20
+	cfg.Network.BridgeVlan[2].Ports = []string{"lan2", "lan4:t"}
+	findings := checkBridgeVlanPorts(cfg, nil)
+	if len(findings) != 1 || findings[0].Section != "guest_vlan" || !strings.Contains(findings[0].Message, "port lan2 is untagged in both VLAN 10 and VLAN 20") {
+		t.Errorf("Expected a double untagged error for lan2, got %v", findings)
+	}
+
+	// lan4 is the primary VLAN of two tagged VLANs
+	cfg.Network.BridgeVlan[1].Ports = []string{"lan2", "lan4:t*"}
+	cfg.Network.BridgeVlan[2].Ports = []string{"lan4:t*"}
+	findings = checkBridgeVlanPorts(cfg, nil)
+	if len(findings) != 1 || !strings.Contains(findings[0].Message, "port lan4 is the primary VLAN (*) of both VLAN 10 and VLAN 20") {
+		t.Errorf("Expected a duplicate PVID error for lan4, got %v", findings)
+	}
+}
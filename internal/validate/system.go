@@ -0,0 +1,93 @@
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	// Embed the IANA time zone database so zonenames can be checked on
+	// hosts without one
+	_ "time/tzdata"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+// checkZonename checks that each system zonename is a known time zone,
+// against the device's zoneinfo when it was read and the IANA database
+// otherwise. OpenWrt writes zonenames with spaces where IANA has
+// underscores, e.g. America/New York.
+func checkZonename(cfg *config.ConfigConfig, deviceSchema *device.DeviceSchema) []report.Finding {
+	if cfg.System == nil {
+		return nil
+	}
+
+	var findings []report.Finding
+	for i, system := range cfg.System.System {
+		if system.Zonename == nil || *system.Zonename == "" {
+			continue
+		}
+		zonename := *system.Zonename
+		zone := strings.ReplaceAll(zonename, " ", "_")
+
+		var message string
+		if deviceSchema != nil && len(deviceSchema.Zoneinfo) > 0 {
+			if !slices.Contains(deviceSchema.Zoneinfo, zone) {
+				message = fmt.Sprintf("zonename %q is not in the device's /usr/share/zoneinfo", zonename)
+			}
+		} else if !isIANAZone(zone) {
+			message = fmt.Sprintf("unknown zonename %q, expected an IANA time zone such as Europe/London", zonename)
+		}
+
+		if message != "" {
+			findings = append(findings, report.Finding{
+				Severity: report.SeverityWarning,
+				Rule:     "zonename",
+				Config:   "system",
+				Section:  sectionName("system", i, system.Name),
+				Message:  message,
+			})
+		}
+	}
+
+	return findings
+}
+
+// isIANAZone reports whether zone is in the IANA time zone database
+func isIANAZone(zone string) bool {
+	if zone == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(zone)
+	return err == nil
+}
+
+// compatVersionPattern matches a compat_version such as 1.0 or 1.1
+var compatVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+$`)
+
+// checkCompatVersion checks each system compat_version is a major.minor
+// version, as sysupgrade compares it with the image's
+func checkCompatVersion(cfg *config.ConfigConfig, _ *device.DeviceSchema) []report.Finding {
+	if cfg.System == nil {
+		return nil
+	}
+
+	var findings []report.Finding
+	for i, system := range cfg.System.System {
+		if system.CompatVersion == nil || compatVersionPattern.MatchString(*system.CompatVersion) {
+			continue
+		}
+		findings = append(findings, report.Finding{
+			Severity: report.SeverityError,
+			Rule:     "compat-version",
+			Config:   "system",
+			Section:  sectionName("system", i, system.Name),
+			Message:  fmt.Sprintf("invalid compat_version %q, expected a version such as 1.1", *system.CompatVersion),
+		})
+	}
+
+	return findings
+}
@@ -0,0 +1,60 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+)
+
+func TestCheckZonename(t *testing.T) {
+	cfg := &config.ConfigConfig{
+		System: &config.SystemConfig{
+			System: []config.SystemSection{
+				{Name: stringPtr("system"), Zonename: stringPtr("America/New York")},
+			},
+		},
+	}
+
+	if findings := checkZonename(cfg, nil); len(findings) != 0 {
+		t.Errorf("Expected a valid zonename to pass, got %v", findings)
+	}
+
+	cfg.System.System[0].Zonename = stringPtr("Europe/Lundon")
+	findings := checkZonename(cfg, nil)
+	if len(findings) != 1 || findings[0].Rule != "zonename" || !strings.Contains(findings[0].Message, "Europe/Lundon") {
+		t.Errorf("Expected an unknown zonename warning, got %v", findings)
+	}
+
+	// The device's zoneinfo is used when it was read
+	schema := &device.DeviceSchema{Zoneinfo: []string{"Europe/London", "UTC"}}
+	cfg.System.System[0].Zonename = stringPtr("Europe/London")
+	if findings := checkZonename(cfg, schema); len(findings) != 0 {
+		t.Errorf("Expected a zone installed on the device to pass, got %v", findings)
+	}
+	cfg.System.System[0].Zonename = stringPtr("Europe/Paris")
+	if findings := checkZonename(cfg, schema); len(findings) != 1 {
+		t.Errorf("Expected a zone missing from the device to be reported, got %v", findings)
+	}
+}
+
+func TestCheckCompatVersion(t *testing.T) {
+	cfg := &config.ConfigConfig{
+		System: &config.SystemConfig{
+			System: []config.SystemSection{
+				{Name: stringPtr("system"), CompatVersion: stringPtr("1.1")},
+			},
+		},
+	}
+
+	if findings := checkCompatVersion(cfg, nil); len(findings) != 0 {
+		t.Errorf("Expected a valid compat_version to pass, got %v", findings)
+	}
+
+	cfg.System.System[0].CompatVersion = stringPtr("v1")
+	findings := checkCompatVersion(cfg, nil)
+	if len(findings) != 1 || findings[0].Rule != "compat-version" || findings[0].Section != "system" {
+		t.Errorf("Expected an invalid compat_version error, got %v", findings)
+	}
+}
@@ -0,0 +1,208 @@
+package validate
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+	"github.com/drummonds/openwrt-configurator.git/internal/uci"
+)
+
+// check inspects a device's resolved config and returns its findings
+type check func(cfg *config.ConfigConfig, deviceSchema *device.DeviceSchema) []report.Finding
+
+// checks are run against the resolved config of every device
+var checks = []check{
+	checkFirewallZones,
+	checkMTU,
+	checkInterfaceAddressing,
+	checkBridgeVlanPorts,
+	checkDHCPCoverage,
+	checkRadioCapabilities,
+	checkWifiKeys,
+	checkMACFilters,
+	checkZonename,
+	checkCompatVersion,
+}
+
+// SchemaFunc returns the schema a device is validated against
+type SchemaFunc func(deviceConfig *config.DeviceConfig) (*device.DeviceSchema, error)
+
+// ValidateConfig validates the config for every enabled device without
+// connecting to them
+func ValidateConfig(oncConfig *config.ONCConfig) []report.Finding {
+	return ValidateConfigWithSchemas(oncConfig, func(deviceConfig *config.DeviceConfig) (*device.DeviceSchema, error) {
+		return &device.DeviceSchema{Name: deviceConfig.ModelID}, nil
+	})
+}
+
+// ValidateConfigWithSchemas validates the config for every enabled device
+// against the schema returned by getSchema, e.g. one fetched from the device
+// so radio capabilities can be checked
+func ValidateConfigWithSchemas(oncConfig *config.ONCConfig, getSchema SchemaFunc) []report.Finding {
+	findings := validateDevices(oncConfig)
+
+	for _, dev := range oncConfig.Devices {
+		if dev.Enabled != nil && !*dev.Enabled {
+			continue
+		}
+		schema, err := getSchema(&dev)
+		if err != nil {
+			findings = append(findings, report.Finding{
+				Severity: report.SeverityError,
+				Rule:     "schema",
+				Device:   DeviceName(&dev),
+				Message:  err.Error(),
+			})
+			continue
+		}
+		findings = append(findings, ValidateDevice(oncConfig, &dev, schema)...)
+	}
+
+	return findings
+}
+
+// ValidateDevice resolves the config for a single device and validates it
+func ValidateDevice(oncConfig *config.ONCConfig, deviceConfig *config.DeviceConfig, deviceSchema *device.DeviceSchema) []report.Finding {
+	deviceName := DeviceName(deviceConfig)
+
+	state, err := device.GetOpenWrtState(oncConfig, deviceConfig, deviceSchema)
+	if err != nil {
+		return []report.Finding{{
+			Severity: report.SeverityError,
+			Rule:     "resolve",
+			Device:   deviceName,
+			Message:  err.Error(),
+		}}
+	}
+
+	var findings []report.Finding
+	for _, warning := range state.Warnings {
+		findings = append(findings, report.Finding{
+			Severity: report.SeverityWarning,
+			Rule:     "deprecated-option",
+			Message:  warning,
+		})
+	}
+	findings = append(findings, checkUnnamedSections(state.Config)...)
+
+	cfg, err := device.DecodeConfig(state.Config)
+	if err != nil {
+		findings = append(findings, report.Finding{
+			Severity: report.SeverityError,
+			Rule:     "decode",
+			Message:  fmt.Sprintf("resolved config doesn't match the expected types: %v", err),
+		})
+	} else {
+		for _, c := range checks {
+			findings = append(findings, c(cfg, deviceSchema)...)
+		}
+	}
+
+	for i := range findings {
+		findings[i].Device = deviceName
+	}
+
+	return findings
+}
+
+// DeviceName returns the name used to identify a device in findings
+func DeviceName(deviceConfig *config.DeviceConfig) string {
+	if deviceConfig.Hostname != "" {
+		return deviceConfig.Hostname
+	}
+	if deviceConfig.IPAddr != "" {
+		return deviceConfig.IPAddr
+	}
+	return deviceConfig.ModelID
+}
+
+func validateDevices(oncConfig *config.ONCConfig) []report.Finding {
+	var findings []report.Finding
+	hostnames := make(map[string]int)
+
+	for i, dev := range oncConfig.Devices {
+		name := DeviceName(&dev)
+		if name == "" {
+			name = fmt.Sprintf("devices[%d]", i)
+		}
+
+		if dev.ModelID == "" {
+			findings = append(findings, report.Finding{
+				Severity: report.SeverityError,
+				Rule:     "device-model",
+				Device:   name,
+				Message:  "model_id is not set",
+			})
+		}
+
+		if dev.ExpectedMAC != "" {
+			if _, err := net.ParseMAC(dev.ExpectedMAC); err != nil {
+				findings = append(findings, report.Finding{
+					Severity: report.SeverityError,
+					Rule:     "device-mac",
+					Device:   name,
+					Message:  fmt.Sprintf("expected_mac %q is not a MAC address", dev.ExpectedMAC),
+				})
+			}
+		}
+
+		if dev.Hostname != "" {
+			hostnames[dev.Hostname]++
+			if hostnames[dev.Hostname] == 2 {
+				findings = append(findings, report.Finding{
+					Severity: report.SeverityError,
+					Rule:     "device-hostname",
+					Device:   name,
+					Message:  "hostname is used by more than one device",
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+// checkUnnamedSections warns about sections without a .name, which are not
+// emitted as UCI commands
+func checkUnnamedSections(openWrtConfig map[string]any) []report.Finding {
+	var findings []report.Finding
+
+	for _, configKey := range uci.SortedKeys(openWrtConfig) {
+		configMap, ok := openWrtConfig[configKey].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		for _, sectionKey := range uci.SortedKeys(configMap) {
+			sections, ok := configMap[sectionKey].([]any)
+			if !ok {
+				continue
+			}
+			for i, section := range sections {
+				sectionMap, ok := section.(map[string]any)
+				if !ok {
+					continue
+				}
+				// Generic radios are named after the device's radios
+				if _, ok := sectionMap["band"]; ok && configKey == "wireless" && sectionKey == "wifi-device" {
+					continue
+				}
+				if name, ok := sectionMap[".name"].(string); !ok || strings.TrimSpace(name) == "" {
+					findings = append(findings, report.Finding{
+						Severity: report.SeverityWarning,
+						Rule:     "section-name",
+						Config:   configKey,
+						Section:  fmt.Sprintf("@%s[%d]", sectionKey, i),
+						Message:  "section has no .name and will not be provisioned",
+					})
+				}
+			}
+		}
+	}
+
+	return findings
+}
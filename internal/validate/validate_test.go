@@ -0,0 +1,101 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+func TestValidateConfig(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "ubnt,edgerouter-x", Hostname: "router"},
+			{ModelID: "", Hostname: "router", ExpectedMAC: "not-a-mac"},
+		},
+		Config: config.ConfigConfig{
+			System: &config.SystemConfig{
+				System: []config.SystemSection{
+					{Hostname: stringPtr("${device.hostname}")},
+				},
+			},
+		},
+	}
+
+	findings := ValidateConfig(oncConfig)
+
+	expected := map[string]string{
+		"device-model":    report.SeverityError,
+		"device-hostname": report.SeverityError,
+		"device-mac":      report.SeverityError,
+		"section-name":    report.SeverityWarning,
+	}
+	for rule, severity := range expected {
+		found := false
+		for _, f := range findings {
+			if f.Rule == rule && f.Severity == severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected %s finding for rule %s, got %v", severity, rule, findings)
+		}
+	}
+}
+
+func TestValidateStrict(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "ubnt,edgerouter-x", Hostname: "router"},
+		},
+		Config: config.ConfigConfig{
+			System: &config.SystemConfig{
+				System: []config.SystemSection{
+					{Hostname: stringPtr("${device.hostname}")},
+				},
+			},
+		},
+	}
+
+	// Only warnings pass normally
+	findings := ValidateConfig(oncConfig)
+	if len(findings) == 0 || report.HasErrors(findings) {
+		t.Fatalf("Expected only warnings, got %v", findings)
+	}
+
+	// and fail in strict mode
+	if !report.HasErrors(report.Strict(findings)) {
+		t.Errorf("Expected warnings to be errors in strict mode, got %v", report.Strict(findings))
+	}
+
+	// unless their rule is suppressed
+	if strict := report.Strict(report.Suppress(findings, []string{"section-name"})); report.HasErrors(strict) {
+		t.Errorf("Expected suppressed warnings to pass in strict mode, got %v", strict)
+	}
+}
+
+func TestValidateInvalidCondition(t *testing.T) {
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "ubnt,edgerouter-x", Hostname: "router"},
+		},
+		PackageProfiles: []config.PackageProfile{
+			{If: stringPtr("device.tag.rol == 'ap'"), Packages: []string{"tcpdump"}},
+		},
+	}
+
+	findings := ValidateConfig(oncConfig)
+	if !report.HasErrors(findings) {
+		t.Errorf("Expected an error for an invalid condition, got %v", findings)
+	}
+}
+
+// Helper functions
+func stringPtr(s string) *string {
+	return &s
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,184 @@
+package validate
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+)
+
+// checkRadioCapabilities warns when a radio is configured with a channel or
+// htmode it doesn't support. Radios without probed capabilities (e.g. when
+// validating offline) are not checked.
+func checkRadioCapabilities(cfg *config.ConfigConfig, deviceSchema *device.DeviceSchema) []report.Finding {
+	if cfg.Wireless == nil || deviceSchema == nil {
+		return nil
+	}
+
+	radios := make(map[string]device.Radio)
+	for _, radio := range deviceSchema.Radios {
+		radios[radio.Name] = radio
+	}
+
+	var findings []report.Finding
+	for i, wifiDevice := range cfg.Wireless.WifiDevice {
+		section := sectionName("wifi-device", i, wifiDevice.Name)
+		radio, ok := radios[section]
+		if !ok {
+			continue
+		}
+		warn := func(message string) {
+			findings = append(findings, report.Finding{
+				Severity: report.SeverityWarning,
+				Rule:     "radio-capability",
+				Config:   "wireless",
+				Section:  section,
+				Message:  message,
+			})
+		}
+
+		if wifiDevice.Channel != nil && len(radio.Channels) > 0 {
+			if channel, err := strconv.Atoi(*wifiDevice.Channel); err == nil && !containsInt(radio.Channels, channel) {
+				warn(fmt.Sprintf("channel %d is not supported by this radio, expected one of %s", channel, joinInts(radio.Channels)))
+			}
+		}
+
+		if wifiDevice.Htmode != nil && len(radio.HTModes) > 0 && !containsString(radio.HTModes, *wifiDevice.Htmode) {
+			warn(fmt.Sprintf("htmode %s is not supported by this radio, expected one of %s", *wifiDevice.Htmode, strings.Join(radio.HTModes, ", ")))
+		}
+	}
+
+	return findings
+}
+
+// rawPSKLength is the length of a raw WPA PSK in hex; passphrases are at
+// most one character shorter
+const rawPSKLength = 64
+
+// checkWifiKeys flags WPA-PSK keys that OpenWrt will treat as a raw PSK
+// rather than a passphrase, which otherwise shows up as a wrong password
+func checkWifiKeys(cfg *config.ConfigConfig, _ *device.DeviceSchema) []report.Finding {
+	if cfg.Wireless == nil {
+		return nil
+	}
+
+	var findings []report.Finding
+	for i, iface := range cfg.Wireless.WifiIface {
+		if iface.Key == nil || iface.Encryption == nil || !usesPSK(*iface.Encryption) {
+			continue
+		}
+		if len(*iface.Key) != rawPSKLength {
+			continue
+		}
+
+		finding := report.Finding{
+			Rule:    "wifi-key",
+			Config:  "wireless",
+			Section: sectionName("wifi-iface", i, iface.Name),
+		}
+		if isHex(*iface.Key) {
+			finding.Severity = report.SeverityInfo
+			finding.Message = "key is 64 hex characters and will be used as a raw PSK, not as a passphrase"
+		} else {
+			finding.Severity = report.SeverityWarning
+			finding.Message = "key is 64 characters but not hex, so it is neither a valid passphrase (8 to 63 characters) nor a raw PSK"
+		}
+		findings = append(findings, finding)
+	}
+
+	return findings
+}
+
+// checkMACFilters checks that a wifi-iface's macfilter is a known policy and
+// its maclist holds MAC addresses, and warns about lists the policy ignores
+func checkMACFilters(cfg *config.ConfigConfig, _ *device.DeviceSchema) []report.Finding {
+	if cfg.Wireless == nil {
+		return nil
+	}
+
+	var findings []report.Finding
+	for i, iface := range cfg.Wireless.WifiIface {
+		section := sectionName("wifi-iface", i, iface.Name)
+		add := func(severity, message string) {
+			findings = append(findings, report.Finding{
+				Severity: severity,
+				Rule:     "macfilter",
+				Config:   "wireless",
+				Section:  section,
+				Message:  message,
+			})
+		}
+
+		policy := "disable"
+		if iface.Macfilter != nil {
+			policy = *iface.Macfilter
+		}
+		switch policy {
+		case "disable":
+			if len(iface.Maclist) > 0 {
+				add(report.SeverityWarning, "maclist is ignored unless macfilter is allow or deny")
+			}
+		case "allow":
+			if len(iface.Maclist) == 0 {
+				add(report.SeverityWarning, "macfilter allow with an empty maclist admits no stations")
+			}
+		case "deny":
+		default:
+			add(report.SeverityError, fmt.Sprintf("macfilter %q is not disable, allow or deny", policy))
+		}
+
+		for _, mac := range iface.Maclist {
+			if _, err := net.ParseMAC(mac); err != nil || strings.Count(mac, ":") != 5 {
+				add(report.SeverityError, fmt.Sprintf("maclist entry %q is not a MAC address", mac))
+			}
+		}
+	}
+
+	return findings
+}
+
+// usesPSK reports whether an encryption mode authenticates with a WPA
+// pre-shared key
+func usesPSK(encryption string) bool {
+	mode, _, _ := strings.Cut(encryption, "+")
+	return strings.HasPrefix(mode, "psk") || mode == "sae-mixed"
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,113 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drummonds/openwrt-configurator.git/internal/config"
+	"github.com/drummonds/openwrt-configurator.git/internal/device"
+	"github.com/drummonds/openwrt-configurator.git/internal/report"
+	"github.com/drummonds/openwrt-configurator.git/internal/ssh"
+)
+
+func TestCheckRadioCapabilities(t *testing.T) {
+	mockClient := ssh.NewMockClient("ubnt,edgerouter-x")
+	mockClient.Responses[`ubus call uci get '{"config": "wireless", "type": "wifi-device"}'`] = `{"values": {
+		"radio0": {".name": "radio0", "type": "mac80211", "path": "platform/soc/18000000.wifi", "band": "2g", "channel": "1"}
+	}}`
+	mockClient.Responses[`ubus call iwinfo freqlist '{"device": "radio0"}'`] = `{"results": [
+		{"channel": 1, "mhz": 2412, "restricted": false},
+		{"channel": 6, "mhz": 2437, "restricted": false},
+		{"channel": 11, "mhz": 2462, "restricted": false},
+		{"channel": 13, "mhz": 2472, "restricted": true}
+	]}`
+	mockClient.Responses[`ubus call iwinfo info '{"device": "radio0"}'`] = `{"htmodes": ["HT20", "HT40"]}`
+
+	oncConfig := &config.ONCConfig{
+		Devices: []config.DeviceConfig{
+			{ModelID: "ubnt,edgerouter-x", Hostname: "ap", IPAddr: "192.168.1.2"},
+		},
+		Config: config.ConfigConfig{
+			Wireless: &config.WirelessConfig{
+				WifiDevice: []config.WifiDeviceSection{
+					{Name: stringPtr("radio0"), Channel: stringPtr("13"), Htmode: stringPtr("VHT80")},
+				},
+			},
+		},
+	}
+
+	schema, err := device.GetDeviceSchemaFromClient(mockClient, &oncConfig.Devices[0])
+	if err != nil {
+		t.Fatalf("Failed to get schema: %v", err)
+	}
+	if len(schema.Radios) != 1 || len(schema.Radios[0].Channels) != 3 {
+		t.Fatalf("Expected radio0 with 3 usable channels, got %+v", schema.Radios)
+	}
+
+	var radioFindings int
+	for _, f := range ValidateDevice(oncConfig, &oncConfig.Devices[0], schema) {
+		if f.Rule == "radio-capability" {
+			radioFindings++
+		}
+	}
+	if radioFindings != 2 {
+		t.Errorf("Expected channel and htmode warnings, got %d", radioFindings)
+	}
+
+	// Without probed capabilities nothing is checked
+	if findings := checkRadioCapabilities(&oncConfig.Config, &device.DeviceSchema{}); len(findings) != 0 {
+		t.Errorf("Expected no findings without capabilities, got %v", findings)
+	}
+}
+
+func TestCheckWifiKeys(t *testing.T) {
+	rawPSK := "0123456789abcdef0123456789abcdef0123456789ABCDEF0123456789abcdef"
+	ambiguous := "this passphrase is exactly sixty four characters long, honestly!"
+
+	cfg := &config.ConfigConfig{
+		Wireless: &config.WirelessConfig{
+			WifiIface: []config.WifiIfaceSection{
+				{Name: stringPtr("raw"), Encryption: stringPtr("psk2+ccmp"), Key: &rawPSK},
+				{Name: stringPtr("normal"), Encryption: stringPtr("psk2"), Key: stringPtr("correct horse battery staple")},
+				{Name: stringPtr("ambiguous"), Encryption: stringPtr("psk-mixed"), Key: &ambiguous},
+				{Name: stringPtr("enterprise"), Encryption: stringPtr("wpa2"), Key: &rawPSK},
+			},
+		},
+	}
+
+	findings := checkWifiKeys(cfg, nil)
+	if len(findings) != 2 {
+		t.Fatalf("Expected 2 findings, got %d: %v", len(findings), findings)
+	}
+	if findings[0].Section != "raw" || findings[0].Severity != report.SeverityInfo {
+		t.Errorf("Expected raw PSK info, got %v", findings[0])
+	}
+	if findings[1].Section != "ambiguous" || findings[1].Severity != report.SeverityWarning {
+		t.Errorf("Expected ambiguous key warning, got %v", findings[1])
+	}
+}
+
+func TestCheckMACFilters(t *testing.T) {
+	cfg := &config.ConfigConfig{
+		Wireless: &config.WirelessConfig{
+			WifiIface: []config.WifiIfaceSection{
+				{Name: stringPtr("allowlist"), Macfilter: stringPtr("allow"), Maclist: []string{"00:11:22:33:44:55", "66:77:88:99:AA:BB"}},
+				{Name: stringPtr("badmac"), Macfilter: stringPtr("deny"), Maclist: []string{"00:11:22:33:44", "00-11-22-33-44-55"}},
+				{Name: stringPtr("ignored"), Maclist: []string{"00:11:22:33:44:55"}},
+				{Name: stringPtr("empty"), Macfilter: stringPtr("allow")},
+				{Name: stringPtr("unknown"), Macfilter: stringPtr("block")},
+				{Name: stringPtr("off"), Macfilter: stringPtr("disable")},
+			},
+		},
+	}
+
+	findings := checkMACFilters(cfg, nil)
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.Section+":"+f.Severity)
+	}
+	expected := []string{"badmac:error", "badmac:error", "ignored:warning", "empty:warning", "unknown:error"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %v, got %v", expected, findings)
+	}
+}
@@ -0,0 +1,72 @@
+package version
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Compare compares two OpenWrt release versions, e.g. "21.02.3" and
+// "23.05.0-rc1", returning -1, 0 or 1. Missing components count as zero and
+// any suffix after the numbers is ignored. SNAPSHOT builds are newer than
+// every release.
+func Compare(a, b string) int {
+	aParts, aSnapshot := parse(a)
+	bParts, bSnapshot := parse(b)
+
+	switch {
+	case aSnapshot && bSnapshot:
+		return 0
+	case aSnapshot:
+		return 1
+	case bSnapshot:
+		return -1
+	}
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// AtLeast reports whether v is min or newer
+func AtLeast(v, min string) bool {
+	return Compare(v, min) >= 0
+}
+
+// parse returns the numeric components of a version and whether it is a
+// SNAPSHOT build
+func parse(v string) ([]int, bool) {
+	v = strings.TrimSpace(v)
+	if strings.EqualFold(v, "snapshot") {
+		return nil, true
+	}
+
+	// Drop suffixes such as -rc1
+	if i := strings.IndexAny(v, "-+ "); i != -1 {
+		v = v[:i]
+	}
+
+	var parts []int
+	for _, field := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+
+	return parts, false
+}
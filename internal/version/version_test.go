@@ -0,0 +1,23 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"21.02.0", "21.02", 0},
+		{"19.07.10", "21.02", -1},
+		{"23.05.0", "21.02.7", 1},
+		{"23.05.0-rc1", "23.05", 0},
+		{"SNAPSHOT", "24.10.0", 1},
+		{"22.03.5", "SNAPSHOT", -1},
+	}
+
+	for _, tc := range testCases {
+		if got := Compare(tc.a, tc.b); got != tc.expected {
+			t.Errorf("Compare(%q, %q) = %d, expected %d", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}